@@ -18,6 +18,7 @@ import (
 	tomb "gopkg.in/tomb.v2"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
@@ -69,29 +70,32 @@ Exit status is 3 if some source data could not be read (incomplete snapshot crea
 
 // BackupOptions bundles all options for the backup command.
 type BackupOptions struct {
-	Parent                  string
-	Force                   bool
-	Excludes                []string
-	InsensitiveExcludes     []string
-	ExcludeFiles            []string
-	InsensitiveExcludeFiles []string
-	ExcludeOtherFS          bool
-	ExcludeIfPresent        []string
-	ExcludeCaches           bool
-	ExcludeLargerThan       string
-	Stdin                   bool
-	StdinFilename           string
-	Tags                    restic.TagLists
-	Host                    string
-	FilesFrom               []string
-	FilesFromVerbatim       []string
-	FilesFromRaw            []string
-	TimeStamp               string
-	WithAtime               bool
-	IgnoreInode             bool
-	IgnoreCtime             bool
-	UseFsSnapshot           bool
-	DryRun                  bool
+	excludePatternOptions
+
+	Parent             string
+	Force              bool
+	ExcludeOtherFS     bool
+	ExcludeIfPresent   []string
+	ExcludeCaches      bool
+	ExcludeLargerThan  string
+	ExcludeIgnoreFiles []string
+	ExcludeGitignore   bool
+	Stdin              bool
+	StdinFilename      string
+	StdinFromCommand   bool
+	StdinCommands      []string
+	FromTar            string
+	Tags               restic.TagLists
+	Host               string
+	FilesFrom          []string
+	FilesFromVerbatim  []string
+	FilesFromRaw       []string
+	TimeStamp          string
+	WithAtime          bool
+	IgnoreInode        bool
+	IgnoreCtime        bool
+	UseFsSnapshot      bool
+	DryRun             bool
 }
 
 var backupOptions BackupOptions
@@ -105,16 +109,18 @@ func init() {
 	f := cmdBackup.Flags()
 	f.StringVar(&backupOptions.Parent, "parent", "", "use this parent `snapshot` (default: last snapshot in the repo that has the same target files/directories)")
 	f.BoolVarP(&backupOptions.Force, "force", "f", false, `force re-reading the target files/directories (overrides the "parent" flag)`)
-	f.StringArrayVarP(&backupOptions.Excludes, "exclude", "e", nil, "exclude a `pattern` (can be specified multiple times)")
-	f.StringArrayVar(&backupOptions.InsensitiveExcludes, "iexclude", nil, "same as --exclude `pattern` but ignores the casing of filenames")
-	f.StringArrayVar(&backupOptions.ExcludeFiles, "exclude-file", nil, "read exclude patterns from a `file` (can be specified multiple times)")
-	f.StringArrayVar(&backupOptions.InsensitiveExcludeFiles, "iexclude-file", nil, "same as --exclude-file but ignores casing of `file`names in patterns")
+	initExcludePatternOptions(f, &backupOptions.excludePatternOptions)
 	f.BoolVarP(&backupOptions.ExcludeOtherFS, "one-file-system", "x", false, "exclude other file systems, don't cross filesystem boundaries and subvolumes")
 	f.StringArrayVar(&backupOptions.ExcludeIfPresent, "exclude-if-present", nil, "takes `filename[:header]`, exclude contents of directories containing filename (except filename itself) if header of that file is as provided (can be specified multiple times)")
 	f.BoolVar(&backupOptions.ExcludeCaches, "exclude-caches", false, `excludes cache directories that are marked with a CACHEDIR.TAG file. See https://bford.info/cachedir/ for the Cache Directory Tagging Standard`)
 	f.StringVar(&backupOptions.ExcludeLargerThan, "exclude-larger-than", "", "max `size` of the files to be backed up (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.StringArrayVar(&backupOptions.ExcludeIgnoreFiles, "exclude-ignore-file", nil, "takes `filename`, exclude items listed in gitignore-style ignore files with this name found in the scanned directories (can be specified multiple times)")
+	f.BoolVar(&backupOptions.ExcludeGitignore, "exclude-gitignore", false, "exclude items listed in .gitignore files found in the scanned directories")
 	f.BoolVar(&backupOptions.Stdin, "stdin", false, "read backup from stdin")
-	f.StringVar(&backupOptions.StdinFilename, "stdin-filename", "stdin", "`filename` to use when reading from stdin")
+	f.StringVar(&backupOptions.StdinFilename, "stdin-filename", "stdin", "`filename` to use when reading from stdin or from a command")
+	f.BoolVar(&backupOptions.StdinFromCommand, "stdin-from-command", false, "execute the command given as arguments and read the backup from its stdout")
+	f.StringArrayVar(&backupOptions.StdinCommands, "stdin-command", nil, "execute `filename=command` and store its stdout as filename (can be specified multiple times)")
+	f.StringVar(&backupOptions.FromTar, "from-tar", "", "read the backup from the tar archive `file`, or from stdin if file is \"-\" (stdin is copied to a temporary file in --cache-dir or $TMPDIR)")
 	f.Var(&backupOptions.Tags, "tag", "add `tags` for the new snapshot in the format `tag[,tag,...]` (can be specified multiple times)")
 
 	f.StringVarP(&backupOptions.Host, "host", "H", "", "set the `hostname` for the snapshot manually. To prevent an expensive rescan use the \"parent\" flag")
@@ -258,6 +264,9 @@ func (opts BackupOptions) Check(gopts GlobalOptions, args []string) error {
 				return errors.Fatal("unable to read password from stdin when data is to be read from stdin, use --password-file or $RESTIC_PASSWORD")
 			}
 		}
+		if opts.FromTar == "-" {
+			return errors.Fatal("unable to read password from stdin when data is to be read from stdin, use --password-file or $RESTIC_PASSWORD")
+		}
 	}
 
 	if opts.Stdin {
@@ -276,46 +285,163 @@ func (opts BackupOptions) Check(gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	if opts.StdinFromCommand || len(opts.StdinCommands) > 0 {
+		if opts.Stdin {
+			return errors.Fatal("--stdin cannot be used together with --stdin-from-command or --stdin-command")
+		}
+		if len(opts.FilesFrom) > 0 || len(opts.FilesFromVerbatim) > 0 || len(opts.FilesFromRaw) > 0 {
+			return errors.Fatal("--files-from cannot be used together with --stdin-from-command or --stdin-command")
+		}
+
+		if opts.StdinFromCommand && len(args) == 0 {
+			return errors.Fatal("--stdin-from-command was specified without a command")
+		}
+		if !opts.StdinFromCommand && len(args) > 0 {
+			return errors.Fatal("--stdin-command was specified and files/dirs were listed as arguments")
+		}
+	}
+
+	if opts.FromTar != "" {
+		if opts.Stdin || opts.StdinFromCommand || len(opts.StdinCommands) > 0 {
+			return errors.Fatal("--from-tar cannot be used together with --stdin, --stdin-from-command or --stdin-command")
+		}
+		if len(opts.FilesFrom) > 0 || len(opts.FilesFromVerbatim) > 0 || len(opts.FilesFromRaw) > 0 {
+			return errors.Fatal("--from-tar cannot be used together with --files-from")
+		}
+		if len(args) > 0 {
+			return errors.Fatal("--from-tar was specified and files/dirs were listed as arguments")
+		}
+	}
+
 	return nil
 }
 
-// collectRejectByNameFuncs returns a list of all functions which may reject data
-// from being saved in a snapshot based on path only
-func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, targets []string) (fs []RejectByNameFunc, err error) {
-	// exclude restic cache
-	if repo.Cache != nil {
-		f, err := rejectResticCache(repo)
+// readsFromFilesystem returns false if the data to back up is read from stdin,
+// from the output of commands or from a tar archive.
+func (opts BackupOptions) readsFromFilesystem() bool {
+	return !opts.Stdin && !opts.StdinFromCommand && len(opts.StdinCommands) == 0 && opts.FromTar == ""
+}
+
+// openTar opens the tar archive filename, or reads it from stdin if filename
+// is "-". As the archive must be seekable, it is first copied to a temporary
+// file in tempDir in that case, the default directory for temporary files is
+// used if tempDir is empty. The returned function closes the archive.
+func openTar(filename, tempDir string) (*fs.Tar, func(), error) {
+	var f *os.File
+	var cleanup func()
+
+	if filename == "-" {
+		if tempDir != "" {
+			err := fs.MkdirAll(tempDir, 0700)
+			if err != nil {
+				return nil, nil, errors.Fatalf("unable to create directory for the tar archive: %v", err)
+			}
+		}
+
+		tmp, err := ioutil.TempFile(tempDir, "restic-tar-")
 		if err != nil {
-			return nil, err
+			return nil, nil, errors.Fatalf("unable to create temporary file for the tar archive: %v", err)
+		}
+		debug.Log("copying tar archive from stdin to %v", tmp.Name())
+		cleanup = func() {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
 		}
 
-		fs = append(fs, f)
+		_, err = io.Copy(tmp, os.Stdin)
+		if err != nil {
+			cleanup()
+			return nil, nil, errors.Fatalf("unable to read tar archive from stdin: %v", err)
+		}
+		f = tmp
+	} else {
+		var err error
+		f, err = os.Open(filename)
+		if err != nil {
+			return nil, nil, errors.Fatalf("unable to open tar archive: %v", err)
+		}
+		cleanup = func() {
+			_ = f.Close()
+		}
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		cleanup()
+		return nil, nil, errors.Fatalf("unable to open tar archive: %v", err)
 	}
 
-	// add patterns from file
-	if len(opts.ExcludeFiles) > 0 {
-		excludes, err := readExcludePatternsFromFiles(opts.ExcludeFiles)
+	tarFS, err := fs.NewTar(f, fi.Size())
+	if err != nil {
+		cleanup()
+		return nil, nil, errors.Fatalf("unable to read tar archive %v: %v", filename, err)
+	}
+
+	return tarFS, cleanup, nil
+}
+
+// stdinCommand is a command whose stdout is saved as a file.
+type stdinCommand struct {
+	filename string
+	args     []string
+}
+
+// collectStdinCommands returns the commands which are executed for
+// --stdin-from-command and --stdin-command.
+func collectStdinCommands(opts BackupOptions, args []string) ([]stdinCommand, error) {
+	var commands []stdinCommand
+	if opts.StdinFromCommand {
+		commands = append(commands, stdinCommand{filename: opts.StdinFilename, args: args})
+	}
+
+	for _, spec := range opts.StdinCommands {
+		i := strings.Index(spec, "=")
+		if i <= 0 {
+			return nil, errors.Fatalf("invalid --stdin-command %q, expected filename=command", spec)
+		}
+
+		cmdArgs, err := backend.SplitShellStrings(spec[i+1:])
 		if err != nil {
-			return nil, err
+			return nil, errors.Fatalf("invalid --stdin-command %q: %v", spec, err)
 		}
-		opts.Excludes = append(opts.Excludes, excludes...)
+		if len(cmdArgs) == 0 {
+			return nil, errors.Fatalf("invalid --stdin-command %q: no command specified", spec)
+		}
+
+		commands = append(commands, stdinCommand{filename: spec[:i], args: cmdArgs})
+	}
+
+	seen := make(map[string]struct{}, len(commands))
+	for i := range commands {
+		filename := path.Join("/", commands[i].filename)
+		if _, ok := seen[filename]; ok {
+			return nil, errors.Fatalf("filename %q is used for more than one command", commands[i].filename)
+		}
+		seen[filename] = struct{}{}
+		commands[i].filename = filename
 	}
 
-	if len(opts.InsensitiveExcludeFiles) > 0 {
-		excludes, err := readExcludePatternsFromFiles(opts.InsensitiveExcludeFiles)
+	return commands, nil
+}
+
+// collectRejectByNameFuncs returns a list of all functions which may reject data
+// from being saved in a snapshot based on path only
+func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, targets []string) (fs []RejectByNameFunc, err error) {
+	// exclude restic cache
+	if repo.Cache != nil {
+		f, err := rejectResticCache(repo)
 		if err != nil {
 			return nil, err
 		}
-		opts.InsensitiveExcludes = append(opts.InsensitiveExcludes, excludes...)
-	}
 
-	if len(opts.InsensitiveExcludes) > 0 {
-		fs = append(fs, rejectByInsensitivePattern(opts.InsensitiveExcludes))
+		fs = append(fs, f)
 	}
 
-	if len(opts.Excludes) > 0 {
-		fs = append(fs, rejectByPattern(opts.Excludes))
+	fsPatterns, err := collectExcludePatterns(opts.excludePatternOptions)
+	if err != nil {
+		return nil, err
 	}
+	fs = append(fs, fsPatterns...)
 
 	if opts.ExcludeCaches {
 		opts.ExcludeIfPresent = append(opts.ExcludeIfPresent, "CACHEDIR.TAG:Signature: 8a477f597d28d172789f06886806bc55")
@@ -337,7 +463,7 @@ func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, t
 // from being saved in a snapshot based on path and file info
 func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, targets []string) (fs []RejectFunc, err error) {
 	// allowed devices
-	if opts.ExcludeOtherFS && !opts.Stdin {
+	if opts.ExcludeOtherFS && opts.readsFromFilesystem() {
 		f, err := rejectByDevice(targets)
 		if err != nil {
 			return nil, err
@@ -345,7 +471,7 @@ func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, targets
 		fs = append(fs, f)
 	}
 
-	if len(opts.ExcludeLargerThan) != 0 && !opts.Stdin {
+	if len(opts.ExcludeLargerThan) != 0 && opts.readsFromFilesystem() {
 		f, err := rejectBySize(opts.ExcludeLargerThan)
 		if err != nil {
 			return nil, err
@@ -353,59 +479,27 @@ func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, targets
 		fs = append(fs, f)
 	}
 
-	return fs, nil
-}
-
-// readExcludePatternsFromFiles reads all exclude files and returns the list of
-// exclude patterns. For each line, leading and trailing white space is removed
-// and comment lines are ignored. For each remaining pattern, environment
-// variables are resolved. For adding a literal dollar sign ($), write $$ to
-// the file.
-func readExcludePatternsFromFiles(excludeFiles []string) ([]string, error) {
-	getenvOrDollar := func(s string) string {
-		if s == "$" {
-			return "$"
-		}
-		return os.Getenv(s)
+	ignoreFiles := opts.ExcludeIgnoreFiles
+	if opts.ExcludeGitignore {
+		// evaluate .gitignore first, so that restic-specific ignore files
+		// take precedence
+		ignoreFiles = append([]string{".gitignore"}, ignoreFiles...)
 	}
 
-	var excludes []string
-	for _, filename := range excludeFiles {
-		err := func() (err error) {
-			data, err := textfile.Read(filename)
-			if err != nil {
-				return err
-			}
-
-			scanner := bufio.NewScanner(bytes.NewReader(data))
-			for scanner.Scan() {
-				line := strings.TrimSpace(scanner.Text())
-
-				// ignore empty lines
-				if line == "" {
-					continue
-				}
-
-				// strip comments
-				if strings.HasPrefix(line, "#") {
-					continue
-				}
-
-				line = os.Expand(line, getenvOrDollar)
-				excludes = append(excludes, line)
-			}
-			return scanner.Err()
-		}()
+	if len(ignoreFiles) > 0 && opts.readsFromFilesystem() {
+		f, err := rejectByIgnoreFile(ignoreFiles, targets)
 		if err != nil {
 			return nil, err
 		}
+		fs = append(fs, f)
 	}
-	return excludes, nil
+
+	return fs, nil
 }
 
 // collectTargets returns a list of target files/dirs from several sources.
 func collectTargets(opts BackupOptions, args []string) (targets []string, err error) {
-	if opts.Stdin {
+	if !opts.readsFromFilesystem() {
 		return nil, nil
 	}
 
@@ -458,7 +552,7 @@ func collectTargets(opts BackupOptions, args []string) (targets []string, err er
 	// Merge args into files-from so we can reuse the normal args checks
 	// and have the ability to use both files-from and args at the same time.
 	targets = append(targets, args...)
-	if len(targets) == 0 && !opts.Stdin {
+	if len(targets) == 0 {
 		return nil, errors.Fatal("nothing to backup, please specify target files/dirs")
 	}
 
@@ -507,6 +601,17 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		return err
 	}
 
+	commands, err := collectStdinCommands(opts, args)
+	if err != nil {
+		return err
+	}
+
+	if opts.FromTar != "" {
+		// files in a tar archive have no reliable inode or ctime, so a
+		// parent snapshot cannot tell whether their content has changed
+		opts.Force = true
+	}
+
 	timeStamp := time.Now()
 	if opts.TimeStamp != "" {
 		timeStamp, err = time.ParseInLocation(TimeFormat, opts.TimeStamp, time.Local)
@@ -571,12 +676,24 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		return err
 	}
 
-	if !gopts.JSON {
-		progressPrinter.V("load index files")
-	}
-	err = repo.LoadIndex(gopts.ctx)
-	if err != nil {
-		return err
+	if repo.WriteOnly() {
+		// index files and snapshots cannot be read with a write-only key, so
+		// all files are read and all data is uploaded again
+		if opts.Parent != "" && !opts.Force {
+			return errors.Fatal("--parent cannot be used with a write-only key")
+		}
+		if !gopts.JSON {
+			progressPrinter.P("using a write-only key, all files are read and all data is uploaded again\n")
+		}
+		opts.Force = true
+	} else {
+		if !gopts.JSON {
+			progressPrinter.V("load index files")
+		}
+		err = repo.LoadIndex(gopts.ctx)
+		if err != nil {
+			return err
+		}
 	}
 
 	parentSnapshotID, err := findParentSnapshot(gopts.ctx, repo, opts, targets)
@@ -643,6 +760,65 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		}
 		targets = []string{filename}
 	}
+	if len(commands) > 0 {
+		var readers []*fs.CommandReader
+		defer func() {
+			// terminate all commands whose output has not been read
+			for _, rd := range readers {
+				_ = rd.Close()
+			}
+		}()
+
+		readersFS := &fs.Readers{}
+		for _, c := range commands {
+			if !gopts.JSON {
+				progressPrinter.V("read data from command %q", strings.Join(c.args, " "))
+			}
+			rd, err := fs.NewCommandReader(gopts.ctx, c.args, gopts.stderr)
+			if err != nil {
+				return errors.Fatal(err.Error())
+			}
+			readers = append(readers, rd)
+
+			readersFS.Files = append(readersFS.Files, &fs.Reader{
+				ModTime:        timeStamp,
+				Name:           c.filename,
+				Mode:           0644,
+				ReadCloser:     rd,
+				AllowEmptyFile: true,
+			})
+			targets = append(targets, c.filename)
+		}
+		targetFS = readersFS
+	}
+	if opts.FromTar != "" {
+		if !gopts.JSON {
+			progressPrinter.V("read data from tar archive %v", opts.FromTar)
+		}
+		tarFS, closeTar, err := openTar(opts.FromTar, gopts.CacheDir)
+		if err != nil {
+			return err
+		}
+		defer closeTar()
+
+		root, err := tarFS.Open("/")
+		if err != nil {
+			return err
+		}
+		names, err := root.Readdirnames(-1)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return errors.Fatal("nothing to backup, the tar archive is empty")
+		}
+
+		targets = nil
+		for _, name := range names {
+			targets = append(targets, path.Join("/", name))
+		}
+		targetFS = tarFS
+	}
 
 	sc := archiver.NewScanner(targetFS)
 	sc.SelectByName = selectByNameFilter
@@ -662,7 +838,13 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	success := true
 	arch.Error = func(item string, fi os.FileInfo, err error) error {
 		success = false
-		return progressReporter.Error(item, fi, err)
+		reterr := progressReporter.Error(item, fi, err)
+		if reterr == nil && len(commands) > 0 {
+			// the output of a failed command is incomplete, abort the
+			// snapshot instead of saving it
+			reterr = err
+		}
+		return reterr
 	}
 	arch.CompleteItem = progressReporter.CompleteItem
 	arch.StartFile = progressReporter.StartFile
@@ -713,7 +895,19 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	if !success {
 		return ErrInvalidSourceData
 	}
+	if werr != nil {
+		return werr
+	}
+
+	// upload files which were spooled by earlier runs, this requires an
+	// exclusive lock
+	if !opts.DryRun && gopts.SpoolDir != "" {
+		unlockRepo(lock)
+		_, err = flushSpool(gopts, repo)
+		if err != nil {
+			Warnf("%v\n", err)
+		}
+	}
 
-	// Return error if any
-	return werr
+	return nil
 }
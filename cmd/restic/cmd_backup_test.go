@@ -1,6 +1,7 @@
 package main
 
 import (
+	"archive/tar"
 	"bytes"
 	"fmt"
 	"os"
@@ -111,3 +112,45 @@ func TestReadFilenamesRaw(t *testing.T) {
 	rtest.Assert(t, strings.Contains(err.Error(), "zero byte"),
 		"wrong error message: %v", err.Error())
 }
+
+func TestOpenTarStdin(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	archive := filepath.Join(dir, "archive.tar")
+	f, err := os.Create(archive)
+	rtest.OK(t, err)
+	tw := tar.NewWriter(f)
+	rtest.OK(t, tw.WriteHeader(&tar.Header{Name: "file", Typeflag: tar.TypeReg, Mode: 0600, Size: 4}))
+	_, err = tw.Write([]byte("data"))
+	rtest.OK(t, err)
+	rtest.OK(t, tw.Close())
+	rtest.OK(t, f.Close())
+
+	stdin, err := os.Open(archive)
+	rtest.OK(t, err)
+	defer func() {
+		_ = stdin.Close()
+	}()
+	oldStdin := os.Stdin
+	os.Stdin = stdin
+	defer func() {
+		os.Stdin = oldStdin
+	}()
+
+	// the archive is copied to the given directory, which is created
+	tempDir := filepath.Join(dir, "cache")
+	tarFS, closeTar, err := openTar("-", tempDir)
+	rtest.OK(t, err)
+
+	_, err = tarFS.Lstat("/file")
+	rtest.OK(t, err)
+
+	tmpFiles, err := filepath.Glob(filepath.Join(tempDir, "restic-tar-*"))
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(tmpFiles))
+
+	closeTar()
+	_, err = os.Stat(tmpFiles[0])
+	rtest.Assert(t, os.IsNotExist(err), "temporary file was not removed: %v", err)
+}
@@ -121,6 +121,10 @@ func runCat(gopts GlobalOptions, args []string) error {
 		Println(string(buf))
 		return nil
 	case "masterkey":
+		if repo.WriteOnly() {
+			return repository.ErrWriteOnly
+		}
+
 		buf, err := json.MarshalIndent(repo.Key(), "", "  ")
 		if err != nil {
 			return err
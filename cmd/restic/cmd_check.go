@@ -9,6 +9,10 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mirror"
+	"github.com/restic/restic/internal/backend/spool"
+	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/checker"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
@@ -45,6 +49,7 @@ type CheckOptions struct {
 	ReadDataSubset string
 	CheckUnused    bool
 	WithCache      bool
+	CheckMirrors   bool
 }
 
 var checkOptions CheckOptions
@@ -57,6 +62,7 @@ func init() {
 	f.StringVar(&checkOptions.ReadDataSubset, "read-data-subset", "", "read a `subset` of data packs, specified as 'n/t' for specific subset or either 'x%' or 'x.y%' for random subset")
 	f.BoolVar(&checkOptions.CheckUnused, "check-unused", false, "find unused blobs")
 	f.BoolVar(&checkOptions.WithCache, "with-cache", false, "use the cache")
+	f.BoolVar(&checkOptions.CheckMirrors, "check-mirrors", false, "report files which are missing in some of the mirrors")
 }
 
 func checkFlags(opts CheckOptions) error {
@@ -239,6 +245,23 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		Verbosef("%d additional files were found in the repo, which likely contain duplicate data.\nYou can run `restic prune` to correct this.\n", orphanedPacks)
 	}
 
+	if opts.CheckMirrors {
+		mbe := findMirror(repo.Backend())
+		if mbe == nil {
+			return errors.Fatal("--check-mirrors needs at least one mirror, specified via --mirror")
+		}
+
+		Verbosef("check mirrors\n")
+		err = mbe.CheckReplicas(gopts.ctx, func(replica string, h restic.Handle) error {
+			errorsFound = true
+			Warnf("%v is missing in mirror %v\n", h, replica)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	Verbosef("check snapshots, trees and blobs\n")
 	errChan = make(chan error)
 	go func() {
@@ -314,6 +337,27 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 	return nil
 }
 
+// findMirror returns the mirror backend wrapped by be, or nil if the
+// repository is not mirrored.
+func findMirror(be restic.Backend) *mirror.Backend {
+	for {
+		switch b := be.(type) {
+		case *mirror.Backend:
+			return b
+		case *backend.RetryBackend:
+			be = b.Backend
+		case *cache.Backend:
+			be = b.Backend
+		case *spool.Backend:
+			be = b.Backend
+		case interface{ Unwrap() restic.Backend }:
+			be = b.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
 // selectPacksByBucket selects subsets of packs by ranges of buckets.
 func selectPacksByBucket(allPacks map[restic.ID]int64, bucket, totalBuckets uint) map[restic.ID]int64 {
 	packs := make(map[restic.ID]int64)
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/restic/restic/internal/backend/archive"
+	"github.com/restic/restic/internal/backend/location"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdCompact = &cobra.Command{
+	Use:   "compact",
+	Short: "Free the space of removed files in a single-file repository",
+	Long: `
+The "compact" command rewrites a repository stored in a single file
+("archive:" location) without the space used by removed files. Files removed
+by "forget" and "prune" only free their space in the file after it has been
+compacted. The file is rewritten to a temporary copy first, so enough free space
+for the remaining data is needed.
+
+The repository must not be used by other restic processes while it is
+compacted, the command refuses to run if the repository is locked.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCompact(globalOptions)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdCompact)
+}
+
+func runCompact(gopts GlobalOptions) error {
+	repo, err := ReadRepo(gopts)
+	if err != nil {
+		return err
+	}
+
+	loc, err := location.Parse(repo)
+	if err != nil {
+		return errors.Fatalf("parsing repository location failed: %v", err)
+	}
+	if loc.Scheme != "archive" {
+		return errors.Fatal("compact is only supported for archive repositories")
+	}
+
+	cfg, err := parseConfig(loc, gopts.extended)
+	if err != nil {
+		return err
+	}
+
+	be, err := archive.Open(gopts.ctx, cfg.(archive.Config))
+	if err != nil {
+		return errors.Fatalf("unable to open repo at %v: %v", repo, err)
+	}
+	defer func() {
+		_ = be.Close()
+	}()
+
+	locks := 0
+	err = be.List(gopts.ctx, restic.LockFile, func(restic.FileInfo) error {
+		locks++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if locks > 0 {
+		return errors.Fatalf("repository is locked by %d lock(s), use the \"unlock\" command to remove stale locks", locks)
+	}
+
+	before, used := be.Size()
+	Verbosef("compacting %v, %s of %s are used\n", repo, formatBytes(uint64(used)), formatBytes(uint64(before)))
+
+	err = be.Compact(gopts.ctx)
+	if err != nil {
+		return err
+	}
+
+	after, _ := be.Size()
+	Printf("reduced size from %s to %s\n", formatBytes(uint64(before)), formatBytes(uint64(after)))
+	return be.Close()
+}
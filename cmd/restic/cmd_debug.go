@@ -330,8 +330,7 @@ func loadBlobs(ctx context.Context, repo restic.Repository, pack restic.ID, list
 
 		key := repo.Key()
 
-		nonce, plaintext := buf[:key.NonceSize()], buf[key.NonceSize():]
-		plaintext, err = key.Open(plaintext[:0], nonce, plaintext, nil)
+		plaintext, err := repository.DecryptBlob(key, blob, buf)
 		if err != nil {
 			Warnf("error decrypting blob: %v\n", err)
 			var plain []byte
@@ -527,7 +526,7 @@ func checkPackSize(blobs []restic.Blob, fileSize int64) {
 	})
 
 	for _, pb := range blobs {
-		Printf("      %v blob %v, offset %-6d, raw length %-6d\n", pb.Type, pb.ID, pb.Offset, pb.Length)
+		Printf("      %v blob %v, offset %-6d, raw length %-6d, uncompressed length %-6d\n", pb.Type, pb.ID, pb.Offset, pb.Length, pb.UncompressedLength)
 		if offset != uint64(pb.Offset) {
 			Printf("      hole in file, want offset %v, got %v\n", offset, pb.Offset)
 		}
@@ -535,10 +534,8 @@ func checkPackSize(blobs []restic.Blob, fileSize int64) {
 		size += uint64(pb.Length)
 	}
 
-	// compute header size, per blob: 1 byte type, 4 byte length, 32 byte id
-	size += uint64(restic.CiphertextLength(len(blobs) * (1 + 4 + 32)))
-	// length in uint32 little endian
-	size += 4
+	// compute header size, including the length in uint32 little endian
+	size += uint64(pack.CalculateHeaderSize(blobs))
 
 	if uint64(fileSize) != size {
 		Printf("      file sizes do not match: computed %v from index, file size is %v\n", size, fileSize)
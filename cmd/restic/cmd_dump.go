@@ -44,6 +44,7 @@ type DumpOptions struct {
 	Paths   []string
 	Tags    restic.TagLists
 	Archive string
+	Sparse  bool
 }
 
 var dumpOptions DumpOptions
@@ -56,6 +57,7 @@ func init() {
 	flags.Var(&dumpOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
 	flags.StringArrayVar(&dumpOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
 	flags.StringVarP(&dumpOptions.Archive, "archive", "a", "tar", "set archive `format` as \"tar\" or \"zip\"")
+	flags.BoolVar(&dumpOptions.Sparse, "sparse", false, "write files containing runs of zeros as sparse files (tar only)")
 }
 
 func splitPath(p string) []string {
@@ -129,10 +131,17 @@ func runDump(opts DumpOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("no file and no snapshot ID specified")
 	}
 
+	if opts.Sparse && opts.Archive != "tar" {
+		return errors.Fatal("--sparse is only supported for the tar archive format")
+	}
+
 	var wd dump.WriteDump
 	switch opts.Archive {
 	case "tar":
 		wd = dump.WriteTar
+		if opts.Sparse {
+			wd = dump.WriteTarSparse
+		}
 	case "zip":
 		wd = dump.WriteZip
 	default:
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/backend/spool"
+	"github.com/restic/restic/internal/cache"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdFlushSpool = &cobra.Command{
+	Use:   "flush-spool",
+	Short: "Upload files stored in the spool directory",
+	Long: `
+The "flush-spool" command uploads the files which were stored in the spool
+directory (--spool-dir) while the repository was unreachable. This also happens
+automatically after a successful backup. The repository is locked exclusively
+while the files are uploaded.
+
+Before an index or snapshot file is uploaded, restic verifies that all data it
+references is still contained in the repository. The data may have been removed
+by "prune" in the meantime, the affected files then remain in the spool
+directory and the backup must be repeated.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runFlushSpool(globalOptions)
+	},
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdFlushSpool)
+}
+
+func runFlushSpool(gopts GlobalOptions) error {
+	if gopts.SpoolDir == "" {
+		return errors.Fatal("no spool directory specified, use --spool-dir")
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	sbe := spoolBackend(repo.Backend())
+	if sbe.Offline() {
+		n, err := sbe.Pending(gopts.ctx)
+		if err != nil {
+			return err
+		}
+		return errors.Fatalf("repository is unreachable, %d files remain in the spool directory", n)
+	}
+
+	_, err = flushSpool(gopts, repo)
+	if err != nil {
+		return err
+	}
+
+	Verbosef("all spooled files have been uploaded\n")
+	return nil
+}
+
+// spoolBackend returns the spool backend used by the repository, or nil.
+func spoolBackend(be restic.Backend) *spool.Backend {
+	for {
+		switch b := be.(type) {
+		case *spool.Backend:
+			return b
+		case *cache.Backend:
+			be = b.Backend
+		default:
+			return nil
+		}
+	}
+}
+
+// flushSpool uploads the files in the spool directory of the repository while
+// holding an exclusive lock, so that no data referenced by the spooled files
+// can be removed concurrently. It returns the number of uploaded files.
+func flushSpool(gopts GlobalOptions, repo *repository.Repository) (int, error) {
+	sbe := spoolBackend(repo.Backend())
+	if sbe == nil || sbe.Offline() {
+		return 0, nil
+	}
+
+	n, err := sbe.Pending(gopts.ctx)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	if repo.WriteOnly() {
+		return 0, errors.Fatal("spooled files cannot be verified with a write-only key, use a key which can read the repository")
+	}
+
+	lock, err := lockRepoExclusive(gopts.ctx, repo)
+	defer unlockRepo(lock)
+	if err != nil {
+		return 0, err
+	}
+
+	v := &spoolVerifier{repo: repo}
+
+	Verbosef("uploading %d files from spool directory %v\n", n, sbe.Dir)
+	uploaded, err := sbe.Flush(gopts.ctx, func(h restic.Handle) error {
+		return v.verify(gopts.ctx, h)
+	}, func(h restic.Handle) {
+		Verboseff("uploaded %v\n", h)
+	})
+	if err != nil {
+		return uploaded, errors.Fatalf("unable to upload spooled files: %v", err)
+	}
+
+	return uploaded, nil
+}
+
+// spoolVerifier checks that the data referenced by spooled index and snapshot
+// files is contained in the repository.
+type spoolVerifier struct {
+	repo *repository.Repository
+
+	// packs contains the pack files in the repository, it is listed after
+	// the spooled pack files have been uploaded
+	packs restic.IDSet
+
+	// indexLoaded is set once the index files of the repository were loaded
+	// for verifying snapshots
+	indexLoaded bool
+}
+
+func (v *spoolVerifier) verify(ctx context.Context, h restic.Handle) error {
+	id, err := restic.ParseID(h.Name)
+	if err != nil {
+		return err
+	}
+
+	if v.packs == nil {
+		v.packs = restic.NewIDSet()
+		err = v.repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+			v.packs.Insert(id)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	switch h.Type {
+	case restic.IndexFile:
+		return v.verifyIndex(ctx, id)
+	case restic.SnapshotFile:
+		return v.verifySnapshot(ctx, id)
+	}
+	return nil
+}
+
+// verifyIndex checks that all pack files listed in the index exist.
+func (v *spoolVerifier) verifyIndex(ctx context.Context, id restic.ID) error {
+	buf, err := v.repo.LoadAndDecrypt(ctx, nil, restic.IndexFile, id)
+	if err != nil {
+		return err
+	}
+
+	idx, _, err := repository.DecodeIndex(buf, id)
+	if err != nil {
+		return err
+	}
+
+	for packID := range idx.Packs() {
+		if !v.packs.Has(packID) {
+			return errors.Errorf("pack %v is missing in the repository", packID.Str())
+		}
+	}
+	return nil
+}
+
+// verifySnapshot checks that all blobs referenced by the snapshot, including
+// blobs which were deduplicated against older data, are contained in pack
+// files which exist.
+func (v *spoolVerifier) verifySnapshot(ctx context.Context, id restic.ID) error {
+	if !v.indexLoaded {
+		// the index must be loaded while holding the exclusive lock, an
+		// index loaded before may reference removed pack files
+		mi := repository.NewMasterIndex()
+		err := repository.ForAllIndexes(ctx, v.repo, func(id restic.ID, idx *repository.Index, oldFormat bool, err error) error {
+			if err != nil {
+				return err
+			}
+			mi.Insert(idx)
+			return nil
+		})
+		if err == nil {
+			err = mi.MergeFinalIndexes()
+		}
+		if err == nil {
+			err = v.repo.SetIndex(mi)
+		}
+		if err != nil {
+			return err
+		}
+		v.indexLoaded = true
+	}
+
+	sn, err := restic.LoadSnapshot(ctx, v.repo, id)
+	if err != nil {
+		return err
+	}
+	if sn.Tree == nil {
+		return errors.Errorf("snapshot %v has no tree", id.Str())
+	}
+
+	blobs := restic.NewBlobSet()
+	err = restic.FindUsedBlobs(ctx, v.repo, restic.IDs{*sn.Tree}, blobs, nil)
+	if err != nil {
+		return err
+	}
+
+	for bh := range blobs {
+		found := false
+		for _, pb := range v.repo.Index().Lookup(bh) {
+			if v.packs.Has(pb.PackID) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Errorf("%v is missing in the repository", bh)
+		}
+	}
+	return nil
+}
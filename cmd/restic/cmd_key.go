@@ -16,11 +16,27 @@ import (
 )
 
 var cmdKey = &cobra.Command{
-	Use:   "key [flags] [list|add|remove|passwd] [ID]",
+	Use:   "key [flags] [list|add|remove|passwd|rotate-master] [ID]",
 	Short: "Manage keys (passwords)",
 	Long: `
 The "key" command manages keys (passwords) for accessing the repository.
 
+Keys added with --write-only can only be used to create new backups. Data
+saved with them can only be read with a normal key. This requires that the
+repository was prepared with "restic migrate enable_write_only_keys". Since a
+write-only key cannot read the index, "backup" reads all files and uploads all
+data again on each run. Keys cannot be added, changed or removed with a
+write-only key.
+
+The "rotate-master" subcommand replaces the master key of the repository.
+All pack, index and snapshot files are encrypted again with the new master
+key and the old files are removed afterwards. All keys which can be opened
+with the repository password or one of the passwords given with
+--other-password-file are replaced by keys for the new master key. Keys
+which cannot be opened are removed if --remove-unknown-keys is specified,
+otherwise the command refuses to run. If the command is interrupted, run
+it again with the same password to continue.
+
 EXIT STATUS
 ===========
 
@@ -36,6 +52,10 @@ var (
 	newPasswordFile string
 	keyUsername     string
 	keyHostname     string
+	keyWriteOnly    bool
+
+	keyOtherPasswordFiles []string
+	keyRemoveUnknown      bool
 )
 
 func init() {
@@ -45,15 +65,19 @@ func init() {
 	flags.StringVarP(&newPasswordFile, "new-password-file", "", "", "`file` from which to read the new password")
 	flags.StringVarP(&keyUsername, "user", "", "", "the username for new keys")
 	flags.StringVarP(&keyHostname, "host", "", "", "the hostname for new keys")
+	flags.BoolVarP(&keyWriteOnly, "write-only", "", false, "add a key which can only be used to create new backups")
+	flags.StringArrayVarP(&keyOtherPasswordFiles, "other-password-file", "", nil, "`file` containing the password of another key to keep for rotate-master (can be specified multiple times)")
+	flags.BoolVarP(&keyRemoveUnknown, "remove-unknown-keys", "", false, "remove keys which cannot be opened with any password for rotate-master")
 }
 
 func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions) error {
 	type keyInfo struct {
-		Current  bool   `json:"current"`
-		ID       string `json:"id"`
-		UserName string `json:"userName"`
-		HostName string `json:"hostName"`
-		Created  string `json:"created"`
+		Current   bool   `json:"current"`
+		ID        string `json:"id"`
+		UserName  string `json:"userName"`
+		HostName  string `json:"hostName"`
+		Created   string `json:"created"`
+		WriteOnly bool   `json:"writeOnly"`
 	}
 
 	var keys []keyInfo
@@ -66,11 +90,12 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 		}
 
 		key := keyInfo{
-			Current:  id.String() == s.KeyName(),
-			ID:       id.Str(),
-			UserName: k.Username,
-			HostName: k.Hostname,
-			Created:  k.Created.Local().Format(TimeFormat),
+			Current:   id.String() == s.KeyName(),
+			ID:        id.Str(),
+			UserName:  k.Username,
+			HostName:  k.Hostname,
+			Created:   k.Created.Local().Format(TimeFormat),
+			WriteOnly: k.WriteOnly,
 		}
 
 		keys = append(keys, key)
@@ -90,6 +115,7 @@ func listKeys(ctx context.Context, s *repository.Repository, gopts GlobalOptions
 	tab.AddColumn("User", "{{ .UserName }}")
 	tab.AddColumn("Host", "{{ .HostName }}")
 	tab.AddColumn("Created", "{{ .Created }}")
+	tab.AddColumn("Write-Only", "{{if .WriteOnly}}yes{{end}}")
 
 	for _, key := range keys {
 		tab.AddRow(key)
@@ -126,7 +152,7 @@ func addKey(gopts GlobalOptions, repo *repository.Repository) error {
 		return err
 	}
 
-	id, err := repository.AddKey(gopts.ctx, repo, pw, keyUsername, keyHostname, repo.Key())
+	id, err := newKey(gopts.ctx, repo, pw, keyUsername, keyHostname, keyWriteOnly)
 	if err != nil {
 		return errors.Fatalf("creating new key failed: %v\n", err)
 	}
@@ -141,6 +167,19 @@ func addKey(gopts GlobalOptions, repo *repository.Repository) error {
 	return nil
 }
 
+// newKey adds a key with the password pw.
+func newKey(ctx context.Context, repo *repository.Repository, pw, username, hostname string, writeOnly bool) (*repository.Key, error) {
+	if !writeOnly {
+		return repository.AddKey(ctx, repo, pw, username, hostname, repo.Key())
+	}
+
+	if repo.Config().PublicKey == nil {
+		return nil, errors.New(`write-only keys are not enabled, run "restic migrate enable_write_only_keys" first`)
+	}
+
+	return repository.AddWriteOnlyKey(ctx, repo, pw, username, hostname, repo.Config())
+}
+
 func deleteKey(ctx context.Context, repo *repository.Repository, name string) error {
 	if name == repo.KeyName() {
 		return errors.Fatal("refusing to remove key currently used to access repository")
@@ -162,7 +201,7 @@ func changePassword(gopts GlobalOptions, repo *repository.Repository) error {
 		return err
 	}
 
-	id, err := repository.AddKey(gopts.ctx, repo, pw, "", "", repo.Key())
+	id, err := newKey(gopts.ctx, repo, pw, "", "", false)
 	if err != nil {
 		return errors.Fatalf("creating new key failed: %v\n", err)
 	}
@@ -205,11 +244,24 @@ func runKey(gopts GlobalOptions, args []string) error {
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 
+	if args[0] == "rotate-master" {
+		// the password is needed again to rewrap the keys
+		var err error
+		gopts.password, err = ReadPassword(gopts, "enter password for repository: ")
+		if err != nil {
+			return err
+		}
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
 
+	if repo.WriteOnly() && args[0] != "list" {
+		return errors.Fatal("keys cannot be managed with a write-only key")
+	}
+
 	switch args[0] {
 	case "list":
 		lock, err := lockRepo(ctx, repo)
@@ -248,6 +300,14 @@ func runKey(gopts GlobalOptions, args []string) error {
 		}
 
 		return changePassword(gopts, repo)
+	case "rotate-master":
+		lock, err := lockRepoExclusive(ctx, repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+
+		return rotateMasterKey(ctx, gopts, repo, lock)
 	}
 
 	return nil
@@ -260,3 +320,95 @@ func loadPasswordFromFile(pwdFile string) (string, error) {
 	}
 	return strings.TrimSpace(string(s)), errors.Wrap(err, "Readfile")
 }
+
+func rotateMasterKey(ctx context.Context, gopts GlobalOptions, repo *repository.Repository, lock *restic.Lock) error {
+	var otherPasswords []string
+	for _, filename := range keyOtherPasswordFiles {
+		pw, err := loadPasswordFromFile(filename)
+		if err != nil {
+			return err
+		}
+		otherPasswords = append(otherPasswords, pw)
+	}
+
+	Verbosef("open keys\n")
+	rot, err := repository.NewMasterKeyRotation(ctx, repo, gopts.password, otherPasswords)
+	if err != nil {
+		return err
+	}
+
+	if unknown := rot.UnknownKeys(); len(unknown) > 0 {
+		for _, name := range unknown {
+			Warnf("unable to open key %v\n", name)
+		}
+		if !keyRemoveUnknown {
+			return errors.Fatal("some keys cannot be opened, specify their passwords with --other-password-file or use --remove-unknown-keys")
+		}
+	}
+
+	if !rot.Switched() {
+		Verbosef("rewrite packs\n")
+		bar := newProgressMax(!gopts.Quiet, 0, "packs rewritten")
+		err = rot.RewritePacks(ctx, bar)
+		bar.Done()
+		if err != nil {
+			return err
+		}
+
+		Verbosef("rewrite snapshots\n")
+		err = rot.RewriteSnapshots(ctx)
+		if err != nil {
+			return err
+		}
+
+		// the lock is refreshed with the key of the repository, which is
+		// replaced when the config is switched
+		Verbosef("switch config to the new master key\n")
+		globalLocks.Lock()
+		err = rot.SwitchConfig(ctx)
+		globalLocks.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	Verbosef("rewrap keys\n")
+	err = rot.RewrapKeys(ctx, keyRemoveUnknown)
+	if err != nil {
+		return err
+	}
+
+	// the lock was saved with the old key, save it again so that it can be
+	// read with the new keys
+	globalLocks.Lock()
+	err = lock.Refresh(ctx)
+	globalLocks.Unlock()
+	if err != nil {
+		return err
+	}
+
+	indexes, packs, err := rot.ObsoleteFiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	Verbosef("remove %d old index files\n", len(indexes))
+	err = DeleteFilesChecked(gopts, repo, indexes, restic.IndexFile)
+	if err != nil {
+		return err
+	}
+
+	Verbosef("remove %d old packs\n", len(packs))
+	err = DeleteFilesChecked(gopts, repo, packs, restic.PackFile)
+	if err != nil {
+		return err
+	}
+
+	err = rot.Finish(ctx)
+	if err != nil {
+		return err
+	}
+
+	Verbosef("master key rotation finished\n")
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"math"
 	"sort"
 	"strconv"
@@ -520,10 +521,22 @@ func prune(opts PruneOptions, gopts GlobalOptions, repo restic.Repository, usedB
 	}
 
 	if len(ignorePacks) != 0 {
-		err = rebuildIndexFiles(gopts, repo, ignorePacks, nil)
+		retainedIndexes, err := rebuildIndexFiles(gopts, repo, ignorePacks, nil)
 		if err != nil {
 			return errors.Fatalf("%s", err)
 		}
+
+		// the index files which could not be removed still reference the
+		// packs, these must be kept until the index files are removed
+		keepPacks, err := indexedPacks(ctx, repo, retainedIndexes)
+		if err != nil {
+			return errors.Fatalf("%s", err)
+		}
+		keepPacks = keepPacks.Intersect(removePacks)
+		if len(keepPacks) != 0 {
+			Warnf("keeping %d packs which are referenced by retained index files\n", len(keepPacks))
+			removePacks = removePacks.Sub(keepPacks)
+		}
 	}
 
 	if len(removePacks) != 0 {
@@ -535,7 +548,10 @@ func prune(opts PruneOptions, gopts GlobalOptions, repo restic.Repository, usedB
 	return nil
 }
 
-func rebuildIndexFiles(gopts GlobalOptions, repo restic.Repository, removePacks restic.IDSet, extraObsolete restic.IDs) error {
+// rebuildIndexFiles saves a new index without the given packs and deletes the
+// old index files. It returns the old index files which are still retained by
+// the backend.
+func rebuildIndexFiles(gopts GlobalOptions, repo restic.Repository, removePacks restic.IDSet, extraObsolete restic.IDs) (retained restic.IDSet, err error) {
 	Verbosef("rebuilding index\n")
 
 	idx := (repo.Index()).(*repository.MasterIndex)
@@ -544,11 +560,31 @@ func rebuildIndexFiles(gopts GlobalOptions, repo restic.Repository, removePacks
 	obsoleteIndexes, err := idx.Save(gopts.ctx, repo, removePacks, extraObsolete, bar)
 	bar.Done()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	Verbosef("deleting obsolete index files\n")
-	return DeleteFilesChecked(gopts, repo, obsoleteIndexes, restic.IndexFile)
+	return deleteFiles(gopts, false, repo, obsoleteIndexes, restic.IndexFile)
+}
+
+// indexedPacks returns the packs referenced by the given index files.
+func indexedPacks(ctx context.Context, repo restic.Repository, indexes restic.IDSet) (restic.IDSet, error) {
+	packs := restic.NewIDSet()
+	var buf []byte
+	for id := range indexes {
+		var err error
+		buf, err = repo.LoadAndDecrypt(ctx, buf[:0], restic.IndexFile, id)
+		if err != nil {
+			return nil, err
+		}
+
+		idx, _, err := repository.DecodeIndex(buf, id)
+		if err != nil {
+			return nil, err
+		}
+		packs.Merge(idx.Packs())
+	}
+	return packs, nil
 }
 
 func getUsedBlobs(gopts GlobalOptions, repo restic.Repository, ignoreSnapshots restic.IDSet) (usedBlobs restic.BlobSet, err error) {
@@ -9,8 +9,11 @@ import (
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/restorer"
+	restoreui "github.com/restic/restic/internal/ui/restore"
+	"github.com/restic/restic/internal/ui/termstatus"
 
 	"github.com/spf13/cobra"
+	tomb "gopkg.in/tomb.v2"
 )
 
 var cmdRestore = &cobra.Command{
@@ -30,7 +33,16 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runRestore(restoreOptions, globalOptions, args)
+		var t tomb.Tomb
+		term := termstatus.New(globalOptions.stdout, globalOptions.stderr, globalOptions.Quiet)
+		t.Go(func() error { term.Run(t.Context(globalOptions.ctx)); return nil })
+
+		err := runRestore(restoreOptions, globalOptions, term, args)
+		t.Kill(nil)
+		if werr := t.Wait(); werr != nil && err == nil {
+			err = errors.Wrap(werr, "term.Run")
+		}
+		return err
 	},
 }
 
@@ -45,6 +57,11 @@ type RestoreOptions struct {
 	Paths              []string
 	Tags               restic.TagLists
 	Verify             bool
+	Overwrite          restorer.OverwriteBehavior
+	CompareContent     bool
+	Delete             bool
+	Sparse             bool
+	ReuseExisting      bool
 }
 
 var restoreOptions RestoreOptions
@@ -63,9 +80,14 @@ func init() {
 	flags.Var(&restoreOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
 	flags.StringArrayVar(&restoreOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
 	flags.BoolVar(&restoreOptions.Verify, "verify", false, "verify restored files content")
+	flags.Var(&restoreOptions.Overwrite, "overwrite", "overwrite `behavior` for existing files, one of (always|if-changed|if-newer|never)")
+	flags.BoolVar(&restoreOptions.CompareContent, "compare-content", false, "compare the content of existing files for --overwrite=if-changed instead of size and modification time")
+	flags.BoolVar(&restoreOptions.Delete, "delete", false, "delete files from the target which are not contained in the snapshot")
+	flags.BoolVar(&restoreOptions.Sparse, "sparse", false, "restore files as sparse files, runs of zeros are not written to disk")
+	flags.BoolVar(&restoreOptions.ReuseExisting, "reuse-existing", false, "copy data already present in existing files instead of downloading it")
 }
 
-func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
+func runRestore(opts RestoreOptions, gopts GlobalOptions, term *termstatus.Terminal, args []string) error {
 	ctx := gopts.ctx
 	hasExcludes := len(opts.Exclude) > 0 || len(opts.InsensitiveExclude) > 0
 	hasIncludes := len(opts.Include) > 0 || len(opts.InsensitiveInclude) > 0
@@ -93,6 +115,14 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("exclude and include patterns are mutually exclusive")
 	}
 
+	if opts.Overwrite == restorer.OverwriteInvalid {
+		return errors.Fatal("invalid overwrite behavior")
+	}
+
+	if opts.CompareContent && opts.Overwrite != restorer.OverwriteIfChanged {
+		return errors.Fatal("--compare-content can only be used with --overwrite=if-changed")
+	}
+
 	snapshotIDString := args[0]
 
 	debug.Log("restore %v to %v", snapshotIDString, opts.Target)
@@ -133,25 +163,41 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 	if err != nil {
 		Exitf(2, "creating restorer failed: %v\n", err)
 	}
+	res.Overwrite = opts.Overwrite
+	res.CompareContent = opts.CompareContent
+	res.Delete = opts.Delete
+	res.Sparse = opts.Sparse
+	res.ReuseExisting = opts.ReuseExisting
+
+	var progressPrinter restoreui.ProgressPrinter
+	if gopts.JSON {
+		progressPrinter = restoreui.NewJSONProgress(term, gopts.verbosity)
+	} else {
+		progressPrinter = restoreui.NewTextProgress(term, gopts.verbosity)
+	}
+	progressReporter := restoreui.NewProgress(progressPrinter)
+	progressReporter.SetMinUpdatePause(calculateProgressInterval(!gopts.Quiet, gopts.JSON))
 
 	totalErrors := 0
 	res.Error = func(location string, err error) error {
-		Warnf("ignoring error for %s: %s\n", location, err)
 		totalErrors++
-		return nil
+		return progressReporter.Error(location, err)
 	}
+	res.StartFile = progressReporter.AddFile
+	res.CompleteBlob = progressReporter.AddProgress
+	res.SkipFile = progressReporter.AddSkippedFile
 
 	excludePatterns := filter.ParsePatterns(opts.Exclude)
 	insensitiveExcludePatterns := filter.ParsePatterns(opts.InsensitiveExclude)
 	selectExcludeFilter := func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
 		matched, err := filter.List(excludePatterns, item)
 		if err != nil {
-			Warnf("error for exclude pattern: %v", err)
+			progressPrinter.E("error for exclude pattern: %v\n", err)
 		}
 
 		matchedInsensitive, err := filter.List(insensitiveExcludePatterns, strings.ToLower(item))
 		if err != nil {
-			Warnf("error for iexclude pattern: %v", err)
+			progressPrinter.E("error for iexclude pattern: %v\n", err)
 		}
 
 		// An exclude filter is basically a 'wildcard but foo',
@@ -169,12 +215,12 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 	selectIncludeFilter := func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool) {
 		matched, childMayMatch, err := filter.ListWithChild(includePatterns, item)
 		if err != nil {
-			Warnf("error for include pattern: %v", err)
+			progressPrinter.E("error for include pattern: %v\n", err)
 		}
 
 		matchedInsensitive, childMayMatchInsensitive, err := filter.ListWithChild(insensitiveIncludePatterns, strings.ToLower(item))
 		if err != nil {
-			Warnf("error for iexclude pattern: %v", err)
+			progressPrinter.E("error for iexclude pattern: %v\n", err)
 		}
 
 		selectedForRestore = matched || matchedInsensitive
@@ -189,19 +235,34 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		res.SelectFilter = selectIncludeFilter
 	}
 
-	Verbosef("restoring %s to %s\n", res.Snapshot(), opts.Target)
+	if !gopts.JSON {
+		progressPrinter.P("restoring %s to %s\n", res.Snapshot(), opts.Target)
+	}
+
+	var t tomb.Tomb
+	t.Go(func() error { return progressReporter.Run(t.Context(ctx)) })
 
 	err = res.RestoreTo(ctx, opts.Target)
+
+	// stop the progress reporter and print the summary
+	t.Kill(nil)
+	werr := t.Wait()
 	if err != nil {
 		return err
 	}
+	if werr != nil {
+		return werr
+	}
+	progressReporter.Finish()
 
 	if totalErrors > 0 {
 		return errors.Fatalf("There were %d errors\n", totalErrors)
 	}
 
 	if opts.Verify {
-		Verbosef("verifying files in %s\n", opts.Target)
+		if !gopts.JSON {
+			progressPrinter.P("verifying files in %s\n", opts.Target)
+		}
 		var count int
 		t0 := time.Now()
 		count, err = res.VerifyFiles(ctx, opts.Target)
@@ -211,8 +272,10 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		if totalErrors > 0 {
 			return errors.Fatalf("There were %d errors\n", totalErrors)
 		}
-		Verbosef("finished verifying %d files in %s (took %s)\n", count, opts.Target,
-			time.Since(t0).Round(time.Millisecond))
+		if !gopts.JSON {
+			progressPrinter.P("finished verifying %d files in %s (took %s)\n", count, opts.Target,
+				time.Since(t0).Round(time.Millisecond))
+		}
 	}
 
 	return nil
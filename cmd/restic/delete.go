@@ -1,29 +1,36 @@
 package main
 
 import (
+	"sync"
+
 	"golang.org/x/sync/errgroup"
 
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 )
 
 // DeleteFiles deletes the given fileList of fileType in parallel
 // it will print a warning if there is an error, but continue deleting the remaining files
 func DeleteFiles(gopts GlobalOptions, repo restic.Repository, fileList restic.IDSet, fileType restic.FileType) {
-	_ = deleteFiles(gopts, true, repo, fileList, fileType)
+	_, _ = deleteFiles(gopts, true, repo, fileList, fileType)
 }
 
 // DeleteFilesChecked deletes the given fileList of fileType in parallel
 // if an error occurs, it will cancel and return this error
 func DeleteFilesChecked(gopts GlobalOptions, repo restic.Repository, fileList restic.IDSet, fileType restic.FileType) error {
-	return deleteFiles(gopts, false, repo, fileList, fileType)
+	_, err := deleteFiles(gopts, false, repo, fileList, fileType)
+	return err
 }
 
 const numDeleteWorkers = 8
 
 // deleteFiles deletes the given fileList of fileType in parallel
 // if ignoreError=true, it will print a warning if there was an error, else it will abort.
-func deleteFiles(gopts GlobalOptions, ignoreError bool, repo restic.Repository, fileList restic.IDSet, fileType restic.FileType) error {
+// Files which are still retained by the backend are skipped and returned.
+func deleteFiles(gopts GlobalOptions, ignoreError bool, repo restic.Repository, fileList restic.IDSet, fileType restic.FileType) (retained restic.IDSet, err error) {
 	totalCount := len(fileList)
+	retained = restic.NewIDSet()
+	var m sync.Mutex
 	fileChan := make(chan restic.ID)
 	wg, ctx := errgroup.WithContext(gopts.ctx)
 	wg.Go(func() error {
@@ -45,6 +52,16 @@ func deleteFiles(gopts GlobalOptions, ignoreError bool, repo restic.Repository,
 			for id := range fileChan {
 				h := restic.Handle{Type: fileType, Name: id.String()}
 				err := repo.Backend().Remove(ctx, h)
+				if errors.Is(err, restic.ErrRetained) {
+					if !gopts.JSON {
+						Warnf("skipping %v: %v\n", h, err)
+					}
+					m.Lock()
+					retained.Insert(id)
+					m.Unlock()
+					bar.Add(1)
+					continue
+				}
 				if err != nil {
 					if !gopts.JSON {
 						Warnf("unable to remove %v from the repository\n", h)
@@ -61,6 +78,6 @@ func deleteFiles(gopts GlobalOptions, ignoreError bool, repo restic.Repository,
 			return nil
 		})
 	}
-	err := wg.Wait()
-	return err
+	err = wg.Wait()
+	return retained, err
 }
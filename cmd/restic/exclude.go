@@ -1,20 +1,26 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/hashicorp/golang-lru/simplelru"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/textfile"
+	"github.com/spf13/pflag"
 )
 
 type rejectionCache struct {
@@ -201,6 +207,204 @@ func isDirExcludedByFile(dir, tagFilename, header string) bool {
 	return true
 }
 
+// ignoreFileRule is a single rule read from a gitignore-style ignore file.
+type ignoreFileRule struct {
+	pattern []filter.Pattern
+	negated bool
+	dirOnly bool
+}
+
+// matches returns true if the rule matches rel, which is the slash-separated
+// path of an item relative to the directory containing the ignore file,
+// starting with a slash.
+func (r ignoreFileRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		// the rule only applies to directories, so a file can only be matched
+		// if one of its parent directories is
+		rel = path.Dir(rel)
+		if rel == "/" {
+			return false
+		}
+	}
+
+	matched, err := filter.List(r.pattern, rel)
+	if err != nil {
+		Warnf("error for ignore file pattern: %v\n", err)
+		return false
+	}
+	return matched
+}
+
+// parseIgnoreFile parses the contents of a gitignore-style ignore file.
+// Patterns which contain a slash are anchored at the directory containing
+// the ignore file, all others match items in any subdirectory. A trailing
+// slash restricts a pattern to directories, a leading "!" negates it.
+func parseIgnoreFile(data []byte) (rules []ignoreFileRule) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+
+		// ignore empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreFileRule
+		if strings.HasPrefix(line, "!") {
+			rule.negated = true
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+
+		if line == "" {
+			continue
+		}
+
+		if strings.Contains(line, "/") {
+			// anchor the pattern at the directory of the ignore file
+			line = "/" + strings.TrimPrefix(line, "/")
+		}
+
+		rule.pattern = filter.ParsePatterns([]string{line})
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// ignoreFileCacheSize is the number of directories for which the rules of the
+// ignore files are cached. The backup walks the directories depth-first, so
+// mostly the directories along the current path are needed.
+const ignoreFileCacheSize = 1024
+
+// ignoreFileChecker evaluates the rules in per-directory ignore files.
+type ignoreFileChecker struct {
+	filenames []string
+	roots     []string
+
+	mtx   sync.Mutex
+	rules *simplelru.LRU
+}
+
+// rulesFor returns the rules for the ignore files in dir. The result is
+// cached for the most recently used directories.
+func (c *ignoreFileChecker) rulesFor(dir string) []ignoreFileRule {
+	c.mtx.Lock()
+	cached, ok := c.rules.Get(dir)
+	c.mtx.Unlock()
+	if ok {
+		return cached.([]ignoreFileRule)
+	}
+
+	var rules []ignoreFileRule
+	for _, filename := range c.filenames {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filename))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			Warnf("could not read ignore file: %v\n", err)
+			continue
+		}
+
+		rules = append(rules, parseIgnoreFile(data)...)
+	}
+
+	c.mtx.Lock()
+	c.rules.Add(dir, rules)
+	c.mtx.Unlock()
+	return rules
+}
+
+// withinRoots returns true if dir is one of the backup targets or located
+// below one of them.
+func (c *ignoreFileChecker) withinRoots(dir string) bool {
+	for _, root := range c.roots {
+		if fs.HasPathPrefix(root, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcluded returns true if item is excluded by an ignore file in one of the
+// directories between the backup targets and item. Rules in deeper
+// directories and later rules within a file take precedence.
+func (c *ignoreFileChecker) isExcluded(item string, isDir bool) bool {
+	item, err := filepath.Abs(filepath.Clean(item))
+	if err != nil {
+		debug.Log("unable to make %v absolute: %v", item, err)
+		return false
+	}
+
+	// collect all parent directories within the backup targets
+	var dirs []string
+	for dir := filepath.Dir(item); c.withinRoots(dir); dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	excluded := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rules := c.rulesFor(dirs[i])
+		if len(rules) == 0 {
+			continue
+		}
+
+		rel, err := filepath.Rel(dirs[i], item)
+		if err != nil {
+			debug.Log("unable to get path of %v relative to %v: %v", item, dirs[i], err)
+			continue
+		}
+		rel = "/" + filepath.ToSlash(rel)
+
+		for _, rule := range rules {
+			if rule.matches(rel, isDir) {
+				excluded = !rule.negated
+			}
+		}
+	}
+
+	if excluded {
+		debug.Log("path %q excluded by an ignore file", item)
+	}
+	return excluded
+}
+
+// rejectByIgnoreFile returns a RejectFunc which rejects items excluded by the
+// gitignore-style ignore files called filenames. The ignore files are read
+// from each directory within the targets, their rules apply to the directory
+// and all subdirectories.
+func rejectByIgnoreFile(filenames []string, targets []string) (RejectFunc, error) {
+	rules, err := simplelru.NewLRU(ignoreFileCacheSize, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ignoreFileChecker{
+		filenames: filenames,
+		rules:     rules,
+	}
+
+	for _, target := range targets {
+		root, err := filepath.Abs(filepath.Clean(target))
+		if err != nil {
+			return nil, err
+		}
+		c.roots = append(c.roots, root)
+	}
+
+	return func(item string, fi os.FileInfo) bool {
+		return c.isExcluded(item, fi.IsDir())
+	}, nil
+}
+
 // DeviceMap is used to track allowed source devices for backup. This is used to
 // check for crossing mount points during backup (for --one-file-system). It
 // maps the name of a source path to its device ID.
@@ -410,3 +614,100 @@ func parseSizeStr(sizeStr string) (int64, error) {
 	}
 	return value * unit, nil
 }
+
+type excludePatternOptions struct {
+	Excludes                []string
+	InsensitiveExcludes     []string
+	ExcludeFiles            []string
+	InsensitiveExcludeFiles []string
+}
+
+func initExcludePatternOptions(f *pflag.FlagSet, opts *excludePatternOptions) {
+	f.StringArrayVarP(&opts.Excludes, "exclude", "e", nil, "exclude a `pattern` (can be specified multiple times)")
+	f.StringArrayVar(&opts.InsensitiveExcludes, "iexclude", nil, "same as --exclude `pattern` but ignores the casing of filenames")
+	f.StringArrayVar(&opts.ExcludeFiles, "exclude-file", nil, "read exclude patterns from a `file` (can be specified multiple times)")
+	f.StringArrayVar(&opts.InsensitiveExcludeFiles, "iexclude-file", nil, "same as --exclude-file but ignores casing of `file`names in patterns")
+}
+
+// Empty returns true if no exclude patterns are configured.
+func (opts *excludePatternOptions) Empty() bool {
+	return len(opts.Excludes) == 0 && len(opts.InsensitiveExcludes) == 0 && len(opts.ExcludeFiles) == 0 && len(opts.InsensitiveExcludeFiles) == 0
+}
+
+// collectExcludePatterns returns a list of functions which reject items based
+// on the configured exclude patterns, including the ones read from files.
+func collectExcludePatterns(opts excludePatternOptions) ([]RejectByNameFunc, error) {
+	var fs []RejectByNameFunc
+	// add patterns from file
+	if len(opts.ExcludeFiles) > 0 {
+		excludePatterns, err := readExcludePatternsFromFiles(opts.ExcludeFiles)
+		if err != nil {
+			return nil, err
+		}
+		opts.Excludes = append(opts.Excludes, excludePatterns...)
+	}
+
+	if len(opts.InsensitiveExcludeFiles) > 0 {
+		excludes, err := readExcludePatternsFromFiles(opts.InsensitiveExcludeFiles)
+		if err != nil {
+			return nil, err
+		}
+		opts.InsensitiveExcludes = append(opts.InsensitiveExcludes, excludes...)
+	}
+
+	if len(opts.InsensitiveExcludes) > 0 {
+		fs = append(fs, rejectByInsensitivePattern(opts.InsensitiveExcludes))
+	}
+
+	if len(opts.Excludes) > 0 {
+		fs = append(fs, rejectByPattern(opts.Excludes))
+	}
+	return fs, nil
+}
+
+// readExcludePatternsFromFiles reads all exclude files and returns the list of
+// exclude patterns. For each line, leading and trailing white space is removed
+// and comment lines are ignored. For each remaining pattern, environment
+// variables are resolved. For adding a literal dollar sign ($), write $$ to
+// the file.
+func readExcludePatternsFromFiles(excludeFiles []string) ([]string, error) {
+	getenvOrDollar := func(s string) string {
+		if s == "$" {
+			return "$"
+		}
+		return os.Getenv(s)
+	}
+
+	var excludes []string
+	for _, filename := range excludeFiles {
+		err := func() (err error) {
+			data, err := textfile.Read(filename)
+			if err != nil {
+				return err
+			}
+
+			scanner := bufio.NewScanner(bytes.NewReader(data))
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+
+				// ignore empty lines
+				if line == "" {
+					continue
+				}
+
+				// strip comments
+				if strings.HasPrefix(line, "#") {
+					continue
+				}
+
+				line = os.Expand(line, getenvOrDollar)
+				excludes = append(excludes, line)
+			}
+			return scanner.Err()
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return excludes, nil
+}
@@ -18,11 +18,13 @@ func TestRejectByPattern(t *testing.T) {
 		{filename: "/home/user/foo.c", reject: false},
 		{filename: "/home/user/foobar", reject: false},
 		{filename: "/home/user/foobar/x", reject: true},
+		{filename: "/home/user/foobar/keep", reject: false},
+		{filename: "/home/user/foobar/keep/x.go", reject: true},
 		{filename: "/home/user/README", reject: false},
 		{filename: "/home/user/README.md", reject: true},
 	}
 
-	patterns := []string{"*.go", "README.md", "/home/user/foobar/*"}
+	patterns := []string{"*.go", "README.md", "/home/user/foobar/*", "!/home/user/foobar/keep", "/home/user/foobar/keep/*.go"}
 
 	for _, tc := range tests {
 		t.Run("", func(t *testing.T) {
@@ -190,6 +192,78 @@ func TestMultipleIsExcludedByFile(t *testing.T) {
 	}
 }
 
+func TestRejectByIgnoreFile(t *testing.T) {
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	files := []struct {
+		path string
+		incl bool
+	}{
+		{".resticignore", true},
+		{"foo.log", false},
+		{"foo.txt", true},
+		{"keep.log", true},
+		{"build/out", false},
+		{"src/build", true},
+		{"src/main.c", true},
+		{"src/main.o", false},
+		{"src/tmp/x", false},
+		{"tmp/x", true},
+
+		// ignore files in subdirectories only apply below them
+		{"sub/.resticignore", true},
+		{"sub/foo.txt", false},
+		{"sub/keep.o", true},
+		{"sub/dir/foo.txt", false},
+		{"other/foo.txt", true},
+	}
+
+	ignoreFiles := map[string]string{
+		".resticignore":     "# comment\n*.log\n!keep.log\n/build\n*.o\nsrc/tmp/\n",
+		"sub/.resticignore": "*.txt\n!keep.o\n",
+	}
+
+	var errs []error
+	for _, f := range files {
+		// create directories first, then the file
+		p := filepath.Join(tempDir, filepath.FromSlash(f.path))
+		data := []byte(f.path)
+		if content, ok := ignoreFiles[f.path]; ok {
+			data = []byte(content)
+		}
+		errs = append(errs, os.MkdirAll(filepath.Dir(p), 0700))
+		errs = append(errs, ioutil.WriteFile(p, data, 0600))
+	}
+	test.OKs(t, errs) // see if anything went wrong during the creation
+
+	reject, err := rejectByIgnoreFile([]string{".resticignore"}, []string{tempDir})
+	test.OK(t, err)
+
+	m := make(map[string]bool)
+	walk := func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		excluded := reject(p, fi)
+		// the log message helps debugging in case the test fails
+		t.Logf("%q: %v", p, excluded)
+		m[p] = !excluded
+		if excluded && fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	test.OK(t, filepath.Walk(tempDir, walk))
+
+	for _, f := range files {
+		p := filepath.Join(tempDir, filepath.FromSlash(f.path))
+		if m[p] != f.incl {
+			t.Errorf("inclusion status of %s is wrong: want %v, got %v", f.path, f.incl, m[p])
+		}
+	}
+}
+
 func TestParseSizeStr(t *testing.T) {
 	sizeStrTests := []struct {
 		in       string
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -13,16 +14,22 @@ import (
 	"time"
 
 	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/archive"
 	"github.com/restic/restic/internal/backend/azure"
 	"github.com/restic/restic/internal/backend/b2"
+	execbackend "github.com/restic/restic/internal/backend/exec"
 	"github.com/restic/restic/internal/backend/gs"
 	"github.com/restic/restic/internal/backend/local"
 	"github.com/restic/restic/internal/backend/location"
+	"github.com/restic/restic/internal/backend/mirror"
 	"github.com/restic/restic/internal/backend/rclone"
 	"github.com/restic/restic/internal/backend/rest"
 	"github.com/restic/restic/internal/backend/s3"
 	"github.com/restic/restic/internal/backend/sftp"
+	"github.com/restic/restic/internal/backend/sharded"
+	"github.com/restic/restic/internal/backend/spool"
 	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/backend/webdav"
 	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/fs"
@@ -64,9 +71,18 @@ type GlobalOptions struct {
 	InsecureTLS     bool
 	TLSClientCert   string
 	CleanupCache    bool
+	Mirrors         []string
+	SpoolDir        string
 
 	LimitUploadKb   int
 	LimitDownloadKb int
+	LimitSchedule   string
+
+	Retry backend.RetryPolicy
+
+	VerifyUploads bool
+
+	Compression repository.CompressionMode
 
 	ctx      context.Context
 	password string
@@ -118,9 +134,27 @@ func init() {
 	f.StringVar(&globalOptions.TLSClientCert, "tls-client-cert", "", "path to a `file` containing PEM encoded TLS client certificate and private key")
 	f.BoolVar(&globalOptions.InsecureTLS, "insecure-tls", false, "skip TLS certificate verification when connecting to the repo (insecure)")
 	f.BoolVar(&globalOptions.CleanupCache, "cleanup-cache", false, "auto remove old cache directories")
+	f.StringArrayVar(&globalOptions.Mirrors, "mirror", nil, "also store all files in the repository at `location` (can be specified multiple times)")
+	f.StringVar(&globalOptions.SpoolDir, "spool-dir", "", "store new files in `directory` while the repository is unreachable and upload them later")
 	f.IntVar(&globalOptions.LimitUploadKb, "limit-upload", 0, "limits uploads to a maximum rate in KiB/s. (default: unlimited)")
 	f.IntVar(&globalOptions.LimitDownloadKb, "limit-download", 0, "limits downloads to a maximum rate in KiB/s. (default: unlimited)")
+	f.StringVar(&globalOptions.LimitSchedule, "limit-schedule", os.Getenv("RESTIC_LIMIT_SCHEDULE"), "limit rates depending on the time of day according to `schedule`, e.g. \"mon-fri 08:00-18:00 upload=2M, else unlimited\" (default: $RESTIC_LIMIT_SCHEDULE)")
+	f.IntVar(&globalOptions.Retry.MaxTries, "retry-max-tries", 10, "retry a failed backend operation at most `n` times")
+	f.DurationVar(&globalOptions.Retry.MaxElapsedTime, "retry-max-time", 15*time.Minute, "give up retrying a failed backend operation after `duration`, 0 for no limit")
+	f.DurationVar(&globalOptions.Retry.InitialInterval, "retry-initial-interval", 500*time.Millisecond, "wait `duration` before the first retry, doubled for each further retry")
+	f.DurationVar(&globalOptions.Retry.MaxInterval, "retry-max-interval", time.Minute, "wait at most `duration` between retries")
+	f.Float64Var(&globalOptions.Retry.Jitter, "retry-jitter", 0.5, "randomize the wait between retries by up to this `fraction`")
+	f.IntVar(&globalOptions.Retry.BreakerThreshold, "retry-circuit-breaker", 0, "fail all backend operations after `n` consecutive errors, 0 to disable")
+	f.DurationVar(&globalOptions.Retry.BreakerCooldown, "retry-circuit-breaker-cooldown", 0, "try the backend again `duration` after the circuit breaker has tripped, 0 to fail for the rest of the run")
+	f.BoolVar(&globalOptions.VerifyUploads, "verify-uploads", false, "check each uploaded pack file by a checksum from the backend or by reading it back, and upload it again if it is corrupted")
 	f.StringSliceVarP(&globalOptions.Options, "option", "o", []string{}, "set extended option (`key=value`, can be specified multiple times)")
+	f.Var(&globalOptions.Compression, "compression", "compression mode (only available for repository format version 2), one of (auto|off|max) (default: $RESTIC_COMPRESSION)")
+
+	// parse the compression mode from the environment, the flag overrides it.
+	// An invalid mode is reported when the repository is opened.
+	if s := os.Getenv("RESTIC_COMPRESSION"); s != "" {
+		_ = globalOptions.Compression.Set(s)
+	}
 	// Use our "generate" command instead of the cobra provided "completion" command
 	cmdRoot.CompletionOptions.DisableDefaultCmd = true
 
@@ -409,6 +443,34 @@ func ReadRepo(opts GlobalOptions) (string, error) {
 
 const maxKeys = 20
 
+type retryMessage struct {
+	MessageType string  `json:"message_type"` // "retry"
+	Operation   string  `json:"operation"`
+	Error       string  `json:"error"`
+	RetryIn     float64 `json:"retry_in"` // in seconds
+}
+
+// reportRetry prints a message about a failed backend operation which is
+// retried after d.
+func reportRetry(opts GlobalOptions, msg string, err error, d time.Duration) {
+	if !opts.JSON {
+		Warnf("%v returned error, retrying after %v: %v\n", msg, d, err)
+		return
+	}
+
+	buf, jerr := json.Marshal(retryMessage{
+		MessageType: "retry",
+		Operation:   msg,
+		Error:       err.Error(),
+		RetryIn:     d.Seconds(),
+	})
+	if jerr != nil {
+		Warnf("JSON encode failed: %v\n", jerr)
+		return
+	}
+	Warnf("%s\n", buf)
+}
+
 // OpenRepository reads the password and opens the repository.
 func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 	repo, err := ReadRepo(opts)
@@ -416,15 +478,30 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 		return nil, err
 	}
 
+	// an invalid --compression is already rejected by the flag parser, so
+	// the mode can only be invalid if it was set via the environment
+	if opts.Compression == repository.CompressionInvalid {
+		return nil, errors.Fatalf("invalid compression mode %q in RESTIC_COMPRESSION, must be one of (auto|off|max)", os.Getenv("RESTIC_COMPRESSION"))
+	}
+
 	be, err := open(repo, opts, opts.extended)
+	if err == nil {
+		policy := opts.Retry
+		if policy == (backend.RetryPolicy{}) {
+			policy = backend.DefaultRetryPolicy()
+		}
+		be = backend.NewRetryBackendWithPolicy(be, policy, func(msg string, err error, d time.Duration) {
+			reportRetry(opts, msg, err, d)
+		})
+	}
+
+	if opts.SpoolDir != "" {
+		be, err = openSpool(be, err, opts)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	be = backend.NewRetryBackend(be, 10, func(msg string, err error, d time.Duration) {
-		Warnf("%v returned error, retrying after %v: %v\n", msg, d, err)
-	})
-
 	// wrap backend if a test specified a hook
 	if opts.backendTestHook != nil {
 		be, err = opts.backendTestHook(be)
@@ -434,6 +511,8 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 	}
 
 	s := repository.New(be)
+	s.SetCompression(opts.Compression)
+	s.SetVerifyUploads(opts.VerifyUploads)
 
 	passwordTriesLeft := 1
 	if stdinIsTerminal() && opts.password == "" {
@@ -463,6 +542,16 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 		return nil, errors.Fatalf("%s", err)
 	}
 
+	if s.Config().Version < 2 && opts.Compression != repository.CompressionAuto {
+		return nil, errors.Fatal("compression requires at least repository format version 2")
+	}
+
+	if s.WriteOnly() && opts.Compression == repository.CompressionOff {
+		// the pack header only records the plaintext length of sealed blobs
+		// for compressed blobs
+		return nil, errors.Fatal("compression cannot be disabled with a write-only key")
+	}
+
 	if stdoutIsTerminal() && !opts.JSON {
 		id := s.Config().ID
 		if len(id) > 8 {
@@ -544,6 +633,24 @@ func parseConfig(loc location.Location, opts options.Options) (interface{}, erro
 		debug.Log("opening sftp repository at %#v", cfg)
 		return cfg, nil
 
+	case "sharded":
+		cfg := loc.Config.(sharded.Config)
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening sharded repository at %#v", cfg)
+		return cfg, nil
+
+	case "archive":
+		cfg := loc.Config.(archive.Config)
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening archive repository at %#v", cfg)
+		return cfg, nil
+
 	case "s3":
 		cfg := loc.Config.(s3.Config)
 		if cfg.KeyID == "" {
@@ -656,13 +763,160 @@ func parseConfig(loc location.Location, opts options.Options) (interface{}, erro
 
 		debug.Log("opening rest repository at %#v", cfg)
 		return cfg, nil
+	case "webdav":
+		cfg := loc.Config.(webdav.Config)
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening webdav repository at %#v", cfg)
+		return cfg, nil
+	case "exec":
+		cfg := loc.Config.(execbackend.Config)
+		if err := opts.Apply(loc.Scheme, &cfg); err != nil {
+			return nil, err
+		}
+
+		debug.Log("opening exec repository at %#v", cfg)
+		return cfg, nil
 	}
 
 	return nil, errors.Fatalf("invalid backend: %q", loc.Scheme)
 }
 
-// Open the backend specified by a location config.
+// Open the backend specified by a location config. When mirrors are
+// configured, the returned backend stores all files in each of them.
 func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend, error) {
+	// all replicas share the same limiter, so that the limits apply to the
+	// total throughput
+	lim, err := newLimiter(gopts)
+	if err != nil {
+		return nil, err
+	}
+
+	var be restic.Backend
+	if len(gopts.Mirrors) > 0 {
+		be, err = newMirror(append([]string{s}, gopts.Mirrors...), opts, func(s string) (restic.Backend, error) {
+			return openLocation(s, gopts, opts, lim)
+		})
+	} else {
+		be, err = openLocation(s, gopts, opts, lim)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// check if config is there
+	fi, err := be.Stat(globalOptions.ctx, restic.Handle{Type: restic.ConfigFile})
+	if err != nil {
+		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, location.StripPassword(s))
+	}
+
+	if fi.Size == 0 {
+		return nil, errors.New("config file has zero size, invalid repository?")
+	}
+
+	return be, nil
+}
+
+// openSpool wraps be in a backend which stores new files in the spool
+// directory while the repository is unreachable. When the repository could not
+// be opened, openErr is set and the spool directory is used on its own.
+func openSpool(be restic.Backend, openErr error, opts GlobalOptions) (restic.Backend, error) {
+	report := func(err error) {
+		Warnf("repository is unreachable, storing new files in spool directory %v: %v\n", opts.SpoolDir, err)
+	}
+
+	if openErr != nil {
+		sbe, err := spool.OpenOffline(opts.ctx, opts.SpoolDir)
+		if err != nil {
+			debug.Log("unable to use spool directory: %v", err)
+			return nil, openErr
+		}
+		report(openErr)
+		return sbe, nil
+	}
+
+	sbe, err := spool.New(opts.ctx, be, opts.SpoolDir)
+	if err != nil {
+		return nil, errors.Fatalf("unable to open spool directory: %v", err)
+	}
+	sbe.Report = report
+	return sbe, nil
+}
+
+// newMirror returns a backend which stores all files in the backends at the
+// locations, which are opened by openFn. Locations which cannot be opened are
+// skipped with a warning as long as the remaining locations satisfy the
+// quorum. Without a quorum, all locations must be opened successfully.
+func newMirror(locations []string, opts options.Options, openFn func(string) (restic.Backend, error)) (restic.Backend, error) {
+	cfg := mirror.NewConfig()
+	if err := opts.Extract("mirror").Apply("mirror", &cfg); err != nil {
+		return nil, err
+	}
+
+	replicas := make([]mirror.Replica, 0, len(locations))
+	for _, s := range locations {
+		be, err := openFn(s)
+		if err != nil {
+			if cfg.Quorum == 0 {
+				closeReplicas(replicas)
+				return nil, err
+			}
+			Warnf("unable to open repository location %v, continuing without it: %v\n", location.StripPassword(s), err)
+			continue
+		}
+		replicas = append(replicas, mirror.Replica{Name: location.StripPassword(s), Backend: be})
+	}
+
+	if len(replicas) < int(cfg.Quorum) {
+		closeReplicas(replicas)
+		return nil, errors.Fatalf("opened %d of %d repository locations, quorum is %d", len(replicas), len(locations), cfg.Quorum)
+	}
+
+	// mirroring needs at least two replicas
+	if len(replicas) == 1 {
+		return replicas[0].Backend, nil
+	}
+
+	mbe, err := mirror.New(cfg, replicas)
+	if err != nil {
+		closeReplicas(replicas)
+		return nil, err
+	}
+	mbe.Report = func(msg string, err error) {
+		Warnf("%v failed for a mirror: %v\n", msg, err)
+	}
+
+	return mbe, nil
+}
+
+// closeReplicas closes the backends of replicas which are not used.
+func closeReplicas(replicas []mirror.Replica) {
+	for _, r := range replicas {
+		if err := r.Close(); err != nil {
+			debug.Log("closing %v failed: %v", r.Name, err)
+		}
+	}
+}
+
+// newLimiter returns the limiter for the bandwidth limits and the schedule
+// configured in gopts.
+func newLimiter(gopts GlobalOptions) (limiter.Limiter, error) {
+	if gopts.LimitSchedule == "" {
+		return limiter.NewStaticLimiter(gopts.LimitUploadKb, gopts.LimitDownloadKb), nil
+	}
+
+	sched, err := limiter.ParseSchedule(gopts.LimitSchedule)
+	if err != nil {
+		return nil, errors.Fatalf("invalid --limit-schedule: %v", err)
+	}
+	return limiter.NewScheduledLimiter(sched, gopts.LimitUploadKb, gopts.LimitDownloadKb), nil
+}
+
+// openLocation opens the backend at the location s, the throughput is limited
+// by lim.
+func openLocation(s string, gopts GlobalOptions, opts options.Options, lim limiter.Limiter) (restic.Backend, error) {
 	debug.Log("parsing location %v", location.StripPassword(s))
 	loc, err := location.Parse(s)
 	if err != nil {
@@ -687,7 +941,6 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 	}
 
 	// wrap the transport so that the throughput via HTTP is limited
-	lim := limiter.NewStaticLimiter(gopts.LimitUploadKb, gopts.LimitDownloadKb)
 	rt = lim.Transport(rt)
 
 	switch loc.Scheme {
@@ -695,6 +948,10 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 		be, err = local.Open(globalOptions.ctx, cfg.(local.Config))
 	case "sftp":
 		be, err = sftp.Open(globalOptions.ctx, cfg.(sftp.Config))
+	case "sharded":
+		be, err = sharded.Open(globalOptions.ctx, cfg.(sharded.Config))
+	case "archive":
+		be, err = archive.Open(globalOptions.ctx, cfg.(archive.Config))
 	case "s3":
 		be, err = s3.Open(globalOptions.ctx, cfg.(s3.Config), rt)
 	case "gs":
@@ -709,6 +966,10 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 		be, err = rest.Open(cfg.(rest.Config), rt)
 	case "rclone":
 		be, err = rclone.Open(cfg.(rclone.Config), lim)
+	case "webdav":
+		be, err = webdav.Open(globalOptions.ctx, cfg.(webdav.Config), rt)
+	case "exec":
+		be, err = execbackend.Open(cfg.(execbackend.Config), lim)
 
 	default:
 		return nil, errors.Fatalf("invalid backend: %q", loc.Scheme)
@@ -726,26 +987,28 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 		}
 	}
 
-	if loc.Scheme == "local" || loc.Scheme == "sftp" {
+	if loc.Scheme == "local" || loc.Scheme == "sftp" || loc.Scheme == "sharded" || loc.Scheme == "archive" {
 		// wrap the backend in a LimitBackend so that the throughput is limited
 		be = limiter.LimitBackend(be, lim)
 	}
 
-	// check if config is there
-	fi, err := be.Stat(globalOptions.ctx, restic.Handle{Type: restic.ConfigFile})
-	if err != nil {
-		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, location.StripPassword(s))
-	}
+	return be, nil
+}
 
-	if fi.Size == 0 {
-		return nil, errors.New("config file has zero size, invalid repository?")
+// Create the backend specified by URI. When mirrors are configured, they are
+// created as well.
+func create(s string, gopts GlobalOptions, opts options.Options) (restic.Backend, error) {
+	if len(gopts.Mirrors) > 0 {
+		return newMirror(append([]string{s}, gopts.Mirrors...), opts, func(s string) (restic.Backend, error) {
+			return createLocation(s, opts)
+		})
 	}
 
-	return be, nil
+	return createLocation(s, opts)
 }
 
-// Create the backend specified by URI.
-func create(s string, opts options.Options) (restic.Backend, error) {
+// createLocation creates the backend at the location s.
+func createLocation(s string, opts options.Options) (restic.Backend, error) {
 	debug.Log("parsing location %v", s)
 	loc, err := location.Parse(s)
 	if err != nil {
@@ -772,6 +1035,10 @@ func create(s string, opts options.Options) (restic.Backend, error) {
 		return local.Create(globalOptions.ctx, cfg.(local.Config))
 	case "sftp":
 		return sftp.Create(globalOptions.ctx, cfg.(sftp.Config))
+	case "sharded":
+		return sharded.Create(globalOptions.ctx, cfg.(sharded.Config))
+	case "archive":
+		return archive.Create(globalOptions.ctx, cfg.(archive.Config))
 	case "s3":
 		return s3.Create(globalOptions.ctx, cfg.(s3.Config), rt)
 	case "gs":
@@ -786,6 +1053,10 @@ func create(s string, opts options.Options) (restic.Backend, error) {
 		return rest.Create(globalOptions.ctx, cfg.(rest.Config), rt)
 	case "rclone":
 		return rclone.Create(globalOptions.ctx, cfg.(rclone.Config))
+	case "webdav":
+		return webdav.Create(globalOptions.ctx, cfg.(webdav.Config), rt)
+	case "exec":
+		return execbackend.Create(globalOptions.ctx, cfg.(execbackend.Config))
 	}
 
 	debug.Log("invalid repository scheme: %v", s)
@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/restic/restic/internal/test"
@@ -59,3 +61,21 @@ func TestReadRepo(t *testing.T) {
 		t.Fatal("must not read repository path from invalid file path")
 	}
 }
+
+func TestOpenRepositoryInvalidCompression(t *testing.T) {
+	tempDir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	rtest.OK(t, os.Setenv("RESTIC_COMPRESSION", "fast"))
+	defer func() {
+		rtest.OK(t, os.Unsetenv("RESTIC_COMPRESSION"))
+	}()
+
+	var opts GlobalOptions
+	opts.Repo = tempDir
+	rtest.Assert(t, opts.Compression.Set(os.Getenv("RESTIC_COMPRESSION")) != nil, "invalid compression mode was accepted")
+
+	_, err := OpenRepository(opts)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), `invalid compression mode "fast" in RESTIC_COMPRESSION`),
+		"expected error for invalid compression mode, got %v", err)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
 	"context"
@@ -20,9 +21,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/options"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
@@ -103,6 +106,26 @@ func testRunRestore(t testing.TB, opts GlobalOptions, dir string, snapshotID res
 	testRunRestoreExcludes(t, opts, dir, snapshotID, nil)
 }
 
+func testRunRestoreAssumeFailure(t testing.TB, snapshotID string, opts RestoreOptions, gopts GlobalOptions) error {
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	var wg errgroup.Group
+	term := termstatus.New(gopts.stdout, gopts.stderr, gopts.Quiet)
+	wg.Go(func() error { term.Run(ctx); return nil })
+
+	restoreErr := runRestore(opts, gopts, term, []string{snapshotID})
+
+	cancel()
+
+	err := wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return restoreErr
+}
+
 func testRunRestoreLatest(t testing.TB, gopts GlobalOptions, dir string, paths []string, hosts []string) {
 	opts := RestoreOptions{
 		Target: dir,
@@ -110,7 +133,7 @@ func testRunRestoreLatest(t testing.TB, gopts GlobalOptions, dir string, paths [
 		Paths:  paths,
 	}
 
-	rtest.OK(t, runRestore(opts, gopts, []string{"latest"}))
+	rtest.OK(t, testRunRestoreAssumeFailure(t, "latest", opts, gopts))
 }
 
 func testRunRestoreExcludes(t testing.TB, gopts GlobalOptions, dir string, snapshotID restic.ID, excludes []string) {
@@ -119,7 +142,7 @@ func testRunRestoreExcludes(t testing.TB, gopts GlobalOptions, dir string, snaps
 		Exclude: excludes,
 	}
 
-	rtest.OK(t, runRestore(opts, gopts, []string{snapshotID.String()}))
+	rtest.OK(t, testRunRestoreAssumeFailure(t, snapshotID.String(), opts, gopts))
 }
 
 func testRunRestoreIncludes(t testing.TB, gopts GlobalOptions, dir string, snapshotID restic.ID, includes []string) {
@@ -128,7 +151,7 @@ func testRunRestoreIncludes(t testing.TB, gopts GlobalOptions, dir string, snaps
 		Include: includes,
 	}
 
-	rtest.OK(t, runRestore(opts, gopts, []string{snapshotID.String()}))
+	rtest.OK(t, testRunRestoreAssumeFailure(t, snapshotID.String(), opts, gopts))
 }
 
 func testRunCheck(t testing.TB, gopts GlobalOptions) {
@@ -420,6 +443,165 @@ func TestBackupNonExistingFile(t *testing.T) {
 	testRunBackup(t, "", dirs, opts, env.gopts)
 }
 
+func TestBackupStdinFromCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a POSIX shell")
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	opts := BackupOptions{
+		StdinFromCommand: true,
+		StdinFilename:    "dump.sql",
+		StdinCommands:    []string{"other.txt=echo 'other data'"},
+	}
+	testRunBackup(t, "", []string{"sh", "-c", "echo dump data"}, opts, env.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+	for name, data := range map[string]string{"dump.sql": "dump data\n", "other.txt": "other data\n"} {
+		buf, err := ioutil.ReadFile(filepath.Join(restoredir, name))
+		rtest.OK(t, err)
+		rtest.Equals(t, data, string(buf))
+	}
+
+	// a failing command must not create a snapshot
+	globalOptions.stderr = ioutil.Discard
+	defer func() {
+		globalOptions.stderr = os.Stderr
+	}()
+
+	opts = BackupOptions{StdinFromCommand: true, StdinFilename: "dump.sql"}
+	err := testRunBackupAssumeFailure(t, "", []string{"sh", "-c", "echo partial; exit 1"}, opts, env.gopts)
+	rtest.Assert(t, err != nil, "backup of failing command did not return an error")
+
+	opts = BackupOptions{StdinCommands: []string{"ok.txt=echo ok", "failed.txt=sh -c 'exit 2'"}}
+	err = testRunBackupAssumeFailure(t, "", nil, opts, env.gopts)
+	rtest.Assert(t, err != nil, "backup of failing command did not return an error")
+
+	snapshotIDs = testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "failed commands created snapshots: %v", snapshotIDs)
+}
+
+func TestBackupFromTar(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("restoring symlinks is not supported on windows")
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	mtime := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	archive := filepath.Join(env.base, "archive.tar")
+	f, err := os.Create(archive)
+	rtest.OK(t, err)
+	tw := tar.NewWriter(f)
+	for _, entry := range []struct {
+		hdr  tar.Header
+		data string
+	}{
+		{tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: mtime}, ""},
+		{tar.Header{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0640, ModTime: mtime}, "file content"},
+		{tar.Header{Name: "dir/hardlink", Typeflag: tar.TypeLink, Linkname: "dir/file", ModTime: mtime}, ""},
+		{tar.Header{Name: "dir/symlink", Typeflag: tar.TypeSymlink, Linkname: "file", Mode: 0777, ModTime: mtime}, ""},
+		{tar.Header{Name: "top", Typeflag: tar.TypeReg, Mode: 0600, ModTime: mtime}, "top content"},
+	} {
+		hdr := entry.hdr
+		hdr.Size = int64(len(entry.data))
+		rtest.OK(t, tw.WriteHeader(&hdr))
+		_, err = tw.Write([]byte(entry.data))
+		rtest.OK(t, err)
+	}
+	rtest.OK(t, tw.Close())
+	rtest.OK(t, f.Close())
+
+	testRunBackup(t, "", nil, BackupOptions{FromTar: archive}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+
+	for name, data := range map[string]string{"dir/file": "file content", "dir/hardlink": "file content", "top": "top content"} {
+		buf, err := ioutil.ReadFile(filepath.Join(restoredir, name))
+		rtest.OK(t, err)
+		rtest.Equals(t, data, string(buf))
+	}
+
+	fi, err := os.Stat(filepath.Join(restoredir, "dir", "file"))
+	rtest.OK(t, err)
+	rtest.Equals(t, os.FileMode(0640), fi.Mode())
+	rtest.Assert(t, fi.ModTime().Equal(mtime), "wrong modification time %v", fi.ModTime())
+
+	linkFi, err := os.Stat(filepath.Join(restoredir, "dir", "hardlink"))
+	rtest.OK(t, err)
+	rtest.Assert(t, os.SameFile(fi, linkFi), "hard link was not restored")
+
+	target, err := os.Readlink(filepath.Join(restoredir, "dir", "symlink"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "file", target)
+}
+
+func TestMirror(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	mirrorRepo := filepath.Join(env.base, "mirror")
+	env.gopts.Mirrors = []string{mirrorRepo}
+
+	testSetupBackupData(t, env)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	// the mirror is a complete repository on its own
+	mirrorOpts := env.gopts
+	mirrorOpts.Repo = mirrorRepo
+	mirrorOpts.Mirrors = nil
+	snapshotIDs := testRunList(t, "snapshots", mirrorOpts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot in the mirror, got %v", snapshotIDs)
+	testRunCheck(t, mirrorOpts)
+
+	rtest.OK(t, runCheck(CheckOptions{CheckMirrors: true}, env.gopts, nil))
+
+	// a file missing in the mirror is reported by check
+	rtest.OK(t, os.Remove(filepath.Join(mirrorRepo, "snapshots", snapshotIDs[0].String())))
+	err := runCheck(CheckOptions{CheckMirrors: true}, env.gopts, nil)
+	rtest.Assert(t, err != nil, "check did not report the missing file in the mirror")
+
+	// the snapshot is still loaded from the primary repository
+	testRunRestore(t, env.gopts, filepath.Join(env.base, "restore"), snapshotIDs[0])
+	testRunCheck(t, env.gopts)
+
+	// a location which cannot be opened is only skipped if the quorum allows it
+	unavailable := env.gopts
+	unavailableRepo := filepath.Join(env.base, "unavailable")
+	unavailable.Mirrors = []string{mirrorRepo, unavailableRepo}
+	unavailable.backendInnerTestHook = func(r restic.Backend) (restic.Backend, error) {
+		if r.Location() == unavailableRepo {
+			return nil, errors.New("repository is unavailable")
+		}
+		return r, nil
+	}
+	_, err = OpenRepository(unavailable)
+	rtest.Assert(t, err != nil, "opening the repository did not fail for an unavailable mirror")
+
+	unavailable.extended = options.Options{"mirror.quorum": "2"}
+	snapshotIDs = testRunList(t, "snapshots", unavailable)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+
+	unavailable.extended = options.Options{"mirror.quorum": "3"}
+	_, err = OpenRepository(unavailable)
+	rtest.Assert(t, err != nil, "opening the repository did not fail for less than quorum locations")
+}
+
 func removePacksExcept(gopts GlobalOptions, t *testing.T, keep restic.IDSet, removeTreePacks bool) {
 	r, err := OpenRepository(gopts)
 	rtest.OK(t, err)
@@ -985,6 +1167,89 @@ func TestTag(t *testing.T) {
 		"expected original ID to be set to the first snapshot id")
 }
 
+func testRunRewriteExclude(t testing.TB, gopts GlobalOptions, excludes []string, forget bool) {
+	opts := RewriteOptions{
+		excludePatternOptions: excludePatternOptions{
+			Excludes: excludes,
+		},
+		Forget: forget,
+	}
+
+	rtest.OK(t, runRewrite(opts, gopts, nil))
+}
+
+func createBasicRewriteRepo(t testing.TB, env *testEnvironment) restic.ID {
+	testSetupBackupData(t, env)
+
+	// create backup
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	testRunCheck(t, env.gopts)
+
+	return snapshotIDs[0]
+}
+
+func TestRewrite(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	originalID := createBasicRewriteRepo(t, env)
+
+	// exclude some data
+	testRunRewriteExclude(t, env.gopts, []string{"3"}, false)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2, "expected two snapshots, got %v", snapshotIDs)
+	testRunCheck(t, env.gopts)
+
+	// the rewritten snapshot has the same timestamp as the original one
+	_, snapmap := testRunSnapshots(t, env.gopts)
+	var rewritten *Snapshot
+	for id := range snapmap {
+		sn := snapmap[id]
+		if sn.Original != nil {
+			rewritten = &sn
+		}
+	}
+	if rewritten == nil {
+		t.Fatal("expected a rewritten snapshot, got nil")
+	}
+	rtest.Assert(t, *rewritten.Original == originalID,
+		"expected original ID to be set to the first snapshot id")
+	rtest.Assert(t, rewritten.HasTags([]string{"rewrite"}), "expected tag rewrite, got %v", rewritten.Tags)
+
+	for _, file := range testRunLs(t, env.gopts, rewritten.ID.String()) {
+		rtest.Assert(t, filepath.Base(file) != "3", "file %v was not excluded", file)
+	}
+}
+
+func TestRewriteUnchanged(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	snapshotID := createBasicRewriteRepo(t, env)
+
+	// use an exclude that will not exclude anything
+	testRunRewriteExclude(t, env.gopts, []string{"3dflkhjgdflhkjetrlkhjgfdlhkj"}, false)
+	newSnapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(newSnapshotIDs) == 1, "expected one snapshot, got %v", newSnapshotIDs)
+	rtest.Assert(t, snapshotID == newSnapshotIDs[0], "snapshot id changed unexpectedly")
+	testRunCheck(t, env.gopts)
+}
+
+func TestRewriteReplace(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+	snapshotID := createBasicRewriteRepo(t, env)
+
+	// exclude some data
+	testRunRewriteExclude(t, env.gopts, []string{"3"}, true)
+	newSnapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(newSnapshotIDs) == 1, "expected one snapshot, got %v", newSnapshotIDs)
+	rtest.Assert(t, snapshotID != newSnapshotIDs[0], "snapshot id should have changed")
+	// check forbids unused blobs, thus remove them first
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0"})
+	testRunCheck(t, env.gopts)
+}
+
 func testRunKeyListOtherIDs(t testing.TB, gopts GlobalOptions) []string {
 	buf := bytes.NewBuffer(nil)
 
@@ -1613,6 +1878,108 @@ func testPrune(t *testing.T, pruneOpts PruneOptions, checkOpts CheckOptions) {
 	rtest.OK(t, runCheck(checkOpts, env.gopts, nil))
 }
 
+// retainBackend refuses to remove files of the given types, like an S3 bucket
+// with object lock enabled.
+type retainBackend struct {
+	restic.Backend
+	types map[restic.FileType]bool
+
+	m       sync.Mutex
+	removed map[restic.Handle]int
+}
+
+func (b *retainBackend) Remove(ctx context.Context, h restic.Handle) error {
+	if !b.types[h.Type] {
+		return b.Backend.Remove(ctx, h)
+	}
+
+	b.m.Lock()
+	b.removed[h]++
+	b.m.Unlock()
+
+	return backoff.Permanent(errors.Wrapf(restic.ErrRetained, "%v is locked", h))
+}
+
+func TestForgetPruneRetained(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+	packs := listPacks(env.gopts, t)
+
+	// the inner hook is wrapped by the RetryBackend and the cache
+	be := &retainBackend{
+		types:   map[restic.FileType]bool{restic.PackFile: true, restic.SnapshotFile: true},
+		removed: make(map[restic.Handle]int),
+	}
+	env.gopts.backendInnerTestHook = func(r restic.Backend) (restic.Backend, error) {
+		be.Backend = r
+		return be, nil
+	}
+
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%"})
+	removed := packs.Sub(listPacks(env.gopts, t))
+	rtest.Assert(t, len(removed) == 0, "retained packs were removed: %v", removed)
+
+	snapshots := testRunList(t, "snapshots", env.gopts)
+	rtest.Equals(t, 1, len(snapshots))
+	testRunForget(t, env.gopts, snapshots[0].String())
+	rtest.Equals(t, snapshots, testRunList(t, "snapshots", env.gopts))
+
+	types := make(map[restic.FileType]bool)
+	for h, n := range be.removed {
+		types[h.Type] = true
+		rtest.Assert(t, n == 1, "removing %v was retried %d times", h, n-1)
+	}
+	for _, tpe := range []restic.FileType{restic.PackFile, restic.SnapshotFile} {
+		rtest.Assert(t, types[tpe], "no %v was removed", tpe)
+	}
+}
+
+func TestPruneRetainedIndex(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testSetupBackupData(t, env)
+	opts := BackupOptions{}
+
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+	packs := listPacks(env.gopts, t)
+
+	// the retention of the packs has already expired, but the index files
+	// were uploaded later and are still retained
+	be := &retainBackend{
+		types:   map[restic.FileType]bool{restic.IndexFile: true},
+		removed: make(map[restic.Handle]int),
+	}
+	env.gopts.backendInnerTestHook = func(r restic.Backend) (restic.Backend, error) {
+		be.Backend = r
+		return be, nil
+	}
+
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%"})
+	rtest.Assert(t, len(be.removed) > 0, "no index was removed")
+	removed := packs.Sub(listPacks(env.gopts, t))
+	rtest.Assert(t, len(removed) == 0, "packs referenced by retained index files were removed: %v", removed)
+	rtest.OK(t, runCheck(CheckOptions{}, env.gopts, nil))
+
+	// once the index files have expired, the packs are removed as well
+	env.gopts.backendInnerTestHook = nil
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%"})
+	removed = packs.Sub(listPacks(env.gopts, t))
+	rtest.Assert(t, len(removed) > 0, "no pack was removed")
+	rtest.OK(t, runCheck(CheckOptions{}, env.gopts, nil))
+}
+
 var pruneDefaultOptions = PruneOptions{MaxUnused: "5%"}
 
 func listPacks(gopts GlobalOptions, t *testing.T) restic.IDSet {
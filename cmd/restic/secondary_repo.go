@@ -40,6 +40,8 @@ func fillSecondaryGlobalOpts(opts secondaryRepoOptions, gopts GlobalOptions, rep
 	dstGopts.PasswordFile = opts.PasswordFile
 	dstGopts.PasswordCommand = opts.PasswordCommand
 	dstGopts.KeyHint = opts.KeyHint
+	// mirrors only apply to the primary repository
+	dstGopts.Mirrors = nil
 	if opts.password != "" {
 		dstGopts.password = opts.password
 	} else {
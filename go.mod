@@ -15,6 +15,7 @@ require (
 	github.com/google/go-cmp v0.5.6
 	github.com/hashicorp/golang-lru v0.5.4
 	github.com/juju/ratelimit v1.0.1
+	github.com/klauspost/compress v1.18.0
 	github.com/kurin/blazer v0.5.3
 	github.com/minio/minio-go/v7 v7.0.14
 	github.com/minio/sha256-simd v1.0.0
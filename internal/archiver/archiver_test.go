@@ -155,6 +155,61 @@ func TestArchiverSaveFile(t *testing.T) {
 	}
 }
 
+func TestArchiverSaveSparseFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const mib = 1 << 20
+	data := restictest.Random(23, mib)
+	content := make([]byte, 8*mib)
+	copy(content, data)
+	copy(content[5*mib:], data)
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, TestDir{"dense": TestFile{Content: string(content)}})
+	defer cleanup()
+
+	// write a file with the same content, but a hole between the data and
+	// at the end
+	filename := filepath.Join(tempdir, "sparse")
+	f, err := os.Create(filename)
+	restictest.OK(t, err)
+	for _, offset := range []int64{0, 5 * mib} {
+		_, err = f.WriteAt(data, offset)
+		restictest.OK(t, err)
+	}
+	restictest.OK(t, f.Truncate(int64(len(content))))
+	restictest.OK(t, f.Close())
+
+	f, err = os.Open(filename)
+	restictest.OK(t, err)
+	fi, err := f.Stat()
+	restictest.OK(t, err)
+	regions := findHoles(f, fi)
+	restictest.OK(t, f.Close())
+	if len(regions) == 1 {
+		t.Skip("file system does not report holes")
+	}
+
+	countZeroBlobs := func(node *restic.Node) (n int) {
+		for _, id := range node.Content {
+			if id == restic.ZeroBlobID(minHoleSize) {
+				n++
+			}
+		}
+		return n
+	}
+
+	node, _ := saveFile(t, repo, filename, fs.Track{FS: fs.Local{}})
+	TestEnsureFileContent(ctx, t, repo, "sparse", node, TestFile{Content: string(content)})
+	// the holes of 4 MiB and 2 MiB are stored as blobs of 512 KiB
+	restictest.Equals(t, 12, countZeroBlobs(node))
+
+	// the chunker splits long runs of zeros into blobs of the same size
+	node, _ = saveFile(t, repo, filepath.Join(tempdir, "dense"), fs.Track{FS: fs.Local{}})
+	TestEnsureFileContent(ctx, t, repo, "dense", node, TestFile{Content: string(content)})
+	restictest.Assert(t, countZeroBlobs(node) > 0, "no all-zero blobs in dense file")
+}
+
 func TestArchiverSaveFileReaderFS(t *testing.T) {
 	var tests = []struct {
 		Data string
@@ -1894,7 +1949,7 @@ func TestArchiverContextCanceled(t *testing.T) {
 	defer removeTempdir()
 
 	// Ensure that the archiver itself reports the canceled context and not just the backend
-	repo, _ := repository.TestRepositoryWithBackend(t, &noCancelBackend{mem.New()})
+	repo, _ := repository.TestRepositoryWithBackend(t, &noCancelBackend{mem.New()}, 0)
 
 	back := restictest.Chdir(t, tempdir)
 	defer back()
@@ -99,7 +99,13 @@ type saveBlobResponse struct {
 }
 
 func (s *BlobSaver) saveBlob(ctx context.Context, t restic.BlobType, buf []byte) (saveBlobResponse, error) {
-	id, known, err := s.repo.SaveBlob(ctx, t, buf, restic.ID{}, false)
+	var id restic.ID
+	// sparse files mostly consist of all-zero blobs, don't hash them again
+	if t == restic.DataBlob && len(buf) > 0 && restic.ZeroPrefixLen(buf) == len(buf) {
+		id = restic.ZeroBlobID(uint(len(buf)))
+	}
+
+	id, known, err := s.repo.SaveBlob(ctx, t, buf, id, false)
 
 	if err != nil {
 		return saveBlobResponse{}, err
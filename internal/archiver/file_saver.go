@@ -155,34 +155,17 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		return saveFileResponse{err: errors.Errorf("node type %q is wrong", node.Type)}
 	}
 
-	// reuse the chunker
-	chnker.Reset(f, s.pol)
-
 	var results []FutureBlob
 
 	node.Content = []restic.ID{}
 	var size uint64
-	for {
-		buf := s.saveFilePool.Get()
-		chunk, err := chnker.Next(buf.Data)
-		if errors.Cause(err) == io.EOF {
-			buf.Release()
-			break
-		}
 
-		buf.Data = chunk.Data
-
-		size += uint64(chunk.Length)
-
-		if err != nil {
-			_ = f.Close()
-			return saveFileResponse{err: err}
-		}
+	save := func(buf *Buffer) error {
+		size += uint64(len(buf.Data))
 
 		// test if the context has been cancelled, return the error
 		if ctx.Err() != nil {
-			_ = f.Close()
-			return saveFileResponse{err: ctx.Err()}
+			return ctx.Err()
 		}
 
 		res := s.saveBlob(ctx, restic.DataBlob, buf)
@@ -190,11 +173,25 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 
 		// test if the context has been cancelled, return the error
 		if ctx.Err() != nil {
-			_ = f.Close()
-			return saveFileResponse{err: ctx.Err()}
+			return ctx.Err()
 		}
 
-		s.CompleteBlob(f.Name(), uint64(len(chunk.Data)))
+		s.CompleteBlob(f.Name(), uint64(len(buf.Data)))
+		return nil
+	}
+
+	regions := findHoles(f, fi)
+	for i, region := range regions {
+		var err error
+		if region.Hole {
+			err = s.saveHole(region.Length, save)
+		} else {
+			err = s.saveData(chnker, f, region, i == len(regions)-1, save)
+		}
+		if err != nil {
+			_ = f.Close()
+			return saveFileResponse{err: err}
+		}
 	}
 
 	err = f.Close()
@@ -220,6 +217,91 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 	}
 }
 
+// minHoleSize is the minimum size of a hole in a sparse file which is not
+// read from the file. Smaller holes are read and chunked like data.
+const minHoleSize = chunker.MinSize
+
+// findHoles returns the regions of a sparse file. If the file does not contain
+// holes or they cannot be detected, the file consists of a single data region.
+func findHoles(f fs.File, fi os.FileInfo) []fs.Region {
+	all := []fs.Region{{Offset: 0, Length: fi.Size()}}
+	if fi.Size() < minHoleSize {
+		return all
+	}
+
+	regions, err := fs.Regions(f, fi.Size(), minHoleSize)
+	if err != nil {
+		debug.Log("unable to find holes in %v: %v", f.Name(), err)
+		return all
+	}
+	if len(regions) == 0 {
+		return all
+	}
+	return regions
+}
+
+// saveData splits the data region of f into chunks and saves them. The last
+// region is read until the end of the file, which may have grown in the
+// meantime.
+func (s *FileSaver) saveData(chnker *chunker.Chunker, f fs.File, region fs.Region, last bool, save func(*Buffer) error) error {
+	var rd io.Reader = f
+	if region.Offset > 0 {
+		_, err := f.Seek(region.Offset, io.SeekStart)
+		if err != nil {
+			return errors.Wrap(err, "Seek")
+		}
+	}
+	if !last {
+		rd = io.LimitReader(f, region.Length)
+	}
+
+	// reuse the chunker
+	chnker.Reset(rd, s.pol)
+
+	for {
+		buf := s.saveFilePool.Get()
+		chunk, err := chnker.Next(buf.Data)
+		if errors.Cause(err) == io.EOF {
+			buf.Release()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		buf.Data = chunk.Data
+		err = save(buf)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// saveHole saves a hole of the given length without reading it. Holes are
+// stored as all-zero blobs with the size the chunker uses for long runs of
+// zeros, whose IDs need not be computed again.
+func (s *FileSaver) saveHole(length int64, save func(*Buffer) error) error {
+	for length > 0 {
+		n := int64(chunker.MinSize)
+		if length < n {
+			n = length
+		}
+		length -= n
+
+		buf := s.saveFilePool.Get()
+		buf.Data = buf.Data[:n]
+		for i := range buf.Data {
+			buf.Data[i] = 0
+		}
+
+		err := save(buf)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *FileSaver) worker(ctx context.Context, jobs <-chan saveFileJob) {
 	// a worker has one chunker which is reused for each file (because it contains a rather large buffer)
 	chnker := chunker.New(nil, s.pol)
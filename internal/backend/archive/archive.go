@@ -0,0 +1,816 @@
+// Package archive implements a backend which stores a repository in a single
+// file, for example on removable media. Files are appended to the archive as
+// segments, removed files only free their space when the archive is
+// compacted.
+package archive
+
+import (
+	"context"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// Backend stores a repository in a single file.
+type Backend struct {
+	Config
+
+	// wm serializes appending segments to the archive.
+	wm sync.Mutex
+
+	// m protects the following fields, they are only modified while wm is
+	// held as well.
+	m        sync.RWMutex
+	f        *os.File
+	readOnly bool
+	dir      map[string]entry
+	end      int64 // offset of the next segment
+	size     int64 // size of the file, larger than end after an interrupted write
+	modified bool  // a directory segment must be written on close
+
+	// rm protects readers, the number of Load calls reading from f. They
+	// only start while m is held, idle is signalled when the last one ends.
+	rm      sync.Mutex
+	readers int
+	idle    *sync.Cond
+}
+
+// statically ensure that Backend implements restic.Backend.
+var _ restic.Backend = &Backend{}
+
+// errLocked is returned by lockFile if the file is locked by another process.
+var errLocked = errors.New("file is locked")
+
+// openLocked opens the file at path and locks it, exclusively if it is opened
+// for writing. An archive can thus only be written by a single process.
+func openLocked(path string, flag int, perm os.FileMode) (*os.File, error) {
+	for {
+		f, err := fs.OpenFile(path, flag, perm)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		err = lockFile(f, flag&(os.O_WRONLY|os.O_RDWR) != 0)
+		if err == errLocked {
+			_ = f.Close()
+			return nil, errors.Errorf("archive %v is used by another process", path)
+		}
+		if err != nil {
+			_ = f.Close()
+			return nil, errors.Wrap(err, "lock")
+		}
+
+		// Compact replaces the file, make sure that the lock was acquired for
+		// the current one
+		fi, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return nil, errors.WithStack(err)
+		}
+		cur, err := fs.Stat(path)
+		if err == nil && os.SameFile(fi, cur) {
+			return f, nil
+		}
+
+		_ = f.Close()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		debug.Log("%v was replaced while it was locked, trying again", path)
+	}
+}
+
+// Open opens the archive file cfg.Path. When the file cannot be written, for
+// example on optical media, it is opened read-only.
+func Open(ctx context.Context, cfg Config) (*Backend, error) {
+	debug.Log("open archive at %v", cfg.Path)
+
+	readOnly := false
+	f, err := openLocked(cfg.Path, os.O_RDWR, 0)
+	if os.IsPermission(errors.Cause(err)) || errors.Is(err, syscall.EROFS) {
+		debug.Log("opening %v read-only: %v", cfg.Path, err)
+		readOnly = true
+		f, err = openLocked(cfg.Path, os.O_RDONLY, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	be := &Backend{Config: cfg, f: f, readOnly: readOnly}
+	be.idle = sync.NewCond(&be.rm)
+	err = be.load()
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, cfg.Path)
+	}
+
+	return be, nil
+}
+
+// Create creates a new archive file at cfg.Path. An existing archive is
+// opened, unless it already contains a config file.
+func Create(ctx context.Context, cfg Config) (*Backend, error) {
+	debug.Log("create archive at %v", cfg.Path)
+
+	f, err := openLocked(cfg.Path, os.O_RDWR|os.O_CREATE, backend.Modes.File)
+	if err != nil {
+		return nil, err
+	}
+
+	be := &Backend{Config: cfg, f: f}
+	be.idle = sync.NewCond(&be.rm)
+	fi, err := f.Stat()
+	if err == nil && fi.Size() == 0 {
+		err = be.init()
+	} else if err == nil {
+		err = be.load()
+		if _, ok := be.dir[string(restic.ConfigFile)]; err == nil && ok {
+			err = errors.New("config file already exists")
+		}
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, cfg.Path)
+	}
+
+	return be, nil
+}
+
+// init writes the magic string to a new archive.
+func (b *Backend) init() error {
+	_, err := b.f.WriteAt([]byte(magic), 0)
+	if err == nil {
+		err = b.f.Sync()
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	b.dir = make(map[string]entry)
+	b.end = int64(len(magic))
+	b.size = b.end
+	return nil
+}
+
+// load reads the directory of the archive, either from the directory segment
+// written when the archive was closed, or from the headers of all segments.
+func (b *Backend) load() error {
+	fi, err := b.f.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	b.size = fi.Size()
+
+	buf := make([]byte, len(magic))
+	_, err = b.f.ReadAt(buf, 0)
+	if err != nil || string(buf) != magic {
+		return errors.New("file is not a restic archive")
+	}
+
+	b.dir, err = b.readDirectory()
+	if err == nil {
+		b.end = b.size
+		return nil
+	}
+
+	debug.Log("unable to read directory (%v), scanning segments", err)
+	return b.scan()
+}
+
+// readAt reads exactly len(buf) bytes at offset off.
+func (b *Backend) readAt(buf []byte, off int64) error {
+	_, err := b.f.ReadAt(buf, off)
+	return errors.WithStack(err)
+}
+
+// readSegment reads the segment at offset off and verifies its checksum.
+func (b *Backend) readSegment(off int64) (header, string, []byte, error) {
+	buf := make([]byte, headerSize)
+	if err := b.readAt(buf, off); err != nil {
+		return header{}, "", nil, err
+	}
+
+	h, err := unmarshalHeader(buf)
+	if err != nil {
+		return header{}, "", nil, err
+	}
+	if off+h.size() > b.size {
+		return header{}, "", nil, errors.New("segment is truncated")
+	}
+
+	buf = make([]byte, int64(h.nameLen)+int64(h.dataLen))
+	if err := b.readAt(buf, off+headerSize); err != nil {
+		return header{}, "", nil, err
+	}
+
+	if crc32.Checksum(buf, crcTable) != h.crc {
+		return header{}, "", nil, errors.Errorf("segment at offset %d is damaged", off)
+	}
+
+	return h, string(buf[:h.nameLen]), buf[h.nameLen:], nil
+}
+
+// readDirectory reads the directory segment referenced by the trailer at the
+// end of the archive.
+func (b *Backend) readDirectory() (map[string]entry, error) {
+	off := b.size - trailerSize
+	if off < int64(len(magic)) {
+		return nil, errors.New("archive has no trailer")
+	}
+
+	h, _, data, err := b.readSegment(off)
+	if err != nil {
+		return nil, err
+	}
+	if h.kind != kindTrailer || len(data) != 8 {
+		return nil, errors.New("archive has no trailer")
+	}
+
+	dirOffset := int64(binary.LittleEndian.Uint64(data))
+	if dirOffset < int64(len(magic)) || dirOffset >= off {
+		return nil, errors.Errorf("invalid directory offset %d", dirOffset)
+	}
+
+	h, _, data, err = b.readSegment(dirOffset)
+	if err != nil {
+		return nil, err
+	}
+	if h.kind != kindDirectory || dirOffset+h.size() != off {
+		return nil, errors.Errorf("no directory at offset %d", dirOffset)
+	}
+
+	return unmarshalDirectory(data)
+}
+
+// scan reads the headers of all segments. The last segment may be incomplete
+// if restic was interrupted while writing it, it is ignored in that case and
+// overwritten by the next segment.
+func (b *Backend) scan() error {
+	b.dir = make(map[string]entry)
+
+	off := int64(len(magic))
+	buf := make([]byte, headerSize)
+	for off < b.size {
+		if off+headerSize > b.size {
+			debug.Log("ignoring truncated segment header at offset %d", off)
+			break
+		}
+
+		if err := b.readAt(buf, off); err != nil {
+			return err
+		}
+		h, err := unmarshalHeader(buf)
+		if err != nil {
+			return errors.Wrapf(err, "offset %d", off)
+		}
+
+		if off+h.size() > b.size {
+			debug.Log("ignoring truncated segment at offset %d", off)
+			break
+		}
+
+		if off+h.size() == b.size {
+			// only the checksum of the last segment is verified, the others
+			// were completely written before the next segment was started
+			if _, _, _, err := b.readSegment(off); err != nil {
+				debug.Log("ignoring last segment: %v", err)
+				break
+			}
+		}
+
+		name := make([]byte, h.nameLen)
+		if err := b.readAt(name, off+headerSize); err != nil {
+			return err
+		}
+
+		switch h.kind {
+		case kindFile:
+			b.dir[string(name)] = entry{
+				offset: off + headerSize + int64(h.nameLen),
+				length: int64(h.dataLen),
+			}
+		case kindRemove:
+			delete(b.dir, string(name))
+		}
+
+		off += h.size()
+	}
+
+	b.end = off
+	// write a directory on close, so the next Open does not need to scan
+	b.modified = b.size > int64(len(magic))
+	return nil
+}
+
+// Location returns the file name of the archive.
+func (b *Backend) Location() string {
+	return b.Path
+}
+
+// Hasher may return a hash function for calculating a content hash for the backend
+func (b *Backend) Hasher() hash.Hash {
+	return nil
+}
+
+// IsNotExist returns true if the error is caused by a non existing file.
+func (b *Backend) IsNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
+
+func notExist(op string, h restic.Handle) error {
+	return errors.WithStack(&os.PathError{Op: op, Path: filename(h), Err: os.ErrNotExist})
+}
+
+// offsetWriter writes to f sequentially, starting at offset off.
+type offsetWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// written records that this process has written the archive up to offset off.
+// This allows prepareAppend to tell the remains of a failed write apart from
+// data written by someone else.
+func (b *Backend) written(off int64) {
+	b.m.Lock()
+	if off > b.size {
+		b.size = off
+	}
+	b.m.Unlock()
+}
+
+// prepareAppend makes sure that the archive can be written. It must be called
+// with wm held.
+func (b *Backend) prepareAppend() error {
+	if b.readOnly {
+		return backoff.Permanent(errors.Errorf("archive %v is read-only", b.Path))
+	}
+
+	fi, err := b.f.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// the archive is locked exclusively, but never remove data which this
+	// process does not know about
+	if fi.Size() != b.size {
+		return backoff.Permanent(errors.Errorf("archive %v was modified by another process, expected %d bytes, found %d", b.Path, b.size, fi.Size()))
+	}
+
+	if b.size > b.end {
+		// remove the remains of a failed write of this process, or of an
+		// interrupted write found by scan
+		debug.Log("truncating %v to %d bytes", b.Path, b.end)
+		err = b.f.Truncate(b.end)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	b.m.Lock()
+	b.size = b.end
+	b.m.Unlock()
+	return nil
+}
+
+// appendSegment appends the segment seg to the archive. It must be called with
+// wm held.
+func (b *Backend) appendSegment(seg []byte) error {
+	if err := b.prepareAppend(); err != nil {
+		return err
+	}
+
+	n, err := b.f.WriteAt(seg, b.end)
+	b.written(b.end + int64(n))
+	if err == nil {
+		err = b.f.Sync()
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	b.m.Lock()
+	b.end += int64(len(seg))
+	b.size = b.end
+	b.modified = true
+	b.m.Unlock()
+	return nil
+}
+
+// Save appends the file to the archive.
+func (b *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	debug.Log("Save %v", h)
+	if err := h.Valid(); err != nil {
+		return backoff.Permanent(err)
+	}
+
+	b.wm.Lock()
+	defer b.wm.Unlock()
+
+	if err := b.prepareAppend(); err != nil {
+		return err
+	}
+
+	name := filename(h)
+	hdr := header{
+		kind:    kindFile,
+		nameLen: uint16(len(name)),
+		dataLen: uint64(rd.Length()),
+	}
+
+	// the checksum in the header is updated after the data was written
+	start := b.end
+	buf := append(hdr.marshal(), name...)
+	n, err := b.f.WriteAt(buf, start)
+	b.written(start + int64(n))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	crc := crc32.New(crcTable)
+	_, _ = crc.Write([]byte(name))
+
+	wr := &offsetWriter{f: b.f, off: start + int64(len(buf))}
+	length, err := io.Copy(io.MultiWriter(wr, crc), rd)
+	b.written(wr.off)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if length != rd.Length() {
+		return errors.Errorf("wrote %d bytes instead of the expected %d bytes", length, rd.Length())
+	}
+
+	hdr.crc = crc.Sum32()
+	_, err = b.f.WriteAt(hdr.marshal(), start)
+	if err == nil {
+		err = b.f.Sync()
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	b.m.Lock()
+	b.dir[name] = entry{offset: start + int64(len(buf)), length: length}
+	b.end = wr.off
+	b.size = b.end
+	b.modified = true
+	b.m.Unlock()
+
+	return nil
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (b *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	debug.Log("Load %v, length %v, offset %v", h, length, offset)
+	if err := h.Valid(); err != nil {
+		return backoff.Permanent(err)
+	}
+
+	if offset < 0 {
+		return errors.New("offset is negative")
+	}
+
+	b.m.RLock()
+	e, ok := b.dir[filename(h)]
+	f := b.f
+	if ok {
+		b.rm.Lock()
+		b.readers++
+		b.rm.Unlock()
+	}
+	b.m.RUnlock()
+
+	if !ok {
+		return notExist("open", h)
+	}
+
+	// fn runs without holding m, f is not closed until it returns
+	defer func() {
+		b.rm.Lock()
+		b.readers--
+		if b.readers == 0 {
+			b.idle.Broadcast()
+		}
+		b.rm.Unlock()
+	}()
+
+	if offset > e.length {
+		offset = e.length
+	}
+	n := e.length - offset
+	if length > 0 && int64(length) < n {
+		n = int64(length)
+	}
+
+	return fn(io.NewSectionReader(f, e.offset+offset, n))
+}
+
+// lockIdle locks m as soon as no Load reads from f anymore, so that f can be
+// closed. It does not wait while holding m, since the functions passed to
+// Load may call other methods of the backend.
+func (b *Backend) lockIdle() {
+	for {
+		b.m.Lock()
+		b.rm.Lock()
+		if b.readers == 0 {
+			b.rm.Unlock()
+			return
+		}
+		b.m.Unlock()
+
+		for b.readers > 0 {
+			b.idle.Wait()
+		}
+		b.rm.Unlock()
+	}
+}
+
+// Stat returns information about a file in the archive.
+func (b *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	debug.Log("Stat %v", h)
+	if err := h.Valid(); err != nil {
+		return restic.FileInfo{}, backoff.Permanent(err)
+	}
+
+	b.m.RLock()
+	defer b.m.RUnlock()
+
+	e, ok := b.dir[filename(h)]
+	if !ok {
+		return restic.FileInfo{}, notExist("stat", h)
+	}
+
+	return restic.FileInfo{Size: e.length, Name: h.Name}, nil
+}
+
+// Test returns true if a file of the given type and name exists in the
+// archive.
+func (b *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	debug.Log("Test %v", h)
+
+	b.m.RLock()
+	defer b.m.RUnlock()
+
+	_, ok := b.dir[filename(h)]
+	return ok, nil
+}
+
+// Remove marks the file as removed, its space is freed by Compact.
+func (b *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	debug.Log("Remove %v", h)
+
+	b.wm.Lock()
+	defer b.wm.Unlock()
+
+	name := filename(h)
+
+	b.m.RLock()
+	_, ok := b.dir[name]
+	b.m.RUnlock()
+	if !ok {
+		return notExist("remove", h)
+	}
+
+	err := b.appendSegment(segment(kindRemove, name, nil))
+	if err != nil {
+		return err
+	}
+
+	b.m.Lock()
+	delete(b.dir, name)
+	b.m.Unlock()
+	return nil
+}
+
+// List runs fn for each file in the archive which has the type t. When an
+// error occurs (or fn returns an error), List stops and returns it.
+func (b *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	debug.Log("List %v", t)
+
+	prefix := string(t) + "/"
+	var list []restic.FileInfo
+
+	b.m.RLock()
+	for name, e := range b.dir {
+		if strings.HasPrefix(name, prefix) {
+			list = append(list, restic.FileInfo{Name: name[len(prefix):], Size: e.length})
+		}
+	}
+	b.m.RUnlock()
+
+	for _, fi := range list {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := fn(fi)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// writeDirectory appends the directory and the trailer segments. It must be
+// called with wm held, which also protects the directory from modifications.
+func (b *Backend) writeDirectory() error {
+	err := b.appendSegment(directorySegments(b.dir, b.end))
+	if err != nil {
+		return err
+	}
+
+	b.m.Lock()
+	b.modified = false
+	b.m.Unlock()
+	return nil
+}
+
+// Close writes the directory if the archive was modified and closes the file.
+func (b *Backend) Close() error {
+	b.wm.Lock()
+	defer b.wm.Unlock()
+
+	if b.f == nil {
+		return nil
+	}
+
+	var err error
+	if b.modified && !b.readOnly {
+		err = b.writeDirectory()
+	}
+
+	b.lockIdle()
+	defer b.m.Unlock()
+
+	if cerr := b.f.Close(); cerr != nil && err == nil {
+		err = errors.WithStack(cerr)
+	}
+	b.f = nil
+	return err
+}
+
+// Delete removes the archive file.
+func (b *Backend) Delete(ctx context.Context) error {
+	b.wm.Lock()
+	b.lockIdle()
+	if b.f != nil {
+		_ = b.f.Close()
+		b.f = nil
+	}
+	b.m.Unlock()
+	b.wm.Unlock()
+
+	return errors.WithStack(fs.Remove(b.Path))
+}
+
+// Size returns the size of the archive file and the number of bytes used by
+// files which have not been removed.
+func (b *Backend) Size() (size int64, used int64) {
+	b.m.RLock()
+	defer b.m.RUnlock()
+
+	for name, e := range b.dir {
+		used += headerSize + int64(len(name)) + e.length
+	}
+	return b.size, used
+}
+
+// Compact rewrites the archive without the space used by removed files and
+// old directories. The new archive is written to a temporary file first which
+// then replaces the archive, so enough free space for a copy of the remaining
+// files is needed.
+func (b *Backend) Compact(ctx context.Context) error {
+	b.wm.Lock()
+	defer b.wm.Unlock()
+
+	if err := b.prepareAppend(); err != nil {
+		return err
+	}
+
+	// the directory is only modified while wm is held
+	names := make([]string, 0, len(b.dir))
+	for name := range b.dir {
+		names = append(names, name)
+	}
+
+	// keep the order of the files in the archive
+	sort.Slice(names, func(i, j int) bool {
+		return b.dir[names[i]].offset < b.dir[names[j]].offset
+	})
+
+	// the new archive is locked before it replaces the old one, so that no
+	// other process can open it in between
+	tmpname := b.Path + ".compact"
+	f, err := openLocked(tmpname, os.O_RDWR|os.O_CREATE, backend.Modes.File)
+	if err == nil {
+		err = errors.WithStack(f.Truncate(0))
+	}
+	if err != nil {
+		if f != nil {
+			_ = f.Close()
+		}
+		return err
+	}
+
+	dir, size, err := b.copyFiles(ctx, f, names)
+	if err != nil {
+		_ = f.Close()
+		_ = fs.Remove(tmpname)
+		return err
+	}
+
+	b.lockIdle()
+	defer b.m.Unlock()
+
+	// an open file cannot be replaced on Windows, so the old archive is
+	// closed (which also releases the lock) before the rename
+	err = b.f.Close()
+	if err == nil {
+		err = fs.Rename(tmpname, b.Path)
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = fs.Remove(tmpname)
+		return b.reopen(errors.WithStack(err))
+	}
+
+	b.f = f
+	b.dir = dir
+	b.end = size
+	b.size = size
+	b.modified = false
+	return nil
+}
+
+// reopen opens the archive again after compacting it failed with err. The
+// file is unchanged, so the directory is still valid. It must be called with
+// m held.
+func (b *Backend) reopen(err error) error {
+	f, oerr := openLocked(b.Path, os.O_RDWR, 0)
+	if oerr != nil {
+		b.f = nil
+		return errors.Wrapf(err, "reopening archive failed: %v", oerr)
+	}
+
+	b.f = f
+	return err
+}
+
+// copyFiles writes a new archive with the files names to f, including the
+// directory and trailer segments.
+func (b *Backend) copyFiles(ctx context.Context, f *os.File, names []string) (map[string]entry, int64, error) {
+	wr := &offsetWriter{f: f}
+	if _, err := wr.Write([]byte(magic)); err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+
+	dir := make(map[string]entry, len(names))
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+
+		e := b.dir[name]
+
+		// copy the complete segment including the header
+		start := e.offset - headerSize - int64(len(name))
+		n, err := io.Copy(wr, io.NewSectionReader(b.f, start, e.offset+e.length-start))
+		if err != nil {
+			return nil, 0, errors.WithStack(err)
+		}
+
+		dir[name] = entry{offset: wr.off - e.length, length: e.length}
+		debug.Log("copied %v (%d bytes)", name, n)
+	}
+
+	if _, err := wr.Write(directorySegments(dir, wr.off)); err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+
+	return dir, wr.off, nil
+}
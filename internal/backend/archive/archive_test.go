@@ -0,0 +1,62 @@
+package archive_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/archive"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func newTestSuite(t testing.TB) *test.Suite {
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			dir, err := ioutil.TempDir(rtest.TestTempDir, "restic-test-archive-")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := archive.Config{
+				Path: filepath.Join(dir, "repo.restic"),
+			}
+			t.Logf("create new backend at %v", cfg.Path)
+			return cfg, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(archive.Config)
+			return archive.Create(context.TODO(), cfg)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(archive.Config)
+			return archive.Open(context.TODO(), cfg)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			cfg := config.(archive.Config)
+			if !rtest.TestCleanupTempDirs {
+				t.Logf("leaving test backend at %v", cfg.Path)
+			}
+
+			rtest.RemoveAll(t, filepath.Dir(cfg.Path))
+			return nil
+		},
+	}
+}
+
+func TestBackend(t *testing.T) {
+	newTestSuite(t).RunTests(t)
+}
+
+func BenchmarkBackend(t *testing.B) {
+	newTestSuite(t).RunBenchmarks(t)
+}
@@ -0,0 +1,26 @@
+package archive
+
+import (
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// Config holds the name of the file a repository is stored in.
+type Config struct {
+	Path string
+}
+
+// ParseConfig parses an archive backend config.
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "archive:") {
+		return nil, errors.New(`invalid format, prefix "archive" not found`)
+	}
+
+	path := s[8:]
+	if path == "" {
+		return nil, errors.New("archive: file name is empty")
+	}
+
+	return Config{Path: path}, nil
+}
@@ -0,0 +1,37 @@
+package archive
+
+import "testing"
+
+var configTests = []struct {
+	s   string
+	cfg Config
+}{
+	{"archive:/media/usb/repo.restic", Config{Path: "/media/usb/repo.restic"}},
+	{"archive:repo.restic", Config{Path: "repo.restic"}},
+	{"archive:E:\\repo.restic", Config{Path: "E:\\repo.restic"}},
+}
+
+func TestParseConfig(t *testing.T) {
+	for i, test := range configTests {
+		cfg, err := ParseConfig(test.s)
+		if err != nil {
+			t.Errorf("test %d:%s failed: %v", i, test.s, err)
+			continue
+		}
+
+		if cfg != test.cfg {
+			t.Errorf("test %d:\ninput:\n  %s\n wrong config, want:\n  %v\ngot:\n  %v",
+				i, test.s, test.cfg, cfg)
+			continue
+		}
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	for _, s := range []string{"archive:", "local:/media/usb/repo.restic"} {
+		_, err := ParseConfig(s)
+		if err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
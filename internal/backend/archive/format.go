@@ -0,0 +1,156 @@
+package archive
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// An archive file starts with the magic string, followed by segments. Each
+// segment consists of a fixed-size header, the name of the file and its data:
+//
+//	kind     uint8
+//	reserved uint8
+//	nameLen  uint16
+//	crc      uint32 (CRC-32C of the name and the data)
+//	dataLen  uint64
+//	name     [nameLen]byte
+//	data     [dataLen]byte
+//
+// All integers are stored in little endian byte order. Segments are only ever
+// appended, a newer segment for the same name replaces an older one.
+//
+// When the archive is closed after it was modified, a directory segment with
+// the offsets of all current files is appended, followed by a trailer segment
+// which holds the offset of the directory. This allows opening the archive
+// without reading all segment headers.
+const magic = "restic archive\n\x01"
+
+const headerSize = 16
+
+// kinds of segments
+const (
+	kindFile      = 1
+	kindRemove    = 2
+	kindDirectory = 3
+	kindTrailer   = 4
+)
+
+// trailerSize is the size of the trailer segment, the data is the offset of
+// the directory segment.
+const trailerSize = headerSize + 8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type header struct {
+	kind    uint8
+	nameLen uint16
+	crc     uint32
+	dataLen uint64
+}
+
+func (h header) size() int64 {
+	return headerSize + int64(h.nameLen) + int64(h.dataLen)
+}
+
+func (h header) marshal() []byte {
+	buf := make([]byte, headerSize)
+	buf[0] = h.kind
+	binary.LittleEndian.PutUint16(buf[2:], h.nameLen)
+	binary.LittleEndian.PutUint32(buf[4:], h.crc)
+	binary.LittleEndian.PutUint64(buf[8:], h.dataLen)
+	return buf
+}
+
+func unmarshalHeader(buf []byte) (header, error) {
+	h := header{
+		kind:    buf[0],
+		nameLen: binary.LittleEndian.Uint16(buf[2:]),
+		crc:     binary.LittleEndian.Uint32(buf[4:]),
+		dataLen: binary.LittleEndian.Uint64(buf[8:]),
+	}
+
+	if h.kind < kindFile || h.kind > kindTrailer || buf[1] != 0 {
+		return header{}, errors.Errorf("invalid segment kind %d", h.kind)
+	}
+	return h, nil
+}
+
+// segment returns a complete segment with the given kind, name and data.
+func segment(kind uint8, name string, data []byte) []byte {
+	crc := crc32.Update(crc32.Checksum([]byte(name), crcTable), crcTable, data)
+	h := header{
+		kind:    kind,
+		nameLen: uint16(len(name)),
+		crc:     crc,
+		dataLen: uint64(len(data)),
+	}
+
+	buf := h.marshal()
+	buf = append(buf, name...)
+	return append(buf, data...)
+}
+
+// entry describes the position of a file's data in the archive.
+type entry struct {
+	offset int64
+	length int64
+}
+
+// marshalDirectory returns the data of a directory segment.
+func marshalDirectory(dir map[string]entry) []byte {
+	var buf []byte
+	var tmp [8]byte
+	for name, e := range dir {
+		binary.LittleEndian.PutUint16(tmp[:], uint16(len(name)))
+		buf = append(buf, tmp[:2]...)
+		buf = append(buf, name...)
+		binary.LittleEndian.PutUint64(tmp[:], uint64(e.offset))
+		buf = append(buf, tmp[:]...)
+		binary.LittleEndian.PutUint64(tmp[:], uint64(e.length))
+		buf = append(buf, tmp[:]...)
+	}
+	return buf
+}
+
+func unmarshalDirectory(buf []byte) (map[string]entry, error) {
+	dir := make(map[string]entry)
+	for len(buf) > 0 {
+		if len(buf) < 2 {
+			return nil, errors.New("directory is truncated")
+		}
+		n := int(binary.LittleEndian.Uint16(buf))
+		buf = buf[2:]
+
+		if len(buf) < n+16 {
+			return nil, errors.New("directory is truncated")
+		}
+		name := string(buf[:n])
+		dir[name] = entry{
+			offset: int64(binary.LittleEndian.Uint64(buf[n:])),
+			length: int64(binary.LittleEndian.Uint64(buf[n+8:])),
+		}
+		buf = buf[n+16:]
+	}
+	return dir, nil
+}
+
+// directorySegments returns the directory segment for dir, which is written
+// at offset off, followed by the trailer segment.
+func directorySegments(dir map[string]entry, off int64) []byte {
+	seg := segment(kindDirectory, "", marshalDirectory(dir))
+
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(off))
+	return append(seg, segment(kindTrailer, "", buf[:])...)
+}
+
+// filename returns the name under which the file h is stored in the archive.
+func filename(h restic.Handle) string {
+	if h.Type == restic.ConfigFile {
+		return string(restic.ConfigFile)
+	}
+	return string(h.Type) + "/" + h.Name
+}
@@ -0,0 +1,256 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func save(t testing.TB, be restic.Backend, data string) restic.Handle {
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash([]byte(data)).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader([]byte(data), nil)))
+	return h
+}
+
+func load(t testing.TB, be restic.Backend, h restic.Handle) string {
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	return string(buf)
+}
+
+func list(t testing.TB, be restic.Backend) []string {
+	var names []string
+	rtest.OK(t, be.List(context.TODO(), restic.PackFile, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	}))
+	sort.Strings(names)
+	return names
+}
+
+func names(handles ...restic.Handle) []string {
+	var names []string
+	for _, h := range handles {
+		names = append(names, h.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newTestArchive(t testing.TB) (Config, func()) {
+	dir, cleanup := rtest.TempDir(t)
+	cfg := Config{Path: filepath.Join(dir, "repo.restic")}
+
+	be, err := Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.OK(t, be.Close())
+
+	return cfg, cleanup
+}
+
+func TestReopen(t *testing.T) {
+	cfg, cleanup := newTestArchive(t)
+	defer cleanup()
+
+	be, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	h1 := save(t, be, "foo")
+	h2 := save(t, be, "bar")
+	rtest.OK(t, be.Remove(context.TODO(), h2))
+	rtest.OK(t, be.Close())
+
+	// the directory written on close is used
+	be, err = Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Assert(t, !be.modified, "directory was not read")
+	rtest.Equals(t, names(h1), list(t, be))
+	rtest.Equals(t, "foo", load(t, be, h1))
+
+	// without closing, the segments are scanned
+	h3 := save(t, be, "baz")
+	rtest.OK(t, be.f.Close())
+	be, err = Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Assert(t, be.modified, "segments were not scanned")
+	rtest.Equals(t, names(h1, h3), list(t, be))
+	rtest.Equals(t, "baz", load(t, be, h3))
+	rtest.OK(t, be.Close())
+}
+
+func TestLocked(t *testing.T) {
+	cfg, cleanup := newTestArchive(t)
+	defer cleanup()
+
+	be, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+
+	_, err = Open(context.TODO(), cfg)
+	rtest.Assert(t, err != nil, "archive was opened twice for writing")
+	_, err = Create(context.TODO(), cfg)
+	rtest.Assert(t, err != nil, "archive was created while it is open")
+	rtest.OK(t, be.Close())
+
+	be, err = Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.OK(t, be.Close())
+}
+
+func TestModifiedByOthers(t *testing.T) {
+	cfg, cleanup := newTestArchive(t)
+	defer cleanup()
+
+	be, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	h1 := save(t, be, "foo")
+	size := be.end
+
+	// data appended by someone else must not be truncated
+	f, err := os.OpenFile(cfg.Path, os.O_RDWR|os.O_APPEND, 0)
+	rtest.OK(t, err)
+	_, err = f.Write([]byte("foreign data"))
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash([]byte("bar")).String()}
+	err = be.Save(context.TODO(), h, restic.NewByteReader([]byte("bar"), nil))
+	rtest.Assert(t, err != nil, "Save did not detect the modified archive")
+
+	fi, err := os.Stat(cfg.Path)
+	rtest.OK(t, err)
+	rtest.Equals(t, size+int64(len("foreign data")), fi.Size())
+	rtest.Equals(t, names(h1), list(t, be))
+	rtest.OK(t, be.f.Close())
+}
+
+func TestInterruptedSave(t *testing.T) {
+	cfg, cleanup := newTestArchive(t)
+	defer cleanup()
+
+	be, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	h1 := save(t, be, "foo")
+	h2 := save(t, be, "bar")
+	size := be.end
+	rtest.OK(t, be.f.Close())
+
+	// damage the last segment, as if the process was killed while writing it
+	rtest.OK(t, os.Truncate(cfg.Path, size-1))
+	be, err = Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Equals(t, names(h1), list(t, be))
+	rtest.OK(t, be.f.Close())
+
+	rtest.OK(t, os.Truncate(cfg.Path, size))
+	f, err := os.OpenFile(cfg.Path, os.O_RDWR, 0)
+	rtest.OK(t, err)
+	_, err = f.WriteAt([]byte("x"), size-1)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+
+	be, err = Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Equals(t, names(h1), list(t, be))
+
+	// the damaged segment is overwritten
+	h3 := save(t, be, "baz")
+	rtest.OK(t, be.Close())
+
+	be, err = Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Equals(t, names(h1, h3), list(t, be))
+	rtest.Equals(t, "baz", load(t, be, h3))
+
+	found, err := be.Test(context.TODO(), h2)
+	rtest.OK(t, err)
+	rtest.Assert(t, !found, "damaged file %v was found", h2)
+	rtest.OK(t, be.Close())
+}
+
+func TestCompact(t *testing.T) {
+	cfg, cleanup := newTestArchive(t)
+	defer cleanup()
+
+	be, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+
+	var keep []restic.Handle
+	for i := 0; i < 20; i++ {
+		h := save(t, be, string(rtest.Random(i, 1000)))
+		if i%2 == 0 {
+			keep = append(keep, h)
+		} else {
+			rtest.OK(t, be.Remove(context.TODO(), h))
+		}
+	}
+
+	before, used := be.Size()
+	rtest.OK(t, be.Compact(context.TODO()))
+	after, usedAfter := be.Size()
+	rtest.Equals(t, used, usedAfter)
+	rtest.Assert(t, after < before, "archive did not shrink: %d -> %d", before, after)
+
+	fi, err := os.Stat(cfg.Path)
+	rtest.OK(t, err)
+	rtest.Equals(t, after, fi.Size())
+
+	rtest.Equals(t, names(keep...), list(t, be))
+	for i, h := range keep {
+		rtest.Equals(t, string(rtest.Random(2*i, 1000)), load(t, be, h))
+	}
+
+	// the archive can be modified and opened again
+	h := save(t, be, "foo")
+	rtest.OK(t, be.Close())
+
+	be, err = Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.Equals(t, names(append(keep, h)...), list(t, be))
+	rtest.OK(t, be.Close())
+}
+
+func TestLoadConcurrent(t *testing.T) {
+	cfg, cleanup := newTestArchive(t)
+	defer cleanup()
+
+	be, err := Open(context.TODO(), cfg)
+	rtest.OK(t, err)
+	h1 := save(t, be, "foo")
+	h2 := save(t, be, "bar")
+	rtest.OK(t, be.Remove(context.TODO(), h2))
+
+	compacted := make(chan error, 1)
+	err = be.Load(context.TODO(), h1, 0, 0, func(rd io.Reader) error {
+		// the backend can be used while fn runs
+		h3 := save(t, be, "baz")
+		rtest.Equals(t, "baz", load(t, be, h3))
+
+		// compacting waits until fn has returned
+		go func() {
+			compacted <- be.Compact(context.TODO())
+		}()
+		select {
+		case err := <-compacted:
+			t.Fatalf("Compact returned while the archive was read: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		buf, err := ioutil.ReadAll(rd)
+		rtest.OK(t, err)
+		rtest.Equals(t, "foo", string(buf))
+		return nil
+	})
+	rtest.OK(t, err)
+	rtest.OK(t, <-compacted)
+
+	rtest.Equals(t, "foo", load(t, be, h1))
+	rtest.OK(t, be.Close())
+}
@@ -0,0 +1,23 @@
+package archive
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile locks f, exclusively if exclusive is set. errLocked is returned if
+// the file is locked by another process. AIX does not support flock, so a
+// POSIX record lock is used, which only conflicts with other processes.
+func lockFile(f *os.File, exclusive bool) error {
+	lk := unix.Flock_t{Type: unix.F_RDLCK}
+	if exclusive {
+		lk.Type = unix.F_WRLCK
+	}
+
+	err := unix.FcntlFlock(f.Fd(), unix.F_SETLK, &lk)
+	if err == unix.EAGAIN || err == unix.EACCES {
+		return errLocked
+	}
+	return err
+}
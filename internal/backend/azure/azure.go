@@ -309,6 +309,11 @@ func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo,
 		Size: int64(blob.Properties.ContentLength),
 		Name: h.Name,
 	}
+
+	// the MD5 sum is only available for files which were uploaded in one piece
+	if sum, err := base64.StdEncoding.DecodeString(blob.Properties.ContentMD5); err == nil && len(sum) == md5.Size {
+		fi.Hash = sum
+	}
 	return fi, nil
 }
 
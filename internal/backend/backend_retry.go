@@ -4,19 +4,83 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/restic"
 )
 
+// RetryPolicy configures how often and how long RetryBackend retries a failed
+// operation.
+type RetryPolicy struct {
+	// MaxTries is the maximum number of retries for a single operation.
+	MaxTries int
+	// MaxElapsedTime is the maximum time spent retrying a single operation,
+	// zero means no limit.
+	MaxElapsedTime time.Duration
+	// InitialInterval is the delay before the first retry, it is doubled
+	// for each retry up to MaxInterval.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	// Jitter randomizes each delay by up to the given fraction.
+	Jitter float64
+
+	// BreakerThreshold is the number of consecutive failed requests after
+	// which all operations fail immediately, zero disables the circuit
+	// breaker.
+	BreakerThreshold int
+	// BreakerCooldown is the time after which a single request is tried
+	// again once the circuit breaker has tripped, zero means that all
+	// further operations fail.
+	BreakerCooldown time.Duration
+}
+
+// DefaultRetryPolicy returns the policy used by NewRetryBackend.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxTries:        10,
+		MaxElapsedTime:  backoff.DefaultMaxElapsedTime,
+		InitialInterval: backoff.DefaultInitialInterval,
+		MaxInterval:     backoff.DefaultMaxInterval,
+		Jitter:          backoff.DefaultRandomizationFactor,
+	}
+}
+
+// ErrCircuitOpen is returned for operations which are not even attempted
+// because too many requests to the backend have failed in a row.
+var ErrCircuitOpen = errors.New("circuit breaker open: too many consecutive backend errors")
+
+// RetryAfterError is returned by HTTP based backends when the server asked
+// the client to wait before sending the next request.
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	if e.After > 0 {
+		return fmt.Sprintf("%v (retry after %v)", e.Err, e.After)
+	}
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
 // RetryBackend retries operations on the backend in case of an error with a
 // backoff.
 type RetryBackend struct {
 	restic.Backend
-	MaxTries int
-	Report   func(string, error, time.Duration)
+	Policy RetryPolicy
+	Report func(string, error, time.Duration)
+
+	m             sync.Mutex
+	failures      int
+	breakerOpened time.Time
 }
 
 // statically ensure that RetryBackend implements restic.Backend.
@@ -25,11 +89,115 @@ var _ restic.Backend = &RetryBackend{}
 // NewRetryBackend wraps be with a backend that retries operations after a
 // backoff. report is called with a description and the error, if one occurred.
 func NewRetryBackend(be restic.Backend, maxTries int, report func(string, error, time.Duration)) *RetryBackend {
+	policy := DefaultRetryPolicy()
+	policy.MaxTries = maxTries
+	return NewRetryBackendWithPolicy(be, policy, report)
+}
+
+// NewRetryBackendWithPolicy wraps be with a backend that retries operations
+// according to policy. report is called for each retry with a description, the
+// error and the delay until the next try.
+func NewRetryBackendWithPolicy(be restic.Backend, policy RetryPolicy, report func(string, error, time.Duration)) *RetryBackend {
 	return &RetryBackend{
-		Backend:  be,
-		MaxTries: maxTries,
-		Report:   report,
+		Backend: be,
+		Policy:  policy,
+		Report:  report,
+	}
+}
+
+// allow returns an error if the circuit breaker is open.
+func (be *RetryBackend) allow() error {
+	if be.Policy.BreakerThreshold <= 0 {
+		return nil
 	}
+
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	if be.failures < be.Policy.BreakerThreshold {
+		return nil
+	}
+
+	if be.Policy.BreakerCooldown > 0 && time.Since(be.breakerOpened) >= be.Policy.BreakerCooldown {
+		// let one request through, it opens the breaker again if it fails
+		be.breakerOpened = time.Now()
+		return nil
+	}
+
+	return ErrCircuitOpen
+}
+
+// record updates the circuit breaker with the result of a request.
+func (be *RetryBackend) record(err error) {
+	if be.Policy.BreakerThreshold <= 0 {
+		return
+	}
+
+	// errors which are not retried, like files protected by a retention
+	// period, are caused by the request and not by the backend
+	var perr *backoff.PermanentError
+	if errors.As(err, &perr) || errors.Is(err, restic.ErrRetained) {
+		return
+	}
+
+	// files which do not exist show that the backend works
+	if err != nil && be.Backend.IsNotExist(err) {
+		err = nil
+	}
+
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	if err == nil {
+		be.failures = 0
+		return
+	}
+
+	be.failures++
+	if be.failures == be.Policy.BreakerThreshold {
+		debug.Log("circuit breaker opened after %d errors, last error: %v", be.failures, err)
+		be.breakerOpened = time.Now()
+	}
+}
+
+// retryAfterBackOff waits at least as long as the last error requests, but
+// never longer than MaxInterval. It stops when waiting would exceed
+// MaxElapsedTime.
+type retryAfterBackOff struct {
+	*backoff.ExponentialBackOff
+	lastErr *error
+}
+
+func (b retryAfterBackOff) NextBackOff() time.Duration {
+	d := b.ExponentialBackOff.NextBackOff()
+	if d == backoff.Stop {
+		return d
+	}
+
+	var rerr *RetryAfterError
+	if !errors.As(*b.lastErr, &rerr) || rerr.After <= d {
+		return d
+	}
+
+	d = rerr.After
+	if d > b.MaxInterval {
+		d = b.MaxInterval
+	}
+	if b.MaxElapsedTime != 0 && b.GetElapsedTime()+d > b.MaxElapsedTime {
+		return backoff.Stop
+	}
+	return d
+}
+
+func (be *RetryBackend) newBackOff(lastErr *error) backoff.BackOff {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = be.Policy.InitialInterval
+	bo.MaxInterval = be.Policy.MaxInterval
+	bo.MaxElapsedTime = be.Policy.MaxElapsedTime
+	bo.RandomizationFactor = be.Policy.Jitter
+	bo.Reset()
+
+	return backoff.WithMaxRetries(retryAfterBackOff{ExponentialBackOff: bo, lastErr: lastErr}, uint64(be.Policy.MaxTries))
 }
 
 func (be *RetryBackend) retry(ctx context.Context, msg string, f func() error) error {
@@ -43,8 +211,24 @@ func (be *RetryBackend) retry(ctx context.Context, msg string, f func() error) e
 		return ctx.Err()
 	}
 
-	err := backoff.RetryNotify(f,
-		backoff.WithContext(backoff.WithMaxRetries(backoff.NewExponentialBackOff(), uint64(be.MaxTries)), ctx),
+	var lastErr error
+	err := backoff.RetryNotify(func() error {
+		if err := be.allow(); err != nil {
+			// report the original error if the breaker tripped while
+			// retrying this operation
+			if lastErr != nil {
+				err = lastErr
+			}
+			return backoff.Permanent(err)
+		}
+
+		lastErr = f()
+		if ctx.Err() == nil {
+			be.record(lastErr)
+		}
+		return lastErr
+	},
+		backoff.WithContext(be.newBackOff(&lastErr), ctx),
 		func(err error, d time.Duration) {
 			if be.Report != nil {
 				be.Report(msg, err, d)
@@ -6,7 +6,9 @@ import (
 	"io"
 	"io/ioutil"
 	"testing"
+	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/mock"
 	"github.com/restic/restic/internal/restic"
@@ -271,3 +273,192 @@ func TestBackendCanceledContext(t *testing.T) {
 
 	// don't test "Delete" as it is not used by normal code
 }
+
+func fastRetryPolicy(maxTries int) RetryPolicy {
+	return RetryPolicy{
+		MaxTries:        maxTries,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+	}
+}
+
+func TestBackendCircuitBreaker(t *testing.T) {
+	errTest := errors.New("test error")
+	calls := 0
+	fail := true
+	be := &mock.Backend{
+		StatFn: func(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+			calls++
+			if fail {
+				return restic.FileInfo{}, errTest
+			}
+			return restic.FileInfo{Name: h.Name}, nil
+		},
+	}
+
+	policy := fastRetryPolicy(2)
+	policy.BreakerThreshold = 5
+	policy.BreakerCooldown = 50 * time.Millisecond
+	retryBackend := NewRetryBackendWithPolicy(be, policy, nil)
+	h := restic.Handle{Type: restic.PackFile, Name: "foo"}
+
+	_, err := retryBackend.Stat(context.TODO(), h)
+	test.Assert(t, err == errTest, "unexpected error %v", err)
+	test.Equals(t, 3, calls)
+
+	// the breaker trips during the second operation, which still returns the original error
+	_, err = retryBackend.Stat(context.TODO(), h)
+	test.Assert(t, err == errTest, "unexpected error %v", err)
+	test.Equals(t, 5, calls)
+
+	_, err = retryBackend.Stat(context.TODO(), h)
+	test.Assert(t, err == ErrCircuitOpen, "unexpected error %v", err)
+	test.Equals(t, 5, calls)
+
+	// after the cooldown, a successful request closes the breaker again
+	time.Sleep(policy.BreakerCooldown)
+	fail = false
+	_, err = retryBackend.Stat(context.TODO(), h)
+	test.OK(t, err)
+	test.Equals(t, 6, calls)
+
+	fail = true
+	_, err = retryBackend.Stat(context.TODO(), h)
+	test.Assert(t, err == errTest, "unexpected error %v", err)
+	test.Equals(t, 9, calls)
+}
+
+func TestBackendCircuitBreakerNotExist(t *testing.T) {
+	errNotExist := errors.New("not found")
+	be := &mock.Backend{
+		StatFn: func(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+			return restic.FileInfo{}, errNotExist
+		},
+		IsNotExistFn: func(err error) bool {
+			return err == errNotExist
+		},
+	}
+
+	policy := fastRetryPolicy(2)
+	policy.BreakerThreshold = 1
+	retryBackend := NewRetryBackendWithPolicy(be, policy, nil)
+
+	for i := 0; i < 3; i++ {
+		_, err := retryBackend.Stat(context.TODO(), restic.Handle{Type: restic.PackFile, Name: "foo"})
+		test.Assert(t, err == errNotExist, "unexpected error %v", err)
+	}
+}
+
+func TestBackendCircuitBreakerPermanent(t *testing.T) {
+	errTest := errors.New("test error")
+	var errs []error
+	be := &mock.Backend{
+		RemoveFn: func(ctx context.Context, h restic.Handle) error {
+			err := errs[0]
+			errs = errs[1:]
+			return err
+		},
+	}
+
+	policy := fastRetryPolicy(0)
+	policy.BreakerThreshold = 2
+	retryBackend := NewRetryBackendWithPolicy(be, policy, nil)
+	h := restic.Handle{Type: restic.PackFile, Name: "foo"}
+
+	// permanent errors and retained files do not count as failed requests
+	errs = []error{
+		errTest,
+		backoff.Permanent(errTest),
+		backoff.Permanent(errors.Wrap(restic.ErrRetained, "foo is locked")),
+		errors.Wrap(restic.ErrRetained, "foo is locked"),
+		errTest,
+	}
+	for range errs {
+		err := retryBackend.Remove(context.TODO(), h)
+		test.Assert(t, err != nil && err != ErrCircuitOpen, "unexpected error %v", err)
+	}
+
+	err := retryBackend.Remove(context.TODO(), h)
+	test.Assert(t, err == ErrCircuitOpen, "unexpected error %v", err)
+}
+
+func TestBackendRetryAfter(t *testing.T) {
+	attempt := 0
+	be := &mock.Backend{
+		StatFn: func(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+			attempt++
+			if attempt == 1 {
+				return restic.FileInfo{}, errors.Wrap(&RetryAfterError{Err: errors.New("slow down"), After: 20 * time.Millisecond}, "Stat")
+			}
+			return restic.FileInfo{Name: h.Name}, nil
+		},
+	}
+
+	var delays []time.Duration
+	policy := fastRetryPolicy(10)
+	policy.MaxInterval = time.Second
+	retryBackend := NewRetryBackendWithPolicy(be, policy, func(msg string, err error, d time.Duration) {
+		delays = append(delays, d)
+	})
+
+	_, err := retryBackend.Stat(context.TODO(), restic.Handle{Type: restic.PackFile, Name: "foo"})
+	test.OK(t, err)
+	test.Equals(t, 2, attempt)
+	test.Equals(t, []time.Duration{20 * time.Millisecond}, delays)
+}
+
+func TestBackendRetryAfterLimits(t *testing.T) {
+	attempt := 0
+	be := &mock.Backend{
+		StatFn: func(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+			attempt++
+			if attempt == 1 {
+				return restic.FileInfo{}, &RetryAfterError{Err: errors.New("slow down"), After: time.Hour}
+			}
+			return restic.FileInfo{Name: h.Name}, nil
+		},
+	}
+
+	// the delay is capped at MaxInterval
+	var delays []time.Duration
+	policy := fastRetryPolicy(10)
+	policy.MaxInterval = 20 * time.Millisecond
+	retryBackend := NewRetryBackendWithPolicy(be, policy, func(msg string, err error, d time.Duration) {
+		delays = append(delays, d)
+	})
+
+	_, err := retryBackend.Stat(context.TODO(), restic.Handle{Type: restic.PackFile, Name: "foo"})
+	test.OK(t, err)
+	test.Equals(t, 2, attempt)
+	test.Equals(t, []time.Duration{20 * time.Millisecond}, delays)
+
+	// no retry if waiting would exceed MaxElapsedTime
+	attempt = 0
+	policy.MaxInterval = time.Hour
+	policy.MaxElapsedTime = time.Second
+	retryBackend = NewRetryBackendWithPolicy(be, policy, nil)
+
+	_, err = retryBackend.Stat(context.TODO(), restic.Handle{Type: restic.PackFile, Name: "foo"})
+	test.Assert(t, err != nil, "missing error")
+	test.Equals(t, 1, attempt)
+}
+
+func TestBackendRetryMaxElapsedTime(t *testing.T) {
+	attempt := 0
+	be := &mock.Backend{
+		StatFn: func(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+			attempt++
+			return restic.FileInfo{}, errors.New("test error")
+		},
+	}
+
+	policy := fastRetryPolicy(1000)
+	policy.InitialInterval = 10 * time.Millisecond
+	policy.MaxInterval = 10 * time.Millisecond
+	policy.MaxElapsedTime = 50 * time.Millisecond
+	retryBackend := NewRetryBackendWithPolicy(be, policy, nil)
+
+	_, err := retryBackend.Stat(context.TODO(), restic.Handle{Type: restic.PackFile, Name: "foo"})
+	test.Assert(t, err != nil, "missing error")
+	test.Assert(t, attempt > 1 && attempt < 20, "unexpected number of attempts %d", attempt)
+}
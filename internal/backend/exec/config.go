@@ -0,0 +1,41 @@
+package exec
+
+import (
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config contains all configuration necessary to start a program which
+// serves a repository.
+type Config struct {
+	Command     string
+	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+}
+
+func init() {
+	options.Register("exec", Config{})
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		Connections: 5,
+	}
+}
+
+// ParseConfig parses the string s and extracts the command line of the
+// program.
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "exec:") {
+		return nil, errors.New("invalid exec backend specification")
+	}
+
+	cfg := NewConfig()
+	cfg.Command = strings.TrimSpace(s[5:])
+	if cfg.Command == "" {
+		return nil, errors.New("exec backend: no command specified")
+	}
+	return cfg, nil
+}
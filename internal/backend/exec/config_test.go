@@ -0,0 +1,48 @@
+package exec
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	var tests = []struct {
+		s   string
+		cfg Config
+	}{
+		{
+			"exec:/usr/local/bin/restic-tape --library lib1",
+			Config{
+				Command:     "/usr/local/bin/restic-tape --library lib1",
+				Connections: 5,
+			},
+		},
+		{
+			"exec:plugin 'with space'",
+			Config{
+				Command:     "plugin 'with space'",
+				Connections: 5,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			cfg, err := ParseConfig(test.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(cfg, test.cfg) {
+				t.Fatalf("wrong config, want:\n  %v\ngot:\n  %v", test.cfg, cfg)
+			}
+		})
+	}
+
+	for _, s := range []string{"exec:", "exec:  ", "rclone:foo"} {
+		_, err := ParseConfig(s)
+		if err == nil {
+			t.Errorf("expected error for %q not found", s)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package exec_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/exec"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func newTestSuite(t testing.TB) *test.Suite {
+	dir, cleanup := rtest.TempDir(t)
+
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			t.Logf("use backend at %v", dir)
+			// the test binary serves the repository, see TestMain
+			cfg := exec.NewConfig()
+			cfg.Command = fmt.Sprintf("'%s' restic-exec-test-server '%s'", os.Args[0], dir)
+			return cfg, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(exec.Config)
+			return exec.Create(context.TODO(), cfg)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(exec.Config)
+			return exec.Open(cfg, nil)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			t.Logf("cleanup dir %v", dir)
+			cleanup()
+			return nil
+		},
+	}
+}
+
+func TestBackendExec(t *testing.T) {
+	newTestSuite(t).RunTests(t)
+}
+
+func BenchmarkBackendExec(t *testing.B) {
+	newTestSuite(t).RunBenchmarks(t)
+}
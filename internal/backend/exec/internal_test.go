@@ -0,0 +1,165 @@
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+
+	"github.com/restic/restic/internal/backend/rest"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// testServerArg is passed to the test binary as the first argument when it is
+// started as a program which serves a repository.
+const testServerArg = "restic-exec-test-server"
+
+func TestMain(m *testing.M) {
+	if len(os.Args) == 3 && os.Args[1] == testServerArg {
+		serveTestRepo(os.Args[2])
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// testProgram returns the command line for a program which serves the
+// repository in dir.
+func testProgram(dir string) []string {
+	return []string{os.Args[0], testServerArg, dir}
+}
+
+// serveTestRepo serves the repository in dir via the REST protocol over stdin
+// and stdout. It implements just enough of the protocol for the tests.
+func serveTestRepo(dir string) {
+	conn := &StdioConn{receive: os.Stdin, send: os.Stdout}
+	srv := &http2.Server{}
+	srv.ServeConn(conn, &http2.ServeConnOpts{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handleTestRequest(dir, w, r)
+		}),
+	})
+}
+
+func handleTestRequest(dir string, w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" && r.Method == http.MethodPost && r.URL.Query().Get("create") == "true" {
+		for _, d := range []string{"data", "keys", "locks", "snapshots", "index"} {
+			if err := os.MkdirAll(filepath.Join(dir, d), 0700); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		return
+	}
+
+	p := path.Clean(r.URL.Path)
+	filename := filepath.Join(dir, filepath.FromSlash(p))
+
+	if strings.HasSuffix(r.URL.Path, "/") && r.Method == http.MethodGet {
+		entries, err := ioutil.ReadDir(filename)
+		if err != nil && !os.IsNotExist(err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		type entry struct {
+			Name string `json:"name"`
+			Size int64  `json:"size"`
+		}
+		list := []entry{}
+		for _, fi := range entries {
+			list = append(list, entry{Name: fi.Name(), Size: fi.Size()})
+		}
+		w.Header().Set("Content-Type", rest.ContentTypeV2)
+		_ = json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		f, err := os.Open(filename)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		fi, err := f.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, "", fi.ModTime(), f)
+
+	case http.MethodPost:
+		buf, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := ioutil.WriteFile(filename, buf, 0600); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+	case http.MethodDelete:
+		err := os.Remove(filename)
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// restic should detect the program exiting.
+func TestProgramExit(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	be, err := CreateProgram(context.TODO(), testProgram(dir), 5)
+	rtest.OK(t, err)
+	defer func() {
+		// ignore the error as the test will kill the program (see below)
+		_ = be.Close()
+	}()
+
+	err = be.cmd.Process.Kill()
+	rtest.OK(t, err)
+	t.Log("killed program")
+
+	for i := 0; i < 10; i++ {
+		_, err = be.Stat(context.TODO(), restic.Handle{
+			Name: "foo",
+			Type: restic.PackFile,
+		})
+		rtest.Assert(t, err != nil, "expected an error")
+	}
+}
+
+func TestProgramNotFound(t *testing.T) {
+	_, err := OpenProgram([]string{"/nonexistent/restic-plugin"}, 5, nil)
+	if err == nil {
+		t.Fatal("expected error for a missing program")
+	}
+
+	_, err = OpenProgram(nil, 5, nil)
+	if err == nil {
+		t.Fatal("expected error for an empty command line")
+	}
+}
@@ -1,4 +1,4 @@
-package rclone
+package exec
 
 import (
 	"net"
@@ -335,7 +335,7 @@ func (be *Backend) Stat(ctx context.Context, h restic.Handle) (bi restic.FileInf
 		return restic.FileInfo{}, errors.Wrap(err, "service.Objects.Get")
 	}
 
-	return restic.FileInfo{Size: attr.Size, Name: h.Name}, nil
+	return restic.FileInfo{Size: attr.Size, Name: h.Name, Hash: attr.MD5}, nil
 }
 
 // Test returns true if a blob of the given type and name exists in the backend.
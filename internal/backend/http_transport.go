@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -119,3 +120,39 @@ func Transport(opts TransportOptions) (http.RoundTripper, error) {
 	// wrap in the debug round tripper (if active)
 	return debug.RoundTripper(tr), nil
 }
+
+// WithRetryAfter returns err as a RetryAfterError if the response asks the
+// client to slow down, so that RetryBackend waits as long as requested. It is
+// used by backends for responses with an unexpected status code.
+func WithRetryAfter(res *http.Response, err error) error {
+	header := res.Header.Get("Retry-After")
+	if res.StatusCode != http.StatusTooManyRequests && (res.StatusCode != http.StatusServiceUnavailable || header == "") {
+		return err
+	}
+
+	return &RetryAfterError{
+		Err:   err,
+		After: parseRetryAfter(header, time.Now()),
+	}
+}
+
+// parseRetryAfter returns the delay requested by the value of a Retry-After
+// header, which is either a number of seconds or a date.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(header); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(header); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+
+	return 0
+}
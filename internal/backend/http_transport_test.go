@@ -0,0 +1,63 @@
+package backend
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/test"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"Sun, 01 Aug 2021 12:00:30 GMT", 30 * time.Second},
+		{"Sun, 01 Aug 2021 11:00:00 GMT", 0},
+		{"foobar", 0},
+	}
+
+	for _, tt := range tests {
+		test.Equals(t, tt.want, parseRetryAfter(tt.header, now))
+	}
+}
+
+func TestWithRetryAfter(t *testing.T) {
+	var tests = []struct {
+		status int
+		header string
+		after  time.Duration
+		retry  bool
+	}{
+		{http.StatusTooManyRequests, "3", 3 * time.Second, true},
+		{http.StatusTooManyRequests, "", 0, true},
+		{http.StatusServiceUnavailable, "5", 5 * time.Second, true},
+		{http.StatusServiceUnavailable, "", 0, false},
+		{http.StatusInternalServerError, "5", 0, false},
+	}
+
+	for _, tt := range tests {
+		res := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		if tt.header != "" {
+			res.Header.Set("Retry-After", tt.header)
+		}
+
+		orig := errors.New("unexpected response")
+		err := WithRetryAfter(res, orig)
+
+		var rerr *RetryAfterError
+		test.Equals(t, tt.retry, errors.As(err, &rerr))
+		if tt.retry {
+			test.Equals(t, tt.after, rerr.After)
+		}
+		test.Assert(t, errors.Is(err, orig), "original error %v was lost: %v", orig, err)
+	}
+}
@@ -4,15 +4,19 @@ package location
 import (
 	"strings"
 
+	"github.com/restic/restic/internal/backend/archive"
 	"github.com/restic/restic/internal/backend/azure"
 	"github.com/restic/restic/internal/backend/b2"
+	"github.com/restic/restic/internal/backend/exec"
 	"github.com/restic/restic/internal/backend/gs"
 	"github.com/restic/restic/internal/backend/local"
 	"github.com/restic/restic/internal/backend/rclone"
 	"github.com/restic/restic/internal/backend/rest"
 	"github.com/restic/restic/internal/backend/s3"
 	"github.com/restic/restic/internal/backend/sftp"
+	"github.com/restic/restic/internal/backend/sharded"
 	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/backend/webdav"
 	"github.com/restic/restic/internal/errors"
 )
 
@@ -41,6 +45,10 @@ var parsers = []parser{
 	{"swift", swift.ParseConfig, noPassword},
 	{"rest", rest.ParseConfig, rest.StripPassword},
 	{"rclone", rclone.ParseConfig, noPassword},
+	{"webdav", webdav.ParseConfig, webdav.StripPassword},
+	{"exec", exec.ParseConfig, noPassword},
+	{"sharded", sharded.ParseConfig, noPassword},
+	{"archive", archive.ParseConfig, noPassword},
 }
 
 // noPassword returns the repository location unchanged (there's no sensitive information there)
@@ -2,15 +2,19 @@ package location
 
 import (
 	"net/url"
+	"path/filepath"
 	"reflect"
 	"testing"
 
+	"github.com/restic/restic/internal/backend/archive"
 	"github.com/restic/restic/internal/backend/b2"
 	"github.com/restic/restic/internal/backend/local"
 	"github.com/restic/restic/internal/backend/rest"
 	"github.com/restic/restic/internal/backend/s3"
 	"github.com/restic/restic/internal/backend/sftp"
+	"github.com/restic/restic/internal/backend/sharded"
 	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/backend/webdav"
 )
 
 func parseURL(s string) *url.URL {
@@ -281,6 +285,30 @@ var parseTests = []struct {
 			},
 		},
 	},
+	{
+		"sharded:/mnt/disk1/restic" + string(filepath.ListSeparator) + "/mnt/disk2/restic",
+		Location{Scheme: "sharded",
+			Config: sharded.Config{
+				Paths:     []string{"/mnt/disk1/restic", "/mnt/disk2/restic"},
+				Placement: "hash",
+			},
+		},
+	},
+	{
+		"archive:/media/usb/repo.restic", Location{Scheme: "archive",
+			Config: archive.Config{
+				Path: "/media/usb/repo.restic",
+			},
+		},
+	},
+	{
+		"webdav:https://hostname.foo/dav/restic", Location{Scheme: "webdav",
+			Config: webdav.Config{
+				URL:         parseURL("https://hostname.foo/dav/restic/"),
+				Connections: 5,
+			},
+		},
+	},
 	{
 		"b2:bucketname:/prefix", Location{Scheme: "b2",
 			Config: b2.Config{
@@ -172,7 +172,14 @@ func (be *MemoryBackend) Stat(ctx context.Context, h restic.Handle) (restic.File
 		return restic.FileInfo{}, errNotFound
 	}
 
-	return restic.FileInfo{Size: int64(len(e)), Name: h.Name}, ctx.Err()
+	beHash := be.Hasher()
+	// must never fail according to interface
+	_, err := beHash.Write(e)
+	if err != nil {
+		panic(err)
+	}
+
+	return restic.FileInfo{Size: int64(len(e)), Name: h.Name, Hash: beHash.Sum(nil)}, ctx.Err()
 }
 
 // Remove deletes a file from the backend.
@@ -0,0 +1,19 @@
+package mirror
+
+import (
+	"github.com/restic/restic/internal/options"
+)
+
+// Config contains the options for mirroring a repository to several backends.
+type Config struct {
+	Quorum uint `option:"quorum" help:"set the number of replicas a file must be saved to (default: all)"`
+}
+
+func init() {
+	options.Register("mirror", Config{})
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{}
+}
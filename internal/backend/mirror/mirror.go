@@ -0,0 +1,397 @@
+// Package mirror implements a backend which stores all files in several
+// backends at once.
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Replica is one of the backends a repository is mirrored to.
+type Replica struct {
+	// Name identifies the replica in errors and reports, it must not contain
+	// any credentials.
+	Name string
+	restic.Backend
+}
+
+// Backend saves and removes files in all replicas, files are loaded from the
+// first replica which has them.
+type Backend struct {
+	replicas []Replica
+	quorum   int
+
+	// Report is called with a description and the error when an operation
+	// failed for a replica, but succeeded for enough other replicas.
+	Report func(string, error)
+}
+
+// statically ensure that Backend implements restic.Backend.
+var _ restic.Backend = &Backend{}
+
+// New returns a backend which mirrors all files to the replicas. Files must be
+// saved to at least cfg.Quorum replicas, when it is zero files must be saved
+// to all replicas.
+func New(cfg Config, replicas []Replica) (*Backend, error) {
+	if len(replicas) < 2 {
+		return nil, errors.Fatal("mirroring needs at least two repository locations")
+	}
+
+	quorum := int(cfg.Quorum)
+	if quorum == 0 {
+		quorum = len(replicas)
+	}
+	if quorum > len(replicas) {
+		return nil, errors.Fatalf("quorum %d is larger than the number of replicas (%d)", quorum, len(replicas))
+	}
+
+	return &Backend{
+		replicas: replicas,
+		quorum:   quorum,
+		Report:   func(string, error) {},
+	}, nil
+}
+
+// Location returns the locations of all replicas.
+func (be *Backend) Location() string {
+	names := make([]string, 0, len(be.replicas))
+	for _, r := range be.replicas {
+		names = append(names, r.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Hasher returns nil, the replicas may use different hash functions. The hash
+// for each replica is computed in Save.
+func (be *Backend) Hasher() hash.Hash {
+	return nil
+}
+
+// IsNotExist returns true if the error was caused by a file which does not
+// exist in a replica.
+func (be *Backend) IsNotExist(err error) bool {
+	if _, ok := errors.Cause(err).(notExistError); ok {
+		return true
+	}
+	for _, r := range be.replicas {
+		if r.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// notExistError is returned when a file does not exist in any replica.
+type notExistError struct {
+	restic.Handle
+}
+
+func (e notExistError) Error() string {
+	return e.Handle.String() + " does not exist in any replica"
+}
+
+// Save stores the data in all replicas in parallel. It fails if the data
+// could not be saved to enough replicas. The data is read into memory once, so
+// that each replica can read it independently of the others.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if err := rd.Rewind(); err != nil {
+		return err
+	}
+
+	buf, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return errors.Wrap(err, "ReadAll")
+	}
+	if int64(len(buf)) != rd.Length() {
+		return errors.Errorf("wrote %d bytes instead of the expected %d bytes", len(buf), rd.Length())
+	}
+
+	errs := make([]error, len(be.replicas))
+	var wg sync.WaitGroup
+	for i, r := range be.replicas {
+		wg.Add(1)
+		go func(i int, r Replica) {
+			defer wg.Done()
+			errs[i] = r.Save(ctx, h, restic.NewByteReader(buf, r.Hasher()))
+		}(i, r)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var firstErr error
+	saved := 0
+	for i, err := range errs {
+		if err != nil {
+			debug.Log("saving %v to %v failed: %v", h, be.replicas[i].Name, err)
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "replica %v", be.replicas[i].Name)
+			}
+			continue
+		}
+		saved++
+	}
+
+	if saved < be.quorum {
+		return errors.Wrapf(firstErr, "saved to %d of %d replicas, quorum is %d", saved, len(be.replicas), be.quorum)
+	}
+
+	if firstErr != nil {
+		be.Report(fmt.Sprintf("Save(%v)", h), firstErr)
+	}
+
+	return nil
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset. When loading the file from a replica fails, the next replica
+// is tried. Errors returned by fn are passed on unchanged.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	var firstErr error
+	missing := 0
+
+	for _, r := range be.replicas {
+		var fnErr error
+		err := r.Load(ctx, h, length, offset, func(rd io.Reader) error {
+			fnErr = fn(rd)
+			return fnErr
+		})
+		// errors returned by fn are not caused by the replica
+		if err == nil || fnErr != nil || ctx.Err() != nil {
+			return err
+		}
+
+		debug.Log("loading %v from %v failed: %v", h, r.Name, err)
+		if r.IsNotExist(err) {
+			missing++
+		} else if firstErr == nil {
+			firstErr = errors.Wrapf(err, "replica %v", r.Name)
+		}
+	}
+
+	if missing == len(be.replicas) {
+		return notExistError{h}
+	}
+	return firstErr
+}
+
+// Stat returns information about the file from the first replica which has
+// it.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	var firstErr error
+	missing := 0
+
+	for _, r := range be.replicas {
+		fi, err := r.Stat(ctx, h)
+		if err == nil || ctx.Err() != nil {
+			return fi, err
+		}
+
+		if r.IsNotExist(err) {
+			missing++
+		} else if firstErr == nil {
+			firstErr = errors.Wrapf(err, "replica %v", r.Name)
+		}
+	}
+
+	if missing == len(be.replicas) {
+		return restic.FileInfo{}, notExistError{h}
+	}
+	return restic.FileInfo{}, firstErr
+}
+
+// Test returns true if the file exists in any replica.
+func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	var firstErr error
+	for _, r := range be.replicas {
+		found, err := r.Test(ctx, h)
+		if err == nil && found {
+			return true, nil
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return false, firstErr
+}
+
+// Remove removes the file from all replicas. Replicas which do not have the
+// file are ignored, unless the file does not exist in any replica.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	var firstErr error
+	removed, missing := 0, 0
+
+	for _, r := range be.replicas {
+		err := r.Remove(ctx, h)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		switch {
+		case err == nil:
+			removed++
+		case r.IsNotExist(err):
+			missing++
+		default:
+			debug.Log("removing %v from %v failed: %v", h, r.Name, err)
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "replica %v", r.Name)
+			}
+		}
+	}
+
+	if missing == len(be.replicas) {
+		return notExistError{h}
+	}
+
+	if removed+missing < be.quorum {
+		return errors.Wrapf(firstErr, "removed from %d of %d replicas, quorum is %d", removed+missing, len(be.replicas), be.quorum)
+	}
+
+	if firstErr != nil {
+		be.Report(fmt.Sprintf("Remove(%v)", h), firstErr)
+	}
+
+	return nil
+}
+
+// List runs fn for each file of type t which exists in any of the replicas.
+// When listing a replica fails, the next replica is listed. It only fails if
+// so many replicas could not be listed that a file saved to a quorum of
+// replicas may be missing. Errors returned by fn are passed on unchanged.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	seen := make(map[string]struct{})
+	var firstErr error
+	failed := 0
+
+	for _, r := range be.replicas {
+		var fnErr error
+		err := r.List(ctx, t, func(fi restic.FileInfo) error {
+			if _, ok := seen[fi.Name]; ok {
+				return nil
+			}
+			seen[fi.Name] = struct{}{}
+			fnErr = fn(fi)
+			return fnErr
+		})
+		// errors returned by fn are not caused by the replica
+		if fnErr != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err != nil {
+			debug.Log("listing %v in %v failed: %v", t, r.Name, err)
+			failed++
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "replica %v", r.Name)
+			}
+		}
+	}
+
+	if failed >= be.quorum {
+		return errors.Wrapf(firstErr, "listed %d of %d replicas, quorum is %d", len(be.replicas)-failed, len(be.replicas), be.quorum)
+	}
+
+	if firstErr != nil {
+		be.Report(fmt.Sprintf("List(%v)", t), firstErr)
+	}
+
+	return ctx.Err()
+}
+
+// Delete removes all data in all replicas.
+func (be *Backend) Delete(ctx context.Context) error {
+	var firstErr error
+	for _, r := range be.replicas {
+		err := r.Delete(ctx)
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "replica %v", r.Name)
+		}
+	}
+	return firstErr
+}
+
+// Close closes all replicas.
+func (be *Backend) Close() error {
+	var firstErr error
+	for _, r := range be.replicas {
+		err := r.Close()
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "replica %v", r.Name)
+		}
+	}
+	return firstErr
+}
+
+// CheckReplicas compares the files in all replicas and calls fn for each file
+// which exists in some replicas, but is missing in the replica with the given
+// name.
+func (be *Backend) CheckReplicas(ctx context.Context, fn func(replica string, h restic.Handle) error) error {
+	for _, t := range []restic.FileType{restic.ConfigFile, restic.KeyFile, restic.SnapshotFile, restic.IndexFile, restic.PackFile} {
+		// the set of replicas which contain each file
+		found := make(map[string][]bool)
+
+		for i, r := range be.replicas {
+			if t == restic.ConfigFile {
+				ok, err := r.Test(ctx, restic.Handle{Type: t})
+				if err != nil {
+					return errors.Wrapf(err, "replica %v", r.Name)
+				}
+				if ok {
+					if found[""] == nil {
+						found[""] = make([]bool, len(be.replicas))
+					}
+					found[""][i] = true
+				}
+				continue
+			}
+
+			err := r.List(ctx, t, func(fi restic.FileInfo) error {
+				if found[fi.Name] == nil {
+					found[fi.Name] = make([]bool, len(be.replicas))
+				}
+				found[fi.Name][i] = true
+				return nil
+			})
+			if err != nil {
+				return errors.Wrapf(err, "replica %v", r.Name)
+			}
+		}
+
+		names := make([]string, 0, len(found))
+		for name := range found {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			for i, ok := range found[name] {
+				if ok {
+					continue
+				}
+				err := fn(be.replicas[i].Name, restic.Handle{Type: t, Name: name})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return ctx.Err()
+}
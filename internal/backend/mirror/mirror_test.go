@@ -0,0 +1,275 @@
+package mirror_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/mirror"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/mock"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+type memConfig struct {
+	be restic.Backend
+}
+
+func newMirror(t testing.TB, quorum uint, backends ...restic.Backend) *mirror.Backend {
+	replicas := make([]mirror.Replica, 0, len(backends))
+	for i, be := range backends {
+		replicas = append(replicas, mirror.Replica{Name: string(rune('a' + i)), Backend: be})
+	}
+
+	cfg := mirror.NewConfig()
+	cfg.Quorum = quorum
+	be, err := mirror.New(cfg, replicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return be
+}
+
+func newTestSuite(t testing.TB) *test.Suite {
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			return &memConfig{}, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*memConfig)
+			if c.be != nil {
+				ok, err := c.be.Test(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+				if err != nil {
+					return nil, err
+				}
+
+				if ok {
+					return nil, errors.New("config already exists")
+				}
+			}
+
+			c.be = newMirror(t, 0, mem.New(), mem.New())
+			return c.be, nil
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(cfg interface{}) (restic.Backend, error) {
+			c := cfg.(*memConfig)
+			if c.be == nil {
+				c.be = newMirror(t, 0, mem.New(), mem.New())
+			}
+			return c.be, nil
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(cfg interface{}) error {
+			// no cleanup needed
+			return nil
+		},
+	}
+}
+
+func TestSuiteBackendMirror(t *testing.T) {
+	newTestSuite(t).RunTests(t)
+}
+
+func TestMirrorInvalidConfig(t *testing.T) {
+	_, err := mirror.New(mirror.NewConfig(), []mirror.Replica{{Name: "a", Backend: mem.New()}})
+	if err == nil {
+		t.Error("expected error for a single replica")
+	}
+
+	_, err = mirror.New(mirror.Config{Quorum: 3}, []mirror.Replica{{Name: "a", Backend: mem.New()}, {Name: "b", Backend: mem.New()}})
+	if err == nil {
+		t.Error("expected error for a quorum larger than the number of replicas")
+	}
+}
+
+func save(t testing.TB, be restic.Backend, h restic.Handle, data []byte) error {
+	return be.Save(context.TODO(), h, restic.NewByteReader(data, be.Hasher()))
+}
+
+func failingBackend() *mock.Backend {
+	m := mock.NewBackend()
+	m.SaveFn = func(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+		return errors.New("save failed")
+	}
+	return m
+}
+
+func TestMirrorSaveQuorum(t *testing.T) {
+	h := restic.Handle{Type: restic.SnapshotFile, Name: restic.NewRandomID().String()}
+	data := []byte("snapshot")
+
+	be := newMirror(t, 0, mem.New(), mem.New(), failingBackend())
+	if err := save(t, be, h, data); err == nil {
+		t.Fatal("expected error for saving to less than all replicas")
+	}
+
+	b := mem.New()
+	reported := 0
+	be = newMirror(t, 2, mem.New(), failingBackend(), b)
+	be.Report = func(msg string, err error) {
+		reported++
+	}
+	rtest.OK(t, save(t, be, h, data))
+	rtest.Equals(t, 1, reported)
+
+	buf, err := backend.LoadAll(context.TODO(), nil, b, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+}
+
+func TestMirrorSaveParallel(t *testing.T) {
+	h := restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+	data := []byte("pack")
+
+	// each replica only returns after all replicas have started saving
+	var started sync.WaitGroup
+	started.Add(2)
+	blocking := func(be restic.Backend) *mock.Backend {
+		m := mock.NewBackend()
+		m.SaveFn = func(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+			started.Done()
+			started.Wait()
+			return be.Save(ctx, h, rd)
+		}
+		m.HasherFn = be.Hasher
+		return m
+	}
+
+	a, b := mem.New(), mem.New()
+	be := newMirror(t, 0, blocking(a), blocking(b))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- save(t, be, h, data)
+	}()
+
+	select {
+	case err := <-done:
+		rtest.OK(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("replicas were not saved to in parallel")
+	}
+
+	for _, r := range []restic.Backend{a, b} {
+		buf, err := backend.LoadAll(context.TODO(), nil, r, h)
+		rtest.OK(t, err)
+		rtest.Equals(t, data, buf)
+	}
+}
+
+func TestMirrorListFallback(t *testing.T) {
+	h := restic.Handle{Type: restic.SnapshotFile, Name: restic.NewRandomID().String()}
+
+	broken := mock.NewBackend()
+	broken.ListFn = func(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+		return errors.New("list failed")
+	}
+
+	a, b := mem.New(), mem.New()
+	rtest.OK(t, save(t, a, h, []byte("a")))
+	rtest.OK(t, save(t, b, h, []byte("b")))
+
+	list := func(be restic.Backend) ([]string, error) {
+		var names []string
+		err := be.List(context.TODO(), restic.SnapshotFile, func(fi restic.FileInfo) error {
+			names = append(names, fi.Name)
+			return nil
+		})
+		return names, err
+	}
+
+	// files saved to a quorum of two replicas are still found
+	reported := 0
+	be := newMirror(t, 2, broken, a, b)
+	be.Report = func(msg string, err error) {
+		reported++
+	}
+	names, err := list(be)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{h.Name}, names)
+	rtest.Equals(t, 1, reported)
+
+	// with a quorum of one the file may only exist in the broken replica
+	be = newMirror(t, 1, broken, a)
+	_, err = list(be)
+	if err == nil {
+		t.Fatal("expected error for listing less than quorum replicas")
+	}
+
+	// errors returned by fn are passed on unchanged
+	stop := errors.New("stop")
+	be = newMirror(t, 2, broken, a, b)
+	err = be.List(context.TODO(), restic.SnapshotFile, func(fi restic.FileInfo) error {
+		return stop
+	})
+	rtest.Assert(t, errors.Cause(err) == stop, "unexpected error %v", err)
+}
+
+func TestMirrorLoadFallback(t *testing.T) {
+	h := restic.Handle{Type: restic.IndexFile, Name: restic.NewRandomID().String()}
+	data := []byte("index")
+
+	a, b := mem.New(), mem.New()
+	rtest.OK(t, save(t, b, h, data))
+
+	be := newMirror(t, 0, a, b)
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	fi, err := be.Stat(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+
+	missing := restic.Handle{Type: restic.IndexFile, Name: restic.NewRandomID().String()}
+	_, err = backend.LoadAll(context.TODO(), nil, be, missing)
+	if !be.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+func TestMirrorCheckReplicas(t *testing.T) {
+	a, b := mem.New(), mem.New()
+	be := newMirror(t, 0, a, b)
+
+	both := restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+	rtest.OK(t, save(t, be, both, []byte("both")))
+	onlyA := restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+	rtest.OK(t, save(t, a, onlyA, []byte("a")))
+	onlyB := restic.Handle{Type: restic.SnapshotFile, Name: restic.NewRandomID().String()}
+	rtest.OK(t, save(t, b, onlyB, []byte("b")))
+
+	missing := make(map[restic.Handle]string)
+	err := be.CheckReplicas(context.TODO(), func(replica string, h restic.Handle) error {
+		missing[h] = replica
+		return nil
+	})
+	rtest.OK(t, err)
+	rtest.Equals(t, map[restic.Handle]string{onlyA: "b", onlyB: "a"}, missing)
+
+	// List returns files from all replicas
+	var names []string
+	rtest.OK(t, be.List(context.TODO(), restic.PackFile, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	}))
+	rtest.Equals(t, 2, len(names))
+
+	// removing a file which is missing in some replicas works
+	rtest.OK(t, be.Remove(context.TODO(), onlyA))
+	err = be.Remove(context.TODO(), onlyA)
+	if !be.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
@@ -151,7 +151,7 @@ func (b *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindRea
 	}
 
 	if resp.StatusCode != 200 {
-		return errors.Errorf("server response unexpected: %v (%v)", resp.Status, resp.StatusCode)
+		return backend.WithRetryAfter(resp, errors.Errorf("server response unexpected: %v (%v)", resp.Status, resp.StatusCode))
 	}
 
 	return errors.Wrap(cerr, "Close")
@@ -289,7 +289,7 @@ func (b *Backend) openReader(ctx context.Context, h restic.Handle, length int, o
 
 	if resp.StatusCode != 200 && resp.StatusCode != 206 {
 		_ = resp.Body.Close()
-		return nil, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status)
+		return nil, backend.WithRetryAfter(resp, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status))
 	}
 
 	// workaround https://github.com/golang/go/issues/46071
@@ -333,7 +333,7 @@ func (b *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, e
 	}
 
 	if resp.StatusCode != 200 {
-		return restic.FileInfo{}, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status)
+		return restic.FileInfo{}, backend.WithRetryAfter(resp, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status))
 	}
 
 	if resp.ContentLength < 0 {
@@ -384,7 +384,8 @@ func (b *Backend) Remove(ctx context.Context, h restic.Handle) error {
 	}
 
 	if resp.StatusCode != 200 {
-		return errors.Errorf("blob not removed, server response: %v (%v)", resp.Status, resp.StatusCode)
+		_ = resp.Body.Close()
+		return backend.WithRetryAfter(resp, errors.Errorf("blob not removed, server response: %v (%v)", resp.Status, resp.StatusCode))
 	}
 
 	_, err = io.Copy(ioutil.Discard, resp.Body)
@@ -418,7 +419,8 @@ func (b *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.Fi
 	}
 
 	if resp.StatusCode != 200 {
-		return errors.Errorf("List failed, server response: %v (%v)", resp.Status, resp.StatusCode)
+		_ = resp.Body.Close()
+		return backend.WithRetryAfter(resp, errors.Errorf("List failed, server response: %v (%v)", resp.Status, resp.StatusCode))
 	}
 
 	if resp.Header.Get("Content-Type") == ContentTypeV2 {
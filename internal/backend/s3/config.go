@@ -1,12 +1,18 @@
 package s3
 
 import (
+	"encoding/base64"
+	"io/ioutil"
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/options"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 )
 
 // Config contains all configuration necessary to connect to an s3 compatible
@@ -25,6 +31,12 @@ type Config struct {
 	Region        string `option:"region" help:"set region"`
 	BucketLookup  string `option:"bucket-lookup" help:"bucket lookup style: 'auto', 'dns', or 'path'"`
 	ListObjectsV1 bool   `option:"list-objects-v1" help:"use deprecated V1 api for ListObjects calls"`
+
+	ObjectLockMode string        `option:"object-lock-mode" help:"upload files with object lock retention in this mode ('governance' or 'compliance')"`
+	Retention      time.Duration `option:"retention" help:"keep uploaded files locked for this duration, e.g. 720h (requires object-lock-mode)"`
+
+	SSECKeyFile string `option:"sse-c-key-file" help:"encrypt files on the server with the customer-provided 256 bit key read from this file (SSE-C)"`
+	SSE         string `option:"sse" help:"encrypt files on the server with S3 managed keys ('aes256') or a KMS key ('kms:<key-id>')"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
@@ -39,6 +51,76 @@ func init() {
 	options.Register("s3", Config{})
 }
 
+// objectLock returns the retention mode for uploaded files, or an empty string
+// if object lock is not used.
+func (cfg Config) objectLock() (minio.RetentionMode, error) {
+	switch strings.ToLower(cfg.ObjectLockMode) {
+	case "":
+		if cfg.Retention != 0 {
+			return "", errors.New("s3: retention requires object-lock-mode to be set")
+		}
+		return "", nil
+	case "governance":
+		if cfg.Retention <= 0 {
+			return "", errors.New("s3: object-lock-mode requires a positive retention")
+		}
+		return minio.Governance, nil
+	case "compliance":
+		if cfg.Retention <= 0 {
+			return "", errors.New("s3: object-lock-mode requires a positive retention")
+		}
+		return minio.Compliance, nil
+	default:
+		return "", errors.Errorf(`s3: bad object-lock-mode %q, must be "governance" or "compliance"`, cfg.ObjectLockMode)
+	}
+}
+
+// serverSideEncryption returns the server-side encryption to use for all
+// objects, or nil if none is configured. The key for SSE-C is read from
+// SSECKeyFile, which must contain either exactly 32 bytes or their base64
+// encoding.
+func (cfg Config) serverSideEncryption() (encrypt.ServerSide, error) {
+	if cfg.SSECKeyFile != "" && cfg.SSE != "" {
+		return nil, errors.New("s3: sse-c-key-file and sse cannot be used together")
+	}
+
+	if cfg.SSECKeyFile != "" {
+		buf, err := ioutil.ReadFile(cfg.SSECKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "s3: reading sse-c-key-file")
+		}
+
+		key := buf
+		if len(key) != 32 {
+			key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(buf)))
+			if err != nil || len(key) != 32 {
+				return nil, errors.New("s3: sse-c-key-file must contain a 256 bit key, either raw or base64 encoded")
+			}
+		}
+
+		sse, err := encrypt.NewSSEC(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "encrypt.NewSSEC")
+		}
+		return sse, nil
+	}
+
+	switch {
+	case cfg.SSE == "":
+		return nil, nil
+	case strings.ToLower(cfg.SSE) == "aes256":
+		return encrypt.NewSSE(), nil
+	case strings.HasPrefix(cfg.SSE, "kms:") && len(cfg.SSE) > len("kms:"):
+		sse, err := encrypt.NewSSEKMS(cfg.SSE[len("kms:"):], nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "encrypt.NewSSEKMS")
+		}
+		return sse, nil
+	default:
+		return nil, errors.Errorf(`s3: bad sse %q, must be "aes256" or "kms:<key-id>"`, cfg.SSE)
+	}
+}
+
 // ParseConfig parses the string s and extracts the s3 config. The two
 // supported configuration formats are s3://host/bucketname/prefix and
 // s3:host/bucketname/prefix. The host can also be a valid s3 region
@@ -1,6 +1,18 @@
 package s3
 
-import "testing"
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rtest "github.com/restic/restic/internal/test"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
 
 var configTests = []struct {
 	s   string
@@ -111,3 +123,90 @@ func TestParseConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestObjectLock(t *testing.T) {
+	var tests = []struct {
+		mode      string
+		retention time.Duration
+		want      minio.RetentionMode
+		err       bool
+	}{
+		{"", 0, "", false},
+		{"", time.Hour, "", true},
+		{"governance", 24 * time.Hour, minio.Governance, false},
+		{"Compliance", 24 * time.Hour, minio.Compliance, false},
+		{"governance", 0, "", true},
+		{"compliance", -time.Hour, "", true},
+		{"foobar", time.Hour, "", true},
+	}
+
+	for _, test := range tests {
+		cfg := Config{ObjectLockMode: test.mode, Retention: test.retention}
+		mode, err := cfg.objectLock()
+		if test.err {
+			if err == nil {
+				t.Errorf("objectLock(%q, %v): expected error, got none", test.mode, test.retention)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("objectLock(%q, %v): unexpected error %v", test.mode, test.retention, err)
+			continue
+		}
+		if mode != test.want {
+			t.Errorf("objectLock(%q, %v): want %q, got %q", test.mode, test.retention, test.want, mode)
+		}
+	}
+}
+
+func TestServerSideEncryption(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	key := bytes.Repeat([]byte{0x42}, 32)
+	rawKeyFile := filepath.Join(tempdir, "raw")
+	rtest.OK(t, ioutil.WriteFile(rawKeyFile, key, 0600))
+	b64KeyFile := filepath.Join(tempdir, "base64")
+	rtest.OK(t, ioutil.WriteFile(b64KeyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600))
+	shortKeyFile := filepath.Join(tempdir, "short")
+	rtest.OK(t, ioutil.WriteFile(shortKeyFile, key[:16], 0600))
+
+	var tests = []struct {
+		cfg  Config
+		want encrypt.Type
+		err  bool
+	}{
+		{Config{}, "", false},
+		{Config{SSECKeyFile: rawKeyFile}, encrypt.SSEC, false},
+		{Config{SSECKeyFile: b64KeyFile}, encrypt.SSEC, false},
+		{Config{SSECKeyFile: shortKeyFile}, "", true},
+		{Config{SSECKeyFile: filepath.Join(tempdir, "missing")}, "", true},
+		{Config{SSE: "AES256"}, encrypt.S3, false},
+		{Config{SSE: "kms:my-key"}, encrypt.KMS, false},
+		{Config{SSE: "kms:"}, "", true},
+		{Config{SSE: "foobar"}, "", true},
+		{Config{SSE: "aes256", SSECKeyFile: rawKeyFile}, "", true},
+	}
+
+	for i, test := range tests {
+		sse, err := test.cfg.serverSideEncryption()
+		if test.err {
+			if err == nil {
+				t.Errorf("test %d: expected error, got none", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: unexpected error %v", i, err)
+			continue
+		}
+
+		var typ encrypt.Type
+		if sse != nil {
+			typ = sse.Type()
+		}
+		if typ != test.want {
+			t.Errorf("test %d: want type %q, got %q", i, test.want, typ)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/restic/restic/internal/backend"
@@ -20,14 +21,22 @@ import (
 	"github.com/cenkalti/backoff/v4"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 )
 
 // Backend stores data on an S3 endpoint.
 type Backend struct {
-	client *minio.Client
-	sem    *backend.Semaphore
-	cfg    Config
+	client   *minio.Client
+	sem      *backend.Semaphore
+	cfg      Config
+	lockMode minio.RetentionMode
+	sse      encrypt.ServerSide
 	backend.Layout
+
+	// lockOnce guards locked, which is true if object lock is enabled for
+	// the bucket.
+	lockOnce sync.Once
+	locked   bool
 }
 
 // make sure that *Backend implements backend.Backend
@@ -42,6 +51,16 @@ func open(ctx context.Context, cfg Config, rt http.RoundTripper) (*Backend, erro
 		minio.MaxRetry = int(cfg.MaxRetries)
 	}
 
+	lockMode, err := cfg.objectLock()
+	if err != nil {
+		return nil, err
+	}
+
+	sse, err := cfg.serverSideEncryption()
+	if err != nil {
+		return nil, err
+	}
+
 	// Chains all credential types, in the following order:
 	// 	- Static credentials provided by user
 	//	- AWS env vars (i.e. AWS_ACCESS_KEY_ID)
@@ -107,9 +126,11 @@ func open(ctx context.Context, cfg Config, rt http.RoundTripper) (*Backend, erro
 	}
 
 	be := &Backend{
-		client: client,
-		sem:    sem,
-		cfg:    cfg,
+		client:   client,
+		sem:      sem,
+		cfg:      cfg,
+		lockMode: lockMode,
+		sse:      sse,
 	}
 
 	l, err := backend.ParseLayout(ctx, be, cfg.Layout, defaultLayout, cfg.Prefix)
@@ -148,8 +169,10 @@ func Create(ctx context.Context, cfg Config, rt http.RoundTripper) (restic.Backe
 	}
 
 	if !found {
-		// create new bucket with default ACL in default region
-		err = be.client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{})
+		// create new bucket with default ACL in default region, object lock
+		// can only be enabled when the bucket is created
+		opts := minio.MakeBucketOptions{ObjectLocking: be.lockMode != ""}
+		err = be.client.MakeBucket(ctx, cfg.Bucket, opts)
 		if err != nil {
 			return nil, errors.Wrap(err, "client.MakeBucket")
 		}
@@ -283,11 +306,17 @@ func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindRe
 	be.sem.GetToken()
 	defer be.sem.ReleaseToken()
 
-	opts := minio.PutObjectOptions{StorageClass: be.cfg.StorageClass}
+	opts := minio.PutObjectOptions{StorageClass: be.cfg.StorageClass, ServerSideEncryption: be.sse}
 	opts.ContentType = "application/octet-stream"
 	// the only option with the high-level api is to let the library handle the checksum computation
 	opts.SendContentMd5 = true
 
+	// lock files are removed again when the operation is finished
+	if h.Type != restic.LockFile && be.objectLocked(ctx) {
+		opts.Mode = be.lockMode
+		opts.RetainUntilDate = time.Now().Add(be.cfg.Retention)
+	}
+
 	debug.Log("PutObject(%v, %v, %v)", be.cfg.Bucket, objName, rd.Length())
 	info, err := be.client.PutObject(ctx, be.cfg.Bucket, objName, ioutil.NopCloser(rd), int64(rd.Length()), opts)
 
@@ -313,6 +342,16 @@ func (wr wrapReader) Close() error {
 	return err
 }
 
+// readSSE returns the server-side encryption which must be passed when reading
+// an object. Only SSE-C requires the key to be sent again, for the other
+// types S3 rejects requests which contain encryption headers.
+func (be *Backend) readSSE() encrypt.ServerSide {
+	if be.sse != nil && be.sse.Type() == encrypt.SSEC {
+		return be.sse
+	}
+	return nil
+}
+
 // Load runs fn with a reader that yields the contents of the file at h at the
 // given offset.
 func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
@@ -334,7 +373,7 @@ func (be *Backend) openReader(ctx context.Context, h restic.Handle, length int,
 	}
 
 	objName := be.Filename(h)
-	opts := minio.GetObjectOptions{}
+	opts := minio.GetObjectOptions{ServerSideEncryption: be.readSSE()}
 
 	var err error
 	if length > 0 {
@@ -375,7 +414,7 @@ func (be *Backend) Stat(ctx context.Context, h restic.Handle) (bi restic.FileInf
 	objName := be.Filename(h)
 	var obj *minio.Object
 
-	opts := minio.GetObjectOptions{}
+	opts := minio.GetObjectOptions{ServerSideEncryption: be.readSSE()}
 
 	be.sem.GetToken()
 	obj, err = be.client.GetObject(ctx, be.cfg.Bucket, objName, opts)
@@ -409,7 +448,7 @@ func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
 	objName := be.Filename(h)
 
 	be.sem.GetToken()
-	_, err := be.client.StatObject(ctx, be.cfg.Bucket, objName, minio.StatObjectOptions{})
+	_, err := be.client.StatObject(ctx, be.cfg.Bucket, objName, minio.StatObjectOptions{ServerSideEncryption: be.readSSE()})
 	be.sem.ReleaseToken()
 
 	if err == nil {
@@ -420,10 +459,22 @@ func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
 	return found, nil
 }
 
-// Remove removes the blob with the given name and type.
+// Remove removes the blob with the given name and type. Files which are still
+// locked by a retention period are not removed, restic.ErrRetained is returned
+// for them instead.
 func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
 	objName := be.Filename(h)
 
+	if h.Type != restic.LockFile && be.objectLocked(ctx) {
+		until, err := be.retainUntil(ctx, objName)
+		if err != nil {
+			return err
+		}
+		if time.Now().Before(until) {
+			return backoff.Permanent(errors.Wrapf(restic.ErrRetained, "%v is locked until %v", h, until.Format(time.RFC3339)))
+		}
+	}
+
 	be.sem.GetToken()
 	err := be.client.RemoveObject(ctx, be.cfg.Bucket, objName, minio.RemoveObjectOptions{})
 	be.sem.ReleaseToken()
@@ -437,6 +488,59 @@ func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
 	return errors.Wrap(err, "client.RemoveObject")
 }
 
+// objectLocked returns true if object lock is enabled for the bucket. Files
+// may be retained even if restic does not set a retention itself, e.g. by a
+// default retention of the bucket. When the configuration of the bucket cannot
+// be read, object lock is assumed to be enabled.
+func (be *Backend) objectLocked(ctx context.Context) bool {
+	if be.lockMode != "" {
+		return true
+	}
+
+	be.lockOnce.Do(func() {
+		be.sem.GetToken()
+		enabled, _, _, _, err := be.client.GetObjectLockConfig(ctx, be.cfg.Bucket)
+		be.sem.ReleaseToken()
+
+		debug.Log("GetObjectLockConfig(%v) -> %v, err %v", be.cfg.Bucket, enabled, err)
+
+		switch {
+		case err == nil:
+			be.locked = enabled == "Enabled"
+		case minio.ToErrorResponse(err).Code == "ObjectLockConfigurationNotFoundError":
+			be.locked = false
+		default:
+			be.locked = true
+		}
+	})
+	return be.locked
+}
+
+// retainUntil returns the time until which the object is locked by a
+// retention period. In a bucket with object lock enabled, removing a locked
+// object would only add a delete marker and hide the object, so this is
+// checked beforehand.
+func (be *Backend) retainUntil(ctx context.Context, objName string) (time.Time, error) {
+	be.sem.GetToken()
+	_, until, err := be.client.GetObjectRetention(ctx, be.cfg.Bucket, objName, "")
+	be.sem.ReleaseToken()
+
+	debug.Log("GetObjectRetention(%v) -> %v, err %v", objName, until, err)
+
+	if err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case "NoSuchKey", "NoSuchObjectLockConfiguration":
+			return time.Time{}, nil
+		}
+		return time.Time{}, errors.Wrap(err, "client.GetObjectRetention")
+	}
+
+	if until == nil {
+		return time.Time{}, nil
+	}
+	return *until, nil
+}
+
 // List runs fn for each file in the backend which has the type t. When an
 // error occurs (or fn returns an error), List stops and returns it.
 func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
@@ -538,13 +642,15 @@ func (be *Backend) Rename(ctx context.Context, h restic.Handle, l backend.Layout
 	debug.Log("  %v -> %v", oldname, newname)
 
 	src := minio.CopySrcOptions{
-		Bucket: be.cfg.Bucket,
-		Object: oldname,
+		Bucket:     be.cfg.Bucket,
+		Object:     oldname,
+		Encryption: be.readSSE(),
 	}
 
 	dst := minio.CopyDestOptions{
-		Bucket: be.cfg.Bucket,
-		Object: newname,
+		Bucket:     be.cfg.Bucket,
+		Object:     newname,
+		Encryption: be.sse,
 	}
 
 	_, err := be.client.CopyObject(ctx, dst, src)
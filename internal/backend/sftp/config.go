@@ -4,6 +4,7 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/options"
@@ -15,6 +16,11 @@ type Config struct {
 
 	Layout  string `option:"layout" help:"use this backend directory layout (default: auto-detect)"`
 	Command string `option:"command" help:"specify command to create sftp connection"`
+
+	Native     bool          `option:"native" help:"use the built-in SSH client instead of running ssh"`
+	KeyFile    string        `option:"key-file" help:"private key for the built-in SSH client (default: ssh-agent, ~/.ssh/id_ed25519, id_ecdsa, id_rsa)"`
+	KnownHosts string        `option:"known-hosts" help:"known_hosts file for the built-in SSH client (default: ~/.ssh/known_hosts)"`
+	KeepAlive  time.Duration `option:"keepalive" help:"interval between keepalive messages of the built-in SSH client (default: 30s)"`
 }
 
 func init() {
@@ -0,0 +1,275 @@
+package sftp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// defaultKeepAlive is the interval between keepalive messages of the built-in
+// SSH client, unless configured otherwise.
+const defaultKeepAlive = 30 * time.Second
+
+// dialTimeout limits the time for establishing the SSH connection.
+const dialTimeout = 30 * time.Second
+
+// homeDir returns the home directory of the current user.
+func homeDir() string {
+	if home := os.Getenv("HOME"); home != "" {
+		return home
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return u.HomeDir
+}
+
+// sshUser returns the user name for the SSH connection.
+func sshUser(cfg Config) (string, error) {
+	if cfg.User != "" {
+		return cfg.User, nil
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return "", errors.Wrap(err, "user.Current")
+	}
+	return u.Username, nil
+}
+
+// sshAuthMethods returns the methods to authenticate with, these are the keys
+// held by the ssh-agent and the private key files. The returned agent
+// connection must be closed by the caller after authentication, it is nil if
+// no agent is used.
+func sshAuthMethods(cfg Config) ([]ssh.AuthMethod, net.Conn, error) {
+	var signers []ssh.Signer
+	var agentConn net.Conn
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			debug.Log("unable to connect to ssh-agent: %v", err)
+		} else {
+			agentConn = conn
+			agentSigners, err := agent.NewClient(conn).Signers()
+			if err != nil {
+				debug.Log("unable to list keys of ssh-agent: %v", err)
+			}
+			signers = append(signers, agentSigners...)
+		}
+	}
+
+	closeAgent := func() {
+		if agentConn != nil {
+			_ = agentConn.Close()
+		}
+	}
+
+	keyFiles := []string{cfg.KeyFile}
+	if cfg.KeyFile == "" {
+		dir := filepath.Join(homeDir(), ".ssh")
+		keyFiles = []string{
+			filepath.Join(dir, "id_ed25519"),
+			filepath.Join(dir, "id_ecdsa"),
+			filepath.Join(dir, "id_rsa"),
+		}
+	}
+
+	for _, filename := range keyFiles {
+		buf, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) && cfg.KeyFile == "" {
+			continue
+		}
+		if err != nil {
+			closeAgent()
+			return nil, nil, errors.Wrap(err, "ReadFile")
+		}
+
+		signer, err := ssh.ParsePrivateKey(buf)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok && cfg.KeyFile == "" {
+			// encrypted keys can only be used via the ssh-agent
+			debug.Log("skipping encrypted key %v", filename)
+			continue
+		}
+		if err != nil {
+			closeAgent()
+			return nil, nil, errors.Wrapf(err, "parsing key %v failed", filename)
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 {
+		closeAgent()
+		return nil, nil, errors.Fatal("no SSH key found, please add one to the ssh-agent or specify the key file with -o sftp.key-file")
+	}
+
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, agentConn, nil
+}
+
+// startNativeClient connects to the server with the built-in SSH client and
+// starts the sftp subsystem.
+func startNativeClient(cfg Config) (*SFTP, error) {
+	username, err := sshUser(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, agentConn, err := sshAuthMethods(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if agentConn != nil {
+		defer func() {
+			_ = agentConn.Close()
+		}()
+	}
+
+	knownHostsFile := cfg.KnownHosts
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(homeDir(), ".ssh", "known_hosts")
+	}
+
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, errors.Fatalf("unable to read known hosts for verifying the host key: %v", err)
+	}
+
+	port := cfg.Port
+	if port == "" {
+		port = "22"
+	}
+	addr := net.JoinHostPort(cfg.Host, port)
+
+	debug.Log("connecting to %v as %v", addr, username)
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:              username,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms(hostKeyCallback, addr),
+		Timeout:           dialTimeout,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "ssh.Dial")
+	}
+
+	// wait in a different goroutine
+	ch := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		err := client.Wait()
+		debug.Log("ssh connection closed, err %v", err)
+		close(done)
+		for {
+			ch <- errors.Wrap(err, "ssh connection closed")
+		}
+	}()
+
+	keepAlive := cfg.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = defaultKeepAlive
+	}
+	go sendKeepAlive(client, keepAlive, done)
+
+	c, err := sftp.NewClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, errors.Errorf("unable to start the sftp session, error: %v", err)
+	}
+
+	return &SFTP{c: c, ssh: client, result: ch}, nil
+}
+
+// hostKeyAlgorithms returns the host key algorithms for the types of the keys
+// which are known for addr, so that the server does not offer a key of a
+// different type which cannot be verified. It returns nil if no key is known,
+// then the default algorithms are used.
+func hostKeyAlgorithms(hostKeyCallback ssh.HostKeyCallback, addr string) []string {
+	// the known keys are returned for a key which does not match any of them
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	probe, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(hostKeyCallback(addr, &net.TCPAddr{}, probe), &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	seen := make(map[string]struct{})
+	for _, known := range keyErr.Want {
+		algos := []string{known.Key.Type()}
+		if known.Key.Type() == ssh.KeyAlgoRSA {
+			// RSA keys can also be used with signatures based on SHA-2
+			algos = []string{ssh.SigAlgoRSASHA2512, ssh.SigAlgoRSASHA2256, ssh.SigAlgoRSA}
+		}
+
+		for _, algo := range algos {
+			if _, ok := seen[algo]; ok {
+				continue
+			}
+			seen[algo] = struct{}{}
+			algorithms = append(algorithms, algo)
+		}
+	}
+
+	debug.Log("host key algorithms for %v: %v", addr, algorithms)
+	return algorithms
+}
+
+// sendKeepAlive regularly sends a request to the server until done is closed,
+// so that idle connections are not terminated. The connection is closed when
+// the server does not answer.
+func sendKeepAlive(client *ssh.Client, interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+
+		res := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			res <- err
+		}()
+
+		select {
+		case <-done:
+			return
+		case err := <-res:
+			if err != nil {
+				debug.Log("keepalive failed: %v", err)
+				_ = client.Close()
+				return
+			}
+		case <-time.After(interval):
+			debug.Log("no reply to keepalive within %v", interval)
+			_ = client.Close()
+			return
+		}
+	}
+}
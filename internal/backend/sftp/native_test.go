@@ -0,0 +1,279 @@
+package sftp_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/sftp"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+
+	pkgsftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newKey returns a new private key and writes it to filename, if set.
+func newKey(t testing.TB, filename string) ssh.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rtest.OK(t, err)
+
+	if filename != "" {
+		buf, err := x509.MarshalECPrivateKey(key)
+		rtest.OK(t, err)
+		data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: buf})
+		rtest.OK(t, ioutil.WriteFile(filename, data, 0600))
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	rtest.OK(t, err)
+	return signer
+}
+
+// startSSHServer starts an SSH server which serves the sftp subsystem and
+// accepts the client key. It returns the address of the server.
+func startSSHServer(t testing.TB, clientKey ssh.PublicKey, hostKeys ...ssh.Signer) string {
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	for _, hostKey := range hostKeys {
+		cfg.AddHostKey(hostKey)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	rtest.OK(t, err)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, cfg)
+		}
+	}()
+
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	return l.Addr().String()
+}
+
+func serveSSH(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for req := range requests {
+				// the payload is the length-prefixed name of the subsystem
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				srv, err := pkgsftp.NewServer(channel)
+				if err != nil {
+					_ = channel.Close()
+					return
+				}
+				go func() {
+					_ = srv.Serve()
+					_ = srv.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// nativeTestConfig starts an SSH server and returns a config for the
+// directory dir on it.
+func nativeTestConfig(t testing.TB, dir string) sftp.Config {
+	keyDir, err := ioutil.TempDir(rtest.TestTempDir, "restic-test-sftp-keys-")
+	rtest.OK(t, err)
+	t.Cleanup(func() {
+		rtest.RemoveAll(t, keyDir)
+	})
+
+	keyFile := filepath.Join(keyDir, "id_ecdsa")
+	clientKey := newKey(t, keyFile)
+	hostKey := newKey(t, "")
+
+	addr := startSSHServer(t, clientKey.PublicKey(), hostKey)
+	host, port, err := net.SplitHostPort(addr)
+	rtest.OK(t, err)
+
+	knownHosts := filepath.Join(keyDir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey.PublicKey())
+	rtest.OK(t, ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0600))
+
+	return sftp.Config{
+		User:       "restic",
+		Host:       host,
+		Port:       port,
+		Path:       dir,
+		Native:     true,
+		KeyFile:    keyFile,
+		KnownHosts: knownHosts,
+	}
+}
+
+func newNativeTestSuite(t testing.TB) *test.Suite {
+	suite := newTestSuite(t)
+
+	suite.NewConfig = func() (interface{}, error) {
+		dir, err := ioutil.TempDir(rtest.TestTempDir, "restic-test-sftp-")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Logf("create new backend at %v", dir)
+		return nativeTestConfig(t, dir), nil
+	}
+
+	return suite
+}
+
+func TestBackendSFTPNative(t *testing.T) {
+	newNativeTestSuite(t).RunTests(t)
+}
+
+func BenchmarkBackendSFTPNative(t *testing.B) {
+	newNativeTestSuite(t).RunBenchmarks(t)
+}
+
+func TestSFTPNativeUnknownHost(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	cfg := nativeTestConfig(t, dir)
+
+	// replace the host key with a different one
+	other := filepath.Join(filepath.Dir(cfg.KnownHosts), "other_known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort(cfg.Host, cfg.Port))}, newKey(t, "").PublicKey())
+	rtest.OK(t, ioutil.WriteFile(other, []byte(line+"\n"), 0600))
+	cfg.KnownHosts = other
+
+	be, err := sftp.Create(context.TODO(), cfg)
+	if err == nil {
+		_ = be.Close()
+		t.Fatal("connecting to a host with an unknown key succeeded")
+	}
+}
+
+func TestSFTPNativeHostKeyAlgorithms(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	cfg := nativeTestConfig(t, dir)
+	buf, err := ioutil.ReadFile(cfg.KeyFile)
+	rtest.OK(t, err)
+	clientKey, err := ssh.ParsePrivateKey(buf)
+	rtest.OK(t, err)
+
+	// the server prefers the ECDSA key, but only the ED25519 key is known
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	rtest.OK(t, err)
+	knownKey, err := ssh.NewSignerFromKey(priv)
+	rtest.OK(t, err)
+
+	addr := startSSHServer(t, clientKey.PublicKey(), newKey(t, ""), knownKey)
+	cfg.Host, cfg.Port, err = net.SplitHostPort(addr)
+	rtest.OK(t, err)
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, knownKey.PublicKey())
+	rtest.OK(t, ioutil.WriteFile(cfg.KnownHosts, []byte(line+"\n"), 0600))
+
+	be, err := sftp.Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	rtest.OK(t, be.Close())
+}
+
+func TestSFTPNativeAgent(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	cfg := nativeTestConfig(t, dir)
+
+	// serve the client key via an agent and remove the key file
+	keyring := agent.NewKeyring()
+	buf, err := ioutil.ReadFile(cfg.KeyFile)
+	rtest.OK(t, err)
+	key, err := ssh.ParseRawPrivateKey(buf)
+	rtest.OK(t, err)
+	rtest.OK(t, keyring.Add(agent.AddedKey{PrivateKey: key}))
+	rtest.OK(t, os.Remove(cfg.KeyFile))
+
+	sock := filepath.Join(filepath.Dir(cfg.KnownHosts), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	rtest.OK(t, err)
+	defer func() {
+		_ = l.Close()
+	}()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	oldSock, hadSock := os.LookupEnv("SSH_AUTH_SOCK")
+	rtest.OK(t, os.Setenv("SSH_AUTH_SOCK", sock))
+	defer func() {
+		if hadSock {
+			_ = os.Setenv("SSH_AUTH_SOCK", oldSock)
+		} else {
+			_ = os.Unsetenv("SSH_AUTH_SOCK")
+		}
+	}()
+
+	// the key file does not exist any more, rely on the agent only
+	cfg.KeyFile = ""
+
+	be, err := sftp.Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+
+	ok, err := be.Test(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "config file found in new repository")
+	rtest.OK(t, be.Close())
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
 )
 
 // SFTP is a backend in a directory accessed via SFTP.
@@ -27,6 +28,7 @@ type SFTP struct {
 	p string
 
 	cmd    *exec.Cmd
+	ssh    *ssh.Client
 	result <-chan error
 
 	backend.Layout
@@ -108,11 +110,15 @@ func (r *SFTP) clientError() error {
 	return nil
 }
 
-// Open opens an sftp backend as described by the config by running
-// "ssh" with the appropriate arguments (or cfg.Command, if set). The function
-// preExec is run just before, postExec just after starting a program.
-func Open(ctx context.Context, cfg Config) (*SFTP, error) {
-	debug.Log("open backend with config %#v", cfg)
+// connect starts the sftp session, either via the built-in SSH client or by
+// running "ssh" with the appropriate arguments (or cfg.Command, if set).
+func connect(cfg Config) (*SFTP, error) {
+	if cfg.Native {
+		if cfg.Command != "" {
+			return nil, errors.Fatal("the options sftp.command and sftp.native cannot be used together")
+		}
+		return startNativeClient(cfg)
+	}
 
 	cmd, args, err := buildSSHCommand(cfg)
 	if err != nil {
@@ -124,6 +130,19 @@ func Open(ctx context.Context, cfg Config) (*SFTP, error) {
 		debug.Log("unable to start program: %v", err)
 		return nil, err
 	}
+	return sftp, nil
+}
+
+// Open opens an sftp backend as described by the config by running
+// "ssh" with the appropriate arguments (or cfg.Command, if set), or by using
+// the built-in SSH client if cfg.Native is set.
+func Open(ctx context.Context, cfg Config) (*SFTP, error) {
+	debug.Log("open backend with config %#v", cfg)
+
+	sftp, err := connect(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	sftp.Layout, err = backend.ParseLayout(ctx, sftp, cfg.Layout, defaultLayout, cfg.Path)
 	if err != nil {
@@ -197,17 +216,11 @@ func buildSSHCommand(cfg Config) (cmd string, args []string, err error) {
 }
 
 // Create creates an sftp backend as described by the config by running "ssh"
-// with the appropriate arguments (or cfg.Command, if set). The function
-// preExec is run just before, postExec just after starting a program.
+// with the appropriate arguments (or cfg.Command, if set), or by using the
+// built-in SSH client if cfg.Native is set.
 func Create(ctx context.Context, cfg Config) (*SFTP, error) {
-	cmd, args, err := buildSSHCommand(cfg)
-	if err != nil {
-		return nil, err
-	}
-
-	sftp, err := startClient(cmd, args...)
+	sftp, err := connect(cfg)
 	if err != nil {
-		debug.Log("unable to start program: %v", err)
 		return nil, err
 	}
 
@@ -498,6 +511,13 @@ func (r *SFTP) Close() error {
 	err := r.c.Close()
 	debug.Log("Close returned error %v", err)
 
+	if r.ssh != nil {
+		// the built-in client has no process which needs to be terminated
+		err = r.ssh.Close()
+		<-r.result
+		return err
+	}
+
 	// wait for closeTimeout before killing the process
 	select {
 	case err := <-r.result:
@@ -0,0 +1,57 @@
+package sharded
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config holds the directories of a repository which is spread over several
+// disks.
+type Config struct {
+	Paths []string
+
+	Placement string `option:"placement" help:"distribute data files over the disks by \"hash\" or free \"space\" (default: hash)"`
+}
+
+// Placement strategies for data files.
+const (
+	PlacementHash  = "hash"
+	PlacementSpace = "space"
+)
+
+func init() {
+	options.Register("sharded", Config{})
+}
+
+// NewConfig returns a new config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		Placement: PlacementHash,
+	}
+}
+
+// ParseConfig parses a sharded backend config. The directories are separated
+// by the OS-specific path list separator (":" on Unix, ";" on Windows), for
+// example "sharded:/mnt/disk1/repo:/mnt/disk2/repo".
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, "sharded:") {
+		return nil, errors.New(`invalid format, prefix "sharded" not found`)
+	}
+
+	cfg := NewConfig()
+	for _, dir := range filepath.SplitList(s[8:]) {
+		if dir == "" {
+			return nil, errors.New("sharded: empty directory name")
+		}
+		cfg.Paths = append(cfg.Paths, dir)
+	}
+
+	if len(cfg.Paths) < 2 {
+		return nil, errors.New("sharded: at least two directories are needed")
+	}
+
+	return cfg, nil
+}
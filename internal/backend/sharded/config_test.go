@@ -0,0 +1,53 @@
+package sharded
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	sep := string(filepath.ListSeparator)
+
+	var tests = []struct {
+		s   string
+		cfg Config
+	}{
+		{"sharded:/mnt/a/repo" + sep + "/mnt/b/repo", Config{
+			Paths:     []string{"/mnt/a/repo", "/mnt/b/repo"},
+			Placement: PlacementHash,
+		}},
+		{"sharded:a" + sep + "b" + sep + "c", Config{
+			Paths:     []string{"a", "b", "c"},
+			Placement: PlacementHash,
+		}},
+	}
+
+	for _, test := range tests {
+		cfg, err := ParseConfig(test.s)
+		if err != nil {
+			t.Fatalf("%s failed: %v", test.s, err)
+		}
+
+		if !reflect.DeepEqual(cfg, test.cfg) {
+			t.Fatalf("input: %s\n wrong config, want:\n  %v\ngot:\n  %v",
+				test.s, test.cfg, cfg)
+		}
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	sep := string(filepath.ListSeparator)
+
+	for _, s := range []string{
+		"sharded:",
+		"sharded:/mnt/a/repo",
+		"sharded:/mnt/a/repo" + sep + sep + "/mnt/b/repo",
+		"local:/mnt/a/repo" + sep + "/mnt/b/repo",
+	} {
+		_, err := ParseConfig(s)
+		if err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
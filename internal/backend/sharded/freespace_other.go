@@ -0,0 +1,14 @@
+// +build !linux,!darwin,!freebsd,!windows
+
+package sharded
+
+import (
+	"runtime"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// freeSpace is not implemented on this platform.
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.Errorf("determining the free space is not supported on %v", runtime.GOOS)
+}
@@ -0,0 +1,21 @@
+package sharded
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// freeSpace returns the number of bytes available to the current user in dir.
+func freeSpace(dir string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var free uint64
+	err = windows.GetDiskFreeSpaceEx(p, &free, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return free, nil
+}
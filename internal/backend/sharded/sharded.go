@@ -0,0 +1,276 @@
+// Package sharded implements repository storage in several local directories,
+// usually on different disks. Data files are distributed over the
+// directories, all other files are stored in every directory.
+package sharded
+
+import (
+	"context"
+	"encoding/binary"
+	"hash"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Backend stores a repository in several local directories.
+type Backend struct {
+	shards    []*local.Local
+	placement string
+	Config
+
+	// freeSpace returns the number of bytes available in dir.
+	freeSpace func(dir string) (uint64, error)
+}
+
+// statically ensure that Backend implements restic.Backend.
+var _ restic.Backend = &Backend{}
+
+func open(cfg Config, fn func(local.Config) (*local.Local, error)) (*Backend, error) {
+	if len(cfg.Paths) < 2 {
+		return nil, errors.Fatal("sharded: at least two directories are needed")
+	}
+
+	switch cfg.Placement {
+	case "", PlacementHash, PlacementSpace:
+	default:
+		return nil, errors.Fatalf("sharded: invalid placement %q, may be one of: hash, space", cfg.Placement)
+	}
+
+	be := &Backend{
+		placement: cfg.Placement,
+		Config:    cfg,
+		freeSpace: freeSpace,
+	}
+
+	for _, dir := range cfg.Paths {
+		shard, err := fn(local.Config{Path: dir})
+		if err != nil {
+			_ = be.Close()
+			return nil, errors.Wrapf(err, "directory %v", dir)
+		}
+		be.shards = append(be.shards, shard)
+	}
+
+	return be, nil
+}
+
+// Open opens the repository in the directories cfg.Paths.
+func Open(ctx context.Context, cfg Config) (*Backend, error) {
+	debug.Log("open sharded backend at %v", cfg.Paths)
+	return open(cfg, func(cfg local.Config) (*local.Local, error) {
+		return local.Open(ctx, cfg)
+	})
+}
+
+// Create creates all files and directories necessary for a new repository in
+// the directories cfg.Paths.
+func Create(ctx context.Context, cfg Config) (*Backend, error) {
+	debug.Log("create sharded backend at %v", cfg.Paths)
+	return open(cfg, func(cfg local.Config) (*local.Local, error) {
+		return local.Create(ctx, cfg)
+	})
+}
+
+// Location returns the directories of the repository.
+func (be *Backend) Location() string {
+	return strings.Join(be.Paths, string(filepath.ListSeparator))
+}
+
+// Hasher may return a hash function for calculating a content hash for the backend
+func (be *Backend) Hasher() hash.Hash {
+	return nil
+}
+
+// IsNotExist returns true if the error is caused by a non existing file.
+func (be *Backend) IsNotExist(err error) bool {
+	if _, ok := errors.Cause(err).(notExistError); ok {
+		return true
+	}
+	return be.shards[0].IsNotExist(err)
+}
+
+// notExistError is returned when a file does not exist in any directory.
+type notExistError struct {
+	restic.Handle
+}
+
+func (e notExistError) Error() string {
+	return e.Handle.String() + " does not exist"
+}
+
+// hashShard returns the index of the directory a data file is stored in when
+// it is placed by hash.
+func (be *Backend) hashShard(h restic.Handle) int {
+	id, err := restic.ParseID(h.Name)
+	if err != nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(id[:4]) % uint32(len(be.shards)))
+}
+
+// spaceShard returns the index of the directory with the most free space.
+func (be *Backend) spaceShard() (int, error) {
+	best, bestFree := -1, uint64(0)
+	for i, dir := range be.Paths {
+		free, err := be.freeSpace(dir)
+		if err != nil {
+			return 0, errors.Wrapf(err, "free space of %v", dir)
+		}
+		if best < 0 || free > bestFree {
+			best, bestFree = i, free
+		}
+	}
+	return best, nil
+}
+
+// order returns the order in which the directories are searched for the file
+// h. For data files, the directory chosen by hash comes first.
+func (be *Backend) order(h restic.Handle) []*local.Local {
+	if h.Type != restic.PackFile {
+		return be.shards
+	}
+
+	first := be.hashShard(h)
+	shards := make([]*local.Local, 0, len(be.shards))
+	shards = append(shards, be.shards[first])
+	for i, shard := range be.shards {
+		if i != first {
+			shards = append(shards, shard)
+		}
+	}
+	return shards
+}
+
+// Save stores data files in one directory and all other files in every
+// directory.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if h.Type != restic.PackFile {
+		for _, shard := range be.shards {
+			if err := rd.Rewind(); err != nil {
+				return err
+			}
+
+			err := shard.Save(ctx, h, rd)
+			if err != nil {
+				return errors.Wrapf(err, "directory %v", shard.Path)
+			}
+		}
+		return nil
+	}
+
+	idx := be.hashShard(h)
+	if be.placement == PlacementSpace {
+		var err error
+		idx, err = be.spaceShard()
+		if err != nil {
+			return err
+		}
+	}
+
+	debug.Log("saving %v to %v", h, be.shards[idx].Path)
+	return be.shards[idx].Save(ctx, h, rd)
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset. The file is loaded from the first directory which contains
+// it.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	for _, shard := range be.order(h) {
+		err := shard.Load(ctx, h, length, offset, fn)
+		if err == nil || !shard.IsNotExist(err) {
+			return err
+		}
+	}
+	return notExistError{h}
+}
+
+// Stat returns information about the file from the first directory which
+// contains it.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	for _, shard := range be.order(h) {
+		fi, err := shard.Stat(ctx, h)
+		if err == nil || !shard.IsNotExist(err) {
+			return fi, err
+		}
+	}
+	return restic.FileInfo{}, notExistError{h}
+}
+
+// Test returns true if the file exists in any directory.
+func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	for _, shard := range be.order(h) {
+		found, err := shard.Test(ctx, h)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// Remove removes the file from all directories which contain it.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	missing := 0
+	for _, shard := range be.shards {
+		err := shard.Remove(ctx, h)
+		if shard.IsNotExist(err) {
+			missing++
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "directory %v", shard.Path)
+		}
+	}
+
+	if missing == len(be.shards) {
+		return notExistError{h}
+	}
+	return nil
+}
+
+// List runs fn for each file of type t in any of the directories.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	seen := make(map[string]struct{})
+
+	for _, shard := range be.shards {
+		err := shard.List(ctx, t, func(fi restic.FileInfo) error {
+			if _, ok := seen[fi.Name]; ok {
+				return nil
+			}
+			seen[fi.Name] = struct{}{}
+			return fn(fi)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// Delete removes the repository in all directories.
+func (be *Backend) Delete(ctx context.Context) error {
+	for _, shard := range be.shards {
+		err := shard.Delete(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "directory %v", shard.Path)
+		}
+	}
+	return nil
+}
+
+// Close closes all directories.
+func (be *Backend) Close() error {
+	var firstErr error
+	for _, shard := range be.shards {
+		err := shard.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,133 @@
+package sharded_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/sharded"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func newTestSuite(t testing.TB, placement string) *test.Suite {
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			cfg := sharded.NewConfig()
+			cfg.Placement = placement
+			for i := 0; i < 3; i++ {
+				dir, err := ioutil.TempDir(rtest.TestTempDir, "restic-test-sharded-")
+				if err != nil {
+					t.Fatal(err)
+				}
+				cfg.Paths = append(cfg.Paths, dir)
+			}
+
+			t.Logf("create new backend at %v", cfg.Paths)
+			return cfg, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(sharded.Config)
+			return sharded.Create(context.TODO(), cfg)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(sharded.Config)
+			return sharded.Open(context.TODO(), cfg)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			cfg := config.(sharded.Config)
+			if !rtest.TestCleanupTempDirs {
+				t.Logf("leaving test backend dirs at %v", cfg.Paths)
+			}
+
+			for _, dir := range cfg.Paths {
+				rtest.RemoveAll(t, dir)
+			}
+			return nil
+		},
+	}
+}
+
+func TestBackend(t *testing.T) {
+	newTestSuite(t, sharded.PlacementHash).RunTests(t)
+}
+
+func TestBackendPlacementSpace(t *testing.T) {
+	newTestSuite(t, sharded.PlacementSpace).RunTests(t)
+}
+
+func BenchmarkBackend(t *testing.B) {
+	newTestSuite(t, sharded.PlacementHash).RunBenchmarks(t)
+}
+
+// countFiles returns the number of files of type tpe in each directory.
+func countFiles(t testing.TB, cfg sharded.Config, tpe restic.FileType) []int {
+	counts := make([]int, len(cfg.Paths))
+	for i, dir := range cfg.Paths {
+		be, err := local.Open(context.TODO(), local.Config{Path: dir})
+		rtest.OK(t, err)
+		rtest.OK(t, be.List(context.TODO(), tpe, func(restic.FileInfo) error {
+			counts[i]++
+			return nil
+		}))
+	}
+	return counts
+}
+
+func TestShardedDistribution(t *testing.T) {
+	cfg := sharded.NewConfig()
+	for i := 0; i < 3; i++ {
+		dir, cleanup := rtest.TempDir(t)
+		defer cleanup()
+		cfg.Paths = append(cfg.Paths, dir)
+	}
+
+	be, err := sharded.Create(context.TODO(), cfg)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, be.Close())
+	}()
+
+	const packs = 30
+	for i := 0; i < packs; i++ {
+		data := rtest.Random(i, 100)
+		h := restic.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+		rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data, be.Hasher())))
+	}
+
+	data := []byte("snapshot")
+	snapshot := restic.Handle{Type: restic.SnapshotFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, be.Save(context.TODO(), snapshot, restic.NewByteReader(data, be.Hasher())))
+
+	// data files are stored exactly once, but not all in the same directory
+	total := 0
+	for _, n := range countFiles(t, cfg, restic.PackFile) {
+		rtest.Assert(t, n < packs, "all data files were saved in the same directory")
+		total += n
+	}
+	rtest.Equals(t, packs, total)
+
+	// other files are stored in every directory
+	rtest.Equals(t, []int{1, 1, 1}, countFiles(t, cfg, restic.SnapshotFile))
+
+	// files are found even if a directory lost a replica of the snapshot
+	rtest.OK(t, os.Remove(filepath.Join(cfg.Paths[0], "snapshots", snapshot.Name)))
+	fi, err := be.Stat(context.TODO(), snapshot)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len(data)), fi.Size)
+
+	rtest.OK(t, be.Remove(context.TODO(), snapshot))
+	_, err = be.Stat(context.TODO(), snapshot)
+	rtest.Assert(t, be.IsNotExist(err), "expected not exist error, got %v", err)
+}
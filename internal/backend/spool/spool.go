@@ -0,0 +1,654 @@
+// Package spool implements a backend which stores new files in a local
+// directory while the repository is unreachable, they are uploaded later by
+// Flush.
+package spool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ErrOffline is returned for operations which need the repository while it
+// is unreachable.
+var ErrOffline = errors.New("repository is unreachable")
+
+// Backend wraps a restic.Backend. Files which cannot be saved in the backend
+// are stored in a spool directory instead, and the backend is considered
+// unreachable for the rest of the run. Spooled files are served by Load, Stat,
+// Test and List until they are uploaded by Flush.
+//
+// The spool directory contains the following subdirectories:
+//
+//	pending: files which have not been uploaded yet
+//	known:   copies of the config and key files of the repository
+//	lists:   names and sizes of the index and snapshot files in the
+//	         repository, recorded by List
+//
+// The copies and lists allow opening the repository and looking up snapshots
+// and the index while the repository is unreachable.
+type Backend struct {
+	restic.Backend
+	Dir string
+
+	pending *local.Local
+	known   *local.Local
+
+	m       sync.Mutex
+	offline bool
+
+	listM sync.Mutex
+
+	// Report is called with the error when the backend becomes unreachable.
+	Report func(error)
+}
+
+// statically ensure that Backend implements restic.Backend.
+var _ restic.Backend = &Backend{}
+
+func newBackend(ctx context.Context, be restic.Backend, dir string, offline bool) (*Backend, error) {
+	pending, err := local.Open(ctx, local.Config{Path: filepath.Join(dir, "pending"), Layout: "default"})
+	if err != nil {
+		return nil, err
+	}
+
+	known, err := local.Open(ctx, local.Config{Path: filepath.Join(dir, "known"), Layout: "default"})
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.MkdirAll(filepath.Join(dir, "lists"), backend.Modes.Dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &Backend{
+		Backend: be,
+		Dir:     dir,
+		pending: pending,
+		known:   known,
+		offline: offline,
+		Report:  func(error) {},
+	}, nil
+}
+
+// New returns a backend which stores files in the spool directory dir while
+// be is unreachable.
+func New(ctx context.Context, be restic.Backend, dir string) (*Backend, error) {
+	debug.Log("using spool directory %v", dir)
+	return newBackend(ctx, be, dir, false)
+}
+
+// OpenOffline returns a backend for the spool directory dir which is used when
+// the repository cannot be opened at all. This requires a copy of the
+// repository config, which is stored in dir whenever the config is loaded
+// from the repository through a spool backend.
+func OpenOffline(ctx context.Context, dir string) (*Backend, error) {
+	debug.Log("using spool directory %v offline", dir)
+	be, err := newBackend(ctx, offlineBackend{}, dir, true)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := be.known.Test(ctx, restic.Handle{Type: restic.ConfigFile})
+	if err == nil && !found {
+		err = errors.Errorf("spool directory %v does not contain a copy of the repository config", dir)
+	}
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+
+	return be, nil
+}
+
+// Offline returns true if the repository is unreachable.
+func (b *Backend) Offline() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.offline
+}
+
+// setOffline marks the repository as unreachable because of err.
+func (b *Backend) setOffline(err error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.offline {
+		return
+	}
+
+	debug.Log("repository is unreachable: %v", err)
+	b.offline = true
+	b.Report(err)
+}
+
+// keepCopy returns true for files which are copied to the spool directory.
+func keepCopy(t restic.FileType) bool {
+	return t == restic.ConfigFile || t == restic.KeyFile
+}
+
+// keepList returns true for file types whose lists are recorded in the spool
+// directory.
+func keepList(t restic.FileType) bool {
+	return t == restic.IndexFile || t == restic.SnapshotFile
+}
+
+// Save stores the file in the backend. When this fails, or the backend is
+// already unreachable, the file is stored in the spool directory.
+func (b *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if !b.Offline() {
+		err := b.Backend.Save(ctx, h, rd)
+		if err == nil {
+			b.saved(ctx, h, rd)
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+		b.setOffline(err)
+
+		err = rd.Rewind()
+		if err != nil {
+			return err
+		}
+	}
+
+	debug.Log("spool %v", h)
+	return b.pending.Save(ctx, h, rd)
+}
+
+// saved is called for each file saved in the backend and records it in the
+// spool directory if needed.
+func (b *Backend) saved(ctx context.Context, h restic.Handle, rd restic.RewindReader) {
+	if keepCopy(h.Type) {
+		b.saveCopy(ctx, h, rd)
+	}
+
+	if keepList(h.Type) {
+		b.updateList(h.Type, func(list []restic.FileInfo) []restic.FileInfo {
+			for _, fi := range list {
+				if fi.Name == h.Name {
+					return list
+				}
+			}
+			return append(list, restic.FileInfo{Name: h.Name, Size: rd.Length()})
+		})
+	}
+}
+
+// saveCopy stores a copy of the file in the spool directory, errors are
+// ignored.
+func (b *Backend) saveCopy(ctx context.Context, h restic.Handle, rd restic.RewindReader) {
+	err := rd.Rewind()
+	if err == nil {
+		err = b.known.Save(ctx, h, rd)
+	}
+	if err != nil {
+		debug.Log("unable to save a copy of %v: %v", h, err)
+	}
+}
+
+// isPending returns true if the file is stored in the spool directory.
+func (b *Backend) isPending(ctx context.Context, h restic.Handle) bool {
+	found, err := b.pending.Test(ctx, h)
+	if err != nil {
+		debug.Log("error testing pending file %v: %v", h, err)
+	}
+	return found
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset. Spooled files are loaded from the spool directory.
+func (b *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if b.isPending(ctx, h) {
+		debug.Log("Load(%v) from spool", h)
+		return b.pending.Load(ctx, h, length, offset, fn)
+	}
+
+	if !keepCopy(h.Type) {
+		if b.Offline() {
+			return errors.Wrap(ErrOffline, h.String())
+		}
+		return b.Backend.Load(ctx, h, length, offset, fn)
+	}
+
+	if !b.Offline() {
+		buf, err := backend.LoadAll(ctx, nil, b.Backend, h)
+		if err != nil {
+			return err
+		}
+
+		err = b.checkCopy(ctx, h, buf)
+		if err != nil {
+			return err
+		}
+		b.saveCopy(ctx, h, restic.NewByteReader(buf, nil))
+
+		return loadBuffer(buf, length, offset, fn)
+	}
+
+	debug.Log("Load(%v) from copy", h)
+	return b.known.Load(ctx, h, length, offset, fn)
+}
+
+// checkCopy makes sure that the spool directory is not used for a different
+// repository by comparing the config buf with the copy.
+func (b *Backend) checkCopy(ctx context.Context, h restic.Handle, buf []byte) error {
+	if h.Type != restic.ConfigFile {
+		return nil
+	}
+
+	cp, err := backend.LoadAll(ctx, nil, b.known, h)
+	if b.known.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(cp, buf) {
+		return errors.Fatalf("spool directory %v is used for a different repository", b.Dir)
+	}
+	return nil
+}
+
+// loadBuffer runs fn with a reader that yields length bytes of buf at offset.
+func loadBuffer(buf []byte, length int, offset int64, fn func(rd io.Reader) error) error {
+	if offset < 0 || offset > int64(len(buf)) {
+		return errors.Errorf("invalid offset %d", offset)
+	}
+
+	buf = buf[offset:]
+	if length > 0 && length < len(buf) {
+		buf = buf[:length]
+	}
+	return fn(bytes.NewReader(buf))
+}
+
+// Stat returns information about the file. Spooled files are looked up in the
+// spool directory.
+func (b *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	fi, err := b.pending.Stat(ctx, h)
+	if err == nil {
+		return fi, nil
+	}
+
+	if !b.Offline() {
+		return b.Backend.Stat(ctx, h)
+	}
+
+	if keepCopy(h.Type) {
+		return b.known.Stat(ctx, h)
+	}
+
+	if keepList(h.Type) {
+		list, err := b.loadList(h.Type)
+		if err != nil {
+			return restic.FileInfo{}, err
+		}
+		for _, fi := range list {
+			if fi.Name == h.Name {
+				return fi, nil
+			}
+		}
+		return restic.FileInfo{}, errors.WithStack(&os.PathError{Op: "stat", Path: h.String(), Err: os.ErrNotExist})
+	}
+
+	return restic.FileInfo{}, errors.Wrap(ErrOffline, h.String())
+}
+
+// Test returns true if the file exists in the backend or in the spool
+// directory.
+func (b *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	if b.isPending(ctx, h) {
+		return true, nil
+	}
+
+	if !b.Offline() {
+		return b.Backend.Test(ctx, h)
+	}
+
+	_, err := b.Stat(ctx, h)
+	if b.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Remove removes the file from the spool directory if it has not been
+// uploaded yet, and from the backend otherwise.
+func (b *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	if b.isPending(ctx, h) {
+		debug.Log("Remove(%v) from spool", h)
+		return b.pending.Remove(ctx, h)
+	}
+
+	if b.Offline() {
+		return errors.Wrap(ErrOffline, h.String())
+	}
+
+	err := b.Backend.Remove(ctx, h)
+	if err != nil {
+		return err
+	}
+
+	if keepCopy(h.Type) {
+		err = b.known.Remove(ctx, h)
+		if err != nil && !b.known.IsNotExist(err) {
+			debug.Log("unable to remove copy of %v: %v", h, err)
+		}
+	}
+
+	if keepList(h.Type) {
+		b.updateList(h.Type, func(list []restic.FileInfo) []restic.FileInfo {
+			for i, fi := range list {
+				if fi.Name == h.Name {
+					return append(list[:i], list[i+1:]...)
+				}
+			}
+			return list
+		})
+	}
+	return nil
+}
+
+// IsNotExist returns true if the error is caused by a non-existing file.
+func (b *Backend) IsNotExist(err error) bool {
+	return b.pending.IsNotExist(err) || b.Backend.IsNotExist(err)
+}
+
+// List runs fn for each file of type t in the backend and in the spool
+// directory. While the repository is unreachable, the index and snapshot
+// files recorded by the last List and the copies of the key files are used
+// instead, other files in the backend cannot be listed.
+func (b *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	seen := make(map[string]struct{})
+
+	if !b.Offline() {
+		var list []restic.FileInfo
+		err := b.Backend.List(ctx, t, func(fi restic.FileInfo) error {
+			seen[fi.Name] = struct{}{}
+			if keepList(t) {
+				list = append(list, fi)
+			}
+			return fn(fi)
+		})
+		if err != nil {
+			return err
+		}
+
+		if keepList(t) {
+			b.updateList(t, func([]restic.FileInfo) []restic.FileInfo {
+				return list
+			})
+		}
+	} else if keepList(t) {
+		list, err := b.loadList(t)
+		if err != nil {
+			return err
+		}
+		for _, fi := range list {
+			seen[fi.Name] = struct{}{}
+			err = fn(fi)
+			if err != nil {
+				return err
+			}
+		}
+	} else if t == restic.KeyFile {
+		err := b.known.List(ctx, t, func(fi restic.FileInfo) error {
+			seen[fi.Name] = struct{}{}
+			return fn(fi)
+		})
+		if err != nil {
+			return err
+		}
+	} else if t != restic.LockFile {
+		return errors.Wrapf(ErrOffline, "List(%v)", t)
+	}
+
+	return b.pending.List(ctx, t, func(fi restic.FileInfo) error {
+		if _, ok := seen[fi.Name]; ok {
+			return nil
+		}
+		return fn(fi)
+	})
+}
+
+func (b *Backend) listFilename(t restic.FileType) string {
+	return filepath.Join(b.Dir, "lists", string(t))
+}
+
+// updateList replaces the recorded list of files of type t with the result of
+// fn, errors are ignored.
+func (b *Backend) updateList(t restic.FileType, fn func([]restic.FileInfo) []restic.FileInfo) {
+	b.listM.Lock()
+	defer b.listM.Unlock()
+
+	list, err := b.loadList(t)
+	if err == nil {
+		err = b.saveList(t, fn(list))
+	}
+	if err != nil {
+		debug.Log("unable to update list of %v files: %v", t, err)
+	}
+}
+
+// saveList records the files of type t in the repository.
+func (b *Backend) saveList(t restic.FileType, list []restic.FileInfo) error {
+	buf, err := json.Marshal(list)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	filename := b.listFilename(t)
+	err = ioutil.WriteFile(filename+".tmp", buf, backend.Modes.File)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(fs.Rename(filename+".tmp", filename))
+}
+
+// loadList returns the files of type t recorded by saveList.
+func (b *Backend) loadList(t restic.FileType) (list []restic.FileInfo, err error) {
+	buf, err := ioutil.ReadFile(b.listFilename(t))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = json.Unmarshal(buf, &list)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid list of %v files", t)
+	}
+	return list, nil
+}
+
+// flushOrder is the order in which spooled files are uploaded, so that files
+// are only referenced by already uploaded files.
+var flushOrder = []restic.FileType{
+	restic.ConfigFile,
+	restic.KeyFile,
+	restic.PackFile,
+	restic.IndexFile,
+	restic.SnapshotFile,
+}
+
+// pendingHandles returns the handles of all files of type t in the spool
+// directory.
+func (b *Backend) pendingHandles(ctx context.Context, t restic.FileType) ([]restic.Handle, error) {
+	if t == restic.ConfigFile {
+		h := restic.Handle{Type: restic.ConfigFile}
+		if b.isPending(ctx, h) {
+			return []restic.Handle{h}, nil
+		}
+		return nil, nil
+	}
+
+	var handles []restic.Handle
+	err := b.pending.List(ctx, t, func(fi restic.FileInfo) error {
+		handles = append(handles, restic.Handle{Type: t, Name: fi.Name})
+		return nil
+	})
+	return handles, err
+}
+
+// Pending returns the number of files which have not been uploaded yet. Lock
+// files are not counted.
+func (b *Backend) Pending(ctx context.Context) (int, error) {
+	n := 0
+	for _, t := range flushOrder {
+		handles, err := b.pendingHandles(ctx, t)
+		if err != nil {
+			return 0, err
+		}
+		n += len(handles)
+	}
+	return n, nil
+}
+
+// Flush uploads all spooled files to the backend and removes them from the
+// spool directory, report is called for each uploaded file. Spooled lock
+// files belong to earlier runs and are removed without uploading them.
+//
+// The spooled index and snapshot files may reference data which was removed
+// from the repository since they were written. Before each of them is
+// uploaded, verify is called and must return an error if data referenced by
+// the file is missing, at this point all spooled pack files have already been
+// uploaded. The caller must hold an exclusive lock on the repository.
+func (b *Backend) Flush(ctx context.Context, verify func(restic.Handle) error, report func(restic.Handle)) (uploaded int, err error) {
+	if b.Offline() {
+		return 0, ErrOffline
+	}
+
+	var buf []byte
+	for _, t := range flushOrder {
+		handles, err := b.pendingHandles(ctx, t)
+		if err != nil {
+			return uploaded, err
+		}
+
+		for _, h := range handles {
+			if t == restic.IndexFile || t == restic.SnapshotFile {
+				err = verify(h)
+				if err != nil {
+					return uploaded, errors.Wrapf(err, "verify %v", h)
+				}
+			}
+
+			buf, err = backend.LoadAll(ctx, buf, b.pending, h)
+			if err != nil {
+				return uploaded, err
+			}
+
+			err = b.upload(ctx, h, buf)
+			if err != nil {
+				return uploaded, errors.Wrapf(err, "upload %v", h)
+			}
+
+			b.saved(ctx, h, restic.NewByteReader(buf, nil))
+
+			err = b.pending.Remove(ctx, h)
+			if err != nil {
+				return uploaded, err
+			}
+
+			uploaded++
+			report(h)
+		}
+	}
+
+	locks, err := b.pendingHandles(ctx, restic.LockFile)
+	if err != nil {
+		return uploaded, err
+	}
+	for _, h := range locks {
+		debug.Log("remove spooled lock %v", h)
+		err = b.pending.Remove(ctx, h)
+		if err != nil && !b.pending.IsNotExist(err) {
+			return uploaded, err
+		}
+	}
+
+	return uploaded, nil
+}
+
+// upload saves buf in the backend, unless the file was already uploaded by
+// an earlier, interrupted Flush.
+func (b *Backend) upload(ctx context.Context, h restic.Handle, buf []byte) error {
+	found, err := b.Backend.Test(ctx, h)
+	if err != nil {
+		return err
+	}
+	if found {
+		debug.Log("%v was already uploaded", h)
+		return nil
+	}
+
+	debug.Log("upload %v", h)
+	return b.Backend.Save(ctx, h, restic.NewByteReader(buf, b.Backend.Hasher()))
+}
+
+// Close closes the backend.
+func (b *Backend) Close() error {
+	err := b.Backend.Close()
+	if cerr := b.pending.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if cerr := b.known.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// offlineBackend is used by OpenOffline in place of the unreachable backend.
+type offlineBackend struct{}
+
+func (offlineBackend) Location() string      { return "offline" }
+func (offlineBackend) Hasher() hash.Hash     { return nil }
+func (offlineBackend) IsNotExist(error) bool { return false }
+func (offlineBackend) Close() error          { return nil }
+
+func (offlineBackend) Test(context.Context, restic.Handle) (bool, error) {
+	return false, ErrOffline
+}
+
+func (offlineBackend) Remove(context.Context, restic.Handle) error {
+	return ErrOffline
+}
+
+func (offlineBackend) Save(context.Context, restic.Handle, restic.RewindReader) error {
+	return ErrOffline
+}
+
+func (offlineBackend) Load(context.Context, restic.Handle, int, int64, func(io.Reader) error) error {
+	return ErrOffline
+}
+
+func (offlineBackend) Stat(context.Context, restic.Handle) (restic.FileInfo, error) {
+	return restic.FileInfo{}, ErrOffline
+}
+
+func (offlineBackend) List(context.Context, restic.FileType, func(restic.FileInfo) error) error {
+	return ErrOffline
+}
+
+func (offlineBackend) Delete(context.Context) error {
+	return ErrOffline
+}
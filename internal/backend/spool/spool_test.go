@@ -0,0 +1,236 @@
+package spool_test
+
+import (
+	"context"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/spool"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// unreachableBackend fails all operations while unreachable is set.
+type unreachableBackend struct {
+	restic.Backend
+	unreachable bool
+}
+
+var errUnreachable = errors.New("network is unreachable")
+
+func (be *unreachableBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if be.unreachable {
+		return errUnreachable
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func (be *unreachableBackend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	if be.unreachable {
+		return errUnreachable
+	}
+	return be.Backend.List(ctx, t, fn)
+}
+
+func save(t testing.TB, be restic.Backend, tpe restic.FileType, data string) restic.Handle {
+	h := restic.Handle{Type: tpe, Name: restic.Hash([]byte(data)).String()}
+	if tpe == restic.ConfigFile {
+		h.Name = ""
+	}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader([]byte(data), be.Hasher())))
+	return h
+}
+
+func load(t testing.TB, be restic.Backend, h restic.Handle) string {
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	return string(buf)
+}
+
+func list(t testing.TB, be restic.Backend, tpe restic.FileType) []string {
+	var names []string
+	rtest.OK(t, be.List(context.TODO(), tpe, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	}))
+	sort.Strings(names)
+	return names
+}
+
+func names(handles ...restic.Handle) []string {
+	var names []string
+	for _, h := range handles {
+		names = append(names, h.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSpool(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	repo := &unreachableBackend{Backend: mem.New()}
+	config := save(t, repo, restic.ConfigFile, "config")
+	oldSnapshot := save(t, repo, restic.SnapshotFile, "old snapshot")
+
+	be, err := spool.New(context.TODO(), repo, dir)
+	rtest.OK(t, err)
+
+	var reported error
+	be.Report = func(err error) {
+		reported = err
+	}
+
+	rtest.Equals(t, "config", load(t, be, config))
+	oldPack := save(t, be, restic.PackFile, "old pack")
+	onlineSnapshot := save(t, be, restic.SnapshotFile, "online snapshot")
+	rtest.Assert(t, !be.Offline(), "backend is offline")
+
+	repo.unreachable = true
+	lock := save(t, be, restic.LockFile, "lock")
+	rtest.Assert(t, be.Offline(), "backend is not offline")
+	rtest.Assert(t, errors.Is(reported, errUnreachable), "wrong error reported: %v", reported)
+
+	pack := save(t, be, restic.PackFile, "pack")
+	index := save(t, be, restic.IndexFile, "index")
+	snapshot := save(t, be, restic.SnapshotFile, "snapshot")
+
+	// spooled files are served by the backend
+	rtest.Equals(t, "pack", load(t, be, pack))
+	fi, err := be.Stat(context.TODO(), index)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len("index")), fi.Size)
+	rtest.Equals(t, names(onlineSnapshot, snapshot), list(t, be, restic.SnapshotFile))
+	rtest.Equals(t, names(lock), list(t, be, restic.LockFile))
+
+	// files in the repository which are not spooled cannot be loaded
+	err = be.Load(context.TODO(), oldPack, 0, 0, func(rd io.Reader) error { return nil })
+	rtest.Assert(t, errors.Is(err, spool.ErrOffline), "wrong error: %v", err)
+
+	found, err := repo.Test(context.TODO(), pack)
+	rtest.OK(t, err)
+	rtest.Assert(t, !found, "pack was saved in the repository")
+
+	n, err := be.Pending(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, n)
+
+	_, err = be.Flush(context.TODO(), func(restic.Handle) error { return nil }, func(restic.Handle) {})
+	rtest.Assert(t, errors.Is(err, spool.ErrOffline), "wrong error: %v", err)
+	rtest.OK(t, be.Close())
+
+	// the next run uploads the spooled files
+	repo.unreachable = false
+	be, err = spool.New(context.TODO(), repo, dir)
+	rtest.OK(t, err)
+
+	// files are not uploaded if the verification fails
+	var verified, uploaded []restic.Handle
+	errMissing := errors.New("missing pack")
+	n, err = be.Flush(context.TODO(), func(h restic.Handle) error {
+		verified = append(verified, h)
+		if h == snapshot {
+			return errMissing
+		}
+		return nil
+	}, func(h restic.Handle) {
+		uploaded = append(uploaded, h)
+	})
+	rtest.Assert(t, errors.Is(err, errMissing), "wrong error: %v", err)
+	rtest.Equals(t, 2, n)
+	rtest.Equals(t, []restic.Handle{index, snapshot}, verified)
+	rtest.Equals(t, []restic.Handle{pack, index}, uploaded)
+
+	found, err = repo.Test(context.TODO(), snapshot)
+	rtest.OK(t, err)
+	rtest.Assert(t, !found, "snapshot was uploaded")
+
+	n, err = be.Flush(context.TODO(), func(h restic.Handle) error { return nil }, func(h restic.Handle) {
+		uploaded = append(uploaded, h)
+	})
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, n)
+	rtest.Equals(t, []restic.Handle{pack, index, snapshot}, uploaded)
+
+	rtest.Equals(t, "snapshot", load(t, repo, snapshot))
+	rtest.Equals(t, names(oldSnapshot, onlineSnapshot, snapshot), list(t, be, restic.SnapshotFile))
+
+	// spooled locks are not uploaded
+	rtest.Equals(t, []string(nil), list(t, be, restic.LockFile))
+
+	n, err = be.Pending(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, n)
+	rtest.OK(t, be.Close())
+}
+
+func TestSpoolOpenOffline(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	_, err := spool.OpenOffline(context.TODO(), dir)
+	rtest.Assert(t, err != nil, "opened spool directory without a copy of the config")
+
+	repo := mem.New()
+	config := save(t, repo, restic.ConfigFile, "config")
+	key := save(t, repo, restic.KeyFile, "key")
+	oldSnapshot := save(t, repo, restic.SnapshotFile, "old snapshot")
+
+	be, err := spool.New(context.TODO(), repo, dir)
+	rtest.OK(t, err)
+
+	// loading the config and keys and listing the snapshots stores copies
+	rtest.Equals(t, "config", load(t, be, config))
+	rtest.Equals(t, "key", load(t, be, key))
+	rtest.Equals(t, names(oldSnapshot), list(t, be, restic.SnapshotFile))
+	rtest.OK(t, be.Close())
+
+	be, err = spool.OpenOffline(context.TODO(), dir)
+	rtest.OK(t, err)
+	rtest.Assert(t, be.Offline(), "backend is not offline")
+
+	rtest.Equals(t, "config", load(t, be, config))
+	rtest.Equals(t, "key", load(t, be, key))
+	rtest.Equals(t, names(key), list(t, be, restic.KeyFile))
+
+	snapshot := save(t, be, restic.SnapshotFile, "snapshot")
+	rtest.Equals(t, names(oldSnapshot, snapshot), list(t, be, restic.SnapshotFile))
+
+	fi, err := be.Stat(context.TODO(), oldSnapshot)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(len("old snapshot")), fi.Size)
+
+	_, err = be.Stat(context.TODO(), restic.Handle{Type: restic.SnapshotFile, Name: restic.NewRandomID().String()})
+	rtest.Assert(t, be.IsNotExist(err), "wrong error: %v", err)
+
+	err = be.List(context.TODO(), restic.PackFile, func(restic.FileInfo) error { return nil })
+	rtest.Assert(t, errors.Is(err, spool.ErrOffline), "wrong error: %v", err)
+	rtest.OK(t, be.Close())
+}
+
+func TestSpoolDifferentRepository(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	repo := mem.New()
+	config := save(t, repo, restic.ConfigFile, "config")
+
+	be, err := spool.New(context.TODO(), repo, dir)
+	rtest.OK(t, err)
+	rtest.Equals(t, "config", load(t, be, config))
+	rtest.OK(t, be.Close())
+
+	other := mem.New()
+	save(t, other, restic.ConfigFile, "other config")
+
+	be, err = spool.New(context.TODO(), other, dir)
+	rtest.OK(t, err)
+	_, err = backend.LoadAll(context.TODO(), nil, be, config)
+	rtest.Assert(t, err != nil, "config of a different repository was accepted")
+	rtest.OK(t, be.Close())
+}
@@ -0,0 +1,76 @@
+package webdav
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/options"
+)
+
+// Config contains all configuration necessary to connect to a WebDAV server.
+type Config struct {
+	URL         *url.URL
+	Layout      string `option:"layout" help:"use this backend layout (default: auto-detect)"`
+	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+}
+
+func init() {
+	options.Register("webdav", Config{})
+}
+
+// NewConfig returns a new Config with the default values filled in.
+func NewConfig() Config {
+	return Config{
+		Connections: 5,
+	}
+}
+
+const scheme = "webdav:"
+
+// ParseConfig parses the string s and extracts the WebDAV server URL.
+func ParseConfig(s string) (interface{}, error) {
+	if !strings.HasPrefix(s, scheme) {
+		return nil, errors.New("invalid WebDAV backend specification")
+	}
+
+	u, err := url.Parse(prepareURL(s))
+	if err != nil {
+		return nil, errors.Wrap(err, "url.Parse")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("invalid WebDAV URL scheme %q, must be http or https", u.Scheme)
+	}
+
+	cfg := NewConfig()
+	cfg.URL = u
+	return cfg, nil
+}
+
+// StripPassword removes the password from the URL
+// If the repository location cannot be parsed as a valid URL, it will be returned as is
+// (it's because this function is used for logging errors)
+func StripPassword(s string) string {
+	s = prepareURL(s)
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return scheme + s
+	}
+
+	if _, set := u.User.Password(); !set {
+		return scheme + s
+	}
+
+	// a password was set: we replace it with ***
+	return scheme + strings.Replace(u.String(), u.User.String()+"@", u.User.Username()+":***@", 1)
+}
+
+func prepareURL(s string) string {
+	s = strings.TrimPrefix(s, scheme)
+	if !strings.HasSuffix(s, "/") {
+		s += "/"
+	}
+	return s
+}
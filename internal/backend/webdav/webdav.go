@@ -0,0 +1,601 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context/ctxhttp"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// make sure the WebDAV backend implements restic.Backend
+var _ restic.Backend = &Backend{}
+
+// Backend stores data on a WebDAV server.
+type Backend struct {
+	url    *url.URL
+	sem    *backend.Semaphore
+	client *http.Client
+	backend.Layout
+}
+
+// ensure statically that *Backend implements backend.Filesystem.
+var _ backend.Filesystem = &Backend{}
+
+const defaultLayout = "default"
+
+func open(ctx context.Context, cfg Config, rt http.RoundTripper) (*Backend, error) {
+	debug.Log("open, config %#v", cfg)
+
+	sem, err := backend.NewSemaphore(cfg.Connections)
+	if err != nil {
+		return nil, err
+	}
+
+	be := &Backend{
+		url:    cfg.URL,
+		client: &http.Client{Transport: rt},
+		sem:    sem,
+	}
+
+	// the layout works on the path of the URL, without a trailing slash
+	dir := path.Clean("/" + cfg.URL.Path)
+	l, err := backend.ParseLayout(ctx, be, cfg.Layout, defaultLayout, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	be.Layout = l
+
+	return be, nil
+}
+
+// Open opens the WebDAV backend with the given config.
+func Open(ctx context.Context, cfg Config, rt http.RoundTripper) (*Backend, error) {
+	return open(ctx, cfg, rt)
+}
+
+// Create creates the directories for a new repository on the WebDAV server.
+func Create(ctx context.Context, cfg Config, rt http.RoundTripper) (*Backend, error) {
+	be, err := open(ctx, cfg, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = be.Stat(ctx, restic.Handle{Type: restic.ConfigFile})
+	if err == nil {
+		return nil, errors.Fatal("config file already exists")
+	}
+
+	for _, d := range be.Paths() {
+		err = be.mkdirAll(ctx, d)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return be, nil
+}
+
+// Location returns this backend's location (the server's URL).
+func (b *Backend) Location() string {
+	return b.url.String()
+}
+
+// Hasher may return a hash function for calculating a content hash for the backend
+func (b *Backend) Hasher() hash.Hash {
+	return nil
+}
+
+// Join combines path components with slashes.
+func (b *Backend) Join(p ...string) string {
+	return path.Join(p...)
+}
+
+// notExistError is returned whenever the requested file or directory does
+// not exist on the server.
+type notExistError struct {
+	path string
+}
+
+func (e notExistError) Error() string {
+	return fmt.Sprintf("%v does not exist", e.path)
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file.
+func (b *Backend) IsNotExist(err error) bool {
+	_, ok := errors.Cause(err).(notExistError)
+	return ok
+}
+
+// fileURL returns the URL of the file or directory at p on the server.
+func (b *Backend) fileURL(p string) string {
+	u := *b.url
+	u.Path = p
+	u.RawPath = ""
+	return u.String()
+}
+
+// do sends a request for the file or directory at p on the server. The
+// caller must close the body of the response.
+func (b *Backend) do(ctx context.Context, method, p string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, b.fileURL(p), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewRequest")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	b.sem.GetToken()
+	resp, err := ctxhttp.Do(ctx, b.client, req)
+	b.sem.ReleaseToken()
+
+	if err != nil {
+		return nil, errors.Wrap(err, method)
+	}
+
+	return resp, nil
+}
+
+// discard reads the remaining body of resp and closes it.
+func discard(resp *http.Response) error {
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// collection returns the path of the directory dir with a trailing slash, as
+// expected by most servers for requests to collections.
+func collection(dir string) string {
+	if strings.HasSuffix(dir, "/") {
+		return dir
+	}
+	return dir + "/"
+}
+
+// mkdirAll creates the directory dir and all its parents.
+func (b *Backend) mkdirAll(ctx context.Context, dir string) error {
+	resp, err := b.do(ctx, "MKCOL", collection(dir), nil, nil)
+	if err != nil {
+		return err
+	}
+	_ = discard(resp)
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return nil
+	case http.StatusMethodNotAllowed:
+		// the collection exists already
+		return nil
+	case http.StatusConflict, http.StatusNotFound:
+		// the parent directory is missing
+		parent := path.Dir(dir)
+		if parent == dir {
+			break
+		}
+		if err := b.mkdirAll(ctx, parent); err != nil {
+			return err
+		}
+		return b.mkdirAll(ctx, dir)
+	}
+
+	return backend.WithRetryAfter(resp, errors.Errorf("creating directory %v failed, server response: %v (%v)", dir, resp.Status, resp.StatusCode))
+}
+
+// Save stores data in the backend at the handle.
+func (b *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if err := h.Valid(); err != nil {
+		return backoff.Permanent(err)
+	}
+
+	filename := b.Filename(h)
+
+	resp, err := b.put(ctx, filename, rd)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound {
+		// the directory (e.g. a subdir for data) is missing, create it and try again
+		if err := b.mkdirAll(ctx, path.Dir(filename)); err != nil {
+			return err
+		}
+
+		if err := rd.Rewind(); err != nil {
+			return err
+		}
+
+		resp, err = b.put(ctx, filename, rd)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	}
+
+	return backend.WithRetryAfter(resp, errors.Errorf("server response unexpected: %v (%v)", resp.Status, resp.StatusCode))
+}
+
+// put uploads rd to the file at p and returns the response, its body has
+// already been read and closed.
+func (b *Backend) put(ctx context.Context, p string, rd restic.RewindReader) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// make sure that the client cannot close the reader by wrapping it
+	req, err := http.NewRequest(http.MethodPut, b.fileURL(p), ioutil.NopCloser(rd))
+	if err != nil {
+		return nil, errors.Wrap(err, "NewRequest")
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	// explicitly set the content length, this prevents chunked encoding and
+	// let's the server know what's coming.
+	req.ContentLength = rd.Length()
+
+	b.sem.GetToken()
+	resp, err := ctxhttp.Do(ctx, b.client, req)
+	b.sem.ReleaseToken()
+
+	if err != nil {
+		return nil, errors.Wrap(err, "client.Put")
+	}
+
+	if err := discard(resp); err != nil {
+		return nil, errors.Wrap(err, "Close")
+	}
+
+	return resp, nil
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (b *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return backend.DefaultLoad(ctx, h, length, offset, b.openReader, fn)
+}
+
+func (b *Backend) openReader(ctx context.Context, h restic.Handle, length int, offset int64) (io.ReadCloser, error) {
+	debug.Log("Load %v, length %v, offset %v", h, length, offset)
+	if err := h.Valid(); err != nil {
+		return nil, backoff.Permanent(err)
+	}
+
+	if offset < 0 {
+		return nil, errors.New("offset is negative")
+	}
+
+	if length < 0 {
+		return nil, errors.Errorf("invalid length %d", length)
+	}
+
+	header := http.Header{}
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+int64(length)-1)
+	}
+	header.Set("Range", byteRange)
+	debug.Log("Load(%v) send range %v", h, byteRange)
+
+	resp, err := b.do(ctx, http.MethodGet, b.Filename(h), nil, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		_ = discard(resp)
+		return nil, notExistError{h.String()}
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		_ = discard(resp)
+		return nil, backend.WithRetryAfter(resp, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status))
+	}
+
+	if resp.StatusCode == http.StatusOK && (offset > 0 || length > 0) {
+		// the server ignored the range and returned the whole file
+		debug.Log("Load(%v) server ignored range %v", h, byteRange)
+		if offset > 0 {
+			_, err = io.CopyN(ioutil.Discard, resp.Body, offset)
+			if err != nil {
+				_ = resp.Body.Close()
+				return nil, errors.Wrap(err, "Discard")
+			}
+		}
+
+		if length > 0 {
+			return backend.LimitReadCloser(resp.Body, int64(length)), nil
+		}
+	}
+
+	return resp.Body, nil
+}
+
+// Stat returns information about a blob.
+func (b *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	if err := h.Valid(); err != nil {
+		return restic.FileInfo{}, backoff.Permanent(err)
+	}
+
+	resp, err := b.do(ctx, http.MethodHead, b.Filename(h), nil, nil)
+	if err != nil {
+		return restic.FileInfo{}, err
+	}
+
+	if err = discard(resp); err != nil {
+		return restic.FileInfo{}, errors.Wrap(err, "Close")
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return restic.FileInfo{}, notExistError{h.String()}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return restic.FileInfo{}, backend.WithRetryAfter(resp, errors.Errorf("unexpected HTTP response (%v): %v", resp.StatusCode, resp.Status))
+	}
+
+	if resp.ContentLength < 0 {
+		return restic.FileInfo{}, errors.New("negative content length")
+	}
+
+	return restic.FileInfo{Size: resp.ContentLength, Name: h.Name}, nil
+}
+
+// Test returns true if a blob of the given type and name exists in the backend.
+func (b *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	_, err := b.Stat(ctx, h)
+	if b.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Remove removes the blob with the given name and type.
+func (b *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	if err := h.Valid(); err != nil {
+		return backoff.Permanent(err)
+	}
+
+	resp, err := b.do(ctx, http.MethodDelete, b.Filename(h), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = discard(resp); err != nil {
+		return errors.Wrap(err, "Close")
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return notExistError{h.String()}
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return backend.WithRetryAfter(resp, errors.Errorf("blob not removed, server response: %v (%v)", resp.Status, resp.StatusCode))
+	}
+
+	return nil
+}
+
+// propfindBody requests only the properties needed to list a directory.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getlastmodified/></D:prop></D:propfind>`
+
+// multistatus is the response to a PROPFIND request, see RFC 4918.
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength string `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }    // base name of the file
+func (fi *fileInfo) Size() int64        { return fi.size }    // length in bytes for regular files; system-dependent for others
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime } // modification time
+func (fi *fileInfo) IsDir() bool        { return fi.isDir }   // abbreviation for Mode().IsDir()
+func (fi *fileInfo) Sys() interface{}   { return nil }        // underlying data source (can return nil)
+
+// Mode returns the file mode bits.
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ReadDir returns the entries for a directory.
+func (b *Backend) ReadDir(ctx context.Context, dir string) ([]os.FileInfo, error) {
+	debug.Log("ReadDir(%v)", dir)
+	dir = path.Clean(dir)
+
+	header := http.Header{}
+	header.Set("Depth", "1")
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := b.do(ctx, "PROPFIND", collection(dir), strings.NewReader(propfindBody), header)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = discard(resp)
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, notExistError{dir}
+	}
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, backend.WithRetryAfter(resp, errors.Errorf("listing %v failed, server response: %v (%v)", dir, resp.Status, resp.StatusCode))
+	}
+
+	var ms multistatus
+	err = xml.NewDecoder(resp.Body).Decode(&ms)
+	if err != nil {
+		return nil, errors.Wrap(err, "Decode")
+	}
+
+	list := make([]os.FileInfo, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, errors.Wrap(err, "url.Parse")
+		}
+
+		// the response also contains the directory itself
+		p := path.Clean(href.Path)
+		if p == dir {
+			continue
+		}
+
+		for _, ps := range r.Propstat {
+			fields := strings.Fields(ps.Status)
+			if len(fields) < 2 || fields[1] != "200" {
+				continue
+			}
+
+			fi := &fileInfo{
+				name:  path.Base(p),
+				isDir: ps.Prop.ResourceType.Collection != nil,
+			}
+			if ps.Prop.ContentLength != "" {
+				fi.size, err = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+				if err != nil {
+					return nil, errors.Wrap(err, "ParseInt")
+				}
+			}
+			if ps.Prop.LastModified != "" {
+				fi.modTime, _ = http.ParseTime(ps.Prop.LastModified)
+			}
+
+			list = append(list, fi)
+			break
+		}
+	}
+
+	return list, nil
+}
+
+// List runs fn for each file in the backend which has the type t. When an
+// error occurs (or fn returns an error), List stops and returns it.
+func (b *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	basedir, subdirs := b.Basedir(t)
+
+	entries, err := b.ReadDir(ctx, basedir)
+	if b.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if subdirs {
+		var files []os.FileInfo
+		for _, dir := range entries {
+			if !dir.IsDir() {
+				continue
+			}
+
+			subentries, err := b.ReadDir(ctx, path.Join(basedir, dir.Name()))
+			if err != nil {
+				return err
+			}
+			files = append(files, subentries...)
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+		entries = files
+	}
+
+	for _, fi := range entries {
+		if fi.IsDir() {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := fn(restic.FileInfo{Name: fi.Name(), Size: fi.Size()})
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	return ctx.Err()
+}
+
+// Close closes all open files.
+func (b *Backend) Close() error {
+	// this does not need to do anything, all open files are closed within the
+	// same function.
+	return nil
+}
+
+// Delete removes all data in the backend.
+func (b *Backend) Delete(ctx context.Context) error {
+	alltypes := []restic.FileType{
+		restic.PackFile,
+		restic.KeyFile,
+		restic.LockFile,
+		restic.SnapshotFile,
+		restic.IndexFile}
+
+	for _, t := range alltypes {
+		err := b.List(ctx, t, func(fi restic.FileInfo) error {
+			return b.Remove(ctx, restic.Handle{Type: t, Name: fi.Name})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := b.Remove(ctx, restic.Handle{Type: restic.ConfigFile})
+	if err != nil && b.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
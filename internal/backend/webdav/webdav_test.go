@@ -0,0 +1,178 @@
+package webdav_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/test"
+	webdavbackend "github.com/restic/restic/internal/backend/webdav"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func runWebDAVServer(t testing.TB, dir string) (*url.URL, func()) {
+	srv := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.Dir(dir),
+		LockSystem: webdav.NewMemLS(),
+	})
+
+	url, err := url.Parse(srv.URL + "/restic-test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return url, srv.Close
+}
+
+func newTestSuite(ctx context.Context, t testing.TB, url *url.URL, minimalData bool) *test.Suite {
+	tr, err := backend.Transport(backend.TransportOptions{})
+	if err != nil {
+		t.Fatalf("cannot create transport for tests: %v", err)
+	}
+
+	return &test.Suite{
+		MinimalData: minimalData,
+
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			cfg := webdavbackend.NewConfig()
+			cfg.URL = url
+			return cfg, nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(webdavbackend.Config)
+			return webdavbackend.Create(ctx, cfg, tr)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			cfg := config.(webdavbackend.Config)
+			return webdavbackend.Open(ctx, cfg, tr)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			return nil
+		},
+	}
+}
+
+func TestBackendWebDAV(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	serverURL, cleanup := runWebDAVServer(t, dir)
+	defer cleanup()
+
+	newTestSuite(ctx, t, serverURL, false).RunTests(t)
+}
+
+func TestBackendWebDAVRetryAfter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	url, err := url.Parse(srv.URL + "/restic-test/")
+	rtest.OK(t, err)
+
+	cfg := webdavbackend.NewConfig()
+	cfg.URL = url
+	cfg.Layout = "default"
+	be, err := webdavbackend.Open(context.TODO(), cfg, http.DefaultTransport)
+	rtest.OK(t, err)
+
+	_, err = be.Stat(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+	var rerr *backend.RetryAfterError
+	rtest.Assert(t, errors.As(err, &rerr), "unexpected error %v", err)
+	rtest.Equals(t, 7*time.Second, rerr.After)
+}
+
+func TestBackendWebDAVIgnoredRange(t *testing.T) {
+	data := []byte("0123456789")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// ignore the Range header and always return the whole file
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	url, err := url.Parse(srv.URL + "/restic-test/")
+	rtest.OK(t, err)
+
+	cfg := webdavbackend.NewConfig()
+	cfg.URL = url
+	cfg.Layout = "default"
+	be, err := webdavbackend.Open(context.TODO(), cfg, http.DefaultTransport)
+	rtest.OK(t, err)
+
+	h := restic.Handle{Type: restic.PackFile, Name: restic.NewRandomID().String()}
+	var tests = []struct {
+		length int
+		offset int64
+		want   string
+	}{
+		{0, 0, "0123456789"},
+		{4, 0, "0123"},
+		{4, 3, "3456"},
+		{0, 8, "89"},
+	}
+
+	for _, tt := range tests {
+		var buf []byte
+		err := be.Load(context.TODO(), h, tt.length, tt.offset, func(rd io.Reader) (err error) {
+			buf, err = ioutil.ReadAll(rd)
+			return err
+		})
+		rtest.OK(t, err)
+		rtest.Equals(t, tt.want, string(buf))
+	}
+}
+
+func TestBackendWebDAVExternalServer(t *testing.T) {
+	repostr := os.Getenv("RESTIC_TEST_WEBDAV_REPOSITORY")
+	if repostr == "" {
+		t.Skipf("environment variable %v not set", "RESTIC_TEST_WEBDAV_REPOSITORY")
+	}
+
+	cfg, err := webdavbackend.ParseConfig(repostr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cfg.(webdavbackend.Config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newTestSuite(ctx, t, c.URL, true).RunTests(t)
+}
+
+func BenchmarkBackendWebDAV(t *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	serverURL, cleanup := runWebDAVServer(t, dir)
+	defer cleanup()
+
+	newTestSuite(ctx, t, serverURL, false).RunBenchmarks(t)
+}
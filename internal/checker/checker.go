@@ -493,8 +493,7 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID, size int6
 			continue
 		}
 
-		nonce, ciphertext := buf[:r.Key().NonceSize()], buf[r.Key().NonceSize():]
-		plaintext, err := r.Key().Open(ciphertext[:0], nonce, ciphertext, nil)
+		plaintext, err := repository.DecryptBlob(r.Key(), blob, buf)
 		if err != nil {
 			debug.Log("  error decrypting blob %v: %v", blob.ID, err)
 			errs = append(errs, errors.Errorf("blob %v: %v", i, err))
@@ -511,7 +510,8 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID, size int6
 		// Check if blob is contained in index and position is correct
 		idxHas := false
 		for _, pb := range idx.Lookup(blob.BlobHandle) {
-			if pb.PackID == id && pb.Offset == blob.Offset && pb.Length == blob.Length {
+			if pb.PackID == id && pb.Offset == blob.Offset && pb.Length == blob.Length &&
+				pb.UncompressedLength == blob.UncompressedLength {
 				idxHas = true
 				break
 			}
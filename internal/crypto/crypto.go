@@ -36,6 +36,9 @@ var (
 type Key struct {
 	MACKey        `json:"mac"`
 	EncryptionKey `json:"encrypt"`
+
+	// sealed is used to open keys sealed for a public key, see SetPrivateKey.
+	sealed *sealedKeys
 }
 
 // EncryptionKey is key used for encryption
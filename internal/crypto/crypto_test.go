@@ -300,3 +300,39 @@ func BenchmarkDecrypt(b *testing.B) {
 		rtest.OK(b, err)
 	}
 }
+
+func TestSealedKey(t *testing.T) {
+	pub, priv := crypto.NewKeyPair()
+	rtest.Equals(t, pub, priv.PublicKey())
+
+	k := crypto.NewRandomKey()
+	sealed := k.SealFor(pub)
+	rtest.Equals(t, crypto.SealedKeySize, len(sealed))
+
+	data := rtest.Random(23, 1<<16)
+	nonce := crypto.NewRandomNonce()
+	buf := append(append(append([]byte{}, sealed...), nonce...), k.Seal(nil, nonce, data, nil)...)
+
+	master := crypto.NewRandomKey()
+	_, err := master.OpenSealed(append([]byte{}, buf...))
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "opened sealed data without private key: %v", err)
+
+	_, otherPriv := crypto.NewKeyPair()
+	master.SetPrivateKey(otherPriv)
+	_, err = master.OpenSealed(append([]byte{}, buf...))
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "opened sealed data with the wrong private key: %v", err)
+
+	master.SetPrivateKey(priv)
+	opened, err := master.OpenSealedKey(sealed)
+	rtest.OK(t, err)
+	rtest.Equals(t, k.EncryptionKey, opened.EncryptionKey)
+	rtest.Equals(t, k.MACKey.K, opened.MACKey.K)
+
+	plaintext, err := master.OpenSealed(append([]byte{}, buf...))
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(data, plaintext), "wrong plaintext returned")
+
+	buf[len(buf)-1] ^= 1
+	_, err = master.OpenSealed(buf)
+	rtest.Assert(t, err == crypto.ErrUnauthenticated, "modified ciphertext was accepted: %v", err)
+}
@@ -0,0 +1,155 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// SealedKeySize is the number of bytes of a key sealed with SealFor.
+const SealedKeySize = aesKeySize + macKeySize + box.AnonymousOverhead
+
+// PublicKey is used to seal keys for the owner of the matching PrivateKey.
+type PublicKey [32]byte
+
+// PrivateKey is used to open keys sealed for the matching PublicKey.
+type PrivateKey [32]byte
+
+// NewKeyPair returns a new random Curve25519 key pair. It panics on error so
+// that the program is safely terminated.
+func NewKeyPair() (*PublicKey, *PrivateKey) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		panic("unable to read enough random bytes for key pair")
+	}
+
+	return (*PublicKey)(pub), (*PrivateKey)(priv)
+}
+
+// PublicKey returns the public key belonging to k.
+func (k *PrivateKey) PublicKey() *PublicKey {
+	pub := &PublicKey{}
+	curve25519.ScalarBaseMult((*[32]byte)(pub), (*[32]byte)(k))
+	return pub
+}
+
+// MarshalJSON converts the PublicKey to JSON.
+func (k *PublicKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k[:])
+}
+
+// UnmarshalJSON fills the key k with data from the JSON representation.
+func (k *PublicKey) UnmarshalJSON(data []byte) error {
+	return unmarshalKey(k[:], data)
+}
+
+// MarshalJSON converts the PrivateKey to JSON.
+func (k *PrivateKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k[:])
+}
+
+// UnmarshalJSON fills the key k with data from the JSON representation.
+func (k *PrivateKey) UnmarshalJSON(data []byte) error {
+	return unmarshalKey(k[:], data)
+}
+
+func unmarshalKey(k []byte, data []byte) error {
+	d := make([]byte, len(k))
+	err := json.Unmarshal(data, &d)
+	if err != nil {
+		return errors.Wrap(err, "Unmarshal")
+	}
+	if len(d) != len(k) {
+		return errors.Errorf("invalid key length %d", len(d))
+	}
+	copy(k, d)
+
+	return nil
+}
+
+// sealedKeys holds a private key and caches the keys opened with it.
+type sealedKeys struct {
+	public  PublicKey
+	private PrivateKey
+
+	m    sync.Mutex
+	keys map[string]*Key
+}
+
+// SealFor encrypts k so that only the owner of the private key belonging to
+// pub can open it. The result is SealedKeySize bytes long.
+func (k *Key) SealFor(pub *PublicKey) []byte {
+	raw := make([]byte, 0, aesKeySize+macKeySize)
+	raw = append(raw, k.EncryptionKey[:]...)
+	raw = append(raw, k.MACKey.K[:]...)
+	raw = append(raw, k.MACKey.R[:]...)
+
+	sealed, err := box.SealAnonymous(nil, raw, (*[32]byte)(pub), rand.Reader)
+	if err != nil {
+		panic("unable to read enough random bytes for sealing key")
+	}
+
+	return sealed
+}
+
+// SetPrivateKey allows k to open data which was encrypted with a key sealed
+// for the public key belonging to priv, see OpenSealed.
+func (k *Key) SetPrivateKey(priv *PrivateKey) {
+	k.sealed = &sealedKeys{
+		public:  *priv.PublicKey(),
+		private: *priv,
+		keys:    make(map[string]*Key),
+	}
+}
+
+// OpenSealedKey returns the key sealed with SealFor. If k has no private key
+// or the key cannot be opened, ErrUnauthenticated is returned.
+func (k *Key) OpenSealedKey(sealed []byte) (*Key, error) {
+	if k.sealed == nil || len(sealed) != SealedKeySize {
+		return nil, ErrUnauthenticated
+	}
+
+	k.sealed.m.Lock()
+	defer k.sealed.m.Unlock()
+
+	if key, ok := k.sealed.keys[string(sealed)]; ok {
+		return key, nil
+	}
+
+	raw, ok := box.OpenAnonymous(nil, sealed, (*[32]byte)(&k.sealed.public), (*[32]byte)(&k.sealed.private))
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	key := &Key{}
+	copy(key.EncryptionKey[:], raw[:aesKeySize])
+	macKeyFromSlice(&key.MACKey, raw[aesKeySize:])
+
+	if !key.Valid() {
+		return nil, errors.New("invalid sealed key")
+	}
+
+	k.sealed.keys[string(sealed)] = key
+	return key, nil
+}
+
+// OpenSealed decrypts and authenticates buf in place, which consists of a
+// sealed key followed by the nonce and the ciphertext encrypted with that key.
+func (k *Key) OpenSealed(buf []byte) ([]byte, error) {
+	if len(buf) < SealedKeySize+Extension {
+		return nil, ErrUnauthenticated
+	}
+
+	key, err := k.OpenSealedKey(buf[:SealedKeySize])
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, ciphertext := buf[SealedKeySize:SealedKeySize+ivSize], buf[SealedKeySize+ivSize:]
+	return key.Open(ciphertext[:0], nonce, ciphertext, nil)
+}
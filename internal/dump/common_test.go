@@ -3,6 +3,7 @@ package dump
 import (
 	"bytes"
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/restic/restic/internal/archiver"
@@ -71,6 +72,23 @@ func WriteTest(t *testing.T, wd WriteDump, cd CheckDump) {
 			},
 			target: "/",
 		},
+		{
+			name: "files with zero runs",
+			args: archiver.TestDir{
+				"sparse": archiver.TestFile{Content: "start" + strings.Repeat("\x00", 2<<20) + "end"},
+				"zeros":  archiver.TestFile{Content: strings.Repeat("\x00", 3<<19)},
+			},
+			target: "/",
+		},
+		{
+			name: "file with zero runs and a long name",
+			args: archiver.TestDir{
+				"dir": archiver.TestDir{
+					strings.Repeat("ü", 60) + "-sparse": archiver.TestFile{Content: strings.Repeat("\x00", 1<<20) + "end"},
+				},
+			},
+			target: "/",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
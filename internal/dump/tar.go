@@ -16,6 +16,10 @@ import (
 type tarDumper struct {
 	cache *bloblru.Cache
 	w     *tar.Writer
+
+	// dst and sparse are only used when writing sparse files
+	dst    io.Writer
+	sparse bool
 }
 
 // Statically ensure that tarDumper implements dumper.
@@ -88,6 +92,16 @@ func (dmp *tarDumper) dumpNode(ctx context.Context, node *restic.Node, repo rest
 		header.Name += "/"
 	}
 
+	if dmp.sparse && IsFile(node) {
+		regions, holes, err := dataRegions(node, repo)
+		if err != nil {
+			return err
+		}
+		if holes {
+			return dmp.writeSparse(ctx, header, node, repo, regions)
+		}
+	}
+
 	err = dmp.w.WriteHeader(header)
 
 	if err != nil {
@@ -0,0 +1,248 @@
+package dump
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// WriteTarSparse works like WriteTar, but files which contain all-zero blobs
+// are written as sparse files in the GNU sparse format 1.0.
+func WriteTarSparse(ctx context.Context, repo restic.Repository, tree *restic.Tree, rootPath string, dst io.Writer) error {
+	dmp := &tarDumper{
+		cache:  NewCache(),
+		w:      tar.NewWriter(dst),
+		dst:    dst,
+		sparse: true,
+	}
+	return writeDump(ctx, repo, tree, rootPath, dmp)
+}
+
+const tarBlockSize = 512
+
+// sparseRegion is a part of a sparse file which contains data.
+type sparseRegion struct {
+	offset, length int64
+}
+
+// dataRegions returns the parts of the file node which contain data. The
+// second return value is false if the file does not contain any holes.
+func dataRegions(node *restic.Node, repo restic.Repository) ([]sparseRegion, bool, error) {
+	var regions []sparseRegion
+	holes := false
+
+	offset := int64(0)
+	for _, id := range node.Content {
+		size, found := repo.LookupBlobSize(id, restic.DataBlob)
+		if !found {
+			return nil, false, errors.Errorf("id %v not found in repository", id)
+		}
+
+		if id == restic.ZeroBlobID(size) {
+			holes = true
+		} else if n := len(regions); n > 0 && regions[n-1].offset+regions[n-1].length == offset {
+			regions[n-1].length += int64(size)
+		} else {
+			regions = append(regions, sparseRegion{offset: offset, length: int64(size)})
+		}
+
+		offset += int64(size)
+	}
+
+	// the sparse map always ends with a region at the end of the file
+	if n := len(regions); n == 0 || regions[n-1].offset+regions[n-1].length != offset {
+		regions = append(regions, sparseRegion{offset: offset})
+	}
+
+	return regions, holes, nil
+}
+
+// writeSparse writes node as a sparse file in the GNU sparse format 1.0: the
+// PAX records describe the sparse file and the data of the entry starts with
+// the sparse map, followed by the regions which contain data.
+func (dmp *tarDumper) writeSparse(ctx context.Context, header *tar.Header, node *restic.Node, repo restic.Repository, regions []sparseRegion) error {
+	sparseMap := &bytes.Buffer{}
+	fmt.Fprintf(sparseMap, "%d\n", len(regions))
+	dataSize := int64(0)
+	for _, r := range regions {
+		fmt.Fprintf(sparseMap, "%d\n%d\n", r.offset, r.length)
+		dataSize += r.length
+	}
+	sparseMap.Write(padding(int64(sparseMap.Len())))
+
+	records := make(map[string]string, len(header.PAXRecords)+4)
+	for k, v := range header.PAXRecords {
+		records[k] = v
+	}
+	records["GNU.sparse.major"] = "1"
+	records["GNU.sparse.minor"] = "0"
+	records["GNU.sparse.name"] = header.Name
+	records["GNU.sparse.realsize"] = strconv.FormatInt(header.Size, 10)
+
+	dir, file := path.Split(header.Name)
+	sparseHeader := *header
+	sparseHeader.Name = path.Join(dir, "GNUSparseFile.0", file)
+	sparseHeader.Size = int64(sparseMap.Len()) + dataSize
+	ustar := ustarHeader(sparseHeader, records)
+
+	xhdr, err := extendedHeader(path.Join(dir, "PaxHeaders.0", file), records)
+	if err != nil {
+		return errors.Wrap(err, "TarHeader")
+	}
+
+	// complete the previous entry before the extended header is written
+	err = dmp.w.Flush()
+	if err != nil {
+		return errors.Wrap(err, "Flush")
+	}
+	if _, err := dmp.dst.Write(xhdr); err != nil {
+		return errors.Wrap(err, "Write")
+	}
+
+	err = dmp.w.WriteHeader(ustar)
+	if err != nil {
+		return errors.Wrap(err, "TarHeader")
+	}
+	if _, err := dmp.w.Write(sparseMap.Bytes()); err != nil {
+		return errors.Wrap(err, "Write")
+	}
+
+	// write the data of all blobs which are not all zero
+	var buf []byte
+	for _, id := range node.Content {
+		size, _ := repo.LookupBlobSize(id, restic.DataBlob)
+		if id == restic.ZeroBlobID(size) {
+			continue
+		}
+
+		blob, ok := dmp.cache.Get(id)
+		if !ok {
+			blob, err = repo.LoadBlob(ctx, restic.DataBlob, id, buf)
+			if err != nil {
+				return err
+			}
+
+			buf = dmp.cache.Add(id, blob) // Reuse evicted buffer.
+		}
+
+		if _, err := dmp.w.Write(blob); err != nil {
+			return errors.Wrap(err, "Write")
+		}
+	}
+
+	return nil
+}
+
+// padding returns the zero bytes needed to fill up the last block of an
+// entry of the given size.
+func padding(size int64) []byte {
+	return make([]byte, (tarBlockSize-size%tarBlockSize)%tarBlockSize)
+}
+
+// limits of the numeric fields of a USTAR header
+const (
+	ustarMaxID   = 1<<21 - 1
+	ustarMaxSize = 1<<33 - 1
+)
+
+// ustarString returns true if s can be stored in a string field of a USTAR
+// header with the given size.
+func ustarString(s string, size int) bool {
+	if len(s) >= size {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// ustarHeader returns a copy of hdr which can be written in the USTAR format,
+// so that archive/tar does not add an extended header of its own. Values
+// which do not fit are stored in records instead, only files larger than 8 GiB
+// need the GNU format.
+func ustarHeader(hdr tar.Header, records map[string]string) *tar.Header {
+	if !ustarString(hdr.Name, 100) {
+		records["path"] = hdr.Name
+		hdr.Name = "GNUSparseFile.0"
+	}
+	if hdr.Uid < 0 || hdr.Uid > ustarMaxID {
+		records["uid"] = strconv.Itoa(hdr.Uid)
+		hdr.Uid = 0
+	}
+	if hdr.Gid < 0 || hdr.Gid > ustarMaxID {
+		records["gid"] = strconv.Itoa(hdr.Gid)
+		hdr.Gid = 0
+	}
+	hdr.Format = tar.FormatUSTAR
+	if hdr.Size > ustarMaxSize {
+		// archive/tar needs the size to write the data, the GNU format
+		// stores it in base-256 encoding
+		records["size"] = strconv.FormatInt(hdr.Size, 10)
+		hdr.Format = tar.FormatGNU
+	}
+	hdr.ModTime = hdr.ModTime.Round(time.Second)
+	if mtime := hdr.ModTime.Unix(); mtime < 0 || mtime > ustarMaxSize {
+		records["mtime"] = strconv.FormatInt(mtime, 10)
+		hdr.ModTime = time.Unix(0, 0)
+	}
+	if !ustarString(hdr.Uname, 32) {
+		records["uname"] = hdr.Uname
+		hdr.Uname = ""
+	}
+	if !ustarString(hdr.Gname, 32) {
+		records["gname"] = hdr.Gname
+		hdr.Gname = ""
+	}
+
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+	hdr.PAXRecords = nil
+	return &hdr
+}
+
+// extendedHeader returns a PAX extended header with the records for the next
+// entry. archive/tar does not write GNU.sparse.* records for a file, but
+// copies all records of a global header. So a global header is encoded and
+// turned into an extended header.
+func extendedHeader(name string, records map[string]string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		Name:       name,
+		PAXRecords: records,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// write the padding of the records
+	err = tw.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	blk := buf.Bytes()
+	blk[156] = tar.TypeXHeader
+
+	// update the checksum of the header block
+	copy(blk[148:156], "        ")
+	sum := int64(0)
+	for _, c := range blk[:tarBlockSize] {
+		sum += int64(c)
+	}
+	copy(blk[148:156], fmt.Sprintf("%06o\x00", sum))
+
+	return blk, nil
+}
@@ -7,18 +7,76 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/restic/restic/internal/fs"
+	rtest "github.com/restic/restic/internal/test"
 )
 
 func TestWriteTar(t *testing.T) {
 	WriteTest(t, WriteTar, checkTar)
 }
 
+func TestWriteTarSparse(t *testing.T) {
+	WriteTest(t, WriteTarSparse, func(t *testing.T, testDir string, srcTar *bytes.Buffer) error {
+		// the zero runs of the test files must not be stored in the archive
+		if srcTar.Len() > 1<<20 {
+			return fmt.Errorf("archive is too large for sparse files: %d bytes", srcTar.Len())
+		}
+		return checkTar(t, testDir, srcTar)
+	})
+}
+
+func TestWriteTarSparseGNUTar(t *testing.T) {
+	out, err := exec.Command("tar", "--version").Output()
+	if err != nil || !strings.Contains(string(out), "GNU tar") {
+		t.Skip("GNU tar is not available")
+	}
+
+	WriteTest(t, WriteTarSparse, func(t *testing.T, testDir string, srcTar *bytes.Buffer) error {
+		tempdir, cleanup := rtest.TempDir(t)
+		defer cleanup()
+
+		archive := filepath.Join(tempdir, "archive.tar")
+		target := filepath.Join(tempdir, "target")
+		rtest.OK(t, ioutil.WriteFile(archive, srcTar.Bytes(), 0600))
+		rtest.OK(t, os.Mkdir(target, 0700))
+
+		out, err := exec.Command("tar", "-x", "-f", archive, "-C", target).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("tar failed: %v\n%s", err, out)
+		}
+
+		return filepath.Walk(testDir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.Mode().IsRegular() {
+				return err
+			}
+
+			rel, err := filepath.Rel(testDir, path)
+			if err != nil {
+				return err
+			}
+
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			got, err := ioutil.ReadFile(filepath.Join(target, rel))
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(want, got) {
+				return fmt.Errorf("contents of %v do not match", rel)
+			}
+			return nil
+		})
+	})
+}
+
 func checkTar(t *testing.T, testDir string, srcTar *bytes.Buffer) error {
 	tr := tar.NewReader(srcTar)
 
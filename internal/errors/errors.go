@@ -53,3 +53,5 @@ func Cause(err error) error {
 // Go 1.13-style error handling.
 
 func Is(x, y error) bool { return errors.Is(x, y) }
+
+func As(err error, tgt interface{}) bool { return errors.As(err, tgt) }
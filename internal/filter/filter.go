@@ -17,7 +17,11 @@ type patternPart struct {
 }
 
 // Pattern represents a preparsed filter pattern
-type Pattern []patternPart
+type Pattern struct {
+	original  string
+	parts     []patternPart
+	isNegated bool
+}
 
 func prepareStr(str string) ([]string, error) {
 	if str == "" {
@@ -26,20 +30,29 @@ func prepareStr(str string) ([]string, error) {
 	return splitPath(str), nil
 }
 
-func preparePattern(pattern string) Pattern {
-	parts := splitPath(filepath.Clean(pattern))
-	patterns := make([]patternPart, len(parts))
-	for i, part := range parts {
+func preparePattern(patternStr string) Pattern {
+	var negate bool
+
+	originalPattern := patternStr
+
+	if patternStr[0] == '!' {
+		negate = true
+		patternStr = patternStr[1:]
+	}
+
+	pathParts := splitPath(filepath.Clean(patternStr))
+	parts := make([]patternPart, len(pathParts))
+	for i, part := range pathParts {
 		isSimple := !strings.ContainsAny(part, "\\[]*?")
 		// Replace "**" with the empty string to get faster comparisons
 		// (length-check only) in hasDoubleWildcard.
 		if part == "**" {
 			part = ""
 		}
-		patterns[i] = patternPart{part, isSimple}
+		parts[i] = patternPart{part, isSimple}
 	}
 
-	return patterns
+	return Pattern{originalPattern, parts, negate}
 }
 
 // Split p into path components. Assuming p has been Cleaned, no component
@@ -102,13 +115,13 @@ func ChildMatch(pattern, str string) (matched bool, err error) {
 	return childMatch(patterns, strs)
 }
 
-func childMatch(patterns Pattern, strs []string) (matched bool, err error) {
-	if patterns[0].pattern != "/" {
+func childMatch(pattern Pattern, strs []string) (matched bool, err error) {
+	if pattern.parts[0].pattern != "/" {
 		// relative pattern can always be nested down
 		return true, nil
 	}
 
-	ok, pos := hasDoubleWildcard(patterns)
+	ok, pos := hasDoubleWildcard(pattern)
 	if ok && len(strs) >= pos {
 		// cut off at the double wildcard
 		strs = strs[:pos]
@@ -116,16 +129,16 @@ func childMatch(patterns Pattern, strs []string) (matched bool, err error) {
 
 	// match path against absolute pattern prefix
 	l := 0
-	if len(strs) > len(patterns) {
-		l = len(patterns)
+	if len(strs) > len(pattern.parts) {
+		l = len(pattern.parts)
 	} else {
 		l = len(strs)
 	}
-	return match(patterns[0:l], strs)
+	return match(Pattern{pattern.original, pattern.parts[0:l], pattern.isNegated}, strs)
 }
 
 func hasDoubleWildcard(list Pattern) (ok bool, pos int) {
-	for i, item := range list {
+	for i, item := range list.parts {
 		if item.pattern == "" {
 			return true, i
 		}
@@ -134,22 +147,22 @@ func hasDoubleWildcard(list Pattern) (ok bool, pos int) {
 	return false, 0
 }
 
-func match(patterns Pattern, strs []string) (matched bool, err error) {
-	if ok, pos := hasDoubleWildcard(patterns); ok {
+func match(pattern Pattern, strs []string) (matched bool, err error) {
+	if ok, pos := hasDoubleWildcard(pattern); ok {
 		// gradually expand '**' into separate wildcards
-		newPat := make(Pattern, len(strs))
+		newPat := make([]patternPart, len(strs))
 		// copy static prefix once
-		copy(newPat, patterns[:pos])
-		for i := 0; i <= len(strs)-len(patterns)+1; i++ {
+		copy(newPat, pattern.parts[:pos])
+		for i := 0; i <= len(strs)-len(pattern.parts)+1; i++ {
 			// limit to static prefix and already appended '*'
 			newPat := newPat[:pos+i]
 			// in the first iteration the wildcard expands to nothing
 			if i > 0 {
 				newPat[pos+i-1] = patternPart{"*", false}
 			}
-			newPat = append(newPat, patterns[pos+1:]...)
+			newPat = append(newPat, pattern.parts[pos+1:]...)
 
-			matched, err := match(newPat, strs)
+			matched, err := match(Pattern{pattern.original, newPat, pattern.isNegated}, strs)
 			if err != nil {
 				return false, err
 			}
@@ -162,6 +175,8 @@ func match(patterns Pattern, strs []string) (matched bool, err error) {
 		return false, nil
 	}
 
+	patterns := pattern.parts
+
 	if len(patterns) == 0 && len(strs) == 0 {
 		return true, nil
 	}
@@ -207,7 +222,8 @@ func match(patterns Pattern, strs []string) (matched bool, err error) {
 	return false, nil
 }
 
-// ParsePatterns prepares a list of patterns for use with List.
+// ParsePatterns prepares a list of patterns for use with List. Patterns
+// prefixed with "!" are negated, see List.
 func ParsePatterns(patterns []string) []Pattern {
 	patpat := make([]Pattern, 0)
 	for _, pat := range patterns {
@@ -221,18 +237,26 @@ func ParsePatterns(patterns []string) []Pattern {
 	return patpat
 }
 
-// List returns true if str matches one of the patterns. Empty patterns are ignored.
+// List returns true if str matches one of the patterns. Empty patterns are
+// ignored. Patterns prefixed by "!" are negated: a path matched by an earlier
+// pattern is no longer matched if it also matches a later negated pattern.
+// Similar to gitignore, the patterns are evaluated in order, so a later
+// positive pattern can match a path again.
 func List(patterns []Pattern, str string) (matched bool, err error) {
 	matched, _, err = list(patterns, false, str)
 	return matched, err
 }
 
-// ListWithChild returns true if str matches one of the patterns. Empty patterns are ignored.
+// ListWithChild returns true if str matches one of the patterns, negated
+// patterns are handled as described for List. In addition it returns whether
+// children of str may be matched by the patterns. Empty patterns are ignored.
 func ListWithChild(patterns []Pattern, str string) (matched bool, childMayMatch bool, err error) {
 	return list(patterns, true, str)
 }
 
-// List returns true if str matches one of the patterns. Empty patterns are ignored.
+// list returns true if str matches one of the patterns. Empty patterns are
+// ignored. Patterns prefixed by "!" are negated: any path matched by a
+// previous pattern which matches the negated pattern is no longer matched.
 func list(patterns []Pattern, checkChildMatches bool, str string) (matched bool, childMayMatch bool, err error) {
 	if len(patterns) == 0 {
 		return false, false, nil
@@ -242,6 +266,12 @@ func list(patterns []Pattern, checkChildMatches bool, str string) (matched bool,
 	if err != nil {
 		return false, false, err
 	}
+
+	hasNegatedPattern := false
+	for _, pat := range patterns {
+		hasNegatedPattern = hasNegatedPattern || pat.isNegated
+	}
+
 	for _, pat := range patterns {
 		m, err := match(pat, strs)
 		if err != nil {
@@ -258,11 +288,17 @@ func list(patterns []Pattern, checkChildMatches bool, str string) (matched bool,
 			c = true
 		}
 
-		matched = matched || m
-		childMayMatch = childMayMatch || c
+		if pat.isNegated {
+			matched = matched && !m
+			childMayMatch = childMayMatch && !m
+		} else {
+			matched = matched || m
+			childMayMatch = childMayMatch || c
 
-		if matched && childMayMatch {
-			return true, true, nil
+			if matched && childMayMatch && !hasNegatedPattern {
+				// without negated patterns the result cannot change any more
+				return true, true, nil
+			}
 		}
 	}
 
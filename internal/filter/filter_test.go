@@ -257,6 +257,16 @@ var filterListTests = []struct {
 	{[]string{"/*/*/bar/test.*"}, "/foo/bar/test.go", false, false},
 	{[]string{"/*/*/bar/test.*", "*.go"}, "/foo/bar/test.go", true, true},
 	{[]string{"", "*.c"}, "/foo/bar/test.go", false, true},
+	{[]string{"!**", "*.go"}, "/foo/bar/test.go", true, true},
+	{[]string{"!*.go"}, "/foo/bar/test.go", false, false},
+	{[]string{"*", "!*.c"}, "/foo/bar/test.go", true, true},
+	{[]string{"*", "!*.go"}, "/foo/bar/test.go", false, false},
+	{[]string{"*.go", "!test.go", "*est.go"}, "/foo/bar/test.go", true, true},
+	{[]string{"/home/*/.cache/*", "!/home/*/.cache/important"}, "/home/user/.cache/important", false, false},
+	{[]string{"/home/*/.cache/*", "!/home/*/.cache/important"}, "/home/user/.cache/tmp", true, true},
+	{[]string{"/home/*/.cache/*", "!/home/*/.cache/important"}, "/home/user/.cache/important/file", false, false},
+	{[]string{"/home", "!/home/secret"}, "/home/secret/file", false, false},
+	{[]string{"/home", "!/home/secret", "/home/secret/keep"}, "/home/secret", false, true},
 }
 
 func TestList(t *testing.T) {
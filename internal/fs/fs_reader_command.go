@@ -0,0 +1,91 @@
+package fs
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// CommandReader reads the standard output of a command. Once all data has
+// been read, an error is returned if the command exited with a non-zero
+// status, so that incomplete output is not mistaken for a successful run.
+type CommandReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+
+	waited bool
+	err    error
+}
+
+// statically ensure that CommandReader implements io.ReadCloser.
+var _ io.ReadCloser = &CommandReader{}
+
+// NewCommandReader starts the command args[0] with the arguments args[1:].
+// The standard error of the command is written to stderr. The command is
+// killed when ctx is cancelled.
+func NewCommandReader(ctx context.Context, args []string, stderr io.Writer) (*CommandReader, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no command specified")
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "StdoutPipe")
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to start command %q", strings.Join(args, " "))
+	}
+
+	return &CommandReader{cmd: cmd, stdout: stdout}, nil
+}
+
+// Read reads from the standard output of the command. When the output ends,
+// Read waits for the command to exit and returns its error, if any.
+func (r *CommandReader) Read(p []byte) (int, error) {
+	// the output pipe is closed once the command has exited
+	if r.waited {
+		if r.err != nil {
+			return 0, r.err
+		}
+		return 0, io.EOF
+	}
+
+	n, err := r.stdout.Read(p)
+	if err == io.EOF {
+		if werr := r.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *CommandReader) wait() error {
+	if r.waited {
+		return r.err
+	}
+	r.waited = true
+
+	err := r.cmd.Wait()
+	if err != nil {
+		r.err = errors.Errorf("command %q failed: %v", strings.Join(r.cmd.Args, " "), err)
+	}
+	return r.err
+}
+
+// Close closes the standard output of the command and waits for it to exit.
+// A command which has not written all of its output yet is terminated by
+// SIGPIPE on its next write.
+func (r *CommandReader) Close() error {
+	if !r.waited {
+		_ = r.stdout.Close()
+	}
+	return r.wait()
+}
@@ -0,0 +1,101 @@
+// +build !windows
+
+package fs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCommandReader(t *testing.T) {
+	var tests = []struct {
+		name    string
+		script  string
+		data    string
+		wantErr bool
+	}{
+		{
+			name:   "success",
+			script: "echo foo; echo bar",
+			data:   "foo\nbar\n",
+		},
+		{
+			name:   "no output",
+			script: "true",
+		},
+		{
+			name:    "failure",
+			script:  "echo partial; exit 1",
+			data:    "partial\n",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stderr := &bytes.Buffer{}
+			rd, err := NewCommandReader(context.TODO(), []string{"sh", "-c", test.script}, stderr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buf, err := ioutil.ReadAll(rd)
+			if test.wantErr && err == nil {
+				t.Error("expected error for failed command")
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+
+			if string(buf) != test.data {
+				t.Errorf("wrong data returned, want %q, got %q", test.data, buf)
+			}
+
+			// reading again after the end of the output returns the same result
+			n, err := rd.Read(make([]byte, 16))
+			if n != 0 {
+				t.Errorf("read %d bytes after EOF", n)
+			}
+			if test.wantErr && (err == nil || err == io.EOF) {
+				t.Errorf("expected error for failed command, got %v", err)
+			}
+			if !test.wantErr && err != io.EOF {
+				t.Errorf("expected EOF, got %v", err)
+			}
+
+			err = rd.Close()
+			if test.wantErr != (err != nil) {
+				t.Errorf("Close() returned unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestCommandReaderStartError(t *testing.T) {
+	_, err := NewCommandReader(context.TODO(), []string{"/nonexistent/restic-test-command"}, ioutil.Discard)
+	if err == nil {
+		t.Fatal("expected error for nonexistent command")
+	}
+}
+
+func TestCommandReaderClose(t *testing.T) {
+	// a command which is still writing is terminated when the reader is closed
+	rd, err := NewCommandReader(context.TODO(), []string{"yes"}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 16)
+	_, err = rd.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rd.Close()
+	if err == nil {
+		t.Fatal("expected error for terminated command")
+	}
+}
@@ -443,3 +443,59 @@ func TestFSReaderMinFileSize(t *testing.T) {
 		})
 	}
 }
+
+func TestFSReaders(t *testing.T) {
+	now := time.Now()
+	files := map[string][]byte{
+		"foo":     []byte("foo data"),
+		"bar":     []byte("bar data"),
+		"sub/baz": []byte("baz data"),
+	}
+
+	newFS := func() *Readers {
+		fs := &Readers{}
+		for name, data := range files {
+			fs.Files = append(fs.Files, &Reader{
+				Name:       name,
+				ReadCloser: ioutil.NopCloser(bytes.NewReader(data)),
+				Mode:       0644,
+				Size:       int64(len(data)),
+				ModTime:    now,
+			})
+		}
+		return fs
+	}
+
+	fs := newFS()
+	for name, data := range files {
+		fi, err := fs.Lstat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != int64(len(data)) || fi.IsDir() {
+			t.Errorf("Lstat(%v) returned wrong file info %v", name, fi)
+		}
+
+		verifyFileContentOpen(t, fs, name, data)
+	}
+
+	fs = newFS()
+	for name, data := range files {
+		verifyFileContentOpenFile(t, fs, name, data)
+	}
+
+	fi, err := fs.Lstat("sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFileInfo(t, fi, "sub", time.Time{}, os.ModeDir|0755, true)
+
+	for _, name := range []string{"missing", "sub/missing"} {
+		if _, err := fs.Lstat(name); !os.IsNotExist(err) {
+			t.Errorf("Lstat(%v) returned unexpected error %v", name, err)
+		}
+		if _, err := fs.Open(name); err == nil {
+			t.Errorf("Open(%v) did not return an error", name)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package fs
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"time"
+)
+
+// Readers is a file system which provides several files, each of them backed
+// by a Reader. The names of all files must be different.
+type Readers struct {
+	Files []*Reader
+}
+
+// statically ensure that Readers implements FS.
+var _ FS = &Readers{}
+
+func (fs *Readers) find(name string) *Reader {
+	for _, rd := range fs.Files {
+		if rd.Name == name {
+			return rd
+		}
+	}
+	return nil
+}
+
+// VolumeName returns leading volume name, for the Readers file system it's
+// always the empty string.
+func (fs *Readers) VolumeName(path string) string {
+	return ""
+}
+
+// Open opens a file for reading.
+func (fs *Readers) Open(name string) (File, error) {
+	switch name {
+	case "/", ".":
+		entries := make([]os.FileInfo, 0, len(fs.Files))
+		for _, rd := range fs.Files {
+			entries = append(entries, rd.fi())
+		}
+		return fakeDir{entries: entries}, nil
+	}
+
+	rd := fs.find(name)
+	if rd == nil {
+		return nil, syscall.ENOENT
+	}
+	return rd.Open(name)
+}
+
+// OpenFile is the generalized open call, see Reader.OpenFile.
+func (fs *Readers) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	rd := fs.find(name)
+	if rd == nil {
+		return nil, syscall.ENOENT
+	}
+	return rd.OpenFile(name, flag, perm)
+}
+
+// Stat returns a FileInfo describing the named file. If there is an error, it
+// will be of type *PathError.
+func (fs *Readers) Stat(name string) (os.FileInfo, error) {
+	return fs.Lstat(name)
+}
+
+// Lstat returns the FileInfo structure describing the named file or one of
+// the directories containing it.
+func (fs *Readers) Lstat(name string) (os.FileInfo, error) {
+	switch name {
+	case "/", ".":
+		return fakeFileInfo{
+			name:    fs.Base(name),
+			mode:    os.ModeDir | 0755,
+			modtime: time.Now(),
+		}, nil
+	}
+
+	for _, rd := range fs.Files {
+		fi, err := rd.Lstat(name)
+		if err == nil {
+			return fi, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// Join joins any number of path elements into a single path.
+func (fs *Readers) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Separator returns the OS and FS dependent separator for dirs/subdirs/files.
+func (fs *Readers) Separator() string {
+	return "/"
+}
+
+// IsAbs reports whether the path is absolute. For the Readers, this is always
+// the case.
+func (fs *Readers) IsAbs(p string) bool {
+	return true
+}
+
+// Abs returns an absolute representation of path. For the Readers, all paths
+// are absolute.
+func (fs *Readers) Abs(p string) (string, error) {
+	return path.Clean(p), nil
+}
+
+// Clean returns the cleaned path. For details, see filepath.Clean.
+func (fs *Readers) Clean(p string) string {
+	return path.Clean(p)
+}
+
+// Base returns the last element of p.
+func (fs *Readers) Base(p string) string {
+	return path.Base(p)
+}
+
+// Dir returns p without the last element.
+func (fs *Readers) Dir(p string) string {
+	return path.Dir(p)
+}
@@ -0,0 +1,396 @@
+package fs
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// TarHeader is returned by the Sys() method of the os.FileInfo of all files
+// provided by Tar.
+type TarHeader struct {
+	tar.Header
+
+	// Inode is a number which identifies a file in the archive, hard links
+	// to a file share its number.
+	Inode uint64
+	// Links is the number of hard links to the file.
+	Links uint64
+}
+
+// tarEntry is a file or directory in a tar archive.
+type tarEntry struct {
+	hdr TarHeader
+
+	// offset of the first header block of the entry and of its data
+	hdrOffset, dataOffset int64
+	sparse                bool
+
+	children map[string]struct{}
+}
+
+// Tar is a read-only file system which provides the contents of a tar
+// archive. The archive is indexed when the file system is created,
+// afterwards files can be read in any order, also concurrently.
+type Tar struct {
+	rd      io.ReaderAt
+	entries map[string]*tarEntry
+}
+
+// statically ensure that Tar implements FS.
+var _ FS = &Tar{}
+
+// NewTar reads all headers of the tar archive rd of the given size.
+func NewTar(rd io.ReaderAt, size int64) (*Tar, error) {
+	fs := &Tar{
+		rd: rd,
+		entries: map[string]*tarEntry{
+			"/": {
+				hdr: TarHeader{
+					Header: tar.Header{Typeflag: tar.TypeDir, Name: "/", Mode: 0755},
+					Links:  1,
+				},
+				children: make(map[string]struct{}),
+			},
+		},
+	}
+
+	pos := &posReader{rd: io.NewSectionReader(rd, 0, size)}
+	tr := tar.NewReader(pos)
+	entryStart := int64(0)
+	inode := uint64(0)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Next")
+		}
+
+		entry := &tarEntry{
+			hdr:        TarHeader{Header: *hdr, Links: 1},
+			hdrOffset:  entryStart,
+			dataOffset: pos.pos,
+			sparse:     isSparse(hdr),
+		}
+
+		if entry.sparse {
+			// the data of sparse files is not stored contiguously, find the
+			// end of the entry by reading it
+			_, err = io.Copy(ioutil.Discard, tr)
+			if err != nil {
+				return nil, errors.Wrap(err, "Copy")
+			}
+			entryStart = pos.pos
+		} else {
+			entryStart = pos.pos
+			if !isHeaderOnly(hdr.Typeflag) {
+				entryStart += hdr.Size
+			}
+		}
+		entryStart += padding(entryStart)
+
+		name := path.Join("/", hdr.Name)
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			inode++
+			entry.hdr.Inode = inode
+		case tar.TypeDir:
+			if old, ok := fs.entries[name]; ok && old.children != nil {
+				entry.children = old.children
+			} else {
+				entry.children = make(map[string]struct{})
+			}
+			inode++
+			entry.hdr.Inode = inode
+		case tar.TypeLink:
+			target, ok := fs.entries[path.Join("/", hdr.Linkname)]
+			if !ok || !target.hdr.FileInfo().Mode().IsRegular() {
+				return nil, errors.Errorf("hard link %v refers to %v, which is not a file in the archive", hdr.Name, hdr.Linkname)
+			}
+			// hard links share the header and data of the target
+			link := *target
+			link.hdr.Name = hdr.Name
+			entry = &link
+		default:
+			// ignore global headers and other entries which are not files
+			continue
+		}
+
+		if name == "/" {
+			if entry.children == nil {
+				return nil, errors.Errorf("root of the archive is not a directory")
+			}
+			fs.entries[name] = entry
+			continue
+		}
+
+		err = fs.insert(name, entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// count the hard links to each file
+	links := make(map[uint64]uint64)
+	for _, entry := range fs.entries {
+		links[entry.hdr.Inode]++
+	}
+	for _, entry := range fs.entries {
+		if entry.hdr.Inode != 0 && entry.children == nil {
+			entry.hdr.Links = links[entry.hdr.Inode]
+		}
+	}
+
+	return fs, nil
+}
+
+// insert adds entry as name, missing parent directories are created.
+func (fs *Tar) insert(name string, entry *tarEntry) error {
+	dir, base := path.Split(name)
+	dir = path.Clean(dir)
+
+	parent, ok := fs.entries[dir]
+	if !ok {
+		// directories which are not contained in the archive get the
+		// modification time of their first entry
+		parent = &tarEntry{
+			hdr: TarHeader{
+				Header: tar.Header{Typeflag: tar.TypeDir, Name: dir, Mode: 0755, ModTime: entry.hdr.ModTime},
+				Links:  1,
+			},
+			children: make(map[string]struct{}),
+		}
+		err := fs.insert(dir, parent)
+		if err != nil {
+			return err
+		}
+	}
+	if parent.children == nil {
+		return errors.Errorf("parent directory of %v is not a directory", name)
+	}
+
+	parent.children[base] = struct{}{}
+	fs.entries[name] = entry
+	return nil
+}
+
+// isSparse returns true if hdr describes a sparse file.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// isHeaderOnly returns true for entries which do not have any data.
+func isHeaderOnly(flag byte) bool {
+	switch flag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		return true
+	}
+	return false
+}
+
+// padding returns the number of bytes needed to fill up the last block of an
+// entry which ends at offset.
+func padding(offset int64) int64 {
+	return -offset & 511
+}
+
+// posReader tracks the position within rd.
+type posReader struct {
+	rd  io.ReadSeeker
+	pos int64
+}
+
+func (r *posReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *posReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.rd.Seek(offset, whence)
+	if err == nil {
+		r.pos = pos
+	}
+	return pos, err
+}
+
+func (fs *Tar) entry(name string) (*tarEntry, bool) {
+	entry, ok := fs.entries[path.Join("/", name)]
+	return entry, ok
+}
+
+func (fs *Tar) fi(name string, entry *tarEntry) os.FileInfo {
+	return tarFileInfo{name: path.Base(path.Join("/", name)), hdr: &entry.hdr}
+}
+
+// VolumeName returns leading volume name, for the Tar file system it's
+// always the empty string.
+func (fs *Tar) VolumeName(path string) string {
+	return ""
+}
+
+// Open opens a file for reading.
+func (fs *Tar) Open(name string) (File, error) {
+	entry, ok := fs.entry(name)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	fi := fs.fi(name, entry)
+	file := fakeFile{name: fi.Name(), FileInfo: fi}
+
+	switch {
+	case entry.children != nil:
+		names := make([]string, 0, len(entry.children))
+		for child := range entry.children {
+			names = append(names, child)
+		}
+		sort.Strings(names)
+
+		entries := make([]os.FileInfo, 0, len(names))
+		for _, child := range names {
+			entries = append(entries, fs.fi(child, fs.entries[path.Join("/", name, child)]))
+		}
+		return fakeDir{entries: entries, fakeFile: file}, nil
+
+	case fi.Mode().IsRegular():
+		if !entry.sparse {
+			rd := io.NewSectionReader(fs.rd, entry.dataOffset, entry.hdr.Size)
+			return &tarFile{Reader: rd, fakeFile: file}, nil
+		}
+
+		// let archive/tar assemble the content of sparse files
+		tr := tar.NewReader(io.NewSectionReader(fs.rd, entry.hdrOffset, 1<<62))
+		_, err := tr.Next()
+		if err != nil {
+			return nil, errors.Wrap(err, "Next")
+		}
+		return &tarFile{Reader: tr, fakeFile: file}, nil
+	}
+
+	return file, nil
+}
+
+// OpenFile is the generalized open call, files can only be opened for
+// reading.
+func (fs *Tar) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag & ^(O_RDONLY|O_NOFOLLOW) != 0 {
+		return nil, errors.Errorf("invalid combination of flags 0x%x", flag)
+	}
+	return fs.Open(name)
+}
+
+// Stat returns a FileInfo describing the named file. Symlinks are not
+// followed.
+func (fs *Tar) Stat(name string) (os.FileInfo, error) {
+	return fs.Lstat(name)
+}
+
+// Lstat returns the FileInfo structure describing the named file.
+func (fs *Tar) Lstat(name string) (os.FileInfo, error) {
+	entry, ok := fs.entry(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return fs.fi(name, entry), nil
+}
+
+// Join joins any number of path elements into a single path.
+func (fs *Tar) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Separator returns the OS and FS dependent separator for dirs/subdirs/files.
+func (fs *Tar) Separator() string {
+	return "/"
+}
+
+// IsAbs reports whether the path is absolute. For the Tar, this is always the
+// case.
+func (fs *Tar) IsAbs(p string) bool {
+	return true
+}
+
+// Abs returns an absolute representation of path. For the Tar, all paths are
+// absolute.
+func (fs *Tar) Abs(p string) (string, error) {
+	return path.Join("/", p), nil
+}
+
+// Clean returns the cleaned path. For details, see filepath.Clean.
+func (fs *Tar) Clean(p string) string {
+	return path.Clean(p)
+}
+
+// Base returns the last element of p.
+func (fs *Tar) Base(p string) string {
+	return path.Base(p)
+}
+
+// Dir returns p without the last element.
+func (fs *Tar) Dir(p string) string {
+	return path.Dir(p)
+}
+
+// tarFile is a regular file in a tar archive.
+type tarFile struct {
+	io.Reader
+	fakeFile
+}
+
+func (f *tarFile) Read(p []byte) (int, error) {
+	return f.Reader.Read(p)
+}
+
+// tarFileInfo describes a file in a tar archive.
+type tarFileInfo struct {
+	name string
+	hdr  *TarHeader
+}
+
+func (fi tarFileInfo) Name() string {
+	return fi.name
+}
+
+func (fi tarFileInfo) Size() int64 {
+	if !fi.Mode().IsRegular() {
+		return 0
+	}
+	return fi.hdr.Size
+}
+
+func (fi tarFileInfo) Mode() os.FileMode {
+	return fi.hdr.FileInfo().Mode()
+}
+
+func (fi tarFileInfo) ModTime() time.Time {
+	return fi.hdr.ModTime
+}
+
+func (fi tarFileInfo) IsDir() bool {
+	return fi.Mode().IsDir()
+}
+
+func (fi tarFileInfo) Sys() interface{} {
+	return fi.hdr
+}
@@ -0,0 +1,175 @@
+package fs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/test"
+)
+
+func createTar(t testing.TB, headers []tar.Header, data map[string]string) *bytes.Reader {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, hdr := range headers {
+		hdr := hdr
+		hdr.Size = int64(len(data[hdr.Name]))
+		err := tw.WriteHeader(&hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tw.Write([]byte(data[hdr.Name]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestFSTar(t *testing.T) {
+	mtime := time.Unix(1600000000, 0)
+	headers := []tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir, Mode: 0700, ModTime: mtime},
+		{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: mtime},
+		{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0640, ModTime: mtime, Uid: 1000, Gid: 100,
+			PAXRecords: map[string]string{"SCHILY.xattr.user.foo": "bar"}},
+		{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "file", Mode: 0777, ModTime: mtime},
+		{Name: "dir/hardlink", Typeflag: tar.TypeLink, Linkname: "dir/file", ModTime: mtime},
+		{Name: "implicit/sub/other", Typeflag: tar.TypeReg, Mode: 0644, ModTime: mtime},
+		{Name: "fifo", Typeflag: tar.TypeFifo, Mode: 0600, ModTime: mtime},
+	}
+	data := map[string]string{
+		"dir/file":           "file content",
+		"implicit/sub/other": "other content",
+	}
+
+	rd := createTar(t, headers, data)
+	fs, err := NewTar(rd, rd.Size())
+	test.OK(t, err)
+
+	verifyDirectoryContents(t, fs, "/", []string{"dir", "implicit", "fifo"})
+	verifyDirectoryContents(t, fs, "/dir", []string{"file", "link", "hardlink"})
+	verifyDirectoryContents(t, fs, "/implicit/sub", []string{"other"})
+
+	for _, name := range []string{"/dir/file", "/dir/hardlink"} {
+		verifyFileContentOpen(t, fs, name, []byte("file content"))
+		verifyFileContentOpenFile(t, fs, name, []byte("file content"))
+	}
+	verifyFileContentOpen(t, fs, "/implicit/sub/other", []byte("other content"))
+
+	var tests = []struct {
+		name  string
+		mode  os.FileMode
+		links uint64
+	}{
+		{"/", os.ModeDir | 0700, 1},
+		{"/dir", os.ModeDir | 0750, 1},
+		{"/dir/file", 0640, 2},
+		{"/dir/hardlink", 0640, 2},
+		{"/dir/link", os.ModeSymlink | 0777, 1},
+		{"/implicit", os.ModeDir | 0755, 1},
+		{"/fifo", os.ModeNamedPipe | 0600, 1},
+	}
+	for _, tt := range tests {
+		fi, err := fs.Lstat(tt.name)
+		test.OK(t, err)
+		checkFileInfo(t, fi, tt.name, mtime, tt.mode, tt.mode.IsDir())
+
+		hdr, ok := fi.Sys().(*TarHeader)
+		if !ok {
+			t.Fatalf("Sys() of %v returned %T", tt.name, fi.Sys())
+		}
+		if hdr.Links != tt.links {
+			t.Errorf("wrong number of links for %v, want %d, got %d", tt.name, tt.links, hdr.Links)
+		}
+	}
+
+	fi, err := fs.Lstat("/dir/file")
+	test.OK(t, err)
+	hdr := fi.Sys().(*TarHeader)
+	test.Equals(t, int64(len(data["dir/file"])), fi.Size())
+	test.Equals(t, 1000, hdr.Uid)
+	test.Equals(t, "bar", hdr.PAXRecords["SCHILY.xattr.user.foo"])
+
+	fi, err = fs.Lstat("/dir/hardlink")
+	test.OK(t, err)
+	test.Equals(t, hdr.Inode, fi.Sys().(*TarHeader).Inode)
+
+	fi, err = fs.Lstat("/implicit/sub/other")
+	test.OK(t, err)
+	if hdr.Inode == fi.Sys().(*TarHeader).Inode {
+		t.Errorf("different files share inode %d", hdr.Inode)
+	}
+
+	_, err = fs.Lstat("/missing")
+	if !os.IsNotExist(err) {
+		t.Errorf("Lstat returned unexpected error %v", err)
+	}
+}
+
+func TestFSTarInvalidHardlink(t *testing.T) {
+	rd := createTar(t, []tar.Header{
+		{Name: "link", Typeflag: tar.TypeLink, Linkname: "missing"},
+	}, nil)
+	_, err := NewTar(rd, rd.Size())
+	if err == nil {
+		t.Fatal("expected error for hard link to missing file")
+	}
+}
+
+func TestFSTarSparse(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not found")
+	}
+
+	tempdir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	// the sparse file has data at the start and end, with a hole in between
+	content := make([]byte, 4<<20)
+	copy(content, "start")
+	copy(content[len(content)-3:], "end")
+	sparse := filepath.Join(tempdir, "sparse")
+	test.OK(t, ioutil.WriteFile(sparse, content, 0644))
+	test.OK(t, ioutil.WriteFile(filepath.Join(tempdir, "after"), []byte("after"), 0644))
+
+	for _, format := range []string{"gnu", "pax"} {
+		t.Run(format, func(t *testing.T) {
+			archive := filepath.Join(tempdir, format+".tar")
+			out, err := exec.Command("tar", "--sparse", "--format="+format, "-C", tempdir, "-cf", archive, "sparse", "after").CombinedOutput()
+			if err != nil {
+				t.Skipf("unable to create sparse tar archive: %v\n%s", err, out)
+			}
+
+			f, err := os.Open(archive)
+			test.OK(t, err)
+			defer func() {
+				test.OK(t, f.Close())
+			}()
+			fi, err := f.Stat()
+			test.OK(t, err)
+
+			fs, err := NewTar(f, fi.Size())
+			test.OK(t, err)
+
+			file, err := fs.Open("/sparse")
+			test.OK(t, err)
+			buf, err := ioutil.ReadAll(file)
+			test.OK(t, err)
+			test.OK(t, file.Close())
+			if !bytes.Equal(buf, content) {
+				t.Error("wrong content returned for sparse file")
+			}
+			verifyFileContentOpen(t, fs, "/after", []byte("after"))
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package fs
+
+import (
+	"io"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+var errSeekHoleUnsupported = errors.New("SEEK_HOLE is not supported")
+
+// Region is a part of a file, which either contains data or is a hole.
+type Region struct {
+	Offset, Length int64
+	Hole           bool
+}
+
+// Regions splits the first size bytes of f into data regions and holes using
+// SEEK_DATA and SEEK_HOLE, which are only supported on Linux. Holes smaller
+// than minHole are treated as data. Afterwards, f is positioned at the start
+// of the file again.
+func Regions(f File, size, minHole int64) ([]Region, error) {
+	data, err := dataRegions(f, size)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []Region
+	end := int64(0)
+	addData := func(offset, length int64) {
+		if n := len(regions); n > 0 && !regions[n-1].Hole {
+			regions[n-1].Length = offset + length - regions[n-1].Offset
+			return
+		}
+		regions = append(regions, Region{Offset: offset, Length: length})
+	}
+
+	for _, r := range append(data, Region{Offset: size}) {
+		if hole := r.Offset - end; hole >= minHole {
+			regions = append(regions, Region{Offset: end, Length: hole, Hole: true})
+		} else if hole > 0 {
+			addData(end, hole)
+		}
+		if r.Length > 0 {
+			addData(r.Offset, r.Length)
+		}
+		end = r.Offset + r.Length
+	}
+
+	return regions, nil
+}
+
+// dataRegions returns the regions of f which contain data by seeking to the
+// next data and the next hole alternately. When an error occurs, f is
+// positioned at the start of the file again.
+func dataRegions(f File, size int64) (regions []Region, err error) {
+	if !seekHoleSupported {
+		return nil, errSeekHoleUnsupported
+	}
+
+	defer func() {
+		if err != nil {
+			// a failed seek does not change the position, so this is only
+			// needed if a previous seek was successful
+			_, _ = f.Seek(0, io.SeekStart)
+		}
+	}()
+
+	for offset := int64(0); offset < size; {
+		var start, end int64
+		start, err = f.Seek(offset, seekData)
+		if isNoData(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start >= size {
+			break
+		}
+
+		end, err = f.Seek(start, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		if end > size {
+			end = size
+		}
+		if end <= start {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		regions = append(regions, Region{Offset: start, Length: end - start})
+		offset = end
+	}
+
+	return regions, nil
+}
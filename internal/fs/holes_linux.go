@@ -0,0 +1,19 @@
+package fs
+
+import (
+	"github.com/restic/restic/internal/errors"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	seekHoleSupported = true
+	seekData          = unix.SEEK_DATA
+	seekHole          = unix.SEEK_HOLE
+)
+
+// isNoData returns true if err reports that there is no more data after the
+// offset passed to Seek with seekData.
+func isNoData(err error) bool {
+	return errors.Is(err, unix.ENXIO)
+}
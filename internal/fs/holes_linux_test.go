@@ -0,0 +1,65 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestRegions(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	const mib = 1 << 20
+	data := rtest.Random(23, mib)
+
+	filename := filepath.Join(tempdir, "sparse")
+	f, err := os.Create(filename)
+	rtest.OK(t, err)
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// data, a large hole, data, a small hole, data and a hole at the end
+	for _, offset := range []int64{0, 5 * mib, 6*mib + 4096} {
+		_, err = f.WriteAt(data, offset)
+		rtest.OK(t, err)
+	}
+	size := int64(10 * mib)
+	rtest.OK(t, f.Truncate(size))
+
+	regions, err := Regions(f, size, 512*1024)
+	rtest.OK(t, err)
+	if len(regions) == 1 {
+		t.Skip("file system does not report holes")
+	}
+
+	rtest.Equals(t, []Region{
+		{Offset: 0, Length: mib},
+		{Offset: mib, Length: 4 * mib, Hole: true},
+		{Offset: 5 * mib, Length: 2*mib + 4096},
+		{Offset: 7*mib + 4096, Length: 3*mib - 4096, Hole: true},
+	}, regions)
+
+	// the file is read from the start afterwards
+	buf := make([]byte, len(data))
+	_, err = f.Read(buf)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	// a file without holes is a single region
+	filename = filepath.Join(tempdir, "dense")
+	rtest.OK(t, ioutil.WriteFile(filename, data, 0600))
+	dense, err := os.Open(filename)
+	rtest.OK(t, err)
+	defer func() {
+		_ = dense.Close()
+	}()
+
+	regions, err = Regions(dense, mib, 512*1024)
+	rtest.OK(t, err)
+	rtest.Equals(t, []Region{{Offset: 0, Length: mib}}, regions)
+}
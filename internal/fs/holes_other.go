@@ -0,0 +1,13 @@
+// +build !linux
+
+package fs
+
+const (
+	seekHoleSupported = false
+	seekData          = 3
+	seekHole          = 4
+)
+
+func isNoData(err error) bool {
+	return false
+}
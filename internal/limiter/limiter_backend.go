@@ -30,6 +30,11 @@ func (r rateLimitedBackend) Save(ctx context.Context, h restic.Handle, rd restic
 	return r.Backend.Save(ctx, h, limited)
 }
 
+// Unwrap returns the backend without rate limiting.
+func (r rateLimitedBackend) Unwrap() restic.Backend {
+	return r.Backend
+}
+
 type limitedRewindReader struct {
 	restic.RewindReader
 
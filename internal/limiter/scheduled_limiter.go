@@ -0,0 +1,357 @@
+package limiter
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+
+	"github.com/juju/ratelimit"
+)
+
+// Rule limits the bandwidth during a time window on some days of the week. A
+// rate of zero means unlimited.
+type Rule struct {
+	// Days contains the days of the week on which the rule applies, an empty
+	// set means every day.
+	Days map[time.Weekday]struct{}
+
+	// Start and End are the time of day (as offset from midnight) during
+	// which the rule applies. If End is before Start, the window wraps
+	// around midnight and ends on the following day. If both are zero, the
+	// rule applies the whole day.
+	Start, End time.Duration
+
+	UploadKb, DownloadKb int
+}
+
+// Matches returns true if the rule applies at time t. When the time window
+// wraps around midnight, the part after midnight belongs to the day before.
+func (r Rule) Matches(t time.Time) bool {
+	day := t.Weekday()
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	switch {
+	case r.Start == 0 && r.End == 0:
+		// the whole day
+	case r.Start <= r.End:
+		if tod < r.Start || tod >= r.End {
+			return false
+		}
+	case tod >= r.Start:
+		// before midnight
+	case tod < r.End:
+		// after midnight, the window started on the day before
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+
+	if len(r.Days) > 0 {
+		if _, ok := r.Days[day]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Schedule is a list of rules, the first rule matching the current time
+// determines the bandwidth limits.
+type Schedule []Rule
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseSchedule parses a bandwidth schedule. Rules are separated by "," or
+// ";", each rule consists of an optional day or range of days, an optional
+// time window and the limits, for example:
+//
+//	mon-fri 08:00-18:00 upload=2M download=10M, sat-sun upload=5M, else unlimited
+//
+// Rates are in KiB/s unless followed by one of the suffixes K, M or G. The
+// rule "else" matches at any time, limits not mentioned in a rule are
+// unlimited.
+func ParseSchedule(s string) (Schedule, error) {
+	var sched Schedule
+
+	for _, str := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' }) {
+		fields := strings.Fields(str)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rule, err := parseRule(fields)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid rule %q", strings.TrimSpace(str))
+		}
+		sched = append(sched, rule)
+	}
+
+	if len(sched) == 0 {
+		return nil, errors.Errorf("schedule %q contains no rules", s)
+	}
+
+	return sched, nil
+}
+
+func parseRule(fields []string) (rule Rule, err error) {
+	if fields[0] == "else" {
+		fields = fields[1:]
+	} else {
+		if !strings.ContainsAny(fields[0], ":=") && fields[0] != "unlimited" {
+			rule.Days, err = parseDays(fields[0])
+			if err != nil {
+				return Rule{}, err
+			}
+			fields = fields[1:]
+		}
+
+		if len(fields) > 0 && strings.ContainsRune(fields[0], ':') {
+			rule.Start, rule.End, err = parseWindow(fields[0])
+			if err != nil {
+				return Rule{}, err
+			}
+			fields = fields[1:]
+		}
+	}
+
+	if len(fields) == 0 {
+		return Rule{}, errors.New("no limits specified")
+	}
+
+	if len(fields) == 1 && fields[0] == "unlimited" {
+		return rule, nil
+	}
+
+	for _, f := range fields {
+		data := strings.SplitN(f, "=", 2)
+		if len(data) != 2 {
+			return Rule{}, errors.Errorf("invalid limit %q", f)
+		}
+
+		rate, err := parseRate(data[1])
+		if err != nil {
+			return Rule{}, err
+		}
+
+		switch data[0] {
+		case "upload":
+			rule.UploadKb = rate
+		case "download":
+			rule.DownloadKb = rate
+		default:
+			return Rule{}, errors.Errorf("unknown limit %q", data[0])
+		}
+	}
+
+	return rule, nil
+}
+
+func parseDays(s string) (map[time.Weekday]struct{}, error) {
+	days := make(map[time.Weekday]struct{})
+
+	data := strings.SplitN(strings.ToLower(s), "-", 2)
+	first, ok := weekdays[data[0]]
+	if !ok {
+		return nil, errors.Errorf("invalid day %q", data[0])
+	}
+
+	last := first
+	if len(data) == 2 {
+		last, ok = weekdays[data[1]]
+		if !ok {
+			return nil, errors.Errorf("invalid day %q", data[1])
+		}
+	}
+
+	for d := first; ; d = (d + 1) % 7 {
+		days[d] = struct{}{}
+		if d == last {
+			break
+		}
+	}
+
+	return days, nil
+}
+
+func parseWindow(s string) (start, end time.Duration, err error) {
+	data := strings.SplitN(s, "-", 2)
+	if len(data) != 2 {
+		return 0, 0, errors.Errorf("invalid time window %q", s)
+	}
+
+	start, err = parseTimeOfDay(data[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	end, err = parseTimeOfDay(data[1])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if start == end {
+		return 0, 0, errors.Errorf("empty time window %q", s)
+	}
+
+	return start, end, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		if s == "24:00" {
+			return 24 * time.Hour, nil
+		}
+		return 0, errors.Errorf("invalid time %q", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseRate returns the rate in KiB/s.
+func parseRate(s string) (int, error) {
+	if s == "unlimited" {
+		return 0, nil
+	}
+
+	if s == "" {
+		return 0, errors.New("empty rate")
+	}
+
+	scale := 1
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		s = s[:len(s)-1]
+	case "M":
+		scale = 1024
+		s = s[:len(s)-1]
+	case "G":
+		scale = 1024 * 1024
+		s = s[:len(s)-1]
+	}
+
+	val, err := strconv.Atoi(s)
+	if err != nil || val < 0 {
+		return 0, errors.Errorf("invalid rate %q", s)
+	}
+
+	return val * scale, nil
+}
+
+type scheduledRule struct {
+	Rule
+	upstream   *ratelimit.Bucket
+	downstream *ratelimit.Bucket
+}
+
+type scheduledLimiter struct {
+	rules []scheduledRule
+	// fallback is used when no rule matches
+	fallback scheduledRule
+
+	now func() time.Time
+}
+
+// NewScheduledLimiter constructs a Limiter which changes the upload and
+// download rate according to the schedule. The rate is determined again for
+// each read or write, so changes take effect while data is transferred. When
+// no rule of the schedule matches, uploadKb and downloadKb are used.
+func NewScheduledLimiter(sched Schedule, uploadKb, downloadKb int) Limiter {
+	l := &scheduledLimiter{
+		fallback: newScheduledRule(Rule{UploadKb: uploadKb, DownloadKb: downloadKb}),
+		now:      time.Now,
+	}
+
+	for _, r := range sched {
+		l.rules = append(l.rules, newScheduledRule(r))
+	}
+
+	return l
+}
+
+func newScheduledRule(r Rule) scheduledRule {
+	sr := scheduledRule{Rule: r}
+	if r.UploadKb > 0 {
+		sr.upstream = ratelimit.NewBucketWithRate(toByteRate(r.UploadKb), int64(toByteRate(r.UploadKb)))
+	}
+	if r.DownloadKb > 0 {
+		sr.downstream = ratelimit.NewBucketWithRate(toByteRate(r.DownloadKb), int64(toByteRate(r.DownloadKb)))
+	}
+	return sr
+}
+
+// current returns the rule which applies right now.
+func (l *scheduledLimiter) current() *scheduledRule {
+	now := l.now()
+	for i := range l.rules {
+		if l.rules[i].Matches(now) {
+			return &l.rules[i]
+		}
+	}
+	return &l.fallback
+}
+
+func (l *scheduledLimiter) upstream() *ratelimit.Bucket {
+	return l.current().upstream
+}
+
+func (l *scheduledLimiter) downstream() *ratelimit.Bucket {
+	return l.current().downstream
+}
+
+func (l *scheduledLimiter) Upstream(r io.Reader) io.Reader {
+	return &scheduledReader{rd: r, bucket: l.upstream}
+}
+
+func (l *scheduledLimiter) UpstreamWriter(w io.Writer) io.Writer {
+	return &scheduledWriter{wr: w, bucket: l.upstream}
+}
+
+func (l *scheduledLimiter) Downstream(r io.Reader) io.Reader {
+	return &scheduledReader{rd: r, bucket: l.downstream}
+}
+
+func (l *scheduledLimiter) DownstreamWriter(w io.Writer) io.Writer {
+	return &scheduledWriter{wr: w, bucket: l.downstream}
+}
+
+// Transport returns an HTTP transport limited with the limiter l.
+func (l *scheduledLimiter) Transport(rt http.RoundTripper) http.RoundTripper {
+	return limitTransport(l, rt)
+}
+
+type scheduledReader struct {
+	rd     io.Reader
+	bucket func() *ratelimit.Bucket
+}
+
+func (r *scheduledReader) Read(buf []byte) (int, error) {
+	n, err := r.rd.Read(buf)
+	if b := r.bucket(); b != nil && n > 0 {
+		b.Wait(int64(n))
+	}
+	return n, err
+}
+
+type scheduledWriter struct {
+	wr     io.Writer
+	bucket func() *ratelimit.Bucket
+}
+
+func (w *scheduledWriter) Write(buf []byte) (int, error) {
+	if b := w.bucket(); b != nil {
+		b.Wait(int64(len(buf)))
+	}
+	return w.wr.Write(buf)
+}
@@ -0,0 +1,171 @@
+package limiter
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/test"
+)
+
+func TestParseSchedule(t *testing.T) {
+	weekdays := map[time.Weekday]struct{}{
+		time.Monday: {}, time.Tuesday: {}, time.Wednesday: {}, time.Thursday: {}, time.Friday: {},
+	}
+
+	var tests = []struct {
+		s    string
+		want Schedule
+	}{
+		{"upload=100", Schedule{{UploadKb: 100}}},
+		{"unlimited", Schedule{{}}},
+		{"mon-fri 08:00-18:00 upload=2M, else unlimited", Schedule{
+			{Days: weekdays, Start: 8 * time.Hour, End: 18 * time.Hour, UploadKb: 2048},
+			{},
+		}},
+		{"sat-sun download=1G; 22:00-06:00 upload=512K download=unlimited", Schedule{
+			{Days: map[time.Weekday]struct{}{time.Saturday: {}, time.Sunday: {}}, DownloadKb: 1024 * 1024},
+			{Start: 22 * time.Hour, End: 6 * time.Hour, UploadKb: 512},
+		}},
+		{"Wed 12:30-24:00 upload=10", Schedule{
+			{Days: map[time.Weekday]struct{}{time.Wednesday: {}}, Start: 12*time.Hour + 30*time.Minute, End: 24 * time.Hour, UploadKb: 10},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			sched, err := ParseSchedule(tt.s)
+			test.OK(t, err)
+			test.Equals(t, tt.want, sched)
+		})
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		" , ;",
+		"mon-fri",
+		"mon-fri 08:00-18:00",
+		"foo upload=1M",
+		"mon-foo upload=1M",
+		"08:00 upload=1M",
+		"08:00-08:00 upload=1M",
+		"upload=",
+		"upload=-1",
+		"upload=1T",
+		"bandwidth=1M",
+		"upload",
+	} {
+		t.Run("", func(t *testing.T) {
+			_, err := ParseSchedule(s)
+			if err == nil {
+				t.Fatalf("ParseSchedule(%q) did not return an error", s)
+			}
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	sched, err := ParseSchedule("mon-fri 08:00-18:00 upload=1, sat 22:00-02:00 upload=2, sun upload=3")
+	test.OK(t, err)
+
+	var tests = []struct {
+		t    string
+		rule int
+	}{
+		{"2021-08-02 07:59", -1}, // Monday
+		{"2021-08-02 08:00", 0},
+		{"2021-08-06 17:59", 0}, // Friday
+		{"2021-08-06 18:00", -1},
+		{"2021-08-07 12:00", -1}, // Saturday
+		{"2021-08-07 01:00", -1},
+		{"2021-08-07 23:00", 1},
+		{"2021-08-08 01:00", 1}, // Sunday
+		{"2021-08-08 02:00", 2},
+	}
+
+	for _, tt := range tests {
+		ts, err := time.Parse("2006-01-02 15:04", tt.t)
+		test.OK(t, err)
+
+		match := -1
+		for i, r := range sched {
+			if r.Matches(ts) {
+				match = i
+				break
+			}
+		}
+
+		if match != tt.rule {
+			t.Errorf("%v: want rule %d, got %d", tt.t, tt.rule, match)
+		}
+	}
+}
+
+func TestRuleMatchesAfterMidnight(t *testing.T) {
+	sched, err := ParseSchedule("mon 22:00-06:00 upload=1")
+	test.OK(t, err)
+	r := sched[0]
+
+	var tests = []struct {
+		t     string
+		match bool
+	}{
+		{"2021-08-02 05:00", false}, // Monday
+		{"2021-08-02 21:59", false},
+		{"2021-08-02 22:00", true},
+		{"2021-08-02 23:59", true},
+		{"2021-08-03 00:00", true}, // Tuesday
+		{"2021-08-03 05:59", true},
+		{"2021-08-03 06:00", false},
+		{"2021-08-03 22:00", false},
+		{"2021-08-04 01:00", false}, // Wednesday
+	}
+
+	for _, tt := range tests {
+		ts, err := time.Parse("2006-01-02 15:04", tt.t)
+		test.OK(t, err)
+
+		if r.Matches(ts) != tt.match {
+			t.Errorf("%v: want match %v, got %v", tt.t, tt.match, !tt.match)
+		}
+	}
+}
+
+func TestScheduledLimiterChangesRate(t *testing.T) {
+	sched, err := ParseSchedule("08:00-18:00 upload=1")
+	test.OK(t, err)
+
+	lim := NewScheduledLimiter(sched, 0, 42).(*scheduledLimiter)
+
+	now, err := time.Parse("15:04", "12:00")
+	test.OK(t, err)
+	lim.now = func() time.Time { return now }
+
+	if lim.upstream() == nil {
+		t.Fatal("upload not limited during the time window")
+	}
+	if lim.downstream() != nil {
+		t.Fatal("download limited during the time window")
+	}
+
+	// a reader created earlier must pick up the new rate
+	rd := lim.Upstream(bytes.NewReader(make([]byte, 10*1024)))
+
+	now = now.Add(8 * time.Hour)
+	if lim.upstream() != nil {
+		t.Fatal("upload limited outside of the time window")
+	}
+	if lim.downstream() == nil {
+		t.Fatal("fallback download limit not applied")
+	}
+
+	start := time.Now()
+	buf := make([]byte, 10*1024)
+	_, err = rd.Read(buf)
+	test.OK(t, err)
+	if time.Since(start) > time.Second {
+		t.Fatalf("read was limited outside of the time window")
+	}
+}
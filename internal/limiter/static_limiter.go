@@ -56,36 +56,38 @@ func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return rt(req)
 }
 
-func (l staticLimiter) roundTripper(rt http.RoundTripper, req *http.Request) (*http.Response, error) {
+// limitTransport returns an HTTP transport which limits request and response
+// bodies with l.
+func limitTransport(l Limiter, rt http.RoundTripper) http.RoundTripper {
 	type readCloser struct {
 		io.Reader
 		io.Closer
 	}
 
-	if req.Body != nil {
-		req.Body = &readCloser{
-			Reader: l.Upstream(req.Body),
-			Closer: req.Body,
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		if req.Body != nil {
+			req.Body = &readCloser{
+				Reader: l.Upstream(req.Body),
+				Closer: req.Body,
+			}
 		}
-	}
 
-	res, err := rt.RoundTrip(req)
+		res, err := rt.RoundTrip(req)
 
-	if res != nil && res.Body != nil {
-		res.Body = &readCloser{
-			Reader: l.Downstream(res.Body),
-			Closer: res.Body,
+		if res != nil && res.Body != nil {
+			res.Body = &readCloser{
+				Reader: l.Downstream(res.Body),
+				Closer: res.Body,
+			}
 		}
-	}
 
-	return res, err
+		return res, err
+	})
 }
 
 // Transport returns an HTTP transport limited with the limiter l.
 func (l staticLimiter) Transport(rt http.RoundTripper) http.RoundTripper {
-	return roundTripper(func(req *http.Request) (*http.Response, error) {
-		return l.roundTripper(rt, req)
-	})
+	return limitTransport(l, rt)
 }
 
 func (l staticLimiter) limitReader(r io.Reader, b *ratelimit.Bucket) io.Reader {
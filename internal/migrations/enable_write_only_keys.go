@@ -0,0 +1,73 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+func init() {
+	register(&EnableWriteOnlyKeys{})
+}
+
+// EnableWriteOnlyKeys adds a key pair to the repository config, so that
+// write-only keys can be added. Data saved with these keys is sealed for the
+// public key and can only be read with the private key, which is stored in
+// the config and thus only available to normal keys. The repository is
+// upgraded to version 3, so that older versions of restic, which cannot read
+// sealed data, refuse to use it.
+type EnableWriteOnlyKeys struct{}
+
+// Name returns the name for this migration.
+func (*EnableWriteOnlyKeys) Name() string {
+	return "enable_write_only_keys"
+}
+
+// Desc returns a short description what the migration does.
+func (*EnableWriteOnlyKeys) Desc() string {
+	return "add a key pair to the config so that write-only keys can be used (requires repository version 2, upgrades to version 3)"
+}
+
+// Check tests whether the migration can be applied.
+func (*EnableWriteOnlyKeys) Check(ctx context.Context, repo restic.Repository) (bool, error) {
+	cfg := repo.Config()
+	return cfg.Version >= 2 && cfg.PublicKey == nil, nil
+}
+
+// Apply runs the migration.
+func (*EnableWriteOnlyKeys) Apply(ctx context.Context, repo restic.Repository) error {
+	h := restic.Handle{Type: restic.ConfigFile}
+
+	// keep the old config file, in case uploading the new one fails
+	rawConfigFile, err := backend.LoadAll(ctx, nil, repo.Backend(), h)
+	if err != nil {
+		return errors.Wrap(err, "load config file failed")
+	}
+
+	cfg := repo.Config()
+	cfg.Version = restic.SealedRepoVersion
+	cfg.PublicKey, cfg.PrivateKey = crypto.NewKeyPair()
+	cfg.LockKey = crypto.NewRandomKey()
+
+	// most backends refuse to overwrite existing files, so remove the config
+	// file first
+	err = repo.Backend().Remove(ctx, h)
+	if err != nil {
+		return errors.Wrap(err, "remove config failed")
+	}
+
+	_, err = repo.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
+	if err != nil {
+		_ = repo.Backend().Remove(ctx, h)
+		rerr := repo.Backend().Save(ctx, h, restic.NewByteReader(rawConfigFile, repo.Backend().Hasher()))
+		if rerr != nil {
+			return errors.Errorf("error uploading config (%v), re-uploading old config file failed as well (%v)", err, rerr)
+		}
+		return errors.Wrap(err, "save new config file failed")
+	}
+
+	return nil
+}
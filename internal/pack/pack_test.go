@@ -20,11 +20,12 @@ import (
 var testLens = []int{23, 31650, 25860, 10928, 13769, 19862, 5211, 127, 13690, 30231}
 
 type Buf struct {
-	data []byte
-	id   restic.ID
+	data               []byte
+	id                 restic.ID
+	uncompressedLength int
 }
 
-func newPack(t testing.TB, k *crypto.Key, lengths []int) ([]Buf, []byte, uint) {
+func newPack(t testing.TB, k *crypto.Key, lengths []int, compressed bool) ([]Buf, []byte, uint) {
 	bufs := []Buf{}
 
 	for _, l := range lengths {
@@ -32,14 +33,19 @@ func newPack(t testing.TB, k *crypto.Key, lengths []int) ([]Buf, []byte, uint) {
 		_, err := io.ReadFull(rand.Reader, b)
 		rtest.OK(t, err)
 		h := sha256.Sum256(b)
-		bufs = append(bufs, Buf{data: b, id: h})
+		buf := Buf{data: b, id: h}
+		if compressed {
+			// the packer does not interpret the data, any length will do
+			buf.uncompressedLength = 2 * l
+		}
+		bufs = append(bufs, buf)
 	}
 
 	// pack blobs
 	var buf bytes.Buffer
 	p := pack.NewPacker(k, &buf)
 	for _, b := range bufs {
-		_, err := p.Add(restic.TreeBlob, b.id, b.data)
+		_, err := p.Add(restic.TreeBlob, b.id, b.data, b.uncompressedLength)
 		rtest.OK(t, err)
 	}
 
@@ -55,7 +61,10 @@ func verifyBlobs(t testing.TB, bufs []Buf, k *crypto.Key, rd io.ReaderAt, packSi
 		written += len(buf.data)
 	}
 	// header length + header + header crypto
-	headerSize := binary.Size(uint32(0)) + restic.CiphertextLength(len(bufs)*int(pack.EntrySize))
+	headerSize := binary.Size(uint32(0)) + crypto.Extension
+	for _, buf := range bufs {
+		headerSize += pack.CalculateEntrySize(restic.Blob{UncompressedLength: uint(buf.uncompressedLength)})
+	}
 	written += headerSize
 
 	// check length
@@ -71,6 +80,7 @@ func verifyBlobs(t testing.TB, bufs []Buf, k *crypto.Key, rd io.ReaderAt, packSi
 	for i, b := range bufs {
 		e := entries[i]
 		rtest.Equals(t, b.id, e.ID)
+		rtest.Equals(t, uint(b.uncompressedLength), e.UncompressedLength)
 
 		if len(buf) < int(e.Length) {
 			buf = make([]byte, int(e.Length))
@@ -89,9 +99,11 @@ func TestCreatePack(t *testing.T) {
 	// create random keys
 	k := crypto.NewRandomKey()
 
-	bufs, packData, packSize := newPack(t, k, testLens)
-	rtest.Equals(t, uint(len(packData)), packSize)
-	verifyBlobs(t, bufs, k, bytes.NewReader(packData), packSize)
+	for _, compressed := range []bool{false, true} {
+		bufs, packData, packSize := newPack(t, k, testLens, compressed)
+		rtest.Equals(t, uint(len(packData)), packSize)
+		verifyBlobs(t, bufs, k, bytes.NewReader(packData), packSize)
+	}
 }
 
 var blobTypeJSON = []struct {
@@ -121,7 +133,7 @@ func TestUnpackReadSeeker(t *testing.T) {
 	// create random keys
 	k := crypto.NewRandomKey()
 
-	bufs, packData, packSize := newPack(t, k, testLens)
+	bufs, packData, packSize := newPack(t, k, testLens, false)
 
 	b := mem.New()
 	id := restic.Hash(packData)
@@ -134,7 +146,7 @@ func TestUnpackReadSeeker(t *testing.T) {
 func TestShortPack(t *testing.T) {
 	k := crypto.NewRandomKey()
 
-	bufs, packData, packSize := newPack(t, k, []int{23})
+	bufs, packData, packSize := newPack(t, k, []int{23}, false)
 
 	b := mem.New()
 	id := restic.Hash(packData)
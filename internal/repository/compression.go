@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// CompressionMode configures if data should be compressed.
+type CompressionMode uint
+
+// Constants for the different compression levels.
+const (
+	CompressionAuto    CompressionMode = 0
+	CompressionOff     CompressionMode = 1
+	CompressionMax     CompressionMode = 2
+	CompressionInvalid CompressionMode = 3
+)
+
+// Set implements the method needed for pflag command flag parsing.
+func (c *CompressionMode) Set(s string) error {
+	switch s {
+	case "auto":
+		*c = CompressionAuto
+	case "off":
+		*c = CompressionOff
+	case "max":
+		*c = CompressionMax
+	default:
+		*c = CompressionInvalid
+		return fmt.Errorf("invalid compression mode %q, must be one of (auto|off|max)", s)
+	}
+
+	return nil
+}
+
+func (c *CompressionMode) String() string {
+	switch *c {
+	case CompressionAuto:
+		return "auto"
+	case CompressionOff:
+		return "off"
+	case CompressionMax:
+		return "max"
+	default:
+		return "invalid"
+	}
+}
+
+// Type implements the method needed for pflag command flag parsing.
+func (c *CompressionMode) Type() string {
+	return "mode"
+}
+
+// unpackedCompressed is the first byte of a compressed file that is not stored
+// in a pack (e.g. an index or a snapshot). Uncompressed files contain JSON
+// data and therefore start with either '{' or '['.
+const unpackedCompressed = 2
+
+var (
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+)
+
+// getZstdDecoder returns the shared zstd decoder. DecodeAll can be called
+// concurrently on the decoder.
+func getZstdDecoder() *zstd.Decoder {
+	zstdDecoderOnce.Do(func() {
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			panic(err)
+		}
+		zstdDecoder = dec
+	})
+	return zstdDecoder
+}
+
+// getZstdEncoder returns the zstd encoder for the compression level configured
+// for the repository. EncodeAll can be called concurrently on the encoder.
+func (r *Repository) getZstdEncoder() *zstd.Encoder {
+	r.allocEnc.Do(func() {
+		level := zstd.SpeedDefault
+		if r.compression == CompressionMax {
+			level = zstd.SpeedBestCompression
+		}
+
+		enc, err := zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(level),
+			// restic checks the hash of all data, the CRC is not needed
+			zstd.WithEncoderCRC(false),
+			// blobs are at most a few MiB in size, use a window that
+			// covers most of them
+			zstd.WithWindowSize(512*1024),
+		)
+		if err != nil {
+			panic(err)
+		}
+		r.enc = enc
+	})
+	return r.enc
+}
+
+// compressUnpacked compresses the contents of a file which is not stored in a
+// pack. Compression is only available starting from repository version 2.
+func (r *Repository) compressUnpacked(p []byte) []byte {
+	if r.cfg.Version < 2 {
+		return p
+	}
+
+	out := []byte{unpackedCompressed}
+	return r.getZstdEncoder().EncodeAll(p, out)
+}
+
+// decompressUnpacked reverses compressUnpacked. Files written by older
+// versions of restic are returned unmodified.
+func (r *Repository) decompressUnpacked(p []byte) ([]byte, error) {
+	if r.cfg.Version < 2 || len(p) == 0 {
+		return p, nil
+	}
+
+	switch p[0] {
+	case '{', '[':
+		// plain JSON data
+		return p, nil
+	case unpackedCompressed:
+		return getZstdDecoder().DecodeAll(p[1:], nil)
+	}
+
+	return nil, errors.Errorf("unsupported encoding format %d", p[0])
+}
+
+// DecryptBlob decrypts the raw data of blob as stored in a pack file and
+// decompresses it if necessary. The returned plaintext may use the memory of
+// buf. The hash of the plaintext is not verified.
+func DecryptBlob(key *crypto.Key, blob restic.Blob, buf []byte) ([]byte, error) {
+	if len(buf) < key.NonceSize() {
+		return nil, errors.Errorf("blob %v is too short", blob.ID.Str())
+	}
+
+	nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+	plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if errors.Cause(err) == crypto.ErrUnauthenticated {
+		// the blob may have been saved with a write-only key
+		plaintext, err = key.OpenSealed(buf)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !blob.IsCompressed() {
+		return plaintext, nil
+	}
+
+	plaintext, err = getZstdDecoder().DecodeAll(plaintext, make([]byte, 0, blob.DataLength()))
+	if err != nil {
+		return nil, errors.Wrap(err, "decompress")
+	}
+
+	return plaintext, nil
+}
@@ -37,12 +37,36 @@ type Key struct {
 	Salt []byte `json:"salt"`
 	Data []byte `json:"data"`
 
-	user   *crypto.Key
-	master *crypto.Key
+	// WriteOnly is set for keys which contain only the configuration of the
+	// repository instead of the master key, see AddWriteOnlyKey.
+	WriteOnly bool `json:"write_only,omitempty"`
+
+	// Rotation is set for the key which holds the new master key during a
+	// master key rotation, see NewMasterKeyRotation.
+	Rotation bool `json:"rotation,omitempty"`
+
+	user       *crypto.Key
+	master     *crypto.Key
+	config     *restic.Config
+	configFile restic.ID
 
 	name string
 }
 
+// writeOnlyKeyData is stored in write-only keys instead of the master key.
+type writeOnlyKeyData struct {
+	Config restic.Config `json:"config"`
+
+	// ConfigFile is the hash of the config file of the repository, which
+	// cannot be decrypted with the key. It ensures that Config matches the
+	// repository.
+	ConfigFile restic.ID `json:"config_file"`
+}
+
+// errConfigMismatch is returned by checkConfigKey if a write-only key was
+// created for a different config file.
+var errConfigMismatch = errors.New("write-only key does not match the repository config")
+
 // Params tracks the parameters used for the KDF. If not set, it will be
 // calibrated on the first run of AddKey().
 var Params *crypto.Params
@@ -93,8 +117,15 @@ func OpenKey(ctx context.Context, s *Repository, name string, password string) (
 	}
 
 	// restore json
-	k.master = &crypto.Key{}
-	err = json.Unmarshal(buf, k.master)
+	if k.WriteOnly {
+		var data writeOnlyKeyData
+		err = json.Unmarshal(buf, &data)
+		k.config = &data.Config
+		k.configFile = data.ConfigFile
+	} else {
+		k.master = &crypto.Key{}
+		err = json.Unmarshal(buf, k.master)
+	}
 	if err != nil {
 		debug.Log("Unmarshal() returned error %v", err)
 		return nil, errors.Wrap(err, "Unmarshal")
@@ -115,11 +146,17 @@ func OpenKey(ctx context.Context, s *Repository, name string, password string) (
 func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int, keyHint string) (k *Key, err error) {
 	checked := 0
 
+	// the config file is needed to check each key, load it only once
+	configFile, configErr := backend.LoadAll(ctx, nil, s.be, restic.Handle{Type: restic.ConfigFile})
+
 	if len(keyHint) > 0 {
 		id, err := restic.Find(ctx, s.Backend(), restic.KeyFile, keyHint)
 
 		if err == nil {
 			key, err := OpenKey(ctx, s, id, password)
+			if err == nil {
+				err = checkConfigKey(key, configFile, configErr)
+			}
 
 			if err == nil {
 				debug.Log("successfully opened hinted key %v", id)
@@ -135,6 +172,10 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 	listCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	// set if a write-only key with the password was found, which was created
+	// for a different config
+	mismatch := false
+
 	// try at most maxKeys keys in repo
 	err = s.Backend().List(listCtx, restic.KeyFile, func(fi restic.FileInfo) error {
 		if maxKeys > 0 && checked > maxKeys {
@@ -160,6 +201,20 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 			return err
 		}
 
+		err = checkConfigKey(key, configFile, configErr)
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			debug.Log("key %v cannot decrypt the config", fi.Name)
+			return nil
+		}
+		if err == errConfigMismatch {
+			debug.Log("key %v does not match the config", fi.Name)
+			mismatch = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
 		debug.Log("successfully opened key %v", fi.Name)
 		k = key
 		cancel()
@@ -174,6 +229,10 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 		return nil, err
 	}
 
+	if k == nil && mismatch {
+		return nil, errors.Fatal("the write-only key does not match the repository config, it must be added again")
+	}
+
 	if k == nil {
 		return nil, ErrNoKeyFound
 	}
@@ -181,6 +240,36 @@ func SearchKey(ctx context.Context, s *Repository, password string, maxKeys int,
 	return k, nil
 }
 
+// checkConfigKey returns crypto.ErrUnauthenticated if the master key in k
+// cannot decrypt the config file buf, which was loaded with the error
+// loadErr. During a master key rotation, the repository contains keys for
+// both the old and the new master key, but only one of them matches the
+// config. Errors loading the config are ignored, they are reported when the
+// config is loaded afterwards.
+//
+// The config stored in a write-only key is used instead of the config of the
+// repository, errConfigMismatch is returned if it was created for a different
+// config file.
+func checkConfigKey(k *Key, buf []byte, loadErr error) error {
+	if k.WriteOnly {
+		if loadErr != nil {
+			return errors.Wrap(loadErr, "load config")
+		}
+		if restic.Hash(buf) != k.configFile {
+			return errConfigMismatch
+		}
+		return nil
+	}
+
+	if loadErr != nil || len(buf) < k.master.NonceSize() {
+		return nil
+	}
+
+	nonce, ciphertext := buf[:k.master.NonceSize()], buf[k.master.NonceSize():]
+	_, err := k.master.Open(nil, nonce, ciphertext, nil)
+	return err
+}
+
 // LoadKey loads a key from the backend.
 func LoadKey(ctx context.Context, s *Repository, name string) (k *Key, err error) {
 	h := restic.Handle{Type: restic.KeyFile, Name: name}
@@ -200,11 +289,75 @@ func LoadKey(ctx context.Context, s *Repository, name string) (k *Key, err error
 
 // AddKey adds a new key to an already existing repository.
 func AddKey(ctx context.Context, s *Repository, password, username, hostname string, template *crypto.Key) (*Key, error) {
+	newkey := &Key{}
+
+	if template == nil {
+		// generate new random master keys
+		newkey.master = crypto.NewRandomKey()
+	} else {
+		// copy master keys from old key
+		newkey.master = template
+	}
+
+	// encrypt master keys (as json) with user key
+	buf, err := json.Marshal(newkey.master)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+
+	err = saveKey(ctx, s, newkey, password, username, hostname, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return newkey, nil
+}
+
+// AddWriteOnlyKey adds a new key which can only be used to save new data in
+// the repository, which is sealed for the public key in cfg. The key contains
+// cfg without the private key instead of the master key, and the hash of the
+// config file currently stored in the repository.
+func AddWriteOnlyKey(ctx context.Context, s *Repository, password, username, hostname string, cfg restic.Config) (*Key, error) {
+	if cfg.Version < restic.SealedRepoVersion {
+		return nil, errors.Errorf("write-only keys require repository version %d", restic.SealedRepoVersion)
+	}
+	if cfg.PublicKey == nil || cfg.LockKey == nil {
+		return nil, errors.New("repository has no public key")
+	}
+
+	configFile, err := backend.LoadAll(ctx, nil, s.be, restic.Handle{Type: restic.ConfigFile})
+	if err != nil {
+		return nil, errors.Wrap(err, "load config")
+	}
+
+	cfg.PrivateKey = nil
+	newkey := &Key{
+		WriteOnly:  true,
+		config:     &cfg,
+		configFile: restic.Hash(configFile),
+	}
+
+	buf, err := json.Marshal(writeOnlyKeyData{Config: cfg, ConfigFile: newkey.configFile})
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+
+	err = saveKey(ctx, s, newkey, password, username, hostname, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return newkey, nil
+}
+
+// saveKey encrypts data with a key derived from password and stores newkey in
+// the repository.
+func saveKey(ctx context.Context, s *Repository, newkey *Key, password, username, hostname string, data []byte) error {
 	// make sure we have valid KDF parameters
 	if Params == nil {
 		p, err := crypto.Calibrate(KDFTimeout, KDFMemory)
 		if err != nil {
-			return nil, errors.Wrap(err, "Calibrate")
+			return errors.Wrap(err, "Calibrate")
 		}
 
 		Params = &p
@@ -212,16 +365,14 @@ func AddKey(ctx context.Context, s *Repository, password, username, hostname str
 	}
 
 	// fill meta data about key
-	newkey := &Key{
-		Created:  time.Now(),
-		Username: username,
-		Hostname: hostname,
+	newkey.Created = time.Now()
+	newkey.Username = username
+	newkey.Hostname = hostname
 
-		KDF: "scrypt",
-		N:   Params.N,
-		R:   Params.R,
-		P:   Params.P,
-	}
+	newkey.KDF = "scrypt"
+	newkey.N = Params.N
+	newkey.R = Params.R
+	newkey.P = Params.P
 
 	if newkey.Hostname == "" {
 		newkey.Hostname, _ = os.Hostname()
@@ -244,33 +395,19 @@ func AddKey(ctx context.Context, s *Repository, password, username, hostname str
 	// call KDF to derive user key
 	newkey.user, err = crypto.KDF(*Params, newkey.Salt, password)
 	if err != nil {
-		return nil, err
-	}
-
-	if template == nil {
-		// generate new random master keys
-		newkey.master = crypto.NewRandomKey()
-	} else {
-		// copy master keys from old key
-		newkey.master = template
-	}
-
-	// encrypt master keys (as json) with user key
-	buf, err := json.Marshal(newkey.master)
-	if err != nil {
-		return nil, errors.Wrap(err, "Marshal")
+		return err
 	}
 
 	nonce := crypto.NewRandomNonce()
-	ciphertext := make([]byte, 0, len(buf)+newkey.user.Overhead()+newkey.user.NonceSize())
+	ciphertext := make([]byte, 0, len(data)+newkey.user.Overhead()+newkey.user.NonceSize())
 	ciphertext = append(ciphertext, nonce...)
-	ciphertext = newkey.user.Seal(ciphertext, nonce, buf, nil)
+	ciphertext = newkey.user.Seal(ciphertext, nonce, data, nil)
 	newkey.Data = ciphertext
 
 	// dump as json
-	buf, err = json.Marshal(newkey)
+	buf, err := json.Marshal(newkey)
 	if err != nil {
-		return nil, errors.Wrap(err, "Marshal")
+		return errors.Wrap(err, "Marshal")
 	}
 
 	// store in repository and return
@@ -281,12 +418,12 @@ func AddKey(ctx context.Context, s *Repository, password, username, hostname str
 
 	err = s.be.Save(ctx, h, restic.NewByteReader(buf, s.be.Hasher()))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	newkey.name = h.Name
 
-	return newkey, nil
+	return nil
 }
 
 func (k *Key) String() string {
@@ -303,5 +440,8 @@ func (k Key) Name() string {
 
 // Valid tests whether the mac and encryption keys are valid (i.e. not zero)
 func (k *Key) Valid() bool {
+	if k.WriteOnly {
+		return k.user.Valid() && k.config.PublicKey != nil && k.config.LockKey.Valid()
+	}
 	return k.user.Valid() && k.master.Valid()
 }
@@ -146,7 +146,7 @@ func (mi *MasterIndex) PackSize(ctx context.Context, onlyHdr bool) map[restic.ID
 		if !onlyHdr {
 			size += int64(blob.Length)
 		}
-		packSize[blob.PackID] = size + int64(pack.EntrySize)
+		packSize[blob.PackID] = size + int64(pack.CalculateEntrySize(blob.Blob))
 	}
 
 	return packSize
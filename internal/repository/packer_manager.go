@@ -1,12 +1,15 @@
 package repository
 
 import (
+	"bytes"
 	"context"
 	"hash"
 	"io"
+	"io/ioutil"
 	"os"
 	"sync"
 
+	"github.com/restic/restic/internal/cache"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/hashing"
 	"github.com/restic/restic/internal/restic"
@@ -124,14 +127,38 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 		return err
 	}
 
-	err = r.be.Save(ctx, h, rd)
-	if err != nil {
-		debug.Log("Save(%v) error: %v", h, err)
-		return err
+	for try := 1; ; try++ {
+		err = r.be.Save(ctx, h, rd)
+		if err != nil {
+			debug.Log("Save(%v) error: %v", h, err)
+			return err
+		}
+
+		debug.Log("saved as %v", h)
+
+		if !r.verifyUploads || r.dryRun {
+			break
+		}
+
+		err = r.verifyPack(ctx, h, id, int64(rd.Length()), beHash)
+		if err == nil {
+			break
+		}
+
+		debug.Log("verifying %v failed: %v", h, err)
+		if try >= maxUploadTries {
+			return errors.Wrapf(err, "upload of %v failed %d times", h, try)
+		}
+
+		// remove the broken file and upload it again
+		if err := r.be.Remove(ctx, h); err != nil {
+			debug.Log("Remove(%v) error: %v", h, err)
+		}
+		if err := rd.Rewind(); err != nil {
+			return err
+		}
 	}
 
-	debug.Log("saved as %v", h)
-
 	err = p.tmpfile.Close()
 	if err != nil {
 		return errors.Wrap(err, "close tempfile")
@@ -153,6 +180,57 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 	return r.SaveFullIndex(ctx)
 }
 
+// maxUploadTries is the number of times a pack file is uploaded before giving
+// up, if verification of the upload fails.
+const maxUploadTries = 3
+
+// verifyPack checks that the pack file h with the given id was stored
+// correctly. If the backend reports a checksum computed by its Hasher(), it is
+// compared with beHash. Otherwise the file is downloaded again and its SHA-256
+// hash is compared with the id.
+func (r *Repository) verifyPack(ctx context.Context, h restic.Handle, id restic.ID, size int64, beHash []byte) error {
+	// bypass the cache, tree packs were stored there during the upload
+	be := r.be
+	if cb, ok := be.(*cache.Backend); ok {
+		be = cb.Backend
+	}
+
+	fi, err := be.Stat(ctx, h)
+	if err != nil {
+		return errors.Wrap(err, "Stat")
+	}
+
+	if fi.Size != size {
+		return errors.Errorf("pack %v has size %d in the backend, want %d", id.Str(), fi.Size, size)
+	}
+
+	if beHash != nil && len(fi.Hash) > 0 {
+		if !bytes.Equal(fi.Hash, beHash) {
+			return errors.Errorf("pack %v has checksum %x in the backend, want %x", id.Str(), fi.Hash, beHash)
+		}
+		return nil
+	}
+
+	var got restic.ID
+	err = be.Load(ctx, h, 0, 0, func(rd io.Reader) error {
+		hrd := hashing.NewReader(rd, sha256.New())
+		_, err := io.Copy(ioutil.Discard, hrd)
+		if err != nil {
+			return err
+		}
+		got = restic.IDFromHash(hrd.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "Load")
+	}
+
+	if !got.Equal(id) {
+		return errors.Errorf("pack %v read back from the backend has hash %v", id.Str(), got.Str())
+	}
+	return nil
+}
+
 // countPacker returns the number of open (unfinished) packers.
 func (r *packerManager) countPacker() int {
 	r.pm.Lock()
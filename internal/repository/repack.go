@@ -25,6 +25,12 @@ const numRepackWorkers = 8
 // The map keepBlobs is modified by Repack, it is used to keep track of which
 // blobs have been processed.
 func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, keepBlobs restic.BlobSet, p *progress.Counter) (obsoletePacks restic.IDSet, err error) {
+	return repack(ctx, repo, repo, packs, keepBlobs, p)
+}
+
+// repack works like Repack, but loads the packs from src and saves the blobs
+// to dst.
+func repack(ctx context.Context, src, dst restic.Repository, packs restic.IDSet, keepBlobs restic.BlobSet, p *progress.Counter) (obsoletePacks restic.IDSet, err error) {
 	debug.Log("repacking %d packs while keeping %d blobs", len(packs), len(keepBlobs))
 
 	wg, wgCtx := errgroup.WithContext(ctx)
@@ -57,7 +63,7 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 			// load the complete pack into a temp file
 			h := restic.Handle{Type: restic.PackFile, Name: packID.String()}
 
-			tempfile, hash, packLength, err := DownloadAndHash(wgCtx, repo.Backend(), h)
+			tempfile, hash, packLength, err := DownloadAndHash(wgCtx, src.Backend(), h)
 			if err != nil {
 				return errors.Wrap(err, "Repack")
 			}
@@ -92,7 +98,7 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 		for job := range processQueue {
 			tempfile, packID, packLength := job.tempfile, job.hash, job.packLength
 
-			blobs, _, err := pack.List(repo.Key(), tempfile, packLength)
+			blobs, _, err := pack.List(src.Key(), tempfile, packLength)
 			if err != nil {
 				return err
 			}
@@ -127,8 +133,7 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 						h, tempfile.Name(), len(buf), n)
 				}
 
-				nonce, ciphertext := buf[:repo.Key().NonceSize()], buf[repo.Key().NonceSize():]
-				plaintext, err := repo.Key().Open(ciphertext[:0], nonce, ciphertext, nil)
+				plaintext, err := DecryptBlob(src.Key(), entry, buf)
 				if err != nil {
 					return err
 				}
@@ -154,7 +159,7 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 				}
 
 				// We do want to save already saved blobs!
-				_, _, err = repo.SaveBlob(wgCtx, entry.Type, plaintext, entry.ID, true)
+				_, _, err = dst.SaveBlob(wgCtx, entry.Type, plaintext, entry.ID, true)
 				if err != nil {
 					return err
 				}
@@ -182,7 +187,7 @@ func Repack(ctx context.Context, repo restic.Repository, packs restic.IDSet, kee
 		return nil, err
 	}
 
-	if err := repo.Flush(ctx); err != nil {
+	if err := dst.Flush(ctx); err != nil {
 		return nil, err
 	}
 
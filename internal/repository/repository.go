@@ -21,10 +21,15 @@ import (
 	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/ui/progress"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/minio/sha256-simd"
 	"golang.org/x/sync/errgroup"
 )
 
+// ErrWriteOnly is returned when data is loaded from a repository which was
+// opened with a write-only key.
+var ErrWriteOnly = errors.Fatal("data cannot be read with a write-only key")
+
 // Repository is used to access a repository in a backend.
 type Repository struct {
 	be      restic.Backend
@@ -34,10 +39,21 @@ type Repository struct {
 	idx     *MasterIndex
 	Cache   *cache.Cache
 
+	// sealedKey is set if the repository was opened with a write-only key. It
+	// is key sealed for the public key of the repository, and is prepended to
+	// all data encrypted with key.
+	sealedKey []byte
+
 	noAutoIndexUpdate bool
+	compression       CompressionMode
+	verifyUploads     bool
+	dryRun            bool
 
 	treePM *packerManager
 	dataPM *packerManager
+
+	allocEnc sync.Once
+	enc      *zstd.Encoder
 }
 
 // New returns a new repository with backend be.
@@ -58,6 +74,12 @@ func (r *Repository) DisableAutoIndexUpdate() {
 	r.noAutoIndexUpdate = true
 }
 
+// SetCompression sets the compression mode used for new data. Compression is
+// only available for repositories with version 2 or later.
+func (r *Repository) SetCompression(mode CompressionMode) {
+	r.compression = mode
+}
+
 // Config returns the repository configuration.
 func (r *Repository) Config() restic.Config {
 	return r.cfg
@@ -76,6 +98,18 @@ func (r *Repository) UseCache(c *cache.Cache) {
 // SetDryRun sets the repo backend into dry-run mode.
 func (r *Repository) SetDryRun() {
 	r.be = dryrun.New(r.be)
+	r.dryRun = true
+}
+
+// SetVerifyUploads enables checking each uploaded pack file, it is uploaded
+// again if it was not stored correctly.
+func (r *Repository) SetVerifyUploads(verify bool) {
+	r.verifyUploads = verify
+}
+
+// WriteOnly returns true if the repository was opened with a write-only key.
+func (r *Repository) WriteOnly() bool {
+	return r.sealedKey != nil
 }
 
 // PrefixLength returns the number of bytes required so that all prefixes of
@@ -94,6 +128,11 @@ func (r *Repository) LoadAndDecrypt(ctx context.Context, buf []byte, t restic.Fi
 
 	debug.Log("load %v with id %v", t, id)
 
+	// lock files can be read by all keys
+	if r.WriteOnly() && t != restic.LockFile {
+		return nil, ErrWriteOnly
+	}
+
 	if t == restic.ConfigFile {
 		id = restic.ID{}
 	}
@@ -118,13 +157,36 @@ func (r *Repository) LoadAndDecrypt(ctx context.Context, buf []byte, t restic.Fi
 		return nil, errors.Errorf("load %v: invalid data returned", h)
 	}
 
-	nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
-	plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	plaintext, err := r.decryptUnpacked(t, buf)
 	if err != nil {
 		return nil, err
 	}
 
-	return plaintext, nil
+	if t == restic.ConfigFile {
+		return plaintext, nil
+	}
+
+	return r.decompressUnpacked(plaintext)
+}
+
+// decryptUnpacked decrypts the file buf of type t in place.
+func (r *Repository) decryptUnpacked(t restic.FileType, buf []byte) ([]byte, error) {
+	nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
+
+	if t == restic.LockFile && r.cfg.LockKey != nil {
+		plaintext, err := r.cfg.LockKey.Open(ciphertext[:0], nonce, ciphertext, nil)
+		if err == nil {
+			return plaintext, nil
+		}
+	}
+
+	plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if errors.Cause(err) == crypto.ErrUnauthenticated {
+		// the file may have been saved with a write-only key
+		return r.key.OpenSealed(buf)
+	}
+
+	return plaintext, err
 }
 
 type haver interface {
@@ -161,6 +223,10 @@ func sortCachedPacksFirst(cache haver, blobs []restic.PackedBlob) {
 func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.ID, buf []byte) ([]byte, error) {
 	debug.Log("load %v with id %v (buf len %v, cap %d)", t, id, len(buf), cap(buf))
 
+	if r.WriteOnly() {
+		return nil, ErrWriteOnly
+	}
+
 	// lookup packs
 	blobs := r.idx.Lookup(restic.BlobHandle{ID: id, Type: t})
 	if len(blobs) == 0 {
@@ -208,9 +274,8 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 			continue
 		}
 
-		// decrypt
-		nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
-		plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+		// decrypt and decompress
+		plaintext, err := DecryptBlob(r.key, blob.Blob, buf)
 		if err != nil {
 			lastError = errors.Errorf("decrypting blob %v failed: %v", id, err)
 			continue
@@ -222,9 +287,14 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 			continue
 		}
 
+		if len(plaintext) > cap(buf) {
+			return plaintext, nil
+		}
+
 		// move decrypted data to the start of the buffer
+		buf = buf[:len(plaintext)]
 		copy(buf, plaintext)
-		return buf[:len(plaintext)], nil
+		return buf, nil
 	}
 
 	if lastError != nil {
@@ -256,9 +326,22 @@ func (r *Repository) LookupBlobSize(id restic.ID, tpe restic.BlobType) (uint, bo
 func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data []byte, id restic.ID) error {
 	debug.Log("save id %v (%v, %d bytes)", id, t, len(data))
 
+	uncompressedLength := 0
+	if r.cfg.Version > 1 {
+		// tree blobs are always compressed, data blobs only if the user did
+		// not disable compression. Blobs saved with a write-only key are
+		// always compressed, so that the length of the plaintext is
+		// recorded in the pack header.
+		if r.compression != CompressionOff || t != restic.DataBlob || r.WriteOnly() {
+			uncompressedLength = len(data)
+			data = r.getZstdEncoder().EncodeAll(data, nil)
+		}
+	}
+
 	nonce := crypto.NewRandomNonce()
 
-	ciphertext := make([]byte, 0, restic.CiphertextLength(len(data)))
+	ciphertext := make([]byte, 0, len(r.sealedKey)+restic.CiphertextLength(len(data)))
+	ciphertext = append(ciphertext, r.sealedKey...)
 	ciphertext = append(ciphertext, nonce...)
 
 	// encrypt blob
@@ -282,7 +365,7 @@ func (r *Repository) SaveAndEncrypt(ctx context.Context, t restic.BlobType, data
 	}
 
 	// save ciphertext
-	_, err = packer.Add(t, id, ciphertext)
+	_, err = packer.Add(t, id, ciphertext, uncompressedLength)
 	if err != nil {
 		return err
 	}
@@ -313,12 +396,22 @@ func (r *Repository) SaveJSONUnpacked(ctx context.Context, t restic.FileType, it
 // SaveUnpacked encrypts data and stores it in the backend. Returned is the
 // storage hash.
 func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []byte) (id restic.ID, err error) {
-	ciphertext := restic.NewBlobBuffer(len(p))
+	if t != restic.ConfigFile {
+		p = r.compressUnpacked(p)
+	}
+
+	key, sealedKey := r.key, r.sealedKey
+	if t == restic.LockFile && r.cfg.LockKey != nil {
+		key, sealedKey = r.cfg.LockKey, nil
+	}
+
+	ciphertext := restic.NewBlobBuffer(len(sealedKey) + len(p))
 	ciphertext = ciphertext[:0]
+	ciphertext = append(ciphertext, sealedKey...)
 	nonce := crypto.NewRandomNonce()
 	ciphertext = append(ciphertext, nonce...)
 
-	ciphertext = r.key.Seal(ciphertext, nonce, p, nil)
+	ciphertext = key.Seal(ciphertext, nonce, p, nil)
 
 	if t == restic.ConfigFile {
 		id = restic.ID{}
@@ -586,20 +679,50 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 		return err
 	}
 
+	r.keyName = key.Name()
+
+	if key.WriteOnly {
+		// the config cannot be loaded, use the copy stored in the key, which
+		// was checked against the config file by SearchKey. New data is
+		// encrypted with a random key, which is sealed for the public key of
+		// the repository.
+		if key.config.Version < restic.SealedRepoVersion || key.config.Version > restic.MaxRepoVersion {
+			return errors.Fatalf("write-only keys cannot be used with repository version %v", key.config.Version)
+		}
+		r.cfg = *key.config
+		r.key = crypto.NewRandomKey()
+		r.sealedKey = r.key.SealFor(r.cfg.PublicKey)
+		r.dataPM.key = r.key
+		r.treePM.key = r.key
+		return nil
+	}
+
 	r.key = key.master
+	r.sealedKey = nil
 	r.dataPM.key = key.master
 	r.treePM.key = key.master
-	r.keyName = key.Name()
 	r.cfg, err = restic.LoadConfig(ctx, r)
 	if err != nil {
 		return errors.Fatalf("config cannot be loaded: %v", err)
 	}
+
+	if r.cfg.PrivateKey != nil {
+		r.key.SetPrivateKey(r.cfg.PrivateKey)
+	}
 	return nil
 }
 
 // Init creates a new master key with the supplied password, initializes and
 // saves the repository config.
-func (r *Repository) Init(ctx context.Context, password string, chunkerPolynomial *chunker.Pol) error {
+func (r *Repository) Init(ctx context.Context, version uint, password string, chunkerPolynomial *chunker.Pol) error {
+	if version > restic.MaxRepoVersion {
+		return fmt.Errorf("repository version %v too high", version)
+	}
+
+	if version < restic.MinRepoVersion {
+		return fmt.Errorf("repository version %v too low", version)
+	}
+
 	has, err := r.be.Test(ctx, restic.Handle{Type: restic.ConfigFile})
 	if err != nil {
 		return err
@@ -608,7 +731,7 @@ func (r *Repository) Init(ctx context.Context, password string, chunkerPolynomia
 		return errors.New("repository master key and config already initialized")
 	}
 
-	cfg, err := restic.CreateConfig()
+	cfg, err := restic.CreateConfig(version)
 	if err != nil {
 		return err
 	}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -13,6 +14,8 @@ import (
 	"time"
 
 	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/repository"
@@ -25,7 +28,11 @@ var testSizes = []int{5, 23, 2<<18 + 23, 1 << 20}
 var rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
 
 func TestSave(t *testing.T) {
-	repo, cleanup := repository.TestRepository(t)
+	repository.TestAllVersions(t, testSave)
+}
+
+func testSave(t *testing.T, version uint) {
+	repo, cleanup := repository.TestRepositoryWithVersion(t, version)
 	defer cleanup()
 
 	for _, size := range testSizes {
@@ -60,7 +67,11 @@ func TestSave(t *testing.T) {
 }
 
 func TestSaveFrom(t *testing.T) {
-	repo, cleanup := repository.TestRepository(t)
+	repository.TestAllVersions(t, testSaveFrom)
+}
+
+func testSaveFrom(t *testing.T, version uint) {
+	repo, cleanup := repository.TestRepositoryWithVersion(t, version)
 	defer cleanup()
 
 	for _, size := range testSizes {
@@ -92,6 +103,31 @@ func TestSaveFrom(t *testing.T) {
 	}
 }
 
+func TestSaveCompressed(t *testing.T) {
+	repo, cleanup := repository.TestRepositoryWithVersion(t, 2)
+	defer cleanup()
+
+	data := bytes.Repeat([]byte("compressible data "), 10000)
+	id, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(context.Background()))
+
+	blobs := repo.Index().Lookup(restic.BlobHandle{ID: id, Type: restic.DataBlob})
+	rtest.Equals(t, 1, len(blobs))
+	rtest.Assert(t, blobs[0].IsCompressed(), "blob %v was not stored compressed", id.Str())
+	rtest.Equals(t, uint(len(data)), blobs[0].DataLength())
+	rtest.Assert(t, blobs[0].Length < uint(len(data)),
+		"compressed blob is not smaller than the data: %v >= %v", blobs[0].Length, len(data))
+
+	size, found := repo.LookupBlobSize(id, restic.DataBlob)
+	rtest.Assert(t, found, "blob %v not found", id.Str())
+	rtest.Equals(t, uint(len(data)), size)
+
+	buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(buf, data), "data does not match")
+}
+
 func BenchmarkSaveAndEncrypt(t *testing.B) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
@@ -151,7 +187,11 @@ func BenchmarkLoadTree(t *testing.B) {
 }
 
 func TestLoadBlob(t *testing.T) {
-	repo, cleanup := repository.TestRepository(t)
+	repository.TestAllVersions(t, testLoadBlob)
+}
+
+func testLoadBlob(t *testing.T, version uint) {
+	repo, cleanup := repository.TestRepositoryWithVersion(t, version)
 	defer cleanup()
 
 	length := 1000000
@@ -252,7 +292,11 @@ func BenchmarkLoadAndDecrypt(b *testing.B) {
 }
 
 func TestLoadJSONUnpacked(t *testing.T) {
-	repo, cleanup := repository.TestRepository(t)
+	repository.TestAllVersions(t, testLoadJSONUnpacked)
+}
+
+func testLoadJSONUnpacked(t *testing.T, version uint) {
+	repo, cleanup := repository.TestRepositoryWithVersion(t, version)
 	defer cleanup()
 
 	if rtest.BenchArchiveDirectory == "" {
@@ -515,3 +559,162 @@ func TestDownloadAndHashErrors(t *testing.T) {
 		})
 	}
 }
+
+// corruptingBackend flips a bit in the first corrupt uploads of pack files.
+type corruptingBackend struct {
+	restic.Backend
+	corrupt int
+	saves   int
+	noHash  bool
+}
+
+func (be *corruptingBackend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	fi, err := be.Backend.Stat(ctx, h)
+	if be.noHash {
+		// force reading back the file
+		fi.Hash = nil
+	}
+	return fi, err
+}
+
+func (be *corruptingBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	if h.Type != restic.PackFile {
+		return be.Backend.Save(ctx, h, rd)
+	}
+
+	be.saves++
+	if be.saves > be.corrupt {
+		return be.Backend.Save(ctx, h, rd)
+	}
+
+	buf, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	buf[len(buf)/2] ^= 0x10
+	return be.Backend.Save(ctx, h, restic.NewByteReader(buf, be.Hasher()))
+}
+
+func TestVerifyUploads(t *testing.T) {
+	for _, test := range []struct {
+		corrupt int
+		noHash  bool
+		saves   int
+		fail    bool
+	}{
+		{0, false, 1, false},
+		{1, false, 2, false},
+		{1, true, 2, false},
+		{5, false, 3, true},
+		{5, true, 3, true},
+	} {
+		t.Run("", func(t *testing.T) {
+			be := &corruptingBackend{Backend: mem.New(), corrupt: test.corrupt, noHash: test.noHash}
+			repo, cleanup := repository.TestRepositoryWithBackend(t, be, 0)
+			defer cleanup()
+			repo.(*repository.Repository).SetVerifyUploads(true)
+
+			data := rtest.Random(23, 1234)
+			id, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{}, false)
+			rtest.OK(t, err)
+
+			err = repo.Flush(context.TODO())
+			rtest.Equals(t, test.saves, be.saves)
+			if test.fail {
+				rtest.Assert(t, err != nil, "corrupted upload was not detected")
+				return
+			}
+			rtest.OK(t, err)
+
+			buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+			rtest.OK(t, err)
+			rtest.Assert(t, bytes.Equal(buf, data), "data does not match")
+		})
+	}
+}
+
+func TestWriteOnlyKey(t *testing.T) {
+	ctx := context.TODO()
+	be := mem.New()
+	r, cleanup := repository.TestRepositoryWithBackend(t, be, 2)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+
+	_, err := repository.AddWriteOnlyKey(ctx, repo, "write-only", "", "", repo.Config())
+	rtest.Assert(t, err != nil, "write-only key was added to a version 2 repository")
+
+	// add a key pair to the config
+	cfg := repo.Config()
+	cfg.Version = restic.SealedRepoVersion
+	cfg.PublicKey, cfg.PrivateKey = crypto.NewKeyPair()
+	cfg.LockKey = crypto.NewRandomKey()
+	rtest.OK(t, be.Remove(ctx, restic.Handle{Type: restic.ConfigFile}))
+	_, err = repo.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
+	rtest.OK(t, err)
+
+	_, err = repository.AddWriteOnlyKey(ctx, repo, "write-only", "", "", cfg)
+	rtest.OK(t, err)
+
+	writer := repository.New(be)
+	rtest.OK(t, writer.SearchKey(ctx, "write-only", 0, ""))
+	rtest.Assert(t, writer.WriteOnly(), "repository was not opened with the write-only key")
+	rtest.Assert(t, writer.Config().PrivateKey == nil, "write-only key contains the private key")
+
+	data := rtest.Random(23, 10*1024)
+	id, _, err := writer.SaveBlob(ctx, restic.DataBlob, data, restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, writer.Flush(ctx))
+
+	snID, err := writer.SaveJSONUnpacked(ctx, restic.SnapshotFile, &restic.Snapshot{Hostname: "foo"})
+	rtest.OK(t, err)
+
+	lock, err := restic.NewLock(ctx, writer)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, lock.Unlock())
+	}()
+
+	_, err = writer.LoadBlob(ctx, restic.DataBlob, id, nil)
+	rtest.Assert(t, err == repository.ErrWriteOnly, "unexpected error loading blob: %v", err)
+
+	var sn restic.Snapshot
+	err = writer.LoadJSONUnpacked(ctx, restic.SnapshotFile, snID, &sn)
+	rtest.Assert(t, err == repository.ErrWriteOnly, "unexpected error loading snapshot: %v", err)
+
+	// everything can be read with the normal key
+	reader := repository.New(be)
+	rtest.OK(t, reader.SearchKey(ctx, rtest.TestPassword, 0, ""))
+	rtest.OK(t, reader.LoadIndex(ctx))
+
+	buf, err := reader.LoadBlob(ctx, restic.DataBlob, id, nil)
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(data, buf), "wrong data returned")
+
+	rtest.OK(t, reader.LoadJSONUnpacked(ctx, restic.SnapshotFile, snID, &sn))
+	rtest.Equals(t, "foo", sn.Hostname)
+
+	rtest.OK(t, reader.List(ctx, restic.PackFile, func(packID restic.ID, size int64) error {
+		blobs, _, err := reader.ListPack(ctx, packID, size)
+		rtest.OK(t, err)
+		rtest.Equals(t, 1, len(blobs))
+		rtest.Equals(t, id, blobs[0].ID)
+		return nil
+	}))
+
+	locks := 0
+	rtest.OK(t, restic.ForAllLocks(ctx, reader, nil, func(id restic.ID, lock *restic.Lock, err error) error {
+		rtest.OK(t, err)
+		locks++
+		return nil
+	}))
+	rtest.Equals(t, 1, locks)
+
+	// the key cannot be used anymore once the config was replaced
+	cfg.ID = restic.NewRandomID().String()
+	rtest.OK(t, be.Remove(ctx, restic.Handle{Type: restic.ConfigFile}))
+	_, err = reader.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
+	rtest.OK(t, err)
+
+	err = repository.New(be).SearchKey(ctx, "write-only", 0, "")
+	rtest.Assert(t, err != nil && err != repository.ErrNoKeyFound, "unexpected error for outdated write-only key: %v", err)
+}
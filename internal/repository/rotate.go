@@ -0,0 +1,541 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/progress"
+)
+
+// MasterKeyRotation re-encrypts a repository with a new master key. The new
+// master key is stored in a separate key file until the rotation is finished,
+// so that an interrupted rotation can be resumed by starting it again with
+// the same password.
+//
+// The steps of a rotation are RewritePacks, RewriteSnapshots, SwitchConfig,
+// RewrapKeys, removing the files returned by ObsoleteFiles and Finish, in
+// this order. Until SwitchConfig has been called, the repository can still be
+// used with the old keys. When a rotation is resumed after the config has
+// been switched, the first three steps must be skipped, see Switched.
+type MasterKeyRotation struct {
+	repo *Repository
+
+	// oldRepo and newRepo use the old and the new master key. Once the config
+	// has been switched, oldRepo is nil and newRepo is repo.
+	oldRepo *Repository
+	newRepo *Repository
+
+	marker   *Key
+	password string
+	keys     []rotationKey
+	unknown  []string
+}
+
+// rotationKey is a key file together with the password which opened it.
+type rotationKey struct {
+	*Key
+	password string
+}
+
+// NewMasterKeyRotation prepares the rotation of the master key of repo, which
+// must have been opened with password. The key files are opened with password
+// or otherPasswords, keys which cannot be opened are reported by UnknownKeys.
+// A new master key is created unless an interrupted rotation is resumed, it is
+// only saved by RewritePacks. The repository is not modified, so the caller
+// can still abort the rotation, for example because of unknown keys.
+func NewMasterKeyRotation(ctx context.Context, repo *Repository, password string, otherPasswords []string) (*MasterKeyRotation, error) {
+	if repo.WriteOnly() {
+		return nil, ErrWriteOnly
+	}
+
+	rot := &MasterKeyRotation{
+		repo:     repo,
+		password: password,
+	}
+
+	passwords := append([]string{password}, otherPasswords...)
+	err := repo.List(ctx, restic.KeyFile, func(id restic.ID, size int64) error {
+		name := id.String()
+		k, err := LoadKey(ctx, repo, name)
+		if err != nil {
+			return err
+		}
+
+		if k.Rotation {
+			if rot.marker != nil {
+				return errors.Fatal("found more than one key for the new master key")
+			}
+
+			rot.marker, err = OpenKey(ctx, repo, name, password)
+			if errors.Cause(err) == crypto.ErrUnauthenticated {
+				return errors.Fatal("the interrupted master key rotation was started with a different password")
+			}
+			return err
+		}
+
+		for _, pw := range passwords {
+			k, err := OpenKey(ctx, repo, name, pw)
+			if errors.Cause(err) == crypto.ErrUnauthenticated {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			debug.Log("opened key %v", name)
+			rot.keys = append(rot.keys, rotationKey{k, pw})
+			return nil
+		}
+
+		debug.Log("unable to open key %v", name)
+		rot.unknown = append(rot.unknown, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rot.marker == nil {
+		rot.marker = &Key{
+			Rotation: true,
+			master:   crypto.NewRandomKey(),
+		}
+	}
+
+	if sameKey(rot.marker.master, repo.key) {
+		// the repository was opened with the new master key
+		rot.newRepo = repo
+		return rot, nil
+	}
+
+	rot.oldRepo = repo
+	rot.newRepo = New(repo.be)
+	rot.newRepo.cfg = repo.cfg
+	rot.newRepo.Cache = repo.Cache
+	rot.newRepo.compression = repo.compression
+	rot.newRepo.verifyUploads = repo.verifyUploads
+	rot.newRepo.useKey(rot.marker)
+
+	return rot, nil
+}
+
+// saveMarker saves the key file for the new master key, unless it was already
+// saved by an interrupted rotation.
+func (rot *MasterKeyRotation) saveMarker(ctx context.Context) error {
+	if rot.marker.Name() != "" {
+		return nil
+	}
+
+	buf, err := json.Marshal(rot.marker.master)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	err = saveKey(ctx, rot.repo, rot.marker, rot.password, "", "", buf)
+	if err != nil {
+		return err
+	}
+	debug.Log("saved new master key as %v", rot.marker.Name())
+
+	// the name of the key is only known now
+	rot.newRepo.useKey(rot.marker)
+	return nil
+}
+
+// useKey switches r to the master key in k. It must not be called while r is
+// used concurrently.
+func (r *Repository) useKey(k *Key) {
+	r.key = k.master
+	r.keyName = k.Name()
+	r.dataPM.key = k.master
+	r.treePM.key = k.master
+}
+
+// sameKey returns true if a and b contain the same keys.
+func sameKey(a, b *crypto.Key) bool {
+	return a.EncryptionKey == b.EncryptionKey && a.MACKey.K == b.MACKey.K
+}
+
+// Switched returns true if the config is already encrypted with the new
+// master key.
+func (rot *MasterKeyRotation) Switched() bool {
+	return rot.oldRepo == nil
+}
+
+// UnknownKeys returns the names of the key files which could not be opened.
+// These can be removed by RewrapKeys.
+func (rot *MasterKeyRotation) UnknownKeys() []string {
+	return rot.unknown
+}
+
+// RewritePacks saves all blobs again with the new master key. Blobs which are
+// already contained in an index encrypted with the new master key are
+// skipped, these were saved by an interrupted rotation. The progress counter
+// p is set to the number of packs which need to be rewritten. Before that, the
+// new master key is saved.
+func (rot *MasterKeyRotation) RewritePacks(ctx context.Context, p *progress.Counter) error {
+	if rot.Switched() {
+		return errors.New("config has already been switched")
+	}
+
+	if err := rot.saveMarker(ctx); err != nil {
+		return err
+	}
+
+	oldIndex := NewMasterIndex()
+	newIndexes := restic.NewIDSet()
+	err := ForAllIndexes(ctx, rot.oldRepo, func(id restic.ID, idx *Index, oldFormat bool, err error) error {
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			newIndexes.Insert(id)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		oldIndex.Insert(idx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for id := range newIndexes {
+		buf, err := rot.newRepo.LoadAndDecrypt(ctx, nil, restic.IndexFile, id)
+		if err != nil {
+			return err
+		}
+
+		idx, _, err := DecodeIndex(buf, id)
+		if err != nil {
+			return err
+		}
+		rot.newRepo.idx.Insert(idx)
+	}
+
+	err = rot.newRepo.idx.MergeFinalIndexes()
+	if err != nil {
+		return err
+	}
+
+	keepBlobs := restic.NewBlobSet()
+	packs := restic.NewIDSet()
+	for blob := range oldIndex.Each(ctx) {
+		if keepBlobs.Has(blob.BlobHandle) || rot.newRepo.idx.Has(blob.BlobHandle) {
+			continue
+		}
+
+		keepBlobs.Insert(blob.BlobHandle)
+		packs.Insert(blob.PackID)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	debug.Log("rewriting %d blobs in %d packs", len(keepBlobs), len(packs))
+	p.SetMax(uint64(len(packs)))
+
+	_, err = repack(ctx, rot.oldRepo, rot.newRepo, packs, keepBlobs, p)
+	return err
+}
+
+// RewriteSnapshots saves all snapshots again with the new master key and
+// removes the old files. As the ID of a snapshot is the hash of its
+// ciphertext, all snapshots get new IDs. The parent and original snapshot
+// referenced by a snapshot are replaced by their new IDs, which requires
+// saving the referenced snapshots first.
+func (rot *MasterKeyRotation) RewriteSnapshots(ctx context.Context) error {
+	if rot.Switched() {
+		return errors.New("config has already been switched")
+	}
+
+	var ids restic.IDs
+	err := rot.oldRepo.List(ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// snapshots which were already saved with the new master key by an
+	// interrupted rotation, by the hash of their plaintext
+	rewritten := make(map[restic.ID]restic.ID)
+	oldSnapshots := make(map[restic.ID]map[string]json.RawMessage)
+	for _, id := range ids {
+		buf, err := rot.oldRepo.LoadAndDecrypt(ctx, nil, restic.SnapshotFile, id)
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			buf, err = rot.newRepo.LoadAndDecrypt(ctx, nil, restic.SnapshotFile, id)
+			if err != nil {
+				return err
+			}
+
+			rewritten[restic.Hash(buf)] = id
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var sn map[string]json.RawMessage
+		err = json.Unmarshal(buf, &sn)
+		if err != nil {
+			return errors.Wrapf(err, "snapshot %v", id.Str())
+		}
+		oldSnapshots[id] = sn
+	}
+
+	// newIDs maps the IDs of the old snapshots to the new ones, order
+	// contains the old snapshots after the snapshots they reference
+	newIDs := make(map[restic.ID]restic.ID)
+	var order restic.IDs
+
+	var rewrite func(id restic.ID, seen restic.IDSet) error
+	rewrite = func(id restic.ID, seen restic.IDSet) error {
+		if _, ok := newIDs[id]; ok {
+			return nil
+		}
+		if seen.Has(id) {
+			return errors.Errorf("snapshot %v references itself", id.Str())
+		}
+		seen.Insert(id)
+
+		sn := oldSnapshots[id]
+		for _, field := range []string{"parent", "original"} {
+			raw, ok := sn[field]
+			if !ok {
+				continue
+			}
+
+			var ref restic.ID
+			err := json.Unmarshal(raw, &ref)
+			if err != nil {
+				return errors.Wrapf(err, "snapshot %v", id.Str())
+			}
+			if _, ok := oldSnapshots[ref]; !ok {
+				// the snapshot was removed, or was already rewritten and
+				// contains the new ID
+				continue
+			}
+
+			err = rewrite(ref, seen)
+			if err != nil {
+				return err
+			}
+
+			sn[field], err = json.Marshal(newIDs[ref])
+			if err != nil {
+				return errors.Wrap(err, "Marshal")
+			}
+		}
+
+		buf, err := json.Marshal(sn)
+		if err != nil {
+			return errors.Wrap(err, "Marshal")
+		}
+
+		newID, ok := rewritten[restic.Hash(buf)]
+		if !ok {
+			newID, err = rot.newRepo.SaveUnpacked(ctx, restic.SnapshotFile, buf)
+			if err != nil {
+				return err
+			}
+		}
+		debug.Log("snapshot %v saved as %v", id, newID)
+
+		newIDs[id] = newID
+		order = append(order, id)
+		return nil
+	}
+
+	for id := range oldSnapshots {
+		err := rewrite(id, restic.NewIDSet())
+		if err != nil {
+			return err
+		}
+	}
+
+	// the old files are removed only after all snapshots were saved, and
+	// snapshots before the ones they reference. This ensures that a resumed
+	// rotation finds all old snapshots which are referenced.
+	for i := len(order) - 1; i >= 0; i-- {
+		err := rot.oldRepo.be.Remove(ctx, restic.Handle{Type: restic.SnapshotFile, Name: order[i].String()})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SwitchConfig saves the config with the new master key. Afterwards, the
+// repository can only be opened with the new master key and the repository
+// passed to NewMasterKeyRotation uses the new master key. If the repository
+// supports write-only keys, a new key pair is generated, as the old private
+// key is known to everyone who knows the old master key. The repository must
+// not be used concurrently while the config is switched, this includes
+// refreshing locks.
+func (rot *MasterKeyRotation) SwitchConfig(ctx context.Context) error {
+	if rot.Switched() {
+		return errors.New("config has already been switched")
+	}
+
+	h := restic.Handle{Type: restic.ConfigFile}
+
+	// keep the old config file, in case uploading the new one fails
+	rawConfigFile, err := backend.LoadAll(ctx, nil, rot.repo.be, h)
+	if err != nil {
+		return errors.Wrap(err, "load config file failed")
+	}
+
+	cfg := rot.repo.cfg
+	if cfg.PublicKey != nil {
+		cfg.PublicKey, cfg.PrivateKey = crypto.NewKeyPair()
+		cfg.LockKey = crypto.NewRandomKey()
+	}
+
+	// most backends refuse to overwrite existing files, so remove the config
+	// file first
+	err = rot.repo.be.Remove(ctx, h)
+	if err != nil {
+		return errors.Wrap(err, "remove config failed")
+	}
+
+	_, err = rot.newRepo.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
+	if err != nil {
+		_ = rot.repo.be.Remove(ctx, h)
+		rerr := rot.repo.be.Save(ctx, h, restic.NewByteReader(rawConfigFile, rot.repo.be.Hasher()))
+		if rerr != nil {
+			return errors.Errorf("error uploading config (%v), re-uploading old config file failed as well (%v)", err, rerr)
+		}
+		return errors.Wrap(err, "save new config file failed")
+	}
+
+	rot.repo.useKey(rot.marker)
+	rot.repo.cfg = cfg
+	if cfg.PrivateKey != nil {
+		rot.repo.key.SetPrivateKey(cfg.PrivateKey)
+	}
+
+	rot.oldRepo = nil
+	rot.newRepo = rot.repo
+	return nil
+}
+
+// RewrapKeys replaces all keys which contain the old master key or an old
+// config with keys for the new master key, using the same password, username
+// and hostname. The unknown keys are only removed if removeUnknown is set,
+// otherwise RewrapKeys refuses to run if there are any.
+func (rot *MasterKeyRotation) RewrapKeys(ctx context.Context, removeUnknown bool) error {
+	if !rot.Switched() {
+		return errors.New("config has not been switched yet")
+	}
+
+	if len(rot.unknown) > 0 && !removeUnknown {
+		return errors.Errorf("unable to open keys %v", strings.Join(rot.unknown, ", "))
+	}
+
+	cfg := rot.repo.cfg
+	for _, k := range rot.keys {
+		var err error
+		switch {
+		case k.WriteOnly && cfg.PublicKey != nil && *k.config.PublicKey == *cfg.PublicKey:
+			continue
+		case !k.WriteOnly && sameKey(k.master, rot.repo.key):
+			continue
+		case k.WriteOnly:
+			_, err = AddWriteOnlyKey(ctx, rot.repo, k.password, k.Username, k.Hostname, cfg)
+		default:
+			_, err = AddKey(ctx, rot.repo, k.password, k.Username, k.Hostname, rot.repo.key)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "rewrap key %v", k.Name())
+		}
+
+		err = rot.repo.be.Remove(ctx, restic.Handle{Type: restic.KeyFile, Name: k.Name()})
+		if err != nil {
+			return err
+		}
+		debug.Log("rewrapped key %v", k.Name())
+	}
+
+	for _, name := range rot.unknown {
+		err := rot.repo.be.Remove(ctx, restic.Handle{Type: restic.KeyFile, Name: name})
+		if err != nil {
+			return err
+		}
+		debug.Log("removed unknown key %v", name)
+	}
+
+	return nil
+}
+
+// ObsoleteFiles returns the index files which are still encrypted with the
+// old master key and the pack files which are not referenced by any of the
+// new index files.
+func (rot *MasterKeyRotation) ObsoleteFiles(ctx context.Context) (indexes restic.IDSet, packs restic.IDSet, err error) {
+	if !rot.Switched() {
+		return nil, nil, errors.New("config has not been switched yet")
+	}
+
+	indexes = restic.NewIDSet()
+	usedPacks := restic.NewIDSet()
+	err = ForAllIndexes(ctx, rot.repo, func(id restic.ID, idx *Index, oldFormat bool, err error) error {
+		if errors.Cause(err) == crypto.ErrUnauthenticated {
+			indexes.Insert(id)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		usedPacks.Merge(idx.Packs())
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	packs = restic.NewIDSet()
+	err = rot.repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		if !usedPacks.Has(id) {
+			packs.Insert(id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return indexes, packs, nil
+}
+
+// Finish removes the key file holding the new master key. If no other key for
+// the password of the rotation exists, a new one is added first.
+func (rot *MasterKeyRotation) Finish(ctx context.Context) error {
+	if !rot.Switched() {
+		return errors.New("config has not been switched yet")
+	}
+
+	hasKey := false
+	for _, k := range rot.keys {
+		if !k.WriteOnly && k.password == rot.password {
+			hasKey = true
+		}
+	}
+
+	if !hasKey {
+		_, err := AddKey(ctx, rot.repo, rot.password, rot.marker.Username, rot.marker.Hostname, rot.repo.key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rot.repo.be.Remove(ctx, restic.Handle{Type: restic.KeyFile, Name: rot.marker.Name()})
+}
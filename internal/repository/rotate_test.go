@@ -0,0 +1,219 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/pack"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func finishRotation(t *testing.T, be restic.Backend, rot *repository.MasterKeyRotation) {
+	ctx := context.TODO()
+
+	if !rot.Switched() {
+		rtest.OK(t, rot.RewritePacks(ctx, nil))
+		rtest.OK(t, rot.RewriteSnapshots(ctx))
+		rtest.OK(t, rot.SwitchConfig(ctx))
+	}
+	rtest.OK(t, rot.RewrapKeys(ctx, true))
+
+	indexes, packs, err := rot.ObsoleteFiles(ctx)
+	rtest.OK(t, err)
+	for id := range indexes {
+		rtest.OK(t, be.Remove(ctx, restic.Handle{Type: restic.IndexFile, Name: id.String()}))
+	}
+	for id := range packs {
+		rtest.OK(t, be.Remove(ctx, restic.Handle{Type: restic.PackFile, Name: id.String()}))
+	}
+
+	rtest.OK(t, rot.Finish(ctx))
+}
+
+func TestMasterKeyRotation(t *testing.T) {
+	ctx := context.TODO()
+	be := mem.New()
+	r, cleanup := repository.TestRepositoryWithBackend(t, be, 2)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+	oldKey := repo.Key()
+
+	// add a key pair to the config
+	cfg := repo.Config()
+	cfg.Version = restic.SealedRepoVersion
+	cfg.PublicKey, cfg.PrivateKey = crypto.NewKeyPair()
+	cfg.LockKey = crypto.NewRandomKey()
+	rtest.OK(t, be.Remove(ctx, restic.Handle{Type: restic.ConfigFile}))
+	_, err := repo.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
+	rtest.OK(t, err)
+
+	_, err = repository.AddKey(ctx, repo, "other", "", "", repo.Key())
+	rtest.OK(t, err)
+	_, err = repository.AddWriteOnlyKey(ctx, repo, "write-only", "", "", cfg)
+	rtest.OK(t, err)
+	_, err = repository.AddKey(ctx, repo, "unknown", "", "", repo.Key())
+	rtest.OK(t, err)
+
+	var blobs []restic.ID
+	for i := 0; i < 5; i++ {
+		data := rtest.Random(i, 10*1024)
+		id, _, err := repo.SaveBlob(ctx, restic.DataBlob, data, restic.ID{}, false)
+		rtest.OK(t, err)
+		blobs = append(blobs, id)
+	}
+	rtest.OK(t, repo.Flush(ctx))
+
+	parent, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, &restic.Snapshot{Hostname: "foo"})
+	rtest.OK(t, err)
+	_, err = repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, &restic.Snapshot{Hostname: "bar", Parent: &parent, Original: &parent})
+	rtest.OK(t, err)
+
+	rot, err := repository.NewMasterKeyRotation(ctx, repo, rtest.TestPassword, []string{"other", "write-only"})
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(rot.UnknownKeys()))
+	rtest.Assert(t, !rot.Switched(), "config was switched before the rotation")
+
+	// interrupt the rotation before the config is switched, the repository
+	// can still be used with the old key
+	rtest.OK(t, rot.RewritePacks(ctx, nil))
+	rtest.OK(t, rot.RewriteSnapshots(ctx))
+
+	repo = repository.New(be)
+	rtest.OK(t, repo.SearchKey(ctx, rtest.TestPassword, 0, ""))
+	rtest.Assert(t, bytes.Equal(oldKey.EncryptionKey[:], repo.Key().EncryptionKey[:]), "repository was not opened with the old key")
+
+	rot, err = repository.NewMasterKeyRotation(ctx, repo, rtest.TestPassword, []string{"other", "write-only"})
+	rtest.OK(t, err)
+	rtest.Assert(t, !rot.Switched(), "config was switched before the rotation")
+
+	// interrupt the rotation after the config was switched
+	rtest.OK(t, rot.RewritePacks(ctx, nil))
+	rtest.OK(t, rot.RewriteSnapshots(ctx))
+	rtest.OK(t, rot.SwitchConfig(ctx))
+
+	repo = repository.New(be)
+	rtest.OK(t, repo.SearchKey(ctx, rtest.TestPassword, 0, ""))
+	rtest.Assert(t, !bytes.Equal(oldKey.EncryptionKey[:], repo.Key().EncryptionKey[:]), "repository was opened with the old key")
+
+	rot, err = repository.NewMasterKeyRotation(ctx, repo, rtest.TestPassword, []string{"other", "write-only"})
+	rtest.OK(t, err)
+	rtest.Assert(t, rot.Switched(), "config was not switched")
+
+	// unknown keys are only removed on request
+	err = rot.RewrapKeys(ctx, false)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), rot.UnknownKeys()[0]), "unexpected error for unknown keys: %v", err)
+	finishRotation(t, be, rot)
+
+	keys := 0
+	rtest.OK(t, be.List(ctx, restic.KeyFile, func(fi restic.FileInfo) error {
+		keys++
+		return nil
+	}))
+	rtest.Equals(t, 3, keys)
+
+	_, err = repository.SearchKey(ctx, repository.New(be), "unknown", 0, "")
+	rtest.Assert(t, err == repository.ErrNoKeyFound, "unexpected error for removed key: %v", err)
+
+	for _, pw := range []string{rtest.TestPassword, "other"} {
+		repo = repository.New(be)
+		rtest.OK(t, repo.SearchKey(ctx, pw, 0, ""))
+		rtest.OK(t, repo.LoadIndex(ctx))
+
+		for i, id := range blobs {
+			buf, err := repo.LoadBlob(ctx, restic.DataBlob, id, nil)
+			rtest.OK(t, err)
+			rtest.Assert(t, bytes.Equal(rtest.Random(i, 10*1024), buf), "wrong data returned for blob %v", i)
+		}
+
+		snapshots := make(map[string]*restic.Snapshot)
+		rtest.OK(t, restic.ForAllSnapshots(ctx, repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+			rtest.OK(t, err)
+			snapshots[sn.Hostname] = sn
+			return nil
+		}))
+		rtest.Equals(t, 2, len(snapshots))
+
+		// references to other snapshots use the new IDs
+		parentID := *snapshots["foo"].ID()
+		rtest.Assert(t, parentID != parent, "snapshot was not rewritten")
+		rtest.Equals(t, parentID, *snapshots["bar"].Parent)
+		rtest.Equals(t, parentID, *snapshots["bar"].Original)
+	}
+
+	writer := repository.New(be)
+	rtest.OK(t, writer.SearchKey(ctx, "write-only", 0, ""))
+	rtest.Assert(t, writer.WriteOnly(), "repository was not opened with the write-only key")
+	rtest.Assert(t, *writer.Config().PublicKey == *repo.Config().PublicKey, "write-only key contains the old public key")
+	rtest.Assert(t, *repo.Config().PublicKey != *cfg.PublicKey, "key pair was not replaced")
+
+	// no file can be decrypted with the old key anymore
+	rtest.OK(t, be.List(ctx, restic.PackFile, func(fi restic.FileInfo) error {
+		h := restic.Handle{Type: restic.PackFile, Name: fi.Name}
+		_, _, err := pack.List(oldKey, restic.ReaderAt(ctx, be, h), fi.Size)
+		rtest.Assert(t, err != nil, "pack %v can be decrypted with the old key", fi.Name)
+		return nil
+	}))
+
+	for _, tpe := range []restic.FileType{restic.IndexFile, restic.SnapshotFile} {
+		rtest.OK(t, be.List(ctx, tpe, func(fi restic.FileInfo) error {
+			var buf []byte
+			rtest.OK(t, be.Load(ctx, restic.Handle{Type: tpe, Name: fi.Name}, 0, 0, func(rd io.Reader) (err error) {
+				buf, err = ioutil.ReadAll(rd)
+				return err
+			}))
+
+			nonce, ciphertext := buf[:oldKey.NonceSize()], buf[oldKey.NonceSize():]
+			_, err := oldKey.Open(nil, nonce, ciphertext, nil)
+			rtest.Assert(t, err == crypto.ErrUnauthenticated, "%v can be decrypted with the old key", fi.Name)
+			return nil
+		}))
+	}
+}
+
+func TestMasterKeyRotationAbort(t *testing.T) {
+	ctx := context.TODO()
+	be := mem.New()
+	r, cleanup := repository.TestRepositoryWithBackend(t, be, 2)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+
+	_, err := repository.AddKey(ctx, repo, "unknown", "", "", repo.Key())
+	rtest.OK(t, err)
+
+	listKeys := func() restic.IDSet {
+		ids := restic.NewIDSet()
+		rtest.OK(t, repo.List(ctx, restic.KeyFile, func(id restic.ID, size int64) error {
+			ids.Insert(id)
+			return nil
+		}))
+		return ids
+	}
+	keys := listKeys()
+
+	// the rotation can be aborted because of unknown keys without leaving
+	// the new master key behind
+	rot, err := repository.NewMasterKeyRotation(ctx, repo, rtest.TestPassword, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(rot.UnknownKeys()))
+	rtest.Equals(t, keys, listKeys())
+
+	rtest.OK(t, rot.RewritePacks(ctx, nil))
+	rtest.Equals(t, len(keys)+1, len(listKeys()))
+
+	// a resumed rotation uses the saved key
+	rot, err = repository.NewMasterKeyRotation(ctx, repo, rtest.TestPassword, []string{"unknown"})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(rot.UnknownKeys()))
+	finishRotation(t, be, rot)
+
+	repo = repository.New(be)
+	rtest.OK(t, repo.SearchKey(ctx, "unknown", 0, ""))
+}
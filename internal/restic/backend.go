@@ -4,8 +4,15 @@ import (
 	"context"
 	"hash"
 	"io"
+
+	"github.com/restic/restic/internal/errors"
 )
 
+// ErrRetained is returned by Backend.Remove for files which the backend
+// refuses to remove because they are still protected, e.g. by an object lock
+// retention period.
+var ErrRetained = errors.New("file is retained by the backend")
+
 // Backend is used to store and access data.
 //
 // Backend operations that return an error will be retried when a Backend is
@@ -68,4 +75,8 @@ type Backend interface {
 type FileInfo struct {
 	Size int64
 	Name string
+
+	// Hash is the checksum of the file as computed by the backend's Hasher(),
+	// it is nil if the backend does not report it.
+	Hash []byte
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/errors"
 
 	"github.com/restic/restic/internal/debug"
@@ -16,11 +17,33 @@ type Config struct {
 	Version           uint        `json:"version"`
 	ID                string      `json:"id"`
 	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+
+	// PublicKey is set when write-only keys can be added to the repository.
+	// Data saved with a write-only key can only be read with PrivateKey.
+	PublicKey  *crypto.PublicKey  `json:"public_key,omitempty"`
+	PrivateKey *crypto.PrivateKey `json:"private_key,omitempty"`
+
+	// LockKey encrypts the lock files, so that all keys can read them.
+	LockKey *crypto.Key `json:"lock_key,omitempty"`
 }
 
-// RepoVersion is the version that is written to the config when a repository
-// is newly created with Init().
-const RepoVersion = 1
+const (
+	// MinRepoVersion is the oldest repository version which is supported.
+	MinRepoVersion = 1
+
+	// MaxRepoVersion is the latest repository version which is supported.
+	// Starting with version 2, blobs and files may be stored compressed.
+	MaxRepoVersion = 3
+
+	// SealedRepoVersion is the first repository version which may contain
+	// data sealed for the public key of the repository by write-only keys.
+	// Older versions of restic refuse to open such repositories.
+	SealedRepoVersion = 3
+
+	// StableRepoVersion is the version that is written to the config when a
+	// repository is newly created with Init() and no version is requested.
+	StableRepoVersion = 1
+)
 
 // JSONUnpackedLoader loads unpacked JSON.
 type JSONUnpackedLoader interface {
@@ -29,30 +52,34 @@ type JSONUnpackedLoader interface {
 
 // CreateConfig creates a config file with a randomly selected polynomial and
 // ID.
-func CreateConfig() (Config, error) {
+func CreateConfig(version uint) (Config, error) {
 	var (
 		err error
 		cfg Config
 	)
 
+	if version < MinRepoVersion || version > MaxRepoVersion {
+		return Config{}, errors.Errorf("unsupported repository version %v", version)
+	}
+
 	cfg.ChunkerPolynomial, err = chunker.RandomPolynomial()
 	if err != nil {
 		return Config{}, errors.Wrap(err, "chunker.RandomPolynomial")
 	}
 
 	cfg.ID = NewRandomID().String()
-	cfg.Version = RepoVersion
+	cfg.Version = version
 
 	debug.Log("New config: %#v", cfg)
 	return cfg, nil
 }
 
 // TestCreateConfig creates a config for use within tests.
-func TestCreateConfig(t testing.TB, pol chunker.Pol) (cfg Config) {
+func TestCreateConfig(t testing.TB, pol chunker.Pol, version uint) (cfg Config) {
 	cfg.ChunkerPolynomial = pol
 
 	cfg.ID = NewRandomID().String()
-	cfg.Version = RepoVersion
+	cfg.Version = version
 
 	return cfg
 }
@@ -77,8 +104,8 @@ func LoadConfig(ctx context.Context, r JSONUnpackedLoader) (Config, error) {
 		return Config{}, err
 	}
 
-	if cfg.Version != RepoVersion {
-		return Config{}, errors.New("unsupported repository version")
+	if cfg.Version < MinRepoVersion || cfg.Version > MaxRepoVersion {
+		return Config{}, errors.Errorf("unsupported repository version %v", cfg.Version)
 	}
 
 	if checkPolynomial {
@@ -21,6 +21,12 @@ func (l loader) LoadJSONUnpacked(ctx context.Context, t restic.FileType, id rest
 }
 
 func TestConfig(t *testing.T) {
+	for _, version := range []uint{restic.MinRepoVersion, restic.MaxRepoVersion} {
+		testConfig(t, version)
+	}
+}
+
+func testConfig(t *testing.T, version uint) {
 	resultConfig := restic.Config{}
 	save := func(tpe restic.FileType, arg interface{}) (restic.ID, error) {
 		rtest.Assert(t, tpe == restic.ConfigFile,
@@ -32,7 +38,7 @@ func TestConfig(t *testing.T) {
 		return restic.ID{}, nil
 	}
 
-	cfg1, err := restic.CreateConfig()
+	cfg1, err := restic.CreateConfig(version)
 	rtest.OK(t, err)
 
 	_, err = saver(save).SaveJSONUnpacked(restic.ConfigFile, cfg1)
@@ -54,3 +60,10 @@ func TestConfig(t *testing.T) {
 	rtest.Assert(t, cfg1 == cfg2,
 		"configs aren't equal: %v != %v", cfg1, cfg2)
 }
+
+func TestConfigInvalidVersion(t *testing.T) {
+	for _, version := range []uint{restic.MinRepoVersion - 1, restic.MaxRepoVersion + 1} {
+		_, err := restic.CreateConfig(version)
+		rtest.Assert(t, err != nil, "no error for invalid version %v", version)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"os/user"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -566,6 +568,11 @@ func lookupGroup(gid uint32) string {
 }
 
 func (node *Node) fillExtra(path string, fi os.FileInfo) error {
+	if hdr, ok := fi.Sys().(*fs.TarHeader); ok {
+		node.fillFromTarHeader(hdr)
+		return nil
+	}
+
 	stat, ok := toStatT(fi.Sys())
 	if !ok {
 		// fill minimal info with current values for uid, gid
@@ -613,6 +620,56 @@ func (node *Node) fillExtra(path string, fi os.FileInfo) error {
 	return nil
 }
 
+// fillFromTarHeader fills the node with the metadata of a file read from a
+// tar archive.
+func (node *Node) fillFromTarHeader(hdr *fs.TarHeader) {
+	node.Inode = hdr.Inode
+	node.UID = uint32(hdr.Uid)
+	node.GID = uint32(hdr.Gid)
+	node.User = hdr.Uname
+	node.Group = hdr.Gname
+
+	node.AccessTime = hdr.AccessTime
+	if node.AccessTime.IsZero() {
+		node.AccessTime = node.ModTime
+	}
+	node.ChangeTime = hdr.ChangeTime
+	if node.ChangeTime.IsZero() {
+		node.ChangeTime = node.ModTime
+	}
+
+	switch node.Type {
+	case "file", "symlink", "fifo":
+		node.Links = hdr.Links
+	case "dev", "chardev":
+		node.Links = hdr.Links
+		node.Device = mkdev(uint64(hdr.Devmajor), uint64(hdr.Devminor))
+	}
+	if node.Type == "symlink" {
+		node.LinkTarget = hdr.Linkname
+		return
+	}
+
+	const prefix = "SCHILY.xattr."
+	for key, value := range hdr.PAXRecords {
+		if strings.HasPrefix(key, prefix) {
+			node.ExtendedAttributes = append(node.ExtendedAttributes, ExtendedAttribute{
+				Name:  strings.TrimPrefix(key, prefix),
+				Value: []byte(value),
+			})
+		}
+	}
+	sort.Slice(node.ExtendedAttributes, func(i, j int) bool {
+		return node.ExtendedAttributes[i].Name < node.ExtendedAttributes[j].Name
+	})
+}
+
+// mkdev combines the major and minor number of a device in the same way as
+// the GNU C library.
+func mkdev(major, minor uint64) uint64 {
+	return (minor & 0xff) | (major&0xfff)<<8 | (minor&^0xff)<<12 | (major&^0xfff)<<32
+}
+
 func (node *Node) fillExtendedAttributes(path string) error {
 	if node.Type == "symlink" {
 		return nil
@@ -1,6 +1,8 @@
 package restic_test
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"io/ioutil"
 	"os"
@@ -9,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
 )
@@ -296,3 +299,60 @@ func TestFixTime(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeFromTarHeader(t *testing.T) {
+	mtime := time.Unix(1600000000, 0)
+	headers := []tar.Header{
+		{Name: "file", Typeflag: tar.TypeReg, Mode: 0640, ModTime: mtime, Uid: 1000, Gid: 100, Uname: "user", Gname: "group",
+			PAXRecords: map[string]string{"SCHILY.xattr.user.b": "2", "SCHILY.xattr.user.a": "1"}},
+		{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "file"},
+		{Name: "symlink", Typeflag: tar.TypeSymlink, Linkname: "file", Mode: 0777, ModTime: mtime},
+		{Name: "dev", Typeflag: tar.TypeBlock, Devmajor: 8, Devminor: 1, Mode: 0660, ModTime: mtime},
+	}
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, hdr := range headers {
+		hdr := hdr
+		rtest.OK(t, tw.WriteHeader(&hdr))
+	}
+	rtest.OK(t, tw.Close())
+
+	tarFS, err := fs.NewTar(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	rtest.OK(t, err)
+
+	nodeFromTar := func(name string) *restic.Node {
+		fi, err := tarFS.Lstat(name)
+		rtest.OK(t, err)
+		node, err := restic.NodeFromFileInfo(name, fi)
+		rtest.OK(t, err)
+		return node
+	}
+
+	file := nodeFromTar("/file")
+	rtest.Equals(t, "file", file.Type)
+	rtest.Equals(t, os.FileMode(0640), file.Mode)
+	rtest.Assert(t, file.ModTime.Equal(mtime), "wrong ModTime %v", file.ModTime)
+	rtest.Assert(t, file.ChangeTime.Equal(mtime), "wrong ChangeTime %v", file.ChangeTime)
+	rtest.Equals(t, uint32(1000), file.UID)
+	rtest.Equals(t, uint32(100), file.GID)
+	rtest.Equals(t, "user", file.User)
+	rtest.Equals(t, "group", file.Group)
+	rtest.Equals(t, uint64(2), file.Links)
+	rtest.Equals(t, []restic.ExtendedAttribute{
+		{Name: "user.a", Value: []byte("1")},
+		{Name: "user.b", Value: []byte("2")},
+	}, file.ExtendedAttributes)
+
+	hardlink := nodeFromTar("/hardlink")
+	rtest.Equals(t, file.Inode, hardlink.Inode)
+	rtest.Equals(t, uint64(2), hardlink.Links)
+
+	symlink := nodeFromTar("/symlink")
+	rtest.Equals(t, "symlink", symlink.Type)
+	rtest.Equals(t, "file", symlink.LinkTarget)
+
+	dev := nodeFromTar("/dev")
+	rtest.Equals(t, "dev", dev.Type)
+	rtest.Equals(t, uint64(0x801), dev.Device)
+}
@@ -0,0 +1,43 @@
+package restic
+
+import (
+	"bytes"
+	"sync"
+)
+
+// ZeroPrefixLen returns the length of the longest all-zero prefix of p.
+func ZeroPrefixLen(p []byte) (n int) {
+	// first skip 1 KiB sized blocks, comparing them with bytes.Equal is much
+	// faster than checking each byte individually
+	var zeros [1024]byte
+	for len(p) >= len(zeros) && bytes.Equal(p[:len(zeros)], zeros[:]) {
+		p = p[len(zeros):]
+		n += len(zeros)
+	}
+
+	for len(p) > 0 && p[0] == 0 {
+		p = p[1:]
+		n++
+	}
+
+	return n
+}
+
+var zeroBlobIDs = struct {
+	sync.Mutex
+	ids map[uint]ID
+}{ids: make(map[uint]ID)}
+
+// ZeroBlobID returns the ID of a blob which consists of length zero bytes.
+// Blobs of sparse files are often all zero, the IDs are therefore cached.
+func ZeroBlobID(length uint) ID {
+	zeroBlobIDs.Lock()
+	defer zeroBlobIDs.Unlock()
+
+	id, ok := zeroBlobIDs.ids[length]
+	if !ok {
+		id = Hash(make([]byte, length))
+		zeroBlobIDs.ids[length] = id
+	}
+	return id
+}
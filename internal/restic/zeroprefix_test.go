@@ -0,0 +1,38 @@
+package restic_test
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestZeroPrefixLen(t *testing.T) {
+	var buf [2048]byte
+
+	// test zero prefixes of various lengths
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i] = 42
+		skipped := restic.ZeroPrefixLen(buf[:])
+		if skipped != i {
+			t.Fatalf("ZeroPrefixLen returned %d, wanted %d", skipped, i)
+		}
+	}
+
+	// test buffers of various sizes
+	for i := 0; i < len(buf); i++ {
+		skipped := restic.ZeroPrefixLen(buf[i:])
+		if skipped != 0 {
+			t.Fatalf("ZeroPrefixLen returned %d, wanted %d", skipped, 0)
+		}
+	}
+}
+
+func TestZeroBlobID(t *testing.T) {
+	for _, length := range []uint{0, 1, 4096, 512 * 1024} {
+		want := restic.Hash(make([]byte, length))
+		rtest.Equals(t, want, restic.ZeroBlobID(length))
+		// second call is answered from the cache
+		rtest.Equals(t, want, restic.ZeroBlobID(length))
+	}
+}
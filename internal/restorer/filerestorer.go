@@ -5,15 +5,18 @@ import (
 	"context"
 	"io"
 	"math"
+	"os"
 	"path/filepath"
 	"sort"
 	"sync"
 
+	"github.com/restic/chunker"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/debug"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 )
 
@@ -35,6 +38,10 @@ type fileInfo struct {
 	size       int64
 	location   string      // file on local filesystem relative to restorer basedir
 	blobs      interface{} // blobs of the file
+
+	present map[int64]struct{} // offsets of blobs which are already present in the target file
+	tmp     bool               // file is restored to a temporary file first, see writePath
+	inPlace bool               // existing file is updated in place
 }
 
 type fileBlobInfo struct {
@@ -51,28 +58,39 @@ type packInfo struct {
 // fileRestorer restores set of files
 type fileRestorer struct {
 	key        *crypto.Key
+	pol        chunker.Pol
 	idx        func(restic.BlobHandle) []restic.PackedBlob
 	packLoader func(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error
 
 	filesWriter *filesWriter
 
-	dst   string
-	files []*fileInfo
+	dst    string
+	files  []*fileInfo
+	sparse bool
+	// reuse enables copying data from existing target files instead of
+	// downloading it.
+	reuse bool
 	Error func(string, error) error
+	// CompleteBlob is called when bytes of the file at location have been
+	// written.
+	CompleteBlob func(location string, bytes uint64)
 }
 
 func newFileRestorer(dst string,
 	packLoader func(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error,
 	key *crypto.Key,
+	pol chunker.Pol,
 	idx func(restic.BlobHandle) []restic.PackedBlob) *fileRestorer {
 
 	return &fileRestorer{
-		key:         key,
-		idx:         idx,
-		packLoader:  packLoader,
-		filesWriter: newFilesWriter(workerCount),
-		dst:         dst,
-		Error:       restorerAbortOnAllErrors,
+		key:          key,
+		pol:          pol,
+		idx:          idx,
+		packLoader:   packLoader,
+		filesWriter:  newFilesWriter(workerCount),
+		dst:          dst,
+		Error:        restorerAbortOnAllErrors,
+		CompleteBlob: func(string, uint64) {},
 	}
 }
 
@@ -84,6 +102,14 @@ func (r *fileRestorer) targetPath(location string) string {
 	return filepath.Join(r.dst, location)
 }
 
+// writePath returns the path the blobs of file are written to.
+func (r *fileRestorer) writePath(file *fileInfo) string {
+	if file.tmp {
+		return r.targetPath(file.location) + tmpFileSuffix
+	}
+	return r.targetPath(file.location)
+}
+
 func (r *fileRestorer) forEachBlob(blobIDs []restic.ID, fn func(packID restic.ID, packBlob restic.Blob)) error {
 	if len(blobIDs) == 0 {
 		return nil
@@ -101,7 +127,108 @@ func (r *fileRestorer) forEachBlob(blobIDs []restic.ID, fn func(packID restic.ID
 }
 
 func (r *fileRestorer) restoreFiles(ctx context.Context) error {
+	wg, ctx := errgroup.WithContext(ctx)
+	reuseCh := make(chan *fileInfo)
+	downloadCh := make(chan *packInfo)
+
+	// all workers check existing files for reusable data before the packs
+	// are downloaded, as this determines which blobs must be downloaded
+	var reused sync.WaitGroup
+	reused.Add(workerCount)
+
+	worker := func() error {
+		err := r.reuseWorker(ctx, reuseCh)
+		reused.Done()
+		if err != nil {
+			return err
+		}
+
+		for pack := range downloadCh {
+			if err := r.downloadPack(ctx, pack); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < workerCount; i++ {
+		wg.Go(worker)
+	}
+
+	// the main restore loop
+	wg.Go(func() error {
+		defer close(downloadCh)
 
+		err := r.scheduleReuse(ctx, reuseCh)
+		if err != nil {
+			return err
+		}
+		reused.Wait()
+
+		packs, packOrder, err := r.collectPacks()
+		if err != nil {
+			return err
+		}
+
+		for _, id := range packOrder {
+			pack := packs[id]
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case downloadCh <- pack:
+				debug.Log("Scheduled download pack %s", pack.id.Str())
+			}
+		}
+		return nil
+	})
+
+	err := wg.Wait()
+	return r.finishTmpFiles(err)
+}
+
+// scheduleReuse sends all files whose target already exists to reuseCh and
+// closes it afterwards. No files are sent unless reuse is enabled.
+func (r *fileRestorer) scheduleReuse(ctx context.Context, reuseCh chan<- *fileInfo) error {
+	defer close(reuseCh)
+
+	if !r.reuse {
+		return nil
+	}
+
+	for _, file := range r.files {
+		if !r.hasExistingTarget(file) {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case reuseCh <- file:
+		}
+	}
+	return nil
+}
+
+// reuseWorker checks the files received from reuseCh for data which can be
+// reused. The buffer for the chunker is only allocated when it is needed.
+func (r *fileRestorer) reuseWorker(ctx context.Context, reuseCh <-chan *fileInfo) error {
+	var chunkBuf []byte
+	for file := range reuseCh {
+		if chunkBuf == nil {
+			chunkBuf = make([]byte, chunker.MaxSize)
+		}
+		err := r.reuseExisting(ctx, file, chunkBuf)
+		if err != nil {
+			debug.Log("unable to reuse existing data of %v: %v", file.location, err)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// collectPacks returns the packs which contain blobs that must be downloaded.
+func (r *fileRestorer) collectPacks() (map[restic.ID]*packInfo, restic.IDs, error) {
 	packs := make(map[restic.ID]*packInfo) // all packs
 	// Process packs in order of first access. While this cannot guarantee
 	// that file chunks are restored sequentially, it offers a good enough
@@ -118,9 +245,13 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 		}
 		fileOffset := int64(0)
 		err := r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob) {
+			offset := fileOffset
+			fileOffset += int64(blob.DataLength())
+			if _, ok := file.present[offset]; ok {
+				return
+			}
 			if largeFile {
-				packsMap[packID] = append(packsMap[packID], fileBlobInfo{id: blob.ID, offset: fileOffset})
-				fileOffset += int64(blob.Length) - crypto.Extension
+				packsMap[packID] = append(packsMap[packID], fileBlobInfo{id: blob.ID, offset: offset})
 			}
 			pack, ok := packs[packID]
 			if !ok {
@@ -135,44 +266,35 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 		})
 		if err != nil {
 			// repository index is messed up, can't do anything
-			return err
+			return nil, nil, err
 		}
 		if largeFile {
 			file.blobs = packsMap
 		}
 	}
 
-	wg, ctx := errgroup.WithContext(ctx)
-	downloadCh := make(chan *packInfo)
+	return packs, packOrder, nil
+}
 
-	worker := func() error {
-		for pack := range downloadCh {
-			if err := r.downloadPack(ctx, pack); err != nil {
-				return err
-			}
+// finishTmpFiles replaces the target files with the temporary files they were
+// restored to. If err is not nil, the temporary files are removed instead.
+func (r *fileRestorer) finishTmpFiles(err error) error {
+	for _, file := range r.files {
+		if !file.tmp {
+			continue
 		}
-		return nil
-	}
-	for i := 0; i < workerCount; i++ {
-		wg.Go(worker)
-	}
 
-	// the main restore loop
-	wg.Go(func() error {
-		for _, id := range packOrder {
-			pack := packs[id]
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case downloadCh <- pack:
-				debug.Log("Scheduled download pack %s", pack.id.Str())
-			}
+		if err != nil {
+			_ = os.Remove(r.writePath(file))
+			continue
 		}
-		close(downloadCh)
-		return nil
-	})
 
-	return wg.Wait()
+		errRename := os.Rename(r.writePath(file), r.targetPath(file.location))
+		if errRename != nil {
+			err = r.Error(file.location, errors.Wrap(errRename, "Rename"))
+		}
+	}
+	return err
 }
 
 const maxBufferSize = 4 * 1024 * 1024
@@ -182,9 +304,10 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 	// calculate pack byte range and blob->[]files->[]offsets mappings
 	start, end := int64(math.MaxInt64), int64(0)
 	blobs := make(map[restic.ID]struct {
-		offset int64                 // offset of the blob in the pack
-		length int                   // length of the blob
-		files  map[*fileInfo][]int64 // file -> offsets (plural!) of the blob in the file
+		offset             int64                 // offset of the blob in the pack
+		length             int                   // length of the blob
+		uncompressedLength uint                  // length of the uncompressed blob, zero if not compressed
+		files              map[*fileInfo][]int64 // file -> offsets (plural!) of the blob in the file
 	})
 	for file := range pack.files {
 		addBlob := func(blob restic.Blob, fileOffset int64) {
@@ -198,6 +321,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 			if !ok {
 				blobInfo.offset = int64(blob.Offset)
 				blobInfo.length = int(blob.Length)
+				blobInfo.uncompressedLength = blob.UncompressedLength
 				blobInfo.files = make(map[*fileInfo][]int64)
 				blobs[blob.ID] = blobInfo
 			}
@@ -206,10 +330,10 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 		if fileBlobs, ok := file.blobs.(restic.IDs); ok {
 			fileOffset := int64(0)
 			err := r.forEachBlob(fileBlobs, func(packID restic.ID, blob restic.Blob) {
-				if packID.Equal(pack.id) {
+				if _, ok := file.present[fileOffset]; !ok && packID.Equal(pack.id) {
 					addBlob(blob, fileOffset)
 				}
-				fileOffset += int64(blob.Length) - crypto.Extension
+				fileOffset += int64(blob.DataLength())
 			})
 			if err != nil {
 				// restoreFiles should have caught this error before
@@ -262,7 +386,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 			if err != nil {
 				return err
 			}
-			blobData, err = r.decryptBlob(blobID, buf)
+			blobData, err = r.decryptBlob(blobID, blob.uncompressedLength, buf)
 			if err != nil {
 				for file := range blob.files {
 					if errFile := sanitizeError(file, err); errFile != nil {
@@ -290,12 +414,16 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 							file.inProgress = true
 							createSize = file.size
 						}
-						return r.filesWriter.writeToFile(r.targetPath(file.location), blobData, offset, createSize)
+						// zeros must be written if the file may
+						// contain old data at that offset
+						sparse := r.sparse && !file.inPlace
+						return r.filesWriter.writeToFile(r.writePath(file), blobData, offset, createSize, sparse)
 					}
 					err := sanitizeError(file, writeToFile())
 					if err != nil {
 						return err
 					}
+					r.CompleteBlob(file.location, uint64(len(blobData)))
 				}
 			}
 		}
@@ -333,12 +461,16 @@ func (r *fileRestorer) downloadBlob(rd io.Reader, blobID restic.ID, length int,
 	return buf, nil
 }
 
-func (r *fileRestorer) decryptBlob(blobID restic.ID, buf []byte) ([]byte, error) {
+func (r *fileRestorer) decryptBlob(blobID restic.ID, uncompressedLength uint, buf []byte) ([]byte, error) {
 	// TODO reconcile with Repository#loadBlob implementation
 
-	// decrypt
-	nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
-	plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	// decrypt and decompress
+	blob := restic.Blob{
+		BlobHandle:         restic.BlobHandle{ID: blobID, Type: restic.DataBlob},
+		Length:             uint(len(buf)),
+		UncompressedLength: uncompressedLength,
+	}
+	plaintext, err := repository.DecryptBlob(r.key, blob, buf)
 	if err != nil {
 		return nil, errors.Errorf("decrypting blob %v failed: %v", blobID, err)
 	}
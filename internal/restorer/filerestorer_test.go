@@ -6,14 +6,22 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
+	"github.com/restic/chunker"
 	"github.com/restic/restic/internal/crypto"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
 	rtest "github.com/restic/restic/internal/test"
 )
 
+const testPol = repository.TestChunkerPol
+
 type TestBlob struct {
 	data string
 	pack string
@@ -149,7 +157,7 @@ func newTestRepo(content []TestFile) *TestRepo {
 func restoreAndVerify(t *testing.T, tempdir string, content []TestFile, files map[string]bool) {
 	repo := newTestRepo(content)
 
-	r := newFileRestorer(tempdir, repo.loader, repo.key, repo.Lookup)
+	r := newFileRestorer(tempdir, repo.loader, repo.key, testPol, repo.Lookup)
 
 	if files == nil {
 		r.files = repo.files
@@ -263,7 +271,7 @@ func TestErrorRestoreFiles(t *testing.T) {
 		return loadError
 	}
 
-	r := newFileRestorer(tempdir, repo.loader, repo.key, repo.Lookup)
+	r := newFileRestorer(tempdir, repo.loader, repo.key, testPol, repo.Lookup)
 	r.files = repo.files
 
 	err := r.restoreFiles(context.TODO())
@@ -303,7 +311,7 @@ func testPartialDownloadError(t *testing.T, part int) {
 		return loader(ctx, h, length, offset, fn)
 	}
 
-	r := newFileRestorer(tempdir, repo.loader, repo.key, repo.Lookup)
+	r := newFileRestorer(tempdir, repo.loader, repo.key, testPol, repo.Lookup)
 	r.files = repo.files
 	r.Error = func(s string, e error) error {
 		// ignore errors as in the `restore` command
@@ -315,3 +323,86 @@ func testPartialDownloadError(t *testing.T, part int) {
 	rtest.OK(t, err)
 	verifyRestore(t, r, repo)
 }
+
+// chunkTestFile splits data with the chunker and stores each blob in a
+// separate pack.
+func chunkTestFile(t testing.TB, name string, data []byte) TestFile {
+	file := TestFile{name: name}
+	chnker := chunker.New(bytes.NewReader(data), testPol)
+	buf := make([]byte, chunker.MaxSize)
+	for {
+		chunk, err := chnker.Next(buf)
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+		file.blobs = append(file.blobs, TestBlob{
+			data: string(chunk.Data),
+			pack: fmt.Sprintf("pack%d", len(file.blobs)),
+		})
+	}
+	return file
+}
+
+func TestFileRestorerReuseExisting(t *testing.T) {
+	data := make([]byte, 10*1024*1024)
+	_, _ = rand.New(rand.NewSource(23)).Read(data)
+	file := chunkTestFile(t, "file", data)
+	if len(file.blobs) < 4 {
+		t.Fatalf("test data yields only %d blobs", len(file.blobs))
+	}
+
+	// modify data within the second blob
+	modified := append([]byte{}, data...)
+	offset := len(file.blobs[0].data) + 100
+	copy(modified[offset:], "modified")
+
+	var tests = []struct {
+		name       string
+		existing   []byte
+		reuse      bool
+		wantLoaded int
+	}{
+		{"unchanged", data, true, 0},
+		{"modified", modified, true, 1},
+		{"shifted", append([]byte("prefix"), data...), true, 1},
+		{"truncated", data[:len(data)-len(file.blobs[len(file.blobs)-1].data)], true, 1},
+		{"appended", append(append([]byte{}, data...), "suffix"...), true, 1},
+		{"unrelated", rtest.Random(42, 1024*1024), true, len(file.blobs)},
+		{"disabled", data, false, len(file.blobs)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tempdir, cleanup := rtest.TempDir(t)
+			defer cleanup()
+
+			target := filepath.Join(tempdir, "file")
+			rtest.OK(t, ioutil.WriteFile(target, test.existing, 0600))
+
+			repo := newTestRepo([]TestFile{file})
+			repo.files[0].size = int64(len(data))
+
+			var m sync.Mutex
+			loaded := 0
+			loader := repo.loader
+			repo.loader = func(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+				m.Lock()
+				loaded++
+				m.Unlock()
+				return loader(ctx, h, length, offset, fn)
+			}
+
+			r := newFileRestorer(tempdir, repo.loader, repo.key, testPol, repo.Lookup)
+			r.files = repo.files
+			r.reuse = test.reuse
+
+			rtest.OK(t, r.restoreFiles(context.TODO()))
+			verifyRestore(t, r, repo)
+			rtest.Equals(t, test.wantLoaded, loaded)
+
+			_, err := os.Stat(target + tmpFileSuffix)
+			rtest.Assert(t, os.IsNotExist(err), "temporary file was not removed: %v", err)
+		})
+	}
+}
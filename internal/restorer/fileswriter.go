@@ -6,6 +6,7 @@ import (
 
 	"github.com/cespare/xxhash/v2"
 	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/restic"
 )
 
 // writes blobs to target files.
@@ -34,7 +35,11 @@ func newFilesWriter(count int) *filesWriter {
 	}
 }
 
-func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, createSize int64) error {
+// writeToFile writes blob at offset to the file at path. If createSize is
+// not negative, the file is created with that size. For sparse files, blobs
+// which only contain zeros are not written, as the file is known to be empty
+// at that offset. Sparse files are not preallocated.
+func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, createSize int64, sparse bool) error {
 	bucket := &w.buckets[uint(xxhash.Sum64String(path))%uint(len(w.buckets))]
 
 	acquireWriter := func() (*os.File, error) {
@@ -58,10 +63,20 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 			return nil, err
 		}
 
+		if createSize >= 0 && sparse {
+			// create the file with the final size, leaving holes for
+			// all parts that are not written
+			err := wr.Truncate(createSize)
+			if err != nil {
+				_ = wr.Close()
+				return nil, err
+			}
+		}
+
 		bucket.files[path] = wr
 		bucket.users[path] = 1
 
-		if createSize >= 0 {
+		if createSize >= 0 && !sparse {
 			err := preallocateFile(wr, createSize)
 			if err != nil {
 				// Just log the preallocate error but don't let it cause the restore process to fail.
@@ -94,6 +109,11 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 		return err
 	}
 
+	if sparse && restic.ZeroPrefixLen(blob) == len(blob) {
+		// the file already contains zeros at this offset
+		return releaseWriter(wr)
+	}
+
 	_, err = wr.WriteAt(blob, offset)
 
 	if err != nil {
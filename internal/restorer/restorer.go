@@ -2,6 +2,7 @@ package restorer
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sync/atomic"
@@ -14,13 +15,100 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// OverwriteBehavior controls how existing files in the target are handled.
+type OverwriteBehavior int
+
+// Constants for the different overwrite behaviors.
+const (
+	// OverwriteAlways always overwrites existing files.
+	OverwriteAlways OverwriteBehavior = iota
+	// OverwriteIfChanged only overwrites existing files if their content
+	// differs from the snapshot.
+	OverwriteIfChanged
+	// OverwriteIfNewer only overwrites existing files if the file in the
+	// snapshot has a newer modification time.
+	OverwriteIfNewer
+	// OverwriteNever never overwrites existing files.
+	OverwriteNever
+	// OverwriteInvalid is returned for invalid overwrite behaviors.
+	OverwriteInvalid
+)
+
+// Set implements the method needed for pflag command flag parsing.
+func (c *OverwriteBehavior) Set(s string) error {
+	switch s {
+	case "always":
+		*c = OverwriteAlways
+	case "if-changed":
+		*c = OverwriteIfChanged
+	case "if-newer":
+		*c = OverwriteIfNewer
+	case "never":
+		*c = OverwriteNever
+	default:
+		*c = OverwriteInvalid
+		return fmt.Errorf("invalid overwrite behavior %q, must be one of (always|if-changed|if-newer|never)", s)
+	}
+
+	return nil
+}
+
+func (c *OverwriteBehavior) String() string {
+	switch *c {
+	case OverwriteAlways:
+		return "always"
+	case OverwriteIfChanged:
+		return "if-changed"
+	case OverwriteIfNewer:
+		return "if-newer"
+	case OverwriteNever:
+		return "never"
+	default:
+		return "invalid"
+	}
+}
+
+// Type implements the method needed for pflag command flag parsing.
+func (c *OverwriteBehavior) Type() string {
+	return "behavior"
+}
+
 // Restorer is used to restore a snapshot to a directory.
 type Restorer struct {
 	repo restic.Repository
 	sn   *restic.Snapshot
 
+	// Overwrite configures how existing files in the target are handled.
+	Overwrite OverwriteBehavior
+	// CompareContent makes OverwriteIfChanged compare the content of existing
+	// files with the snapshot instead of the size and modification time.
+	CompareContent bool
+	// Delete removes files from the restored directories which are not
+	// contained in the snapshot.
+	Delete bool
+	// Sparse restores files as sparse files, all-zero blobs are not written.
+	Sparse bool
+	// ReuseExisting copies data which is already present in existing files in
+	// the target instead of downloading it from the repository.
+	ReuseExisting bool
+
+	// skipped contains the locations of existing files which were not
+	// overwritten. The value specifies whether the metadata of the file
+	// should be restored nevertheless.
+	skipped map[string]bool
+
 	Error        func(location string, err error) error
 	SelectFilter func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool)
+
+	// StartFile is called for each file which is restored, together with the
+	// number of bytes which will be written.
+	StartFile func(location string, size uint64)
+	// CompleteBlob is called when bytes of the file at location have been
+	// written, either downloaded from the repository or reused from the
+	// existing file.
+	CompleteBlob func(location string, bytes uint64)
+	// SkipFile is called for each existing file which is not overwritten.
+	SkipFile func(location string, size uint64)
 }
 
 var restorerAbortOnAllErrors = func(location string, err error) error { return err }
@@ -31,6 +119,9 @@ func NewRestorer(ctx context.Context, repo restic.Repository, id restic.ID) (*Re
 		repo:         repo,
 		Error:        restorerAbortOnAllErrors,
 		SelectFilter: func(string, string, *restic.Node) (bool, bool) { return true, true },
+		StartFile:    func(string, uint64) {},
+		CompleteBlob: func(string, uint64) {},
+		SkipFile:     func(string, uint64) {},
 	}
 
 	var err error
@@ -44,6 +135,7 @@ func NewRestorer(ctx context.Context, repo restic.Repository, id restic.ID) (*Re
 }
 
 type treeVisitor struct {
+	visitTree func(tree *restic.Tree, target, location string) error
 	enterDir  func(node *restic.Node, target, location string) error
 	visitNode func(node *restic.Node, target, location string) error
 	leaveDir  func(node *restic.Node, target, location string) error
@@ -59,6 +151,20 @@ func (res *Restorer) traverseTree(ctx context.Context, target, location string,
 		return hasRestored, res.Error(location, err)
 	}
 
+	if visitor.visitTree != nil {
+		err = visitor.visitTree(tree, target, location)
+		switch err {
+		case nil:
+		case context.Canceled, context.DeadlineExceeded:
+			return hasRestored, err
+		default:
+			err = res.Error(location, err)
+			if err != nil {
+				return hasRestored, err
+			}
+		}
+	}
+
 	for _, node := range tree.Nodes {
 
 		// ensure that the node name does not contain anything that refers to a
@@ -207,6 +313,156 @@ func (res *Restorer) restoreEmptyFileAt(node *restic.Node, target, location stri
 	return res.restoreNodeMetadataTo(node, target, location)
 }
 
+// checkExistingFile decides whether the file node must be written to target,
+// depending on the overwrite behavior. Existing files which are not written
+// are recorded in res.skipped, together with the information whether their
+// metadata should be restored.
+func (res *Restorer) checkExistingFile(node *restic.Node, target, location string) (write bool, err error) {
+	fi, err := fs.Lstat(target)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "Lstat")
+	}
+
+	var restoreMetadata bool
+	switch res.Overwrite {
+	case OverwriteAlways:
+		write = true
+	case OverwriteIfChanged:
+		write = !res.isUnchanged(node, target, fi)
+		// an unchanged file may still have outdated metadata
+		restoreMetadata = true
+	case OverwriteIfNewer:
+		write = node.ModTime.After(fi.ModTime())
+	case OverwriteNever:
+		write = false
+	default:
+		return false, errors.Errorf("invalid overwrite behavior %v", res.Overwrite)
+	}
+
+	if !write {
+		debug.Log("not overwriting existing file %v", target)
+		res.skipped[location] = restoreMetadata
+		return false, nil
+	}
+
+	if !fi.Mode().IsRegular() && !fi.IsDir() {
+		// never write to a file through a symlink or into a special file,
+		// replace it instead
+		if err := fs.Remove(target); err != nil {
+			return false, errors.Wrap(err, "Remove")
+		}
+	}
+
+	return true, nil
+}
+
+// isUnchanged returns true if the existing file at target with the file info
+// fi has the same content as node. Unless res.CompareContent is set, the
+// content is assumed to be identical if size and modification time match.
+func (res *Restorer) isUnchanged(node *restic.Node, target string, fi os.FileInfo) bool {
+	if !fi.Mode().IsRegular() || fi.Size() != int64(node.Size) {
+		return false
+	}
+
+	if !res.CompareContent {
+		return fi.ModTime().Equal(node.ModTime)
+	}
+
+	_, err := res.verifyFile(target, node, nil)
+	if err != nil {
+		debug.Log("content of %v differs: %v", target, err)
+		return false
+	}
+	return true
+}
+
+// prepareNodeTarget removes an existing item at target so that the special
+// file node can be created, depending on the overwrite behavior. It returns
+// false if the existing item must be kept.
+func (res *Restorer) prepareNodeTarget(node *restic.Node, target, location string) (create bool, err error) {
+	fi, err := fs.Lstat(target)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "Lstat")
+	}
+
+	if res.Overwrite == OverwriteNever || (res.Overwrite == OverwriteIfNewer && !node.ModTime.After(fi.ModTime())) {
+		debug.Log("not overwriting existing item %v", target)
+		res.skipped[location] = false
+		return false, nil
+	}
+
+	if fi.IsDir() {
+		// let the creation fail, a directory is never removed implicitly
+		return true, nil
+	}
+
+	if err := fs.Remove(target); err != nil {
+		return false, errors.Wrap(err, "Remove")
+	}
+	return true, nil
+}
+
+// nodeTypeMatches returns true if the existing item with the file info fi has
+// the same type as node.
+func nodeTypeMatches(node *restic.Node, fi os.FileInfo) bool {
+	switch node.Type {
+	case "dir":
+		return fi.IsDir()
+	case "file":
+		return fi.Mode().IsRegular()
+	case "symlink":
+		return fi.Mode()&os.ModeSymlink != 0
+	default:
+		return !fi.IsDir() && !fi.Mode().IsRegular()
+	}
+}
+
+// removeUnexpected removes all items from the directory target which are not
+// contained in tree. Items which exist with a different type than in the
+// snapshot are removed as well, so that they can be restored.
+func (res *Restorer) removeUnexpected(tree *restic.Tree, target string) error {
+	d, err := fs.Open(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "Open")
+	}
+
+	names, err := d.Readdirnames(-1)
+	_ = d.Close()
+	if err != nil {
+		return errors.Wrap(err, "Readdirnames")
+	}
+
+	for _, name := range names {
+		path := filepath.Join(target, name)
+
+		if node := tree.Find(name); node != nil {
+			fi, err := fs.Lstat(path)
+			if err != nil {
+				return errors.Wrap(err, "Lstat")
+			}
+			if nodeTypeMatches(node, fi) {
+				continue
+			}
+		}
+
+		debug.Log("removing %v, not contained in the snapshot", path)
+		if err := fs.RemoveAll(path); err != nil {
+			return errors.Wrap(err, "RemoveAll")
+		}
+	}
+
+	return nil
+}
+
 // RestoreTo creates the directories and files in the snapshot below dst.
 // Before an item is created, res.Filter is called.
 func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
@@ -218,16 +474,31 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 		}
 	}
 
+	res.skipped = make(map[string]bool)
+	// directories in which items which are not part of the snapshot are removed
+	deleteIn := map[string]struct{}{dst: {}}
+
 	idx := restic.NewHardlinkIndex()
-	filerestorer := newFileRestorer(dst, res.repo.Backend().Load, res.repo.Key(), res.repo.Index().Lookup)
+	filerestorer := newFileRestorer(dst, res.repo.Backend().Load, res.repo.Key(), res.repo.Config().ChunkerPolynomial, res.repo.Index().Lookup)
 	filerestorer.Error = res.Error
+	filerestorer.sparse = res.Sparse
+	filerestorer.reuse = res.ReuseExisting
+	filerestorer.CompleteBlob = res.CompleteBlob
 
 	debug.Log("first pass for %q", dst)
 
 	// first tree pass: create directories and collect all files to restore
 	_, err = res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+		visitTree: func(tree *restic.Tree, target, location string) error {
+			if _, ok := deleteIn[target]; !res.Delete || !ok {
+				return nil
+			}
+			debug.Log("first pass, visitTree: remove unexpected items in %q", location)
+			return res.removeUnexpected(tree, target)
+		},
 		enterDir: func(node *restic.Node, target, location string) error {
 			debug.Log("first pass, enterDir: mkdir %q, leaveDir should restore metadata", location)
+			deleteIn[target] = struct{}{}
 			// create dir with default permissions
 			// #leaveDir restores dir metadata after visiting all children
 			return fs.MkdirAll(target, 0700)
@@ -257,6 +528,16 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 				idx.Add(node.Inode, node.DeviceID, location)
 			}
 
+			write, err := res.checkExistingFile(node, target, location)
+			if err != nil {
+				return err
+			}
+			if !write {
+				res.SkipFile(location, node.Size)
+				return nil
+			}
+
+			res.StartFile(location, node.Size)
 			filerestorer.addFile(location, node.Content, int64(node.Size))
 
 			return nil
@@ -278,18 +559,52 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 		visitNode: func(node *restic.Node, target, location string) error {
 			debug.Log("second pass, visitNode: restore node %q", location)
 			if node.Type != "file" {
+				create, err := res.prepareNodeTarget(node, target, location)
+				if err != nil || !create {
+					return err
+				}
 				return res.restoreNodeTo(ctx, node, target, location)
 			}
 
+			// existing files which were not overwritten in the first pass
+			if restoreMetadata, ok := res.skipped[location]; ok {
+				if !restoreMetadata {
+					return nil
+				}
+				return res.restoreNodeMetadataTo(node, target, location)
+			}
+
 			// create empty files, but not hardlinks to empty files
 			if node.Size == 0 && (node.Links < 2 || !idx.Has(node.Inode, node.DeviceID)) {
 				if node.Links > 1 {
 					idx.Add(node.Inode, node.DeviceID, location)
 				}
+				write, err := res.checkExistingFile(node, target, location)
+				if err != nil {
+					return err
+				}
+				if !write {
+					res.SkipFile(location, 0)
+					if !res.skipped[location] {
+						return nil
+					}
+					return res.restoreNodeMetadataTo(node, target, location)
+				}
+				res.StartFile(location, 0)
 				return res.restoreEmptyFileAt(node, target, location)
 			}
 
 			if idx.Has(node.Inode, node.DeviceID) && idx.GetFilename(node.Inode, node.DeviceID) != location {
+				create, err := res.prepareNodeTarget(node, target, location)
+				if err != nil {
+					return err
+				}
+				if !create {
+					res.SkipFile(location, node.Size)
+					return nil
+				}
+				// the data of hardlinks is only written once
+				res.StartFile(location, 0)
 				return res.restoreHardlinkAt(node, filerestorer.targetPath(idx.GetFilename(node.Inode, node.DeviceID)), target, location)
 			}
 
@@ -334,6 +649,10 @@ func (res *Restorer) VerifyFiles(ctx context.Context, dst string) (int, error) {
 				if node.Type != "file" {
 					return nil
 				}
+				// skip existing files which were not overwritten
+				if _, ok := res.skipped[location]; ok {
+					return nil
+				}
 				select {
 				case <-ctx.Done():
 					return ctx.Err()
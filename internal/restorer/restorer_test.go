@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -847,3 +848,222 @@ func TestVerifyCancel(t *testing.T) {
 	rtest.Equals(t, 1, len(errs))
 	rtest.Assert(t, strings.Contains(errs[0].Error(), "Invalid file size for"), "wrong error %q", errs[0].Error())
 }
+
+func TestRestorerOverwrite(t *testing.T) {
+	baseTime := time.Date(2021, 7, 1, 12, 0, 0, 0, time.Local)
+
+	snapshot := Snapshot{
+		Nodes: map[string]Node{
+			"foo":  File{Data: "content: foo\n", ModTime: baseTime},
+			"same": File{Data: "content: same\n", ModTime: baseTime},
+			"dirtest": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n", ModTime: baseTime},
+				},
+				ModTime: baseTime,
+			},
+		},
+	}
+
+	// existing files in the target directory, with the same size but
+	// different content for foo and same
+	existing := []struct {
+		name    string
+		data    string
+		modTime time.Time
+	}{
+		{"foo", "changed: foo\n", baseTime.Add(-time.Hour)},
+		{"same", "content: sam3\n", baseTime},
+		{"dirtest/file", "local\n", baseTime.Add(time.Hour)},
+	}
+
+	const (
+		snap  = "snapshot"
+		local = "local"
+	)
+
+	var tests = []struct {
+		overwrite      OverwriteBehavior
+		compareContent bool
+		want           map[string]string
+	}{
+		{OverwriteAlways, false, map[string]string{"foo": snap, "same": snap, "dirtest/file": snap}},
+		{OverwriteIfChanged, false, map[string]string{"foo": snap, "same": local, "dirtest/file": snap}},
+		{OverwriteIfChanged, true, map[string]string{"foo": snap, "same": snap, "dirtest/file": snap}},
+		{OverwriteIfNewer, false, map[string]string{"foo": snap, "same": local, "dirtest/file": local}},
+		{OverwriteNever, false, map[string]string{"foo": local, "same": local, "dirtest/file": local}},
+	}
+
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, snapshot)
+
+	for _, test := range tests {
+		t.Run(test.overwrite.String(), func(t *testing.T) {
+			tempdir, cleanup := rtest.TempDir(t)
+			defer cleanup()
+
+			for _, e := range existing {
+				filename := filepath.Join(tempdir, filepath.FromSlash(e.name))
+				rtest.OK(t, os.MkdirAll(filepath.Dir(filename), 0700))
+				rtest.OK(t, ioutil.WriteFile(filename, []byte(e.data), 0600))
+				rtest.OK(t, os.Chtimes(filename, e.modTime, e.modTime))
+			}
+
+			res, err := NewRestorer(context.TODO(), repo, id)
+			rtest.OK(t, err)
+			res.Overwrite = test.overwrite
+			res.CompareContent = test.compareContent
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+			nverified, err := res.VerifyFiles(ctx, tempdir)
+			rtest.OK(t, err)
+
+			restored := 0
+			for _, e := range existing {
+				data, err := ioutil.ReadFile(filepath.Join(tempdir, filepath.FromSlash(e.name)))
+				rtest.OK(t, err)
+
+				want := e.data
+				if test.want[e.name] == snap {
+					want = "content: " + filepath.Base(e.name) + "\n"
+					restored++
+				}
+				if string(data) != want {
+					t.Errorf("file %v has wrong content: want %q, got %q", e.name, want, data)
+				}
+			}
+
+			// files which were not overwritten are not verified
+			rtest.Equals(t, restored, nverified)
+		})
+	}
+}
+
+func TestRestorerDelete(t *testing.T) {
+	snapshot := Snapshot{
+		Nodes: map[string]Node{
+			"foo": File{Data: "content: foo\n"},
+			"dirtest": Dir{
+				Nodes: map[string]Node{
+					"file": File{Data: "content: file\n"},
+				},
+			},
+		},
+	}
+
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, snapshot)
+
+	for _, del := range []bool{false, true} {
+		t.Run("", func(t *testing.T) {
+			tempdir, cleanup := rtest.TempDir(t)
+			defer cleanup()
+
+			// create files which are not part of the snapshot
+			extraFiles := []string{"extra", "dirtest/extra", "extradir/file"}
+			for _, name := range extraFiles {
+				filename := filepath.Join(tempdir, filepath.FromSlash(name))
+				rtest.OK(t, os.MkdirAll(filepath.Dir(filename), 0700))
+				rtest.OK(t, ioutil.WriteFile(filename, []byte(name), 0600))
+			}
+
+			res, err := NewRestorer(context.TODO(), repo, id)
+			rtest.OK(t, err)
+			res.Delete = del
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			rtest.OK(t, res.RestoreTo(ctx, tempdir))
+
+			nverified, err := res.VerifyFiles(ctx, tempdir)
+			rtest.OK(t, err)
+			rtest.Equals(t, 2, nverified)
+
+			for _, name := range extraFiles {
+				_, err := os.Stat(filepath.Join(tempdir, filepath.FromSlash(name)))
+				if del && !os.IsNotExist(err) {
+					t.Errorf("file %v was not deleted: %v", name, err)
+				}
+				if !del && err != nil {
+					t.Errorf("file %v was removed: %v", name, err)
+				}
+			}
+
+			_, err = os.Stat(filepath.Join(tempdir, "extradir"))
+			rtest.Assert(t, del == os.IsNotExist(err), "unexpected state of directory extradir: %v", err)
+		})
+	}
+}
+
+func TestRestorerProgress(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"foo":   File{Data: "content: foo\n"},
+			"empty": File{Data: ""},
+			"dirtest": Dir{
+				Nodes: map[string]Node{
+					"file":    File{Data: "content: file\n"},
+					"skipped": File{Data: "content: skipped\n"},
+				},
+			},
+		},
+	})
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	skippedFile := filepath.Join(tempdir, "dirtest", "skipped")
+	rtest.OK(t, os.MkdirAll(filepath.Dir(skippedFile), 0700))
+	rtest.OK(t, ioutil.WriteFile(skippedFile, []byte("existing"), 0600))
+
+	res, err := NewRestorer(context.TODO(), repo, id)
+	rtest.OK(t, err)
+	res.Overwrite = OverwriteNever
+
+	var m sync.Mutex
+	started := make(map[string]uint64)
+	written := make(map[string]uint64)
+	skipped := make(map[string]uint64)
+	res.StartFile = func(location string, size uint64) {
+		m.Lock()
+		started[location] = size
+		m.Unlock()
+	}
+	res.CompleteBlob = func(location string, bytes uint64) {
+		m.Lock()
+		written[location] += bytes
+		m.Unlock()
+	}
+	res.SkipFile = func(location string, size uint64) {
+		m.Lock()
+		skipped[location] = size
+		m.Unlock()
+	}
+
+	rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+	rtest.Equals(t, map[string]uint64{
+		"/foo":          13,
+		"/empty":        0,
+		"/dirtest/file": 14,
+	}, started)
+	rtest.Equals(t, map[string]uint64{
+		"/foo":          13,
+		"/dirtest/file": 14,
+	}, written)
+	rtest.Equals(t, map[string]uint64{
+		"/dirtest/skipped": 17,
+	}, skipped)
+}
@@ -3,7 +3,10 @@
 package restorer
 
 import (
+	"bytes"
 	"context"
+	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -59,3 +62,76 @@ func TestRestorerRestoreEmptyHardlinkedFileds(t *testing.T) {
 		rtest.Equals(t, s1.Ino, s2.Ino)
 	}
 }
+
+func TestRestorerSparseFiles(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	zeros := make([]byte, 1024*1024)
+	_, id := saveSnapshot(t, repo, Snapshot{
+		Nodes: map[string]Node{
+			"zeros": File{Data: string(zeros)},
+			"data":  File{Data: "content: data\n"},
+		},
+	})
+
+	for _, sparse := range []bool{false, true} {
+		res, err := NewRestorer(context.TODO(), repo, id)
+		rtest.OK(t, err)
+		res.Sparse = sparse
+
+		tempdir, cleanup := rtest.TempDir(t)
+		defer cleanup()
+
+		rtest.OK(t, res.RestoreTo(context.TODO(), tempdir))
+
+		nverified, err := res.VerifyFiles(context.TODO(), tempdir)
+		rtest.OK(t, err)
+		rtest.Equals(t, 2, nverified)
+
+		fi, err := os.Stat(filepath.Join(tempdir, "zeros"))
+		rtest.OK(t, err)
+		rtest.Equals(t, int64(len(zeros)), fi.Size())
+
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok || !sparse {
+			continue
+		}
+		// st.Blocks is the number of 512 byte blocks allocated for the file
+		if st.Blocks*512 >= int64(len(zeros)) {
+			t.Errorf("file was not restored as sparse file, %d blocks allocated", st.Blocks)
+		}
+	}
+}
+
+func TestFileRestorerReuseHardlinked(t *testing.T) {
+	data := make([]byte, 10*1024*1024)
+	_, _ = rand.New(rand.NewSource(23)).Read(data)
+	file := chunkTestFile(t, "file", data)
+
+	// modify data within the second blob, all other blobs are in place
+	modified := append([]byte{}, data...)
+	copy(modified[len(file.blobs[0].data)+100:], "modified")
+
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	target := filepath.Join(tempdir, "file")
+	rtest.OK(t, ioutil.WriteFile(target, modified, 0600))
+	rtest.OK(t, os.Link(target, filepath.Join(tempdir, "link")))
+
+	repo := newTestRepo([]TestFile{file})
+	repo.files[0].size = int64(len(data))
+
+	r := newFileRestorer(tempdir, repo.loader, repo.key, testPol, repo.Lookup)
+	r.files = repo.files
+	r.reuse = true
+
+	rtest.OK(t, r.restoreFiles(context.TODO()))
+	verifyRestore(t, r, repo)
+
+	// the file was replaced instead of being updated in place
+	link, err := ioutil.ReadFile(filepath.Join(tempdir, "link"))
+	rtest.OK(t, err)
+	rtest.Assert(t, bytes.Equal(modified, link), "hard link to the restored file was modified")
+}
@@ -0,0 +1,243 @@
+package restorer
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/restic/chunker"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
+)
+
+// tmpFileSuffix is appended to the name of a target file while it is restored
+// to a temporary file.
+const tmpFileSuffix = ".restic-tmp"
+
+// localBlob describes a blob found in an existing target file.
+type localBlob struct {
+	offsets []int64
+	length  int
+}
+
+// contains returns true if the blob is stored at offset in the existing file.
+func (b localBlob) contains(offset int64) bool {
+	for _, o := range b.offsets {
+		if o == offset {
+			return true
+		}
+	}
+	return false
+}
+
+// hasExistingTarget returns true if the target of file already exists and may
+// contain data which can be reused.
+func (r *fileRestorer) hasExistingTarget(file *fileInfo) bool {
+	fi, err := os.Lstat(r.targetPath(file.location))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			debug.Log("unable to check existing target of %v: %v", file.location, err)
+		}
+		return false
+	}
+	return fi.Mode().IsRegular() && fi.Size() > 0
+}
+
+// reuseExisting checks whether the existing target of file contains blobs of
+// the file. The existing file is split with the chunker of the repository, all
+// chunks whose ID matches a blob of the file do not need to be downloaded from
+// the repository.
+//
+// If all matching blobs are already stored at the right offset, the file is
+// updated in place. Otherwise the file is restored to a temporary file, the
+// matching blobs are copied from the existing file and the temporary file
+// replaces the target once the restore has finished. Files with more than one
+// hard link are never updated in place, as this would also modify the other
+// links.
+func (r *fileRestorer) reuseExisting(ctx context.Context, file *fileInfo, buf []byte) error {
+	target := r.targetPath(file.location)
+
+	// offsets of all blobs in the restored file
+	wanted := make(map[restic.ID][]int64)
+	fileOffset := int64(0)
+	err := r.forEachBlob(file.blobs.(restic.IDs), func(packID restic.ID, blob restic.Blob) {
+		wanted[blob.ID] = append(wanted[blob.ID], fileOffset)
+		fileOffset += int64(blob.DataLength())
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(target)
+	if err != nil {
+		return errors.Wrap(err, "Open")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "Stat")
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	local, err := r.findLocalBlobs(ctx, f, wanted, buf)
+	if err != nil {
+		return err
+	}
+	if len(local) == 0 {
+		debug.Log("no reusable data found in %v", target)
+		return nil
+	}
+
+	inPlace := fs.ExtendedStat(fi).Links <= 1
+	for id, blob := range local {
+		for _, offset := range wanted[id] {
+			if !blob.contains(offset) {
+				inPlace = false
+			}
+		}
+	}
+
+	if inPlace {
+		return r.reuseInPlace(file, target, local, wanted)
+	}
+	return r.reuseCopy(ctx, file, f, local, wanted, buf)
+}
+
+// findLocalBlobs splits rd into chunks and returns the position of all chunks
+// which are contained in wanted.
+func (r *fileRestorer) findLocalBlobs(ctx context.Context, rd io.Reader, wanted map[restic.ID][]int64, buf []byte) (map[restic.ID]localBlob, error) {
+	local := make(map[restic.ID]localBlob)
+	chnker := chunker.New(rd, r.pol)
+	for {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		chunk, err := chnker.Next(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "chunker.Next")
+		}
+
+		id := restic.Hash(chunk.Data)
+		if _, ok := wanted[id]; !ok {
+			continue
+		}
+		blob := local[id]
+		blob.offsets = append(blob.offsets, int64(chunk.Start))
+		blob.length = int(chunk.Length)
+		local[id] = blob
+	}
+	return local, nil
+}
+
+// reuseInPlace prepares the existing file target to be updated in place. Only
+// blobs which are not contained in local are written afterwards.
+func (r *fileRestorer) reuseInPlace(file *fileInfo, target string, local map[restic.ID]localBlob, wanted map[restic.ID][]int64) error {
+	debug.Log("updating %v in place, reusing %d blobs", target, len(local))
+
+	err := os.Truncate(target, file.size)
+	if err != nil {
+		return errors.Wrap(err, "Truncate")
+	}
+
+	file.present = make(map[int64]struct{})
+	for id, blob := range local {
+		for _, offset := range wanted[id] {
+			file.present[offset] = struct{}{}
+			r.CompleteBlob(file.location, uint64(blob.length))
+		}
+	}
+	// the file already exists and must not be truncated by the files writer
+	file.inProgress = true
+	file.inPlace = true
+	return nil
+}
+
+// reuseCopy creates a temporary file for file and copies all blobs contained
+// in local from the existing file src to it.
+func (r *fileRestorer) reuseCopy(ctx context.Context, file *fileInfo, src *os.File, local map[restic.ID]localBlob, wanted map[restic.ID][]int64, buf []byte) (err error) {
+	tmpname := r.targetPath(file.location) + tmpFileSuffix
+	debug.Log("restoring %v to %v, copying %d blobs", file.location, tmpname, len(local))
+
+	dst, err := os.OpenFile(tmpname, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+	defer func() {
+		errClose := dst.Close()
+		if err == nil && errClose != nil {
+			err = errors.Wrap(errClose, "Close")
+		}
+		if err != nil {
+			_ = os.Remove(tmpname)
+		}
+	}()
+
+	if !r.sparse {
+		err = preallocateFile(dst, file.size)
+		if err != nil {
+			// preallocation is only an optimization, see filesWriter.writeToFile
+			debug.Log("Failed to preallocate %v with size %v: %v", tmpname, file.size, err)
+		}
+	}
+	err = dst.Truncate(file.size)
+	if err != nil {
+		return errors.Wrap(err, "Truncate")
+	}
+
+	present := make(map[int64]struct{})
+	for id, blob := range local {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		data := buf[:blob.length]
+		_, err = src.ReadAt(data, blob.offsets[0])
+		if err != nil {
+			return errors.Wrap(err, "ReadAt")
+		}
+		if !restic.Hash(data).Equal(id) {
+			// the existing file has been modified in the meantime
+			debug.Log("content of blob %v in %v changed", id.Str(), file.location)
+			continue
+		}
+
+		sparse := r.sparse && restic.ZeroPrefixLen(data) == len(data)
+		for _, offset := range wanted[id] {
+			present[offset] = struct{}{}
+			if sparse {
+				// leave a hole in the temporary file
+				continue
+			}
+			_, err = dst.WriteAt(data, offset)
+			if err != nil {
+				return errors.Wrap(err, "WriteAt")
+			}
+		}
+	}
+
+	for id, blob := range local {
+		for _, offset := range wanted[id] {
+			if _, ok := present[offset]; ok {
+				r.CompleteBlob(file.location, uint64(blob.length))
+			}
+		}
+	}
+
+	file.present = present
+	file.tmp = true
+	// the temporary file must not be truncated by the files writer
+	file.inProgress = true
+	return nil
+}
@@ -0,0 +1,109 @@
+package serve
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Users holds the user names and password hashes read from an htpasswd file.
+type Users struct {
+	hashes map[string]string
+
+	// checking bcrypt hashes is slow by design, so the passwords which were
+	// accepted already are remembered as plain SHA256 hashes
+	m     sync.Mutex
+	valid map[string][sha256.Size]byte
+}
+
+// LoadHtpasswd reads the users from the htpasswd file filename.
+func LoadHtpasswd(filename string) (*Users, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+
+	users, err := ParseHtpasswd(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, filename)
+	}
+
+	return users, f.Close()
+}
+
+// ParseHtpasswd reads users from rd, which contains lines in the format
+// "user:hash". Only bcrypt and SHA1 ("{SHA}") hashes are supported, which can
+// be generated with `htpasswd -B` and `htpasswd -s`.
+func ParseHtpasswd(rd io.Reader) (*Users, error) {
+	users := &Users{
+		hashes: make(map[string]string),
+		valid:  make(map[string][sha256.Size]byte),
+	}
+
+	sc := bufio.NewScanner(rd)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		data := strings.SplitN(text, ":", 2)
+		if len(data) != 2 || data[0] == "" {
+			return nil, errors.Errorf("line %d: invalid format", line)
+		}
+
+		if !strings.HasPrefix(data[1], "{SHA}") && !strings.HasPrefix(data[1], "$2") {
+			return nil, errors.Errorf("line %d: unsupported password hash for user %q", line, data[0])
+		}
+
+		users.hashes[data[0]] = data[1]
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Authenticate returns true if the password is valid for the user.
+func (u *Users) Authenticate(user, password string) bool {
+	hash, ok := u.hashes[user]
+	if !ok {
+		return false
+	}
+
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	}
+
+	sum := sha256.Sum256([]byte(password))
+
+	u.m.Lock()
+	valid, ok := u.valid[user]
+	u.m.Unlock()
+	if ok && subtle.ConstantTimeCompare(valid[:], sum[:]) == 1 {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+
+	u.m.Lock()
+	u.valid[user] = sum
+	u.m.Unlock()
+	return true
+}
@@ -0,0 +1,49 @@
+package serve
+
+import (
+	"strings"
+	"testing"
+
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParseHtpasswd(t *testing.T) {
+	users, err := ParseHtpasswd(strings.NewReader(`
+# comment
+alice:$2a$05$YklFxyjdHJiz6EXHb.daI.WD1iC/lRa1M6ItfZpp5y1JVq0sJiin2
+bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=
+`))
+	rtest.OK(t, err)
+
+	var tests = []struct {
+		user, password string
+		ok             bool
+	}{
+		{"alice", "secret", true},
+		{"alice", "secret", true},
+		{"alice", "wrong", false},
+		{"bob", "secret", true},
+		{"bob", "wrong", false},
+		{"carol", "secret", false},
+	}
+
+	for _, test := range tests {
+		ok := users.Authenticate(test.user, test.password)
+		if ok != test.ok {
+			t.Errorf("Authenticate(%q, %q) returned %v, want %v", test.user, test.password, ok, test.ok)
+		}
+	}
+}
+
+func TestParseHtpasswdInvalid(t *testing.T) {
+	for _, s := range []string{
+		"alice",
+		":{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		"alice:$apr1$Sw5cAYUu$FsKMiavnTVOm9J4U8f2H60",
+	} {
+		_, err := ParseHtpasswd(strings.NewReader(s))
+		if err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package serve
+
+import (
+	"testing"
+
+	"github.com/restic/restic/internal/restic"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+func TestParsePath(t *testing.T) {
+	id := "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"
+
+	var tests = []struct {
+		path string
+		req  request
+	}{
+		{"/", request{root: true}},
+		{"/config", request{h: restic.Handle{Type: restic.ConfigFile}}},
+		{"/data/", request{h: restic.Handle{Type: restic.PackFile}, list: true}},
+		{"/keys/" + id, request{h: restic.Handle{Type: restic.KeyFile, Name: id}}},
+		{"/alice/", request{repo: "alice", root: true}},
+		{"/alice/work/config", request{repo: "alice/work", h: restic.Handle{Type: restic.ConfigFile}}},
+		{"/alice/locks/", request{repo: "alice", h: restic.Handle{Type: restic.LockFile}, list: true}},
+		{"/alice/index/" + id, request{repo: "alice", h: restic.Handle{Type: restic.IndexFile, Name: id}}},
+	}
+
+	for _, test := range tests {
+		req, err := parsePath(test.path)
+		rtest.OK(t, err)
+		rtest.Equals(t, test.req, req)
+	}
+
+	for _, p := range []string{"data/", "/../config", "/alice//config", "/data/foo"} {
+		_, err := parsePath(p)
+		if err == nil {
+			t.Errorf("expected error for %q", p)
+		}
+	}
+}
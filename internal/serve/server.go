@@ -0,0 +1,509 @@
+// Package serve implements a server for the REST protocol which stores the
+// repository in any restic.Backend.
+package serve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/restic/restic/internal/backend/rest"
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Config configures a Server.
+type Config struct {
+	// AppendOnly forbids removing and overwriting files, except for locks.
+	AppendOnly bool
+
+	// PrivateRepos restricts each user to the repositories below the path
+	// which matches the user name. This requires Users to be set.
+	PrivateRepos bool
+
+	// Users holds the credentials for HTTP basic authentication. When it is
+	// nil, no authentication is required.
+	Users *Users
+
+	// MaxSize is the maximum size of a file which can be saved, the whole file
+	// is held in memory before it is passed on to the backend.
+	MaxSize int64
+}
+
+// DefaultMaxSize is used when Config.MaxSize is zero.
+const DefaultMaxSize = 256 * 1024 * 1024
+
+// OpenFunc returns the backend for the repository with the given name, which
+// consists of path components separated by slashes. The empty name
+// refers to the main repository. When create is true, the repository is
+// initialized.
+type OpenFunc func(ctx context.Context, name string, create bool) (restic.Backend, error)
+
+// Server serves repositories via the REST protocol.
+type Server struct {
+	cfg  Config
+	open OpenFunc
+
+	m     sync.Mutex
+	repos map[string]restic.Backend
+}
+
+// statically ensure that Server implements http.Handler.
+var _ http.Handler = &Server{}
+
+// New returns a server which accesses the repositories via open.
+func New(cfg Config, open OpenFunc) (*Server, error) {
+	if cfg.PrivateRepos && cfg.Users == nil {
+		return nil, errors.Fatal("private repositories need authentication")
+	}
+
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = DefaultMaxSize
+	}
+
+	return &Server{
+		cfg:   cfg,
+		open:  open,
+		repos: make(map[string]restic.Backend),
+	}, nil
+}
+
+// Close closes the backends of all repositories.
+func (s *Server) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var firstErr error
+	for name, be := range s.repos {
+		err := be.Close()
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "repository %q", name)
+		}
+		delete(s.repos, name)
+	}
+	return firstErr
+}
+
+// backend returns the backend for the repository name, it is opened on first
+// use. The backend is opened without holding the lock, so that requests for
+// other repositories are not blocked. When several requests open the same
+// repository at once, the backend opened first is used.
+func (s *Server) backend(ctx context.Context, name string) (restic.Backend, error) {
+	s.m.Lock()
+	be, ok := s.repos[name]
+	s.m.Unlock()
+	if ok {
+		return be, nil
+	}
+
+	be, err := s.open(ctx, name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if other, ok := s.repos[name]; ok {
+		_ = be.Close()
+		return other, nil
+	}
+	s.repos[name] = be
+	return be, nil
+}
+
+// errRepoExists is returned by create if the repository already contains a
+// config file.
+var errRepoExists = errors.New("repository already exists")
+
+// create initializes the repository name and replaces the backend used for it.
+// An existing repository is never initialized again.
+func (s *Server) create(ctx context.Context, name string) error {
+	be, err := s.open(ctx, name, true)
+	if err != nil {
+		return err
+	}
+
+	found, err := be.Test(ctx, restic.Handle{Type: restic.ConfigFile})
+	if err == nil && found {
+		err = errRepoExists
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	old, ok := s.repos[name]
+	if err != nil {
+		if !ok || old != be {
+			_ = be.Close()
+		}
+		return err
+	}
+
+	if ok && old != be {
+		_ = old.Close()
+	}
+	s.repos[name] = be
+	return nil
+}
+
+// fileTypes maps the directory names used by the REST protocol to file types.
+var fileTypes = map[string]restic.FileType{
+	"data":      restic.PackFile,
+	"keys":      restic.KeyFile,
+	"locks":     restic.LockFile,
+	"snapshots": restic.SnapshotFile,
+	"index":     restic.IndexFile,
+}
+
+// request is a parsed request path.
+type request struct {
+	repo string
+	h    restic.Handle
+	// list is set for requests for the list of files of type h.Type.
+	list bool
+	// root is set for requests for the repository itself.
+	root bool
+}
+
+// parsePath splits the path of a request into the repository name and the
+// file.
+func parsePath(p string) (request, error) {
+	if !strings.HasPrefix(p, "/") {
+		return request{}, errors.Errorf("invalid path %q", p)
+	}
+
+	dir := strings.HasSuffix(p, "/")
+	p = strings.Trim(p, "/")
+
+	var parts []string
+	if p != "" {
+		parts = strings.Split(p, "/")
+	}
+
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return request{}, errors.Errorf("invalid path component %q", part)
+		}
+	}
+
+	var req request
+	n := len(parts)
+	switch {
+	case n >= 1 && !dir && parts[n-1] == "config":
+		req.h = restic.Handle{Type: restic.ConfigFile}
+		parts = parts[:n-1]
+	case n >= 1 && dir && isFileType(parts[n-1]):
+		req.h = restic.Handle{Type: fileTypes[parts[n-1]]}
+		req.list = true
+		parts = parts[:n-1]
+	case n >= 2 && !dir && isFileType(parts[n-2]):
+		if _, err := restic.ParseID(parts[n-1]); err != nil {
+			return request{}, errors.Errorf("invalid file name %q", parts[n-1])
+		}
+		req.h = restic.Handle{Type: fileTypes[parts[n-2]], Name: parts[n-1]}
+		parts = parts[:n-2]
+	default:
+		req.root = true
+	}
+
+	req.repo = strings.Join(parts, "/")
+	return req, nil
+}
+
+func isFileType(s string) bool {
+	_, ok := fileTypes[s]
+	return ok
+}
+
+// allowed returns true if user may access the repository name.
+func (s *Server) allowed(user, name string) bool {
+	if !s.cfg.PrivateRepos {
+		return true
+	}
+	return name == user || strings.HasPrefix(name, user+"/")
+}
+
+// ServeHTTP handles a request using the REST protocol.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	debug.Log("%v %v", r.Method, r.URL.Path)
+
+	var user string
+	if s.cfg.Users != nil {
+		var password string
+		var ok bool
+		user, password, ok = r.BasicAuth()
+		if !ok || !s.cfg.Users.Authenticate(user, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="restic"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	req, err := parsePath(r.URL.Path)
+	if err != nil {
+		debug.Log("invalid request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !s.allowed(user, req.repo) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if req.root {
+		if r.Method != http.MethodPost || r.URL.Query().Get("create") != "true" {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		err := s.create(r.Context(), req.repo)
+		if err == errRepoExists {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			s.internalError(w, err)
+		}
+		return
+	}
+
+	be, err := s.backend(r.Context(), req.repo)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+
+	if req.list {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		s.list(w, r, be, req.h.Type)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		s.stat(w, r, be, req.h)
+	case http.MethodGet:
+		s.load(w, r, be, req.h)
+	case http.MethodPost:
+		s.save(w, r, be, req.h)
+	case http.MethodDelete:
+		s.remove(w, r, be, req.h)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// internalError logs err and returns it to the client.
+func (s *Server) internalError(w http.ResponseWriter, err error) {
+	debug.Log("error: %v", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// backendError returns an error from the backend to the client.
+func (s *Server) backendError(w http.ResponseWriter, be restic.Backend, err error) {
+	if be.IsNotExist(err) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	s.internalError(w, err)
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, be restic.Backend, t restic.FileType) {
+	type entry struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+
+	entries := []entry{}
+	err := be.List(r.Context(), t, func(fi restic.FileInfo) error {
+		entries = append(entries, entry{Name: fi.Name, Size: fi.Size})
+		return nil
+	})
+	if err != nil {
+		s.backendError(w, be, err)
+		return
+	}
+
+	var body interface{} = entries
+	contentType := rest.ContentTypeV2
+	if r.Header.Get("Accept") != rest.ContentTypeV2 {
+		// protocol version 1 only returns the names
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		body = names
+		contentType = rest.ContentTypeV1
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	err = json.NewEncoder(w).Encode(body)
+	if err != nil {
+		debug.Log("sending list failed: %v", err)
+	}
+}
+
+func (s *Server) stat(w http.ResponseWriter, r *http.Request, be restic.Backend, h restic.Handle) {
+	fi, err := be.Stat(r.Context(), h)
+	if err != nil {
+		s.backendError(w, be, err)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size, 10))
+}
+
+// parseRange returns the offset and length of the byte range in the Range
+// header. The length is zero when the range extends to the end of the file.
+func parseRange(header string) (offset int64, length int64, err error) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, 0, errors.Errorf("unsupported range %q", header)
+	}
+
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return 0, 0, errors.Errorf("unsupported range %q", header)
+	}
+
+	offset, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || offset < 0 {
+		return 0, 0, errors.Errorf("invalid range %q", header)
+	}
+
+	if parts[1] == "" {
+		return offset, 0, nil
+	}
+
+	end, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || end < offset {
+		return 0, 0, errors.Errorf("invalid range %q", header)
+	}
+
+	return offset, end - offset + 1, nil
+}
+
+func (s *Server) load(w http.ResponseWriter, r *http.Request, be restic.Backend, h restic.Handle) {
+	var offset, length int64
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader != "" {
+		var err error
+		offset, length, err = parseRange(rangeHeader)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	fi, err := be.Stat(r.Context(), h)
+	if err != nil {
+		s.backendError(w, be, err)
+		return
+	}
+
+	if offset > fi.Size || (offset == fi.Size && fi.Size > 0) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", fi.Size))
+		http.Error(w, http.StatusText(http.StatusRequestedRangeNotSatisfiable), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	if length == 0 || offset+length > fi.Size {
+		length = fi.Size - offset
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if rangeHeader != "" {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, fi.Size))
+	}
+
+	if length == 0 {
+		return
+	}
+
+	wroteHeader := false
+	err = be.Load(r.Context(), h, int(length), offset, func(rd io.Reader) error {
+		if rangeHeader != "" {
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		wroteHeader = true
+		_, err := io.CopyN(w, rd, length)
+		return err
+	})
+	if err != nil {
+		if !wroteHeader {
+			s.backendError(w, be, err)
+			return
+		}
+		// the status has already been sent, the client will notice the
+		// short response
+		debug.Log("loading %v failed: %v", h, err)
+	}
+}
+
+func (s *Server) save(w http.ResponseWriter, r *http.Request, be restic.Backend, h restic.Handle) {
+	if r.ContentLength > s.cfg.MaxSize {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if s.cfg.AppendOnly {
+		// files must not be overwritten in append-only mode
+		ok, err := be.Test(r.Context(), h)
+		if err != nil {
+			s.internalError(w, err)
+			return
+		}
+		if ok {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r.Body, s.cfg.MaxSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n > s.cfg.MaxSize {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// all files except the config are named after the hash of their content
+	if h.Type != restic.ConfigFile && restic.Hash(buf.Bytes()).String() != h.Name {
+		http.Error(w, "file content does not match its name", http.StatusBadRequest)
+		return
+	}
+
+	err = be.Save(r.Context(), h, restic.NewByteReader(buf.Bytes(), be.Hasher()))
+	if err != nil {
+		s.internalError(w, err)
+	}
+}
+
+func (s *Server) remove(w http.ResponseWriter, r *http.Request, be restic.Backend, h restic.Handle) {
+	// in append-only mode, only locks can be removed
+	if s.cfg.AppendOnly && h.Type != restic.LockFile {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	err := be.Remove(r.Context(), h)
+	if err != nil {
+		s.backendError(w, be, err)
+	}
+}
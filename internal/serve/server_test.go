@@ -0,0 +1,267 @@
+package serve_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/internal/backend/rest"
+	"github.com/restic/restic/internal/backend/test"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/serve"
+	rtest "github.com/restic/restic/internal/test"
+)
+
+// memRepos returns an OpenFunc which keeps all repositories in memory.
+func memRepos() (serve.OpenFunc, map[string]*mem.MemoryBackend) {
+	var m sync.Mutex
+	repos := make(map[string]*mem.MemoryBackend)
+
+	return func(ctx context.Context, name string, create bool) (restic.Backend, error) {
+		m.Lock()
+		defer m.Unlock()
+
+		be, ok := repos[name]
+		if !ok {
+			be = mem.New()
+			repos[name] = be
+		}
+		return be, nil
+	}, repos
+}
+
+func newServer(t testing.TB, cfg serve.Config) (*httptest.Server, map[string]*mem.MemoryBackend) {
+	open, repos := memRepos()
+	srv, err := serve.New(cfg, open)
+	rtest.OK(t, err)
+
+	ts := httptest.NewServer(srv)
+	return ts, repos
+}
+
+func restConfig(t testing.TB, u string) rest.Config {
+	cfg, err := rest.ParseConfig("rest:" + u)
+	rtest.OK(t, err)
+	return cfg.(rest.Config)
+}
+
+func newTestSuite(t testing.TB, ts *httptest.Server) *test.Suite {
+	return &test.Suite{
+		// NewConfig returns a config for a new temporary backend that will be used in tests.
+		NewConfig: func() (interface{}, error) {
+			return restConfig(t, ts.URL+"/"), nil
+		},
+
+		// CreateFn is a function that creates a temporary repository for the tests.
+		Create: func(config interface{}) (restic.Backend, error) {
+			return rest.Create(context.TODO(), config.(rest.Config), http.DefaultTransport)
+		},
+
+		// OpenFn is a function that opens a previously created temporary repository.
+		Open: func(config interface{}) (restic.Backend, error) {
+			return rest.Open(config.(rest.Config), http.DefaultTransport)
+		},
+
+		// CleanupFn removes data created during the tests.
+		Cleanup: func(config interface{}) error {
+			return nil
+		},
+	}
+}
+
+func TestBackendServe(t *testing.T) {
+	ts, _ := newServer(t, serve.Config{})
+	defer ts.Close()
+
+	newTestSuite(t, ts).RunTests(t)
+}
+
+func save(t testing.TB, be restic.Backend, tpe restic.FileType, data string) restic.Handle {
+	h := restic.Handle{Type: tpe, Name: restic.Hash([]byte(data)).String()}
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader([]byte(data), be.Hasher())))
+	return h
+}
+
+func TestServeAppendOnly(t *testing.T) {
+	ts, _ := newServer(t, serve.Config{AppendOnly: true})
+	defer ts.Close()
+
+	be, err := rest.Create(context.TODO(), restConfig(t, ts.URL+"/"), http.DefaultTransport)
+	rtest.OK(t, err)
+
+	h := save(t, be, restic.SnapshotFile, "snapshot")
+	err = be.Save(context.TODO(), h, restic.NewByteReader([]byte("snapshot"), be.Hasher()))
+	rtest.Assert(t, err != nil, "overwriting a file succeeded in append-only mode")
+
+	err = be.Remove(context.TODO(), h)
+	rtest.Assert(t, err != nil, "removing a file succeeded in append-only mode")
+
+	ok, err := be.Test(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, ok, "file is missing")
+
+	// locks can still be removed
+	lock := save(t, be, restic.LockFile, "lock")
+	rtest.OK(t, be.Remove(context.TODO(), lock))
+}
+
+func TestServeInvalidContent(t *testing.T) {
+	ts, repos := newServer(t, serve.Config{})
+	defer ts.Close()
+
+	be, err := rest.Create(context.TODO(), restConfig(t, ts.URL+"/"), http.DefaultTransport)
+	rtest.OK(t, err)
+
+	h := restic.Handle{Type: restic.PackFile, Name: restic.Hash([]byte("foo")).String()}
+	err = be.Save(context.TODO(), h, restic.NewByteReader([]byte("bar"), nil))
+	rtest.Assert(t, err != nil, "saving a file with wrong content succeeded")
+
+	ok, err := repos[""].Test(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "file with wrong content was saved")
+}
+
+func TestServeAuth(t *testing.T) {
+	// the password is "secret" for both users
+	users, err := serve.ParseHtpasswd(strings.NewReader(
+		"alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n" +
+			"bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"))
+	rtest.OK(t, err)
+
+	ts, repos := newServer(t, serve.Config{Users: users, PrivateRepos: true})
+	defer ts.Close()
+
+	withUser := func(user, password, path string) rest.Config {
+		u, err := url.Parse(ts.URL + path)
+		rtest.OK(t, err)
+		u.User = url.UserPassword(user, password)
+		return restConfig(t, u.String())
+	}
+
+	_, err = rest.Create(context.TODO(), withUser("alice", "wrong", "/alice/"), http.DefaultTransport)
+	rtest.Assert(t, err != nil, "wrong password was accepted")
+
+	_, err = rest.Create(context.TODO(), withUser("bob", "secret", "/alice/"), http.DefaultTransport)
+	rtest.Assert(t, err != nil, "bob could create a repository for alice")
+
+	be, err := rest.Create(context.TODO(), withUser("alice", "secret", "/alice/"), http.DefaultTransport)
+	rtest.OK(t, err)
+	h := save(t, be, restic.SnapshotFile, "snapshot")
+
+	ok, err := repos["alice"].Test(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, ok, "file is missing in the repository of alice")
+
+	be, err = rest.Open(withUser("bob", "secret", "/alice/"), http.DefaultTransport)
+	rtest.OK(t, err)
+	_, err = be.Stat(context.TODO(), h)
+	rtest.Assert(t, err != nil, "bob could access the repository of alice")
+
+	_, err = serve.New(serve.Config{PrivateRepos: true}, nil)
+	rtest.Assert(t, err != nil, "expected error for private repositories without users")
+}
+
+func TestServeCreateExisting(t *testing.T) {
+	ts, repos := newServer(t, serve.Config{AppendOnly: true})
+	defer ts.Close()
+
+	be, err := rest.Create(context.TODO(), restConfig(t, ts.URL+"/"), http.DefaultTransport)
+	rtest.OK(t, err)
+	config := restic.Handle{Type: restic.ConfigFile}
+	rtest.OK(t, be.Save(context.TODO(), config, restic.NewByteReader([]byte("config"), be.Hasher())))
+
+	res, err := http.Post(ts.URL+"/?create=true", "", nil)
+	rtest.OK(t, err)
+	rtest.OK(t, res.Body.Close())
+	rtest.Equals(t, http.StatusForbidden, res.StatusCode)
+
+	ok, err := repos[""].Test(context.TODO(), config)
+	rtest.OK(t, err)
+	rtest.Assert(t, ok, "config is missing")
+}
+
+func TestServeSlowOpen(t *testing.T) {
+	open, _ := memRepos()
+	started, release := make(chan struct{}), make(chan struct{})
+	srv, err := serve.New(serve.Config{}, func(ctx context.Context, name string, create bool) (restic.Backend, error) {
+		if name == "slow" {
+			close(started)
+			<-release
+		}
+		return open(ctx, name, create)
+	})
+	rtest.OK(t, err)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		res, err := http.Head(ts.URL + "/slow/config")
+		if err == nil {
+			err = res.Body.Close()
+		}
+		done <- err
+	}()
+
+	// requests for other repositories are not blocked while opening a backend
+	<-started
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Head(ts.URL + "/config")
+	close(release)
+	rtest.OK(t, err)
+	rtest.OK(t, res.Body.Close())
+	rtest.Equals(t, http.StatusNotFound, res.StatusCode)
+	rtest.OK(t, <-done)
+}
+
+func TestServeMaxSize(t *testing.T) {
+	ts, repos := newServer(t, serve.Config{MaxSize: 10})
+	defer ts.Close()
+
+	be, err := rest.Create(context.TODO(), restConfig(t, ts.URL+"/"), http.DefaultTransport)
+	rtest.OK(t, err)
+
+	rtest.OK(t, be.Save(context.TODO(), restic.Handle{Type: restic.ConfigFile}, restic.NewByteReader([]byte("config"), be.Hasher())))
+
+	data := []byte("larger than ten bytes")
+	h := restic.Handle{Type: restic.SnapshotFile, Name: restic.Hash(data).String()}
+	err = be.Save(context.TODO(), h, restic.NewByteReader(data, be.Hasher()))
+	rtest.Assert(t, err != nil, "file larger than the maximum size was saved")
+
+	ok, err := repos[""].Test(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "file larger than the maximum size was saved")
+}
+
+func TestBackendServeLocal(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	srv, err := serve.New(serve.Config{}, func(ctx context.Context, name string, create bool) (restic.Backend, error) {
+		cfg := local.Config{Path: filepath.Join(dir, filepath.FromSlash(name))}
+		if create {
+			return local.Create(ctx, cfg)
+		}
+		return local.Open(ctx, cfg)
+	})
+	rtest.OK(t, err)
+
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	suite := newTestSuite(t, ts)
+	suite.Cleanup = func(config interface{}) error {
+		rtest.OK(t, srv.Close())
+		return os.RemoveAll(dir)
+	}
+	suite.RunTests(t)
+}
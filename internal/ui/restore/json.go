@@ -0,0 +1,166 @@
+package restore
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/termstatus"
+)
+
+// JSONProgress reports progress for the `restore` command in JSON.
+type JSONProgress struct {
+	*ui.Message
+
+	term *termstatus.Terminal
+	v    uint
+}
+
+// assert that JSONProgress implements the ProgressPrinter interface
+var _ ProgressPrinter = &JSONProgress{}
+
+// NewJSONProgress returns a new restore progress reporter.
+func NewJSONProgress(term *termstatus.Terminal, verbosity uint) *JSONProgress {
+	return &JSONProgress{
+		Message: ui.NewMessage(term, verbosity),
+		term:    term,
+		v:       verbosity,
+	}
+}
+
+func toJSONString(status interface{}) string {
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(status)
+	if err != nil {
+		panic(err)
+	}
+	return buf.String()
+}
+
+func (b *JSONProgress) print(status interface{}) {
+	b.term.Print(toJSONString(status))
+}
+
+func (b *JSONProgress) error(status interface{}) {
+	b.term.Error(toJSONString(status))
+}
+
+// Update updates the status lines.
+func (b *JSONProgress) Update(total, processed Counter, errors uint, start time.Time, secs uint64) {
+	elapsed := time.Since(start)
+	status := statusUpdate{
+		MessageType:      "status",
+		SecondsElapsed:   uint64(elapsed / time.Second),
+		SecondsRemaining: secs,
+		TotalFiles:       total.Files,
+		FilesRestored:    processed.Files,
+		TotalBytes:       total.Bytes,
+		BytesRestored:    processed.Bytes,
+		ErrorCount:       errors,
+	}
+
+	if total.Bytes > 0 {
+		status.PercentDone = float64(processed.Bytes) / float64(total.Bytes)
+	}
+	if elapsed > 0 {
+		status.BytesPerSecond = uint64(float64(processed.Bytes) / elapsed.Seconds())
+	}
+
+	b.print(status)
+}
+
+// Error is the error callback function for the restorer, it prints the error
+// and returns nil.
+func (b *JSONProgress) Error(item string, err error) error {
+	b.error(errorUpdate{
+		MessageType: "error",
+		Error:       err,
+		During:      "restore",
+		Item:        item,
+	})
+	return nil
+}
+
+// CompleteItem prints a message for a restored or skipped file in verbose
+// mode.
+func (b *JSONProgress) CompleteItem(action string, item string, size uint64) {
+	if b.v < 2 {
+		return
+	}
+
+	switch action {
+	case "restored":
+		b.print(verboseUpdate{
+			MessageType: "verbose_status",
+			Action:      "restored",
+			Item:        item,
+			Size:        size,
+		})
+	case "skipped":
+		b.print(verboseUpdate{
+			MessageType: "verbose_status",
+			Action:      "unchanged",
+			Item:        item,
+			Size:        size,
+		})
+	}
+}
+
+// Reset no-op
+func (b *JSONProgress) Reset() {
+}
+
+// Finish prints the finishing messages.
+func (b *JSONProgress) Finish(start time.Time, summary *Summary) {
+	b.print(summaryOutput{
+		MessageType:   "summary",
+		TotalFiles:    summary.Total.Files,
+		FilesRestored: summary.Restored.Files,
+		FilesSkipped:  summary.Skipped.Files,
+		TotalBytes:    summary.Total.Bytes,
+		BytesRestored: summary.Restored.Bytes,
+		BytesSkipped:  summary.Skipped.Bytes,
+		ErrorCount:    summary.Errors,
+		TotalDuration: time.Since(start).Seconds(),
+	})
+}
+
+type statusUpdate struct {
+	MessageType      string  `json:"message_type"` // "status"
+	SecondsElapsed   uint64  `json:"seconds_elapsed,omitempty"`
+	SecondsRemaining uint64  `json:"seconds_remaining,omitempty"`
+	PercentDone      float64 `json:"percent_done"`
+	TotalFiles       uint64  `json:"total_files,omitempty"`
+	FilesRestored    uint64  `json:"files_restored,omitempty"`
+	TotalBytes       uint64  `json:"total_bytes,omitempty"`
+	BytesRestored    uint64  `json:"bytes_restored,omitempty"`
+	BytesPerSecond   uint64  `json:"bytes_per_second,omitempty"`
+	ErrorCount       uint    `json:"error_count,omitempty"`
+}
+
+type errorUpdate struct {
+	MessageType string `json:"message_type"` // "error"
+	Error       error  `json:"error"`
+	During      string `json:"during"`
+	Item        string `json:"item"`
+}
+
+type verboseUpdate struct {
+	MessageType string `json:"message_type"` // "verbose_status"
+	Action      string `json:"action"`
+	Item        string `json:"item"`
+	Size        uint64 `json:"size"`
+}
+
+type summaryOutput struct {
+	MessageType   string  `json:"message_type"` // "summary"
+	TotalFiles    uint64  `json:"total_files"`
+	FilesRestored uint64  `json:"files_restored"`
+	FilesSkipped  uint64  `json:"files_skipped"`
+	TotalBytes    uint64  `json:"total_bytes"`
+	BytesRestored uint64  `json:"bytes_restored"`
+	BytesSkipped  uint64  `json:"bytes_skipped"`
+	ErrorCount    uint    `json:"error_count"`
+	TotalDuration float64 `json:"total_duration"` // in seconds
+}
@@ -0,0 +1,218 @@
+package restore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/ui/signals"
+)
+
+// ProgressPrinter prints the progress reported by Progress.
+type ProgressPrinter interface {
+	Update(total, processed Counter, errors uint, start time.Time, secs uint64)
+	Error(item string, err error) error
+	CompleteItem(action string, item string, size uint64)
+	Finish(start time.Time, summary *Summary)
+	Reset()
+
+	E(msg string, args ...interface{})
+	P(msg string, args ...interface{})
+	V(msg string, args ...interface{})
+	VV(msg string, args ...interface{})
+}
+
+// Counter counts files and bytes.
+type Counter struct {
+	Files, Bytes uint64
+}
+
+// Summary contains the statistics of a finished restore.
+type Summary struct {
+	Restored Counter
+	Skipped  Counter
+	Total    Counter
+	Errors   uint
+}
+
+// fileProgress tracks the number of bytes of a file which have been written.
+type fileProgress struct {
+	size, written uint64
+}
+
+// Progress reports progress for the `restore` command.
+type Progress struct {
+	MinUpdatePause time.Duration
+
+	start time.Time
+
+	m         sync.Mutex
+	total     Counter
+	processed Counter
+	skipped   Counter
+	errors    uint
+	files     map[string]fileProgress
+	changed   bool
+
+	closed chan struct{}
+
+	printer ProgressPrinter
+}
+
+// NewProgress returns a new progress reporter which uses printer for the
+// output.
+func NewProgress(printer ProgressPrinter) *Progress {
+	return &Progress{
+		// limit to 60fps by default
+		MinUpdatePause: time.Second / 60,
+		start:          time.Now(),
+
+		files:  make(map[string]fileProgress),
+		closed: make(chan struct{}),
+
+		printer: printer,
+	}
+}
+
+// Run regularly updates the status lines. It should be called in a separate
+// goroutine.
+func (p *Progress) Run(ctx context.Context) error {
+	defer close(p.closed)
+	// Reset status when finished
+	defer p.printer.Reset()
+
+	var tick <-chan time.Time
+	if p.MinUpdatePause > 0 {
+		t := time.NewTicker(p.MinUpdatePause)
+		defer t.Stop()
+		tick = t.C
+	}
+	signalsCh := signals.GetProgressChannel()
+
+	var lastUpdate time.Time
+	for {
+		forceUpdate := false
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick:
+		case <-signalsCh:
+			forceUpdate = true
+		}
+
+		p.m.Lock()
+		changed := p.changed
+		p.changed = false
+		p.m.Unlock()
+
+		// refresh elapsed time and ETA at least once per second
+		if !forceUpdate && !changed && time.Since(lastUpdate) < time.Second {
+			continue
+		}
+		lastUpdate = time.Now()
+
+		p.update()
+	}
+}
+
+func (p *Progress) update() {
+	p.m.Lock()
+	total, processed, errors := p.total, p.processed, p.errors
+	p.m.Unlock()
+
+	var secondsRemaining uint64
+	if processed.Bytes > 0 && processed.Bytes < total.Bytes {
+		secs := float64(time.Since(p.start) / time.Second)
+		todo := float64(total.Bytes - processed.Bytes)
+		secondsRemaining = uint64(secs / float64(processed.Bytes) * todo)
+	}
+
+	p.printer.Update(total, processed, errors, p.start, secondsRemaining)
+}
+
+// AddFile announces a file with the given size which will be restored. Files
+// of size zero are complete immediately.
+func (p *Progress) AddFile(item string, size uint64) {
+	p.m.Lock()
+	p.changed = true
+	p.total.Files++
+	p.total.Bytes += size
+	if size > 0 {
+		p.files[item] = fileProgress{size: size}
+		p.m.Unlock()
+		return
+	}
+	p.processed.Files++
+	p.m.Unlock()
+
+	p.printer.CompleteItem("restored", item, 0)
+}
+
+// AddProgress records that bytes of the file item have been written. Once all
+// data of a file has been written, it is reported as restored.
+func (p *Progress) AddProgress(item string, bytes uint64) {
+	p.m.Lock()
+	p.changed = true
+	p.processed.Bytes += bytes
+
+	f, ok := p.files[item]
+	if !ok {
+		p.m.Unlock()
+		return
+	}
+	f.written += bytes
+	if f.written < f.size {
+		p.files[item] = f
+		p.m.Unlock()
+		return
+	}
+	delete(p.files, item)
+	p.processed.Files++
+	p.m.Unlock()
+
+	p.printer.CompleteItem("restored", item, f.size)
+}
+
+// AddSkippedFile records that the existing file item has not been
+// overwritten.
+func (p *Progress) AddSkippedFile(item string, size uint64) {
+	p.m.Lock()
+	p.changed = true
+	p.skipped.Files++
+	p.skipped.Bytes += size
+	p.m.Unlock()
+
+	p.printer.CompleteItem("skipped", item, size)
+}
+
+// Error is the error callback function for the restorer, it prints the error
+// and returns nil.
+func (p *Progress) Error(item string, err error) error {
+	p.m.Lock()
+	p.changed = true
+	p.errors++
+	p.m.Unlock()
+
+	return p.printer.Error(item, err)
+}
+
+// SetMinUpdatePause sets p.MinUpdatePause.
+func (p *Progress) SetMinUpdatePause(d time.Duration) {
+	p.MinUpdatePause = d
+}
+
+// Finish prints the summary. It waits until Run has returned.
+func (p *Progress) Finish() {
+	<-p.closed
+
+	p.m.Lock()
+	summary := &Summary{
+		Restored: p.processed,
+		Skipped:  p.skipped,
+		Total:    p.total,
+		Errors:   p.errors,
+	}
+	p.m.Unlock()
+
+	p.printer.Finish(p.start, summary)
+}
@@ -0,0 +1,90 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/ui"
+)
+
+type mockPrinter struct {
+	ui.Message
+
+	items   map[string]string
+	errors  []string
+	summary *Summary
+}
+
+func (p *mockPrinter) Update(total, processed Counter, errors uint, start time.Time, secs uint64) {}
+
+func (p *mockPrinter) Error(item string, err error) error {
+	p.errors = append(p.errors, item)
+	return nil
+}
+
+func (p *mockPrinter) CompleteItem(action string, item string, size uint64) {
+	p.items[item] = action
+}
+
+func (p *mockPrinter) Finish(start time.Time, summary *Summary) {
+	p.summary = summary
+}
+
+func (p *mockPrinter) Reset() {}
+
+func TestProgress(t *testing.T) {
+	printer := &mockPrinter{items: make(map[string]string)}
+	p := NewProgress(printer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = p.Run(ctx)
+		close(done)
+	}()
+
+	p.AddFile("/foo", 10)
+	p.AddFile("/bar", 5)
+	p.AddFile("/empty", 0)
+	p.AddSkippedFile("/skipped", 7)
+
+	p.AddProgress("/foo", 4)
+	p.AddProgress("/bar", 5)
+	if _, ok := printer.items["/foo"]; ok {
+		t.Errorf("file /foo reported as complete before all data was written")
+	}
+	p.AddProgress("/foo", 6)
+
+	_ = p.Error("/baz", errors.New("error"))
+
+	cancel()
+	<-done
+	p.Finish()
+
+	want := map[string]string{
+		"/foo":     "restored",
+		"/bar":     "restored",
+		"/empty":   "restored",
+		"/skipped": "skipped",
+	}
+	for item, action := range want {
+		if printer.items[item] != action {
+			t.Errorf("item %v: want action %q, got %q", item, action, printer.items[item])
+		}
+	}
+
+	wantSummary := Summary{
+		Restored: Counter{Files: 3, Bytes: 15},
+		Skipped:  Counter{Files: 1, Bytes: 7},
+		Total:    Counter{Files: 3, Bytes: 15},
+		Errors:   1,
+	}
+	if printer.summary == nil || *printer.summary != wantSummary {
+		t.Errorf("wrong summary, want %+v, got %+v", wantSummary, printer.summary)
+	}
+	if len(printer.errors) != 1 || printer.errors[0] != "/baz" {
+		t.Errorf("wrong errors reported: %v", printer.errors)
+	}
+}
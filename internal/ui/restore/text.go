@@ -0,0 +1,98 @@
+package restore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/termstatus"
+)
+
+// TextProgress reports progress for the `restore` command.
+type TextProgress struct {
+	*ui.Message
+
+	term *termstatus.Terminal
+}
+
+// assert that TextProgress implements the ProgressPrinter interface
+var _ ProgressPrinter = &TextProgress{}
+
+// NewTextProgress returns a new restore progress reporter.
+func NewTextProgress(term *termstatus.Terminal, verbosity uint) *TextProgress {
+	return &TextProgress{
+		Message: ui.NewMessage(term, verbosity),
+		term:    term,
+	}
+}
+
+// Update updates the status lines.
+func (b *TextProgress) Update(total, processed Counter, errors uint, start time.Time, secs uint64) {
+	elapsed := time.Since(start)
+
+	var eta, percent string
+	if secs > 0 && processed.Bytes < total.Bytes {
+		eta = fmt.Sprintf(" ETA %s", ui.FormatSeconds(secs))
+	}
+	if total.Bytes > 0 {
+		percent = ui.FormatPercent(processed.Bytes, total.Bytes) + "  "
+	}
+
+	var rate string
+	if r := ui.FormatRate(processed.Bytes, elapsed); r != "" {
+		rate = ", " + r
+	}
+
+	status := fmt.Sprintf("[%s] %s%v files %s, total %v files %v, %d errors%s%s",
+		ui.FormatDuration(elapsed),
+		percent,
+		processed.Files,
+		ui.FormatBytes(processed.Bytes),
+		total.Files,
+		ui.FormatBytes(total.Bytes),
+		errors,
+		rate,
+		eta,
+	)
+
+	b.term.SetStatus([]string{status})
+}
+
+// Error is the error callback function for the restorer, it prints the error
+// and returns nil.
+func (b *TextProgress) Error(item string, err error) error {
+	b.E("ignoring error for %s: %s\n", item, err)
+	return nil
+}
+
+// CompleteItem prints a message for a restored or skipped file in verbose
+// mode.
+func (b *TextProgress) CompleteItem(action string, item string, size uint64) {
+	switch action {
+	case "restored":
+		b.VV("restored  %v with size %v", item, ui.FormatBytes(size))
+	case "skipped":
+		b.VV("unchanged %v with size %v", item, ui.FormatBytes(size))
+	}
+}
+
+// Reset status
+func (b *TextProgress) Reset() {
+	if b.term.CanUpdateStatus() {
+		b.term.SetStatus([]string{""})
+	}
+}
+
+// Finish prints the finishing messages.
+func (b *TextProgress) Finish(start time.Time, summary *Summary) {
+	elapsed := time.Since(start)
+	b.P("Summary: Restored %d / %d files (%s / %s) in %s",
+		summary.Restored.Files, summary.Total.Files,
+		ui.FormatBytes(summary.Restored.Bytes), ui.FormatBytes(summary.Total.Bytes),
+		ui.FormatDuration(elapsed),
+	)
+	if summary.Skipped.Files > 0 {
+		b.P("Skipped %d existing files (%s)", summary.Skipped.Files, ui.FormatBytes(summary.Skipped.Bytes))
+	}
+	b.V("Throughput: %s", ui.FormatRate(summary.Restored.Bytes, elapsed))
+}
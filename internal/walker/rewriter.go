@@ -0,0 +1,108 @@
+package walker
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/restic"
+)
+
+// SelectByNameFunc returns true for all items that should be included (files
+// and dirs). If false is returned, files are ignored and dirs are not even
+// walked.
+type SelectByNameFunc func(item string) bool
+
+// TreeFilterVisitor configures which nodes are kept by FilterTree.
+type TreeFilterVisitor struct {
+	// SelectByName is called for each node, nodes for which it returns false
+	// are removed from the tree.
+	SelectByName SelectByNameFunc
+	// PrintExclude is called for each node that is removed, it may be nil.
+	PrintExclude func(path string)
+}
+
+// TreeLoadSaver loads and saves trees.
+type TreeLoadSaver interface {
+	restic.TreeLoader
+	SaveTree(context.Context, *restic.Tree) (restic.ID, error)
+}
+
+// FilterTree walks the tree nodeID recursively and removes all nodes which are
+// not selected by the visitor. For each modified tree, a new tree is saved to
+// repo. Returned is the ID of the resulting tree, which is equal to nodeID if
+// nothing has been removed.
+func FilterTree(ctx context.Context, repo TreeLoadSaver, nodepath string, nodeID restic.ID, visitor *TreeFilterVisitor) (newNodeID restic.ID, err error) {
+	curTree, err := repo.LoadTree(ctx, nodeID)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	// check that we can properly encode this tree without losing information,
+	// this would e.g. be the case for trees with attributes unknown to this
+	// version of restic
+	testID, err := treeID(curTree)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	if nodeID != testID {
+		return restic.ID{}, errors.Errorf("cannot encode tree at %q without losing information", nodepath)
+	}
+
+	debug.Log("filterTree: %s, nodeId: %s\n", nodepath, nodeID.Str())
+
+	changed := false
+	tree := restic.NewTree(len(curTree.Nodes))
+	for _, node := range curTree.Nodes {
+		if ctx.Err() != nil {
+			return restic.ID{}, ctx.Err()
+		}
+
+		p := path.Join(nodepath, node.Name)
+		if !visitor.SelectByName(p) {
+			if visitor.PrintExclude != nil {
+				visitor.PrintExclude(p)
+			}
+			changed = true
+			continue
+		}
+
+		if node.Subtree != nil {
+			newID, err := FilterTree(ctx, repo, p, *node.Subtree, visitor)
+			if err != nil {
+				return restic.ID{}, err
+			}
+			if newID != *node.Subtree {
+				node.Subtree = &newID
+				changed = true
+			}
+		}
+
+		err = tree.Insert(node)
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	if !changed {
+		return nodeID, nil
+	}
+
+	return repo.SaveTree(ctx, tree)
+}
+
+// treeID returns the ID a tree would have when saved to a repository.
+func treeID(tree *restic.Tree) (restic.ID, error) {
+	buf, err := json.Marshal(tree)
+	if err != nil {
+		return restic.ID{}, errors.Wrap(err, "MarshalJSON")
+	}
+
+	// trees are always stored with a trailing newline, see
+	// Repository.SaveTree
+	buf = append(buf, '\n')
+
+	return restic.Hash(buf), nil
+}
@@ -0,0 +1,175 @@
+package walker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/restic/restic/internal/restic"
+)
+
+// WritableTreeMap also supports saving new trees.
+type WritableTreeMap struct {
+	TreeMap
+}
+
+func (t WritableTreeMap) SaveTree(ctx context.Context, tree *restic.Tree) (restic.ID, error) {
+	buf, err := json.Marshal(tree)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	buf = append(buf, '\n')
+
+	id := restic.Hash(buf)
+	if _, ok := t.TreeMap[id]; !ok {
+		t.TreeMap[id] = tree
+	}
+	return id, nil
+}
+
+// buildWritableTreeMap saves the trees of tree to m in the same way as a
+// repository would and returns the ID of the root tree.
+func buildWritableTreeMap(tree TestTree, m WritableTreeMap) restic.ID {
+	res := restic.NewTree(0)
+
+	for name, item := range tree {
+		switch elem := item.(type) {
+		case TestFile:
+			err := res.Insert(&restic.Node{
+				Name: name,
+				Type: "file",
+			})
+			if err != nil {
+				panic(err)
+			}
+		case TestTree:
+			id := buildWritableTreeMap(elem, m)
+			err := res.Insert(&restic.Node{
+				Name:    name,
+				Subtree: &id,
+				Type:    "dir",
+			})
+			if err != nil {
+				panic(err)
+			}
+		default:
+			panic(fmt.Sprintf("invalid type %T", elem))
+		}
+	}
+
+	id, err := m.SaveTree(context.TODO(), res)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// listPaths returns all paths in the tree root in the order visited by Walk.
+func listPaths(t testing.TB, repo restic.TreeLoader, root restic.ID) []string {
+	var paths []string
+	err := Walk(context.TODO(), repo, root, nil, func(_ restic.ID, path string, _ *restic.Node, err error) (bool, error) {
+		if err != nil {
+			return false, err
+		}
+		paths = append(paths, path)
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestFilterTree(t *testing.T) {
+	var tests = []struct {
+		tree     TestTree
+		excludes []string
+		want     []string
+		excluded []string
+	}{
+		{
+			tree: TestTree{
+				"foo":    TestFile{},
+				"subdir": TestTree{"secret": TestFile{}, "bar": TestFile{}},
+			},
+			excludes: []string{"/subdir/secret"},
+			want:     []string{"/", "/foo", "/subdir", "/subdir/bar"},
+			excluded: []string{"/subdir/secret"},
+		},
+		{
+			tree: TestTree{
+				"foo": TestFile{},
+				"tmp": TestTree{
+					"a": TestFile{},
+					"b": TestTree{"c": TestFile{}},
+				},
+			},
+			excludes: []string{"/tmp"},
+			want:     []string{"/", "/foo"},
+			excluded: []string{"/tmp"},
+		},
+		{
+			tree: TestTree{
+				"foo":    TestFile{},
+				"subdir": TestTree{"bar": TestFile{}},
+			},
+			excludes: []string{"/nonexistent"},
+			want:     []string{"/", "/foo", "/subdir", "/subdir/bar"},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			repo := WritableTreeMap{TreeMap{}}
+			root := buildWritableTreeMap(test.tree, repo)
+
+			var excluded []string
+			visitor := &TreeFilterVisitor{
+				SelectByName: func(item string) bool {
+					for _, exclude := range test.excludes {
+						if item == exclude {
+							return false
+						}
+					}
+					return true
+				},
+				PrintExclude: func(path string) {
+					excluded = append(excluded, path)
+				},
+			}
+
+			newRoot, err := FilterTree(context.TODO(), repo, "/", root, visitor)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(test.excluded) == 0 && newRoot != root {
+				t.Errorf("unmodified tree got a new ID %v, want %v", newRoot.Str(), root.Str())
+			}
+
+			if !cmp.Equal(test.excluded, excluded) {
+				t.Error(cmp.Diff(test.excluded, excluded))
+			}
+
+			paths := listPaths(t, repo, newRoot)
+			if !cmp.Equal(test.want, paths) {
+				t.Error(cmp.Diff(test.want, paths))
+			}
+		})
+	}
+}
+
+func TestFilterTreeLossyEncoding(t *testing.T) {
+	// trees built by BuildTreeMap use IDs which do not match their encoding
+	tree, root := BuildTreeMap(TestTree{"foo": TestFile{}})
+	repo := WritableTreeMap{tree}
+
+	_, err := FilterTree(context.TODO(), repo, "/", root, &TreeFilterVisitor{
+		SelectByName: func(string) bool { return true },
+	})
+	if err == nil {
+		t.Fatal("expected error for tree which cannot be encoded without loss")
+	}
+}